@@ -0,0 +1,427 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"commandCenter/dnsquery"
+	"commandCenter/styles"
+	"commandCenter/validators"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+)
+
+const (
+	transferMaster = "master"
+	transferJSON   = "json"
+)
+
+type ZoneTransferCheck struct {
+	request dnsquery.TransferRequest
+	servers []string
+	output  string
+	file    string
+}
+
+type TransferChange struct {
+	FromSerial uint32            `json:"from_serial"`
+	ToSerial   uint32            `json:"to_serial"`
+	Deleted    []dnsquery.Record `json:"deleted"`
+	Added      []dnsquery.Record `json:"added"`
+}
+
+type TransferOutput struct {
+	Zone          string            `json:"zone"`
+	Server        string            `json:"server"`
+	Type          string            `json:"type"`
+	Serial        uint32            `json:"serial"`
+	Incremental   bool              `json:"incremental"`
+	Authenticated bool              `json:"authenticated"`
+	DurationMs    float64           `json:"duration_ms"`
+	Records       []dnsquery.Record `json:"records,omitempty"`
+	Changes       []TransferChange  `json:"changes,omitempty"`
+}
+
+var axfrCmd = &cobra.Command{
+	Use:     "axfr",
+	Short:   "Transfer a zone from a nameserver with AXFR or IXFR.",
+	Long:    "Request a full (AXFR) or incremental (IXFR) zone transfer, optionally signed with TSIG, and report servers that allow unauthenticated transfers.",
+	Aliases: []string{"ixfr", "xfr", "transfer"},
+	Example: `
+      # Transfer a zone from every one of its nameservers and flag the ones allowing it without TSIG
+      ops dns axfr -d example.com
+
+      # Transfer from a given primary with a TSIG key and save it as a master file
+      ops dns axfr -d example.com -s 192.0.2.53 -y hmac-sha256:transfer-key:c2VjcmV0 -f example.com.zone
+
+      # Request the changes since serial 2024010101 as JSON
+      ops dns axfr -d example.com -s 192.0.2.53 --ixfr --serial 2024010101 -o json
+
+      # Get help for the axfr command
+      ops dns axfr --help
+    `,
+
+	Run: transferZone,
+}
+
+// init initializes the axfr command and its flags.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - None
+func init() {
+	dnsCmd.AddCommand(axfrCmd)
+
+	axfrCmd.Flags().StringP("domain", "d", "", "zone to transfer")
+	axfrCmd.Flags().StringSliceP("server", "s", []string{}, "nameserver to transfer from as host[:port], repeatable (default the NS records of the zone)")
+	axfrCmd.Flags().Bool("ixfr", false, "request an incremental transfer (IXFR) instead of a full one")
+	axfrCmd.Flags().Uint32("serial", 0, "SOA serial the incremental transfer starts from, used with --ixfr")
+	axfrCmd.Flags().StringP("tsig", "y", "", "TSIG key as [algorithm:]name:base64-secret, algorithm defaulting to hmac-sha256")
+	axfrCmd.Flags().StringP("output", "o", transferMaster, "output format: master (RFC 1035 zone file) or json")
+	axfrCmd.Flags().StringP("file", "f", "", "file the zone is written to instead of stdout")
+	axfrCmd.Flags().Duration("timeout", 10*time.Second, "timeout for connecting and for each message of the transfer")
+
+	axfrCmd.MarkFlagRequired("domain")
+}
+
+// transferZone is the main function for the axfr command.
+//
+// Args:
+//   - cmd: The cobra command.
+//   - args: The command arguments.
+//
+// Returns:
+//   - None
+func transferZone(cmd *cobra.Command, args []string) {
+	zone, err := validators.VerifyStringInputs(cmd, "domain")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	servers, err := validators.VerifyStringSliceInputs(cmd, "server")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	ixfr, err := validators.VerifyBoolInputs(cmd, "ixfr")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	serial, err := validators.VerifyUint32Inputs(cmd, "serial")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	key, err := validators.VerifyStringInputs(cmd, "tsig")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	output, err := validators.VerifyStringInputs(cmd, "output")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	file, err := validators.VerifyStringInputs(cmd, "file")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	timeout, err := validators.VerifyDurationInputs(cmd, "timeout")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	output = strings.ToLower(output)
+	if output != transferMaster && output != transferJSON {
+		log.Fatalln(styles.NewStyles().Error.Render(fmt.Sprintf("unknown output format '%s', use master or json", output)))
+	}
+
+	var tsig *dnsquery.TSIG
+	if key != "" {
+		tsig, err = dnsquery.ParseTSIG(key)
+		if err != nil {
+			log.Fatalln(styles.NewStyles().Error.Render(err.Error()))
+		}
+	}
+
+	check := ZoneTransferCheck{
+		request: dnsquery.TransferRequest{
+			Zone:    zone,
+			Serial:  serial,
+			IXFR:    ixfr,
+			TSIG:    tsig,
+			Timeout: timeout,
+		},
+		servers: servers,
+		output:  output,
+		file:    file,
+	}
+
+	if err := check.Run(cmd.Context()); err != nil {
+		log.Fatalln(styles.NewStyles().Error.Render(err.Error()))
+	}
+}
+
+// Run transfers the zone from every server, saves the first successful
+// transfer and reports the servers that allow transfers without TSIG.
+//
+// Args:
+//   - ctx: The context bounding every transfer.
+//
+// Returns:
+//   - error: An error if no server could be found or none allowed the transfer.
+func (Z ZoneTransferCheck) Run(ctx context.Context) error {
+	servers := Z.servers
+	if len(servers) == 0 {
+		var err error
+		servers, err = zoneNameservers(ctx, Z.request.Zone)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The report goes to stderr when the zone itself is written to stdout.
+	report := io.Writer(os.Stderr)
+	if Z.file != "" {
+		report = os.Stdout
+	}
+
+	var (
+		saved bool
+		open  []string
+	)
+	for _, server := range servers {
+		request := Z.request
+		request.Server = server
+
+		transfer, err := dnsquery.ZoneTransfer(ctx, request)
+		if err != nil {
+			fmt.Fprintln(report, styles.NewStyles().Error.Render(fmt.Sprintf("%s: %s", server, transferFailure(err))))
+		} else {
+			fmt.Fprintf(report, "%s: %s of %s allowed, serial %d, %d records in %s\n",
+				transfer.Server, transfer.Type, transfer.Zone, transfer.Serial, len(transfer.Records), transfer.Duration.Round(time.Millisecond),
+			)
+			if !saved {
+				if err := Z.save(transfer); err != nil {
+					return err
+				}
+				saved = true
+			}
+		}
+
+		// Without TSIG the transfer itself shows whether the server is open,
+		// with TSIG an unsigned probe is needed.
+		unauthenticated := err == nil && request.TSIG == nil
+		if request.TSIG != nil {
+			probe := request
+			probe.TSIG = nil
+			_, probeErr := dnsquery.ZoneTransfer(ctx, probe)
+			unauthenticated = probeErr == nil
+			if probeErr != nil {
+				fmt.Fprintf(report, "%s: unsigned transfer refused (%s)\n", server, transferFailure(probeErr))
+			}
+		}
+		if unauthenticated {
+			open = append(open, server)
+		}
+	}
+
+	if len(open) > 0 {
+		fmt.Fprintln(report, styles.NewStyles().Error.Render(fmt.Sprintf(
+			"⚠️ %d of %d servers allow zone transfers to unauthenticated clients: %s", len(open), len(servers), strings.Join(open, ", "),
+		)))
+	} else {
+		fmt.Fprintln(report, styles.NewStyles().Highlight.Render("✅ No server allows zone transfers to unauthenticated clients"))
+	}
+
+	if !saved {
+		return fmt.Errorf("no server allowed the transfer of %s", dns.Fqdn(Z.request.Zone))
+	}
+
+	return nil
+}
+
+// zoneNameservers looks up the NS records of a zone.
+//
+// Args:
+//   - ctx: The context bounding the lookup.
+//   - zone: The zone.
+//
+// Returns:
+//   - []string: The nameserver host names.
+//   - error: An error if the zone has no NS records.
+func zoneNameservers(ctx context.Context, zone string) ([]string, error) {
+	upstreams, err := dnsquery.NewUpstreams(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := upstreams.Lookup(ctx, dns.Fqdn(zone), "NS")
+	if err != nil {
+		return nil, fmt.Errorf("could not find the nameservers of %s: %w", dns.Fqdn(zone), err)
+	}
+
+	var servers []string
+	for _, rr := range response.Msg.Answer {
+		if ns, ok := rr.(*dns.NS); ok {
+			servers = append(servers, strings.TrimSuffix(ns.Ns, "."))
+		}
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("%s has no NS records, use --server", dns.Fqdn(zone))
+	}
+
+	return servers, nil
+}
+
+// transferFailure describes why a transfer failed.
+//
+// Args:
+//   - err: The transfer error.
+//
+// Returns:
+//   - string: The reason, naming the rcode when the server refused.
+func transferFailure(err error) string {
+	var rcodeErr *dnsquery.RcodeError
+	if errors.As(err, &rcodeErr) {
+		return "transfer refused with " + dns.RcodeToString[rcodeErr.Rcode]
+	}
+
+	return err.Error()
+}
+
+// save writes a transfer to the output file or stdout.
+//
+// Args:
+//   - transfer: The transfer to write.
+//
+// Returns:
+//   - error: An error if the file cannot be written.
+func (Z ZoneTransferCheck) save(transfer dnsquery.Transfer) error {
+	out := io.Writer(os.Stdout)
+	if Z.file != "" {
+		file, err := os.Create(Z.file)
+		if err != nil {
+			return fmt.Errorf("could not create '%s': %w", Z.file, err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if Z.output == transferJSON {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(newTransferOutput(transfer))
+	}
+
+	return writeMasterFile(out, transfer)
+}
+
+// newTransferOutput converts a transfer into its JSON form. Incremental
+// transfers list their changes instead of the raw records.
+//
+// Args:
+//   - transfer: The transfer.
+//
+// Returns:
+//   - TransferOutput: The JSON output.
+func newTransferOutput(transfer dnsquery.Transfer) TransferOutput {
+	output := TransferOutput{
+		Zone:          transfer.Zone,
+		Server:        transfer.Server,
+		Type:          transfer.Type,
+		Serial:        transfer.Serial,
+		Incremental:   transfer.Incremental,
+		Authenticated: transfer.Authenticated,
+		DurationMs:    float64(transfer.Duration) / float64(time.Millisecond),
+	}
+
+	if !transfer.Incremental {
+		output.Records = transferRecords(transfer.Records)
+		return output
+	}
+
+	output.Changes = make([]TransferChange, 0, len(transfer.Changes))
+	for _, change := range transfer.Changes {
+		output.Changes = append(output.Changes, TransferChange{
+			FromSerial: change.FromSerial,
+			ToSerial:   change.ToSerial,
+			Deleted:    transferRecords(change.Deleted),
+			Added:      transferRecords(change.Added),
+		})
+	}
+
+	return output
+}
+
+// transferRecords converts resource records into structured records.
+//
+// Args:
+//   - rrs: The resource records.
+//
+// Returns:
+//   - []dnsquery.Record: The structured records.
+func transferRecords(rrs []dns.RR) []dnsquery.Record {
+	records := make([]dnsquery.Record, 0, len(rrs))
+	for _, rr := range rrs {
+		records = append(records, dnsquery.NewRecord(rr))
+	}
+
+	return records
+}
+
+// writeMasterFile writes a transfer as an RFC 1035 master file. Incremental
+// transfers are written as comments listing the deleted and added records.
+//
+// Args:
+//   - out: The writer.
+//   - transfer: The transfer.
+//
+// Returns:
+//   - error: An error if the file cannot be written.
+func writeMasterFile(out io.Writer, transfer dnsquery.Transfer) error {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "; %s of %s from %s, serial %d, %s\n",
+		transfer.Type, transfer.Zone, transfer.Server, transfer.Serial, time.Now().UTC().Format(time.RFC3339),
+	)
+	fmt.Fprintf(&builder, "$ORIGIN %s\n", transfer.Zone)
+
+	if transfer.Incremental {
+		if len(transfer.Changes) == 0 {
+			fmt.Fprintf(&builder, "; serial %d is current, no changes\n", transfer.Serial)
+		}
+		for _, change := range transfer.Changes {
+			fmt.Fprintf(&builder, "; serial %d -> %d\n", change.FromSerial, change.ToSerial)
+			for _, rr := range change.Deleted {
+				fmt.Fprintf(&builder, "; - %s\n", rr.String())
+			}
+			for _, rr := range change.Added {
+				fmt.Fprintf(&builder, "; + %s\n", rr.String())
+			}
+		}
+	} else {
+		records := transfer.Records
+		// A full transfer ends with the SOA it started with.
+		if len(records) > 1 && records[len(records)-1].Header().Rrtype == dns.TypeSOA {
+			records = records[:len(records)-1]
+		}
+		for _, rr := range records {
+			builder.WriteString(rr.String() + "\n")
+		}
+	}
+
+	_, err := io.WriteString(out, builder.String())
+	return err
+}
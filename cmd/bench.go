@@ -0,0 +1,650 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"commandCenter/dnsquery"
+	"commandCenter/styles"
+	"commandCenter/validators"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+)
+
+// benchDomains is the generated query mix used when no input file is given.
+var benchDomains = []string{
+	"google.com", "youtube.com", "facebook.com", "wikipedia.org", "amazon.com",
+	"github.com", "cloudflare.com", "microsoft.com", "apple.com", "netflix.com",
+	"reddit.com", "linkedin.com", "instagram.com", "yahoo.com", "bing.com",
+}
+
+// benchBuckets are the upper bounds of the latency histogram.
+var benchBuckets = []time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	20 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 2 * time.Second,
+}
+
+type Bench struct {
+	servers     []string
+	transport   dnsquery.Transport
+	queries     []BulkQuery
+	random      float64
+	concurrency int
+	qps         float64
+	duration    time.Duration
+}
+
+type BenchLatency struct {
+	Count  int     `json:"count"`
+	MeanMs float64 `json:"mean_ms"`
+	P50Ms  float64 `json:"p50_ms"`
+	P90Ms  float64 `json:"p90_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+type BenchBucket struct {
+	Le    string `json:"le"`
+	Count int    `json:"count"`
+}
+
+type BenchCache struct {
+	Cold     BenchLatency `json:"cold"`
+	Warm     BenchLatency `json:"warm"`
+	Repeats  int          `json:"repeats"`
+	Hits     int          `json:"hits"`
+	HitRatio float64      `json:"hit_ratio"`
+}
+
+type BenchReport struct {
+	Server      string         `json:"server"`
+	Transport   string         `json:"transport"`
+	DurationS   float64        `json:"duration_s"`
+	Concurrency int            `json:"concurrency"`
+	TargetQPS   float64        `json:"target_qps"`
+	Queries     int            `json:"queries"`
+	Answered    int            `json:"answered"`
+	QPS         float64        `json:"qps"`
+	Rcodes      map[string]int `json:"rcodes"`
+	Timeouts    int            `json:"timeouts"`
+	Errors      int            `json:"errors"`
+	ErrorSample []string       `json:"error_sample,omitempty"`
+	Latency     BenchLatency   `json:"latency"`
+	Histogram   []BenchBucket  `json:"histogram"`
+	Cache       BenchCache     `json:"cache"`
+}
+
+// benchSample is the outcome of one query, late when it finished after the
+// end of the run.
+type benchSample struct {
+	key     string
+	repeat  bool
+	latency time.Duration
+	rcode   string
+	ttl     uint32
+	hasTTL  bool
+	err     error
+	late    bool
+}
+
+var benchCmd = &cobra.Command{
+	Use:     "bench",
+	Short:   "Benchmark the performance of DNS resolvers.",
+	Long:    "Send a query mix to one or more resolvers at a target QPS or concurrency for a set duration and report throughput, latency, rcodes, timeouts and cache behaviour.",
+	Aliases: []string{"benchmark", "perf", "load"},
+	Example: `
+      # Benchmark the default resolver for 10s with 10 concurrent clients
+      ops dns bench
+
+      # Compare two internal resolvers at 500 QPS for a minute
+      ops dns bench -s 10.0.0.2 -s 10.0.0.3 --qps 500 --duration 1m
+
+      # Replay a query file (one domain per line, optionally followed by types) against a local server
+      ops dns bench -s 127.0.0.1:8888 -i queries.txt -c 50
+
+      # Force cache misses on 20% of the queries with random labels and print JSON
+      ops dns bench -s 1.1.1.1 -d example.com -d example.org --types a,aaaa --random 0.2 -o json
+
+      # Get help for the bench command
+      ops dns bench --help
+    `,
+
+	Run: benchResolvers,
+}
+
+// init initializes the bench command and its flags.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - None
+func init() {
+	dnsCmd.AddCommand(benchCmd)
+	addTransportFlags(benchCmd)
+
+	benchCmd.Flags().StringSliceP("server", "s", []string{}, "resolver to benchmark as host[:port] or DoH URL, repeat to compare several (default 8.8.8.8)")
+	benchCmd.Flags().StringP("input", "i", "", "file with one domain per line, optionally followed by record types, or - for stdin")
+	benchCmd.Flags().StringSliceP("domain", "d", benchDomains, "domains of the generated query mix")
+	benchCmd.Flags().StringSlice("types", []string{"A", "AAAA", "MX", "TXT"}, "record types of the generated query mix and of input lines that name none")
+	benchCmd.Flags().Float64("random", 0, "share of queries, between 0 and 1, prefixed with a random label to force cache misses")
+	benchCmd.Flags().IntP("concurrency", "c", 10, "number of concurrent clients")
+	benchCmd.Flags().Float64("qps", 0, "target queries per second across all clients, 0 for as fast as possible")
+	benchCmd.Flags().Duration("duration", 10*time.Second, "duration of the benchmark for each resolver")
+	benchCmd.Flags().StringP("output", "o", outputPlain, "output format: plain or json")
+
+	benchCmd.MarkFlagsMutuallyExclusive("input", "domain")
+}
+
+// benchResolvers is the main function for the bench command.
+//
+// Args:
+//   - cmd: The cobra command.
+//   - args: The command arguments.
+//
+// Returns:
+//   - None
+func benchResolvers(cmd *cobra.Command, args []string) {
+	servers, err := validators.VerifyStringSliceInputs(cmd, "server")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if len(servers) == 0 {
+		servers = []string{dnsquery.DefaultUpstream}
+	}
+
+	input, err := validators.VerifyStringInputs(cmd, "input")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	domains, err := validators.VerifyStringSliceInputs(cmd, "domain")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	types, err := validators.VerifyStringSliceInputs(cmd, "types")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	random, err := validators.VerifyFloatInputs(cmd, "random")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if random < 0 || random > 1 {
+		log.Fatalln(styles.NewStyles().Error.Render("--random must be between 0 and 1"))
+	}
+
+	concurrency, err := validators.VerifyIntInputs(cmd, "concurrency")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	qps, err := qpsFromFlags(cmd)
+	if err != nil {
+		log.Fatalln(styles.NewStyles().Error.Render(err.Error()))
+	}
+
+	duration, err := validators.VerifyDurationInputs(cmd, "duration")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	output, err := validators.VerifyStringInputs(cmd, "output")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	output = strings.ToLower(output)
+	if output != outputPlain && output != outputJSON {
+		log.Fatalln(styles.NewStyles().Error.Render(fmt.Sprintf("unknown output format '%s', use plain or json", output)))
+	}
+
+	transport, err := transportFromFlags(cmd)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	queries, err := benchQueries(input, domains, types)
+	if err != nil {
+		log.Fatalln(styles.NewStyles().Error.Render(err.Error()))
+	}
+
+	bench := Bench{
+		servers:     servers,
+		transport:   transport,
+		queries:     queries,
+		random:      random,
+		concurrency: max(concurrency, 1),
+		qps:         qps,
+		duration:    duration,
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+	defer stop()
+
+	var reports []BenchReport
+	for _, server := range bench.servers {
+		if output == outputPlain {
+			fmt.Printf(styles.NewStyles().Title.Render("⏱️ Benchmarking %s over %s for %s ⏱️"), server, transport.Name(), duration)
+			fmt.Println()
+		}
+
+		report, err := bench.Run(ctx, server)
+		if err != nil {
+			log.Fatalln(styles.NewStyles().Error.Render(err.Error()))
+		}
+		reports = append(reports, report)
+
+		if output == outputPlain {
+			printBenchReport(report)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if output == outputJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(reports); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	if len(reports) > 1 {
+		printBenchComparison(reports)
+	}
+}
+
+// benchQueries builds the query mix from an input file in the bulk format,
+// or from every combination of the given domains and types.
+//
+// Args:
+//   - input: The path to the query file, "-" for stdin, empty to generate the mix.
+//   - domains: The domains of the generated mix.
+//   - types: The record types of the generated mix and of input lines naming none.
+//
+// Returns:
+//   - []BulkQuery: The query mix.
+//   - error: An error if the file cannot be read, a type is unknown or the mix is empty.
+func benchQueries(input string, domains, types []string) ([]BulkQuery, error) {
+	var queries []BulkQuery
+	if input == "" {
+		for _, domain := range domains {
+			for _, qtype := range types {
+				queries = append(queries, BulkQuery{name: domain, qtype: qtype})
+			}
+		}
+	} else {
+		reader, err := openBulkInput(input)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+
+		stream := make(chan BulkQuery)
+		readErr := make(chan error, 1)
+		go func() {
+			readErr <- readBulkQueries(reader, types, stream)
+		}()
+		for query := range stream {
+			queries = append(queries, query)
+		}
+		if err := <-readErr; err != nil {
+			return nil, fmt.Errorf("could not read '%s': %w", input, err)
+		}
+	}
+
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("the query mix is empty")
+	}
+	for _, query := range queries {
+		if _, err := dnsquery.ParseType(query.qtype); err != nil {
+			return nil, err
+		}
+	}
+
+	return queries, nil
+}
+
+// Run benchmarks one resolver, cycling through the query mix with concurrent
+// clients until the duration is over or the context is stopped.
+//
+// Args:
+//   - ctx: The context stopping the benchmark early.
+//   - server: The resolver to benchmark.
+//
+// Returns:
+//   - BenchReport: The throughput, latency, rcode and cache statistics.
+//   - error: An error if the server is not a valid upstream.
+func (B Bench) Run(ctx context.Context, server string) (BenchReport, error) {
+	upstreams, err := dnsquery.NewUpstreams([]string{server}, B.transport)
+	if err != nil {
+		return BenchReport{}, err
+	}
+	domain := Domain{resolver: upstreams}
+
+	runCtx, cancel := context.WithTimeout(ctx, B.duration)
+	defer cancel()
+
+	limiter, stop := newRateLimiter(B.qps)
+	defer stop()
+
+	var (
+		wg   sync.WaitGroup
+		sent atomic.Int64
+	)
+	samples := make(chan benchSample, B.concurrency)
+	start := time.Now()
+	for range B.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if limiter != nil {
+					select {
+					case <-limiter:
+					case <-runCtx.Done():
+						return
+					}
+				}
+				if runCtx.Err() != nil {
+					return
+				}
+
+				query := B.queries[(sent.Add(1)-1)%int64(len(B.queries))]
+				name := query.name
+				if B.random > 0 && rand.Float64() < B.random {
+					name = fmt.Sprintf("%08x.%s", rand.Uint32(), name)
+				}
+
+				D := domain
+				D.domainName = name
+				queryStart := time.Now()
+				// In-flight queries use the parent context so they are not
+				// cut short and counted as timeouts when the duration ends.
+				response, err := D.PrepareDnsCall(ctx, query.qtype)
+				sample := newBenchSample(strings.ToLower(dns.Fqdn(name))+" "+strings.ToUpper(query.qtype), time.Since(queryStart), response, err)
+				sample.late = runCtx.Err() != nil
+				samples <- sample
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(samples)
+	}()
+
+	// The run ends with runCtx, not once the queries still in flight have
+	// come back, so the throughput is measured over the duration itself.
+	var (
+		collected []benchSample
+		elapsed   time.Duration
+	)
+	seen := map[string]bool{}
+	ended := runCtx.Done()
+	for pending := samples; pending != nil; {
+		select {
+		case <-ended:
+			elapsed, ended = time.Since(start), nil
+		case sample, ok := <-pending:
+			if !ok {
+				pending = nil
+				continue
+			}
+			sample.repeat = seen[sample.key]
+			seen[sample.key] = true
+			collected = append(collected, sample)
+		}
+	}
+	if ended != nil {
+		elapsed = time.Since(start)
+	}
+
+	report := newBenchReport(collected, elapsed)
+	report.Server = upstreams.Servers()[0]
+	report.Transport = B.transport.Name()
+	report.Concurrency = B.concurrency
+	report.TargetQPS = B.qps
+
+	return report, nil
+}
+
+// newBenchSample records the outcome of a single query.
+//
+// Args:
+//   - key: The question, used to find repeated queries.
+//   - latency: The time the query took.
+//   - response: The response.
+//   - err: The error returned instead of a response.
+//
+// Returns:
+//   - benchSample: The sample.
+func newBenchSample(key string, latency time.Duration, response dnsquery.Response, err error) benchSample {
+	sample := benchSample{key: key, latency: latency, err: err}
+	if err != nil {
+		return sample
+	}
+
+	sample.rcode = dns.RcodeToString[response.Msg.Rcode]
+	for _, rr := range response.Msg.Answer {
+		if !sample.hasTTL || rr.Header().Ttl < sample.ttl {
+			sample.ttl, sample.hasTTL = rr.Header().Ttl, true
+		}
+	}
+	if !sample.hasTTL {
+		for _, rr := range response.Msg.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				sample.ttl, sample.hasTTL = soa.Hdr.Ttl, true
+			}
+		}
+	}
+
+	return sample
+}
+
+// newBenchReport aggregates the samples of a run. A repeated query counts as
+// a cache hit when its TTL has counted down from the highest TTL seen for
+// the same question. The throughput only counts the answers received before
+// the end of the run.
+//
+// Args:
+//   - samples: The samples in the order they completed.
+//   - elapsed: The duration of the run.
+//
+// Returns:
+//   - BenchReport: The aggregated report.
+func newBenchReport(samples []benchSample, elapsed time.Duration) BenchReport {
+	report := BenchReport{
+		DurationS: elapsed.Seconds(),
+		Queries:   len(samples),
+		Rcodes:    map[string]int{},
+		Histogram: make([]BenchBucket, len(benchBuckets)+1),
+	}
+	for i, bound := range benchBuckets {
+		report.Histogram[i].Le = bound.String()
+	}
+	report.Histogram[len(benchBuckets)].Le = "+Inf"
+
+	maxTTL := map[string]uint32{}
+	for _, sample := range samples {
+		if sample.hasTTL {
+			maxTTL[sample.key] = max(maxTTL[sample.key], sample.ttl)
+		}
+	}
+
+	var (
+		all, cold, warm []time.Duration
+		answeredInTime  int
+	)
+	for _, sample := range samples {
+		if sample.err != nil {
+			var timeoutErr *dnsquery.TimeoutError
+			if errors.As(sample.err, &timeoutErr) {
+				report.Timeouts++
+			} else {
+				report.Errors++
+				if message := sample.err.Error(); len(report.ErrorSample) < 5 && !slices.Contains(report.ErrorSample, message) {
+					report.ErrorSample = append(report.ErrorSample, message)
+				}
+			}
+			continue
+		}
+
+		report.Answered++
+		if !sample.late {
+			answeredInTime++
+		}
+		report.Rcodes[sample.rcode]++
+		all = append(all, sample.latency)
+
+		bucket, _ := slices.BinarySearch(benchBuckets, sample.latency)
+		report.Histogram[bucket].Count++
+
+		if !sample.repeat {
+			cold = append(cold, sample.latency)
+			continue
+		}
+		warm = append(warm, sample.latency)
+		report.Cache.Repeats++
+		if sample.hasTTL && sample.ttl < maxTTL[sample.key] {
+			report.Cache.Hits++
+		}
+	}
+
+	// Answers that came back after the run ended are reported but not
+	// counted in the throughput of the run.
+	report.QPS = float64(answeredInTime) / max(elapsed.Seconds(), 1e-9)
+	report.Latency = newBenchLatency(all)
+	report.Cache.Cold = newBenchLatency(cold)
+	report.Cache.Warm = newBenchLatency(warm)
+	if report.Cache.Repeats > 0 {
+		report.Cache.HitRatio = float64(report.Cache.Hits) / float64(report.Cache.Repeats)
+	}
+
+	return report
+}
+
+// newBenchLatency computes latency statistics.
+//
+// Args:
+//   - latencies: The latencies, in any order.
+//
+// Returns:
+//   - BenchLatency: The mean and percentiles in milliseconds.
+func newBenchLatency(latencies []time.Duration) BenchLatency {
+	if len(latencies) == 0 {
+		return BenchLatency{}
+	}
+
+	slices.Sort(latencies)
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+
+	return BenchLatency{
+		Count:  len(latencies),
+		MeanMs: milliseconds(total / time.Duration(len(latencies))),
+		P50Ms:  milliseconds(percentile(latencies, 0.50)),
+		P90Ms:  milliseconds(percentile(latencies, 0.90)),
+		P99Ms:  milliseconds(percentile(latencies, 0.99)),
+		MaxMs:  milliseconds(latencies[len(latencies)-1]),
+	}
+}
+
+// milliseconds converts a duration to fractional milliseconds.
+//
+// Args:
+//   - duration: The duration.
+//
+// Returns:
+//   - float64: The duration in milliseconds.
+func milliseconds(duration time.Duration) float64 {
+	return float64(duration) / float64(time.Millisecond)
+}
+
+// printBenchReport prints the report of one resolver with a latency histogram.
+//
+// Args:
+//   - report: The report.
+//
+// Returns:
+//   - None
+func printBenchReport(report BenchReport) {
+	fmt.Printf("queries: %d  answered: %d  throughput: %.1f qps  timeouts: %d  errors: %d\n",
+		report.Queries, report.Answered, report.QPS, report.Timeouts, report.Errors,
+	)
+
+	rcodes := make([]string, 0, len(report.Rcodes))
+	for rcode, count := range report.Rcodes {
+		rcodes = append(rcodes, fmt.Sprintf("%s: %d", rcode, count))
+	}
+	slices.Sort(rcodes)
+	if len(rcodes) == 0 {
+		rcodes = []string{"none"}
+	}
+	fmt.Printf("rcodes: %s\n", strings.Join(rcodes, "  "))
+
+	for _, message := range report.ErrorSample {
+		fmt.Println(styles.NewStyles().Error.Render(message))
+	}
+
+	fmt.Printf("latency mean: %.3fms  p50: %.3fms  p90: %.3fms  p99: %.3fms  max: %.3fms\n",
+		report.Latency.MeanMs, report.Latency.P50Ms, report.Latency.P90Ms, report.Latency.P99Ms, report.Latency.MaxMs,
+	)
+
+	widest := 0
+	for _, bucket := range report.Histogram {
+		widest = max(widest, bucket.Count)
+	}
+	for _, bucket := range report.Histogram {
+		if bucket.Count == 0 {
+			continue
+		}
+		bar := strings.Repeat("█", max(bucket.Count*40/max(widest, 1), 1))
+		fmt.Printf("  ≤ %-6s %s %d\n", bucket.Le, styles.NewStyles().Highlight.Render(bar), bucket.Count)
+	}
+
+	fmt.Printf("cache cold p50: %.3fms (%d)  warm p50: %.3fms (%d)  hits: %d/%d (%.1f%%)\n\n",
+		report.Cache.Cold.P50Ms, report.Cache.Cold.Count, report.Cache.Warm.P50Ms, report.Cache.Warm.Count,
+		report.Cache.Hits, report.Cache.Repeats, report.Cache.HitRatio*100,
+	)
+}
+
+// printBenchComparison prints one row per resolver to compare them.
+//
+// Args:
+//   - reports: The reports of every resolver.
+//
+// Returns:
+//   - None
+func printBenchComparison(reports []BenchReport) {
+	fmt.Println(styles.NewStyles().Title.Render("Comparison"))
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "SERVER\tQPS\tP50\tP90\tP99\tMAX\tTIMEOUTS\tERRORS\tCACHE HITS")
+	for _, report := range reports {
+		fmt.Fprintf(writer, "%s\t%.1f\t%.3fms\t%.3fms\t%.3fms\t%.3fms\t%d\t%d\t%.1f%%\n",
+			report.Server, report.QPS, report.Latency.P50Ms, report.Latency.P90Ms, report.Latency.P99Ms,
+			report.Latency.MaxMs, report.Timeouts, report.Errors, report.Cache.HitRatio*100,
+		)
+	}
+	writer.Flush()
+}
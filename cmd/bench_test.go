@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewBenchReportThroughput(t *testing.T) {
+	samples := []benchSample{
+		{key: "a.example.test. A", rcode: "NOERROR", latency: time.Millisecond},
+		{key: "b.example.test. A", rcode: "NOERROR", latency: time.Millisecond},
+		{key: "c.example.test. A", rcode: "NOERROR", latency: time.Millisecond},
+		{key: "d.example.test. A", rcode: "NOERROR", latency: 300 * time.Millisecond, late: true},
+	}
+
+	report := newBenchReport(samples, 2*time.Second)
+	if report.Queries != 4 || report.Answered != 4 {
+		t.Errorf("queries %d answered %d, want every sample reported", report.Queries, report.Answered)
+	}
+	if report.QPS != 1.5 {
+		t.Errorf("qps = %g, want 1.5 from the answers received within the run", report.QPS)
+	}
+}
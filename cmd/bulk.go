@@ -0,0 +1,466 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"commandCenter/dnsquery"
+	"commandCenter/styles"
+	"commandCenter/validators"
+
+	"github.com/goccy/go-yaml"
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+)
+
+const (
+	statusTimeout = "TIMEOUT"
+	statusError   = "ERROR"
+
+	// maxQPS is the highest --qps accepted, far beyond what one client sends.
+	maxQPS = 1e6
+)
+
+type BulkQuery struct {
+	name  string
+	qtype string
+}
+
+type BulkOptions struct {
+	input   string
+	workers int
+	qps     float64
+}
+
+type BulkSummary struct {
+	Total    int
+	Success  int
+	NoData   int
+	NXDomain int
+	ServFail int
+	Timeouts int
+	Errors   int
+	P50      time.Duration
+	P90      time.Duration
+	P99      time.Duration
+	Max      time.Duration
+}
+
+// openBulkInput opens the list of domains to resolve, "-" meaning stdin.
+//
+// Args:
+//   - input: The path to the list or "-".
+//
+// Returns:
+//   - io.ReadCloser: The list reader.
+//   - error: An error if the file cannot be opened.
+func openBulkInput(input string) (io.ReadCloser, error) {
+	if input == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+
+	file, err := os.Open(input)
+	if err != nil {
+		return nil, fmt.Errorf("could not open '%s': %w", input, err)
+	}
+
+	return file, nil
+}
+
+// readBulkQueries streams queries from a list with one domain per line,
+// optionally followed by record types. Blank lines and # comments are skipped.
+//
+// Args:
+//   - reader: The list reader.
+//   - defaultTypes: The record types used for lines that name none.
+//   - queries: The channel receiving the queries, closed once the list is read.
+//
+// Returns:
+//   - error: An error if the list cannot be read.
+func readBulkQueries(reader io.Reader, defaultTypes []string, queries chan<- BulkQuery) error {
+	defer close(queries)
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ','
+		})
+		if len(fields) == 0 {
+			continue
+		}
+
+		types := fields[1:]
+		if len(types) == 0 {
+			types = defaultTypes
+		}
+		for _, qtype := range types {
+			queries <- BulkQuery{name: fields[0], qtype: qtype}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// ResolveBulk resolves every domain from the bulk input with a bounded worker
+// pool, streaming each result as it finishes and ending with a summary.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//
+// Returns:
+//   - error: An error if the bulk input cannot be opened.
+func (D Domain) ResolveBulk(ctx context.Context) error {
+	reader, err := openBulkInput(D.bulk.input)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	defaultTypes := []string{D.qtype}
+	if D.all {
+		defaultTypes = D.recordTypes
+	}
+
+	queries := make(chan BulkQuery)
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- readBulkQueries(reader, defaultTypes, queries)
+	}()
+
+	limiter, stop := newRateLimiter(D.bulk.qps)
+	defer stop()
+
+	results := make(chan dnsquery.Result)
+	var wg sync.WaitGroup
+	for range max(D.bulk.workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for query := range queries {
+				if limiter != nil {
+					<-limiter
+				}
+				results <- D.bulkResult(ctx, query)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var (
+		summary   BulkSummary
+		latencies []time.Duration
+	)
+	for result := range results {
+		summary.add(result)
+		if result.Server != "" {
+			latencies = append(latencies, time.Duration(result.RttMs*float64(time.Millisecond)))
+		}
+
+		if err := D.streamResult(result); err != nil {
+			fmt.Println(styles.NewStyles().Error.Render(fmt.Sprintf("Could not render result: %s", err)))
+		}
+	}
+	summary.setLatencies(latencies)
+
+	if err := <-readErr; err != nil {
+		fmt.Println(styles.NewStyles().Error.Render(fmt.Sprintf("Could not read '%s': %s", D.bulk.input, err)))
+	}
+
+	D.printBulkSummary(summary)
+
+	return nil
+}
+
+// newRateLimiter returns a channel that ticks at most qps times per second.
+//
+// Args:
+//   - qps: The maximum queries per second, 0 for unlimited.
+//
+// Returns:
+//   - <-chan time.Time: The ticks to wait for before each query, nil when unlimited.
+//   - func(): Stops the limiter.
+func newRateLimiter(qps float64) (<-chan time.Time, func()) {
+	if qps <= 0 {
+		return nil, func() {}
+	}
+
+	// Rates above one per nanosecond would round the interval down to zero,
+	// which the ticker refuses.
+	ticker := time.NewTicker(max(time.Duration(float64(time.Second)/qps), time.Nanosecond))
+
+	return ticker.C, ticker.Stop
+}
+
+// qpsFromFlags reads the --qps flag, rejecting rates that cannot be paced.
+//
+// Args:
+//   - cmd: The cobra command.
+//
+// Returns:
+//   - float64: The maximum queries per second, 0 or less for unlimited.
+//   - error: An error if the flag cannot be parsed or is not a usable rate.
+func qpsFromFlags(cmd *cobra.Command) (float64, error) {
+	qps, err := validators.VerifyFloatInputs(cmd, "qps")
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(qps) || qps > maxQPS {
+		return 0, fmt.Errorf("invalid --qps %g, use at most %g queries per second or 0 for unlimited", qps, float64(maxQPS))
+	}
+
+	return qps, nil
+}
+
+// throttledResolver waits for a rate limiter tick before every query, so
+// helpers that take a resolver, such as the NSEC walk, share the --qps budget.
+type throttledResolver struct {
+	dnsquery.Resolver
+	limiter <-chan time.Time
+}
+
+// wait blocks until the next tick of the limiter.
+//
+// Args:
+//   - ctx: The context bounding the wait.
+//
+// Returns:
+//   - error: The context error if it is done first.
+func (T throttledResolver) wait(ctx context.Context) error {
+	if T.limiter == nil {
+		return nil
+	}
+
+	select {
+	case <-T.limiter:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Lookup resolves a name once the limiter allows it.
+//
+// Args:
+//   - ctx: The context bounding the wait and the query.
+//   - name: The name to resolve.
+//   - qtype: The query type.
+//
+// Returns:
+//   - dnsquery.Response: The response of the wrapped resolver.
+//   - error: The context error, or the error of the wrapped resolver.
+func (T throttledResolver) Lookup(ctx context.Context, name, qtype string) (dnsquery.Response, error) {
+	if err := T.wait(ctx); err != nil {
+		return dnsquery.Response{}, err
+	}
+
+	return T.Resolver.Lookup(ctx, name, qtype)
+}
+
+// Exchange sends a message once the limiter allows it.
+//
+// Args:
+//   - ctx: The context bounding the wait and the query.
+//   - m: The DNS message.
+//
+// Returns:
+//   - dnsquery.Response: The response of the wrapped resolver.
+//   - error: The context error, or the error of the wrapped resolver.
+func (T throttledResolver) Exchange(ctx context.Context, m *dns.Msg) (dnsquery.Response, error) {
+	if err := T.wait(ctx); err != nil {
+		return dnsquery.Response{}, err
+	}
+
+	return T.Resolver.Exchange(ctx, m)
+}
+
+// bulkResult resolves a single bulk query without aborting on errors.
+//
+// Args:
+//   - ctx: The context bounding the query.
+//   - query: The query to resolve.
+//
+// Returns:
+//   - dnsquery.Result: The structured result, with a TIMEOUT or ERROR status on failure.
+func (D Domain) bulkResult(ctx context.Context, query BulkQuery) dnsquery.Result {
+	response, err := dnsquery.Negative(D.resolver.Lookup(ctx, query.name, query.qtype))
+	if err == nil {
+		result := dnsquery.NewResult(response)
+		if D.follow {
+			if chain, err := D.followChain(ctx, response); err == nil {
+				result.Chain = &chain
+			}
+		}
+		return result
+	}
+
+	result := dnsquery.Result{
+		Question: dnsquery.Question{Name: dns.Fqdn(query.name), Type: strings.ToUpper(query.qtype), Class: "IN"},
+		Status:   statusError,
+		Error:    err.Error(),
+	}
+
+	var timeoutErr *dnsquery.TimeoutError
+	if errors.As(err, &timeoutErr) {
+		result.Status = statusTimeout
+	}
+
+	return result
+}
+
+// streamResult prints a single bulk result in the selected output format.
+// JSON is written as one object per line and YAML as one document per result.
+//
+// Args:
+//   - result: The result to print.
+//
+// Returns:
+//   - error: An error if the result cannot be encoded.
+func (D Domain) streamResult(result dnsquery.Result) error {
+	switch D.output {
+	case outputJSON:
+		return json.NewEncoder(os.Stdout).Encode(result)
+	case outputYAML:
+		encoded, err := yaml.Marshal(result)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Printf("---\n%s", encoded)
+		return err
+	case outputTable:
+		values := make([]string, 0, len(result.Answer))
+		for _, record := range result.Answer {
+			values = append(values, record.Value)
+		}
+		if result.Error != "" {
+			values = append(values, result.Error)
+		}
+		if warning := chainWarning(result.Chain); warning != "" {
+			values = append(values, warning)
+		}
+		_, err := fmt.Printf("%-40s %-6s %-9s %9.3fms  %s\n",
+			result.Question.Name, result.Question.Type, result.Status, result.RttMs, strings.Join(values, ", "),
+		)
+		return err
+	}
+
+	line := fmt.Sprintf("%s %s %s", result.Question.Name, result.Question.Type, result.Status)
+	switch result.Status {
+	case dns.RcodeToString[dns.RcodeSuccess]:
+		fmt.Println(styles.NewStyles().Title.Render(line))
+		for _, record := range result.Answer {
+			fmt.Println(styles.NewStyles().Highlight.Render(
+				fmt.Sprintf("%s %d %s %s", record.Name, record.TTL, record.Type, record.Value),
+			))
+		}
+	case statusTimeout, statusError:
+		fmt.Println(styles.NewStyles().Error.Render(fmt.Sprintf("%s: %s", line, result.Error)))
+	default:
+		fmt.Println(styles.NewStyles().Error.Render(line))
+	}
+	if warning := chainWarning(result.Chain); warning != "" {
+		fmt.Println(styles.NewStyles().Error.Render(warning))
+	}
+
+	return nil
+}
+
+// add counts a result in the summary.
+//
+// Args:
+//   - result: The result to count.
+//
+// Returns:
+//   - None
+func (S *BulkSummary) add(result dnsquery.Result) {
+	S.Total++
+
+	switch result.Status {
+	case dns.RcodeToString[dns.RcodeSuccess]:
+		S.Success++
+	case dnsquery.StatusNoData:
+		S.NoData++
+	case dns.RcodeToString[dns.RcodeNameError]:
+		S.NXDomain++
+	case dns.RcodeToString[dns.RcodeServerFailure]:
+		S.ServFail++
+	case statusTimeout:
+		S.Timeouts++
+	default:
+		S.Errors++
+	}
+}
+
+// setLatencies fills the latency percentiles of the summary.
+//
+// Args:
+//   - latencies: The round trip times of every answered query.
+//
+// Returns:
+//   - None
+func (S *BulkSummary) setLatencies(latencies []time.Duration) {
+	if len(latencies) == 0 {
+		return
+	}
+
+	slices.Sort(latencies)
+	S.P50 = percentile(latencies, 0.50)
+	S.P90 = percentile(latencies, 0.90)
+	S.P99 = percentile(latencies, 0.99)
+	S.Max = latencies[len(latencies)-1]
+}
+
+// percentile returns the nearest-rank percentile of sorted durations.
+//
+// Args:
+//   - sorted: The durations in ascending order.
+//   - p: The percentile between 0 and 1.
+//
+// Returns:
+//   - time.Duration: The duration at that percentile.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+// printBulkSummary prints the bulk summary. Structured outputs write it to
+// stderr so stdout only carries results.
+//
+// Args:
+//   - summary: The summary to print.
+//
+// Returns:
+//   - None
+func (D Domain) printBulkSummary(summary BulkSummary) {
+	out := os.Stdout
+	if D.output == outputJSON || D.output == outputYAML {
+		out = os.Stderr
+	}
+
+	fmt.Fprintln(out, styles.NewStyles().Title.Render("Summary"))
+	fmt.Fprintf(out, "total: %d  success: %d  nodata: %d  nxdomain: %d  servfail: %d  timeouts: %d  errors: %d\n",
+		summary.Total, summary.Success, summary.NoData, summary.NXDomain, summary.ServFail, summary.Timeouts, summary.Errors,
+	)
+	fmt.Fprintf(out, "latency p50: %s  p90: %s  p99: %s  max: %s\n",
+		summary.P50.Round(time.Microsecond), summary.P90.Round(time.Microsecond),
+		summary.P99.Round(time.Microsecond), summary.Max.Round(time.Microsecond),
+	)
+}
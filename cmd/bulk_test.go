@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewRateLimiter(t *testing.T) {
+	for _, qps := range []float64{0, -1, 0.5, 1e6, 1e12} {
+		limiter, stop := newRateLimiter(qps)
+		if (limiter == nil) != (qps <= 0) {
+			t.Errorf("qps %g: limiter = %v", qps, limiter)
+		}
+		if limiter != nil && qps >= 1e6 {
+			select {
+			case <-limiter:
+			case <-time.After(time.Second):
+				t.Errorf("qps %g: no tick within a second", qps)
+			}
+		}
+		stop()
+	}
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"commandCenter/dnsquery"
+	"commandCenter/styles"
+
+	"github.com/miekg/dns"
+)
+
+// followChain follows the CNAME and DNAME aliases of the name a response
+// answers, for the type it was asked.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//   - response: The DNS response.
+//
+// Returns:
+//   - dnsquery.Chain: The chain.
+//   - error: An error if a query of the chain fails.
+func (D Domain) followChain(ctx context.Context, response dnsquery.Response) (dnsquery.Chain, error) {
+	if len(response.Msg.Question) == 0 {
+		return dnsquery.Chain{}, fmt.Errorf("the response has no question to follow")
+	}
+	question := response.Msg.Question[0]
+
+	return dnsquery.FollowChain(ctx, D.resolver, question.Name, dns.TypeToString[question.Qtype])
+}
+
+// printChain follows the aliases of a response and prints every hop with its
+// TTL, the final records and any loop, excessive length or dangling target
+// when --follow is set.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//   - response: The DNS response.
+//
+// Returns:
+//   - None
+func (D Domain) printChain(ctx context.Context, response dnsquery.Response) {
+	if !D.follow {
+		return
+	}
+
+	chain, err := D.followChain(ctx, response)
+	if err != nil {
+		fmt.Println(styles.NewStyles().Error.Render(fmt.Sprintf("could not follow the chain: %s", err)))
+		return
+	}
+
+	fmt.Printf(styles.NewStyles().Title.Render("🔗 Chain of %s (%d hops) 🔗"), chain.Name, len(chain.Hops))
+	fmt.Println()
+	for i, hop := range chain.Hops {
+		fmt.Printf("%2d. %s %d %s %s (from %s)\n", i+1, hop.Name, hop.TTL, hop.Type, hop.Target, hop.Server)
+	}
+
+	for _, record := range chain.Answer {
+		fmt.Println(styles.NewStyles().Highlight.Render(
+			fmt.Sprintf("%s %d %s %s", record.Name, record.TTL, record.Type, record.Value),
+		))
+	}
+
+	if warning := chainWarning(&chain); warning != "" {
+		fmt.Println(styles.NewStyles().Error.Render(warning))
+	} else if len(chain.Answer) == 0 {
+		fmt.Printf("no %s records at %s (%s)\n", chain.Type, chain.Final, chain.Rcode)
+	}
+}
+
+// chainWarning describes what is wrong with a chain.
+//
+// Args:
+//   - chain: The chain, nil when --follow is not set.
+//
+// Returns:
+//   - string: The warning, empty for a healthy chain.
+func chainWarning(chain *dnsquery.Chain) string {
+	switch {
+	case chain == nil:
+		return ""
+	case chain.Loop:
+		return fmt.Sprintf("alias loop: the chain of %s comes back to %s", chain.Name, chain.Final)
+	case chain.TooLong:
+		return fmt.Sprintf("chain too long: more than %d aliases from %s", dnsquery.MaxChainLength, chain.Name)
+	case chain.Dangling:
+		last := chain.Hops[len(chain.Hops)-1]
+		return fmt.Sprintf("dangling %s: %s points to %s which does not exist (NXDOMAIN), a subdomain takeover risk", last.Type, last.Name, last.Target)
+	}
+
+	return ""
+}
@@ -0,0 +1,505 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"commandCenter/dnsquery"
+	"commandCenter/styles"
+	"commandCenter/validators"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+)
+
+const (
+	sourceWordlist = "wordlist"
+	sourceNSEC     = "nsec"
+
+	// wildcardProbes is the number of random labels queried to detect wildcards.
+	wildcardProbes = 3
+)
+
+// defaultSubdomains are common host names tried when no wordlist is given.
+var defaultSubdomains = []string{
+	"www", "mail", "smtp", "imap", "pop", "webmail", "mx", "ns1", "ns2", "dns", "vpn", "remote",
+	"api", "app", "dev", "test", "staging", "stage", "beta", "demo", "admin", "portal", "intranet",
+	"git", "gitlab", "jenkins", "ci", "jira", "wiki", "docs", "blog", "shop", "cdn", "static",
+	"assets", "img", "media", "files", "ftp", "sftp", "db", "sql", "monitor", "grafana", "status",
+	"auth", "sso", "login", "m", "mobile", "autodiscover", "owa", "exchange",
+}
+
+type Enumeration struct {
+	domain      string
+	words       []string
+	types       []string
+	resolver    dnsquery.Resolver
+	concurrency int
+	qps         float64
+	nsec        bool
+	nsecLimit   int
+}
+
+type EnumHost struct {
+	Name    string              `json:"name"`
+	Sources []string            `json:"sources"`
+	Records map[string][]string `json:"records"`
+}
+
+type EnumReport struct {
+	Domain   string              `json:"domain"`
+	Wildcard map[string][]string `json:"wildcard"`
+	NSEC     *dnsquery.NSECWalk  `json:"nsec,omitempty"`
+	Queries  int                 `json:"queries"`
+	Filtered int                 `json:"wildcard_filtered"`
+	Hosts    []EnumHost          `json:"hosts"`
+}
+
+var enumCmd = &cobra.Command{
+	Use:     "enum",
+	Short:   "Enumerate the subdomains of a domain.",
+	Long:    "Discover the hosts of a domain by resolving candidates from a wordlist and by walking its NSEC chain, filtering out answers produced by wildcard records. Only use it on domains you are authorized to assess.",
+	Aliases: []string{"enumerate", "subdomains"},
+	Example: `
+      # Try the built-in list of common host names and walk the NSEC chain if the zone allows it
+      ops dns enum -d example.com
+
+      # Brute-force a wordlist with 50 concurrent queries, at most 200 per second, as JSON
+      ops dns enum -d example.com -w subdomains.txt -c 50 --qps 200 -o json
+
+      # Enumerate a zone served locally by ops server dns
+      ops dns enum -d example.test -w words.txt -s 127.0.0.1:8888
+
+      # Get help for the enum command
+      ops dns enum --help
+    `,
+
+	Run: enumerateDomain,
+}
+
+// init initializes the enum command and its flags.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - None
+func init() {
+	dnsCmd.AddCommand(enumCmd)
+	addUpstreamFlags(enumCmd)
+
+	enumCmd.Flags().StringP("domain", "d", "", "domain to enumerate")
+	enumCmd.Flags().StringP("wordlist", "w", "", "file with one candidate label per line, or - for stdin (default a built-in list of common names)")
+	enumCmd.Flags().StringSlice("types", []string{"A", "AAAA"}, "record types resolved for every candidate")
+	enumCmd.Flags().IntP("concurrency", "c", 20, "number of concurrent queries")
+	enumCmd.Flags().Float64("qps", 0, "maximum queries per second, 0 for unlimited")
+	enumCmd.Flags().Bool("nsec", true, "walk the NSEC chain of the zone when it is signed with NSEC")
+	enumCmd.Flags().Int("nsec-limit", 10000, "maximum number of names walked in the NSEC chain")
+	enumCmd.Flags().StringP("output", "o", outputPlain, "output format: plain or json")
+
+	enumCmd.MarkFlagRequired("domain")
+}
+
+// enumerateDomain is the main function for the enum command.
+//
+// Args:
+//   - cmd: The cobra command.
+//   - args: The command arguments.
+//
+// Returns:
+//   - None
+func enumerateDomain(cmd *cobra.Command, args []string) {
+	domain, err := validators.VerifyStringInputs(cmd, "domain")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	wordlist, err := validators.VerifyStringInputs(cmd, "wordlist")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	types, err := validators.VerifyStringSliceInputs(cmd, "types")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for i, qtype := range types {
+		if _, err := dnsquery.ParseType(qtype); err != nil {
+			log.Fatalln(styles.NewStyles().Error.Render(err.Error()))
+		}
+		types[i] = strings.ToUpper(qtype)
+	}
+
+	concurrency, err := validators.VerifyIntInputs(cmd, "concurrency")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	qps, err := qpsFromFlags(cmd)
+	if err != nil {
+		log.Fatalln(styles.NewStyles().Error.Render(err.Error()))
+	}
+
+	nsec, err := validators.VerifyBoolInputs(cmd, "nsec")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	nsecLimit, err := validators.VerifyIntInputs(cmd, "nsec-limit")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	output, err := validators.VerifyStringInputs(cmd, "output")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	output = strings.ToLower(output)
+	if output != outputPlain && output != outputJSON {
+		log.Fatalln(styles.NewStyles().Error.Render(fmt.Sprintf("unknown output format '%s', use plain or json", output)))
+	}
+
+	words := defaultSubdomains
+	if wordlist != "" {
+		words, err = readWordlist(wordlist)
+		if err != nil {
+			log.Fatalln(styles.NewStyles().Error.Render(err.Error()))
+		}
+	}
+
+	upstreams, err := upstreamsFromFlags(cmd)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	enumeration := Enumeration{
+		domain:      dns.CanonicalName(domain),
+		words:       words,
+		types:       types,
+		resolver:    upstreams,
+		concurrency: max(concurrency, 1),
+		qps:         qps,
+		nsec:        nsec,
+		nsecLimit:   nsecLimit,
+	}
+
+	report := enumeration.Run(cmd.Context())
+
+	if output == outputJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	printEnumReport(report)
+}
+
+// readWordlist reads candidate labels, one per line. Blank lines and #
+// comments are skipped and duplicates removed.
+//
+// Args:
+//   - wordlist: The path to the wordlist, or - for stdin.
+//
+// Returns:
+//   - []string: The labels.
+//   - error: An error if the wordlist cannot be read or is empty.
+func readWordlist(wordlist string) ([]string, error) {
+	reader, err := openBulkInput(wordlist)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var (
+		words []string
+		seen  = map[string]bool{}
+	)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		word := strings.Trim(strings.ToLower(strings.TrimSpace(line)), ".")
+		if word == "" || seen[word] {
+			continue
+		}
+		seen[word] = true
+		words = append(words, word)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read '%s': %w", wordlist, err)
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("'%s' has no candidate names", wordlist)
+	}
+
+	return words, nil
+}
+
+// Run detects wildcards, walks the NSEC chain and resolves every candidate.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//
+// Returns:
+//   - EnumReport: The hosts found.
+func (E Enumeration) Run(ctx context.Context) EnumReport {
+	limiter, stop := newRateLimiter(E.qps)
+	defer stop()
+	throttled := throttledResolver{Resolver: E.resolver, limiter: limiter}
+
+	report := EnumReport{Domain: E.domain, Wildcard: E.detectWildcard(ctx, throttled), Hosts: []EnumHost{}}
+	report.Queries = wildcardProbes * len(E.types)
+
+	sources := map[string][]string{}
+	for _, word := range E.words {
+		name := dns.CanonicalName(word + "." + E.domain)
+		sources[name] = append(sources[name], sourceWordlist)
+	}
+
+	if E.nsec {
+		walk := dnsquery.WalkNSEC(ctx, throttled, E.domain, E.nsecLimit)
+		report.NSEC = &walk
+		report.Queries += walk.Queries
+		for _, name := range walk.Names {
+			if !slices.Contains(sources[name.Name], sourceNSEC) {
+				sources[name.Name] = append(sources[name.Name], sourceNSEC)
+			}
+		}
+	}
+
+	names := make(chan string)
+	go func() {
+		defer close(names)
+		for _, name := range slices.Sorted(maps.Keys(sources)) {
+			select {
+			case names <- name:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for range E.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				host, queries, filtered := E.resolveHost(ctx, name, sources[name], report.Wildcard, limiter)
+
+				mu.Lock()
+				report.Queries += queries
+				report.Filtered += filtered
+				if len(host.Records) > 0 || slices.Contains(host.Sources, sourceNSEC) {
+					report.Hosts = append(report.Hosts, host)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	slices.SortFunc(report.Hosts, func(a, b EnumHost) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return report
+}
+
+// detectWildcard queries random labels under the domain for every type. Any
+// answer means a wildcard record, whose values are then used to filter out
+// candidates that only resolve through it.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//   - resolver: The resolver used for the probes, rate limited with --qps.
+//
+// Returns:
+//   - map[string][]string: The values answered for random labels, by type.
+func (E Enumeration) detectWildcard(ctx context.Context, resolver dnsquery.Resolver) map[string][]string {
+	wildcard := map[string][]string{}
+	for _, qtype := range E.types {
+		for range wildcardProbes {
+			domain := Domain{
+				domainName: fmt.Sprintf("%08x%08x.%s", rand.Uint32(), rand.Uint32(), E.domain),
+				resolver:   resolver,
+			}
+			response, err := domain.PrepareDnsCall(ctx, qtype)
+			if err != nil {
+				continue
+			}
+			for _, value := range answerValues(response, qtype) {
+				if !slices.Contains(wildcard[qtype], value) {
+					wildcard[qtype] = append(wildcard[qtype], value)
+				}
+			}
+		}
+		slices.Sort(wildcard[qtype])
+	}
+
+	return wildcard
+}
+
+// resolveHost resolves a candidate for every type, dropping answers that
+// only match the wildcard. Names found in the NSEC chain exist, so their
+// answers are always kept.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//   - name: The candidate name.
+//   - sources: Where the candidate came from.
+//   - wildcard: The values answered for random labels, by type.
+//   - limiter: The rate limiter ticks, nil when unlimited.
+//
+// Returns:
+//   - EnumHost: The host with its records.
+//   - int: The number of queries made.
+//   - int: The number of answers filtered out as wildcard matches.
+func (E Enumeration) resolveHost(ctx context.Context, name string, sources []string, wildcard map[string][]string, limiter <-chan time.Time) (EnumHost, int, int) {
+	host := EnumHost{Name: name, Sources: sources, Records: map[string][]string{}}
+	domain := Domain{domainName: name, resolver: E.resolver}
+	exists := slices.Contains(sources, sourceNSEC)
+
+	queries, filtered := 0, 0
+	for _, qtype := range E.types {
+		if limiter != nil {
+			select {
+			case <-limiter:
+			case <-ctx.Done():
+				return host, queries, filtered
+			}
+		}
+
+		queries++
+		response, err := domain.PrepareDnsCall(ctx, qtype)
+		if err != nil {
+			continue
+		}
+		// A name that does not exist has no other types either.
+		if response.Msg.Rcode == dns.RcodeNameError {
+			break
+		}
+
+		values := answerValues(response, qtype)
+		if len(values) == 0 {
+			continue
+		}
+		if !exists && len(wildcard[qtype]) > 0 && isSubset(values, wildcard[qtype]) {
+			filtered++
+			continue
+		}
+		host.Records[qtype] = values
+	}
+
+	return host, queries, filtered
+}
+
+// answerValues lists the sorted values of an answer, records of another type
+// than the queried one prefixed with their type.
+//
+// Args:
+//   - response: The DNS response.
+//   - qtype: The queried type.
+//
+// Returns:
+//   - []string: The values.
+func answerValues(response dnsquery.Response, qtype string) []string {
+	var values []string
+	for _, rr := range response.Msg.Answer {
+		value := snapshotValue(rr, strings.ToUpper(qtype))
+		if !slices.Contains(values, value) {
+			values = append(values, value)
+		}
+	}
+	slices.Sort(values)
+
+	return values
+}
+
+// isSubset reports whether every value is in the reference set.
+//
+// Args:
+//   - values: The values.
+//   - reference: The reference set.
+//
+// Returns:
+//   - bool: Whether values is a subset of reference.
+func isSubset(values, reference []string) bool {
+	for _, value := range values {
+		if !slices.Contains(reference, value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// printEnumReport prints the wildcard and NSEC findings and a table of the
+// hosts found.
+//
+// Args:
+//   - report: The report.
+//
+// Returns:
+//   - None
+func printEnumReport(report EnumReport) {
+	fmt.Printf(styles.NewStyles().Title.Render("🔎 Subdomains of %s 🔎"), report.Domain)
+	fmt.Println()
+
+	for _, qtype := range slices.Sorted(maps.Keys(report.Wildcard)) {
+		fmt.Println(styles.NewStyles().Error.Render(fmt.Sprintf(
+			"wildcard %s *.%s answers %s, matching candidates are filtered out", qtype, report.Domain, strings.Join(report.Wildcard[qtype], ", "),
+		)))
+	}
+
+	if report.NSEC != nil {
+		line := fmt.Sprintf("NSEC walk: %s, %d names", report.NSEC.Status, len(report.NSEC.Names))
+		if report.NSEC.Reason != "" {
+			line += " (" + report.NSEC.Reason + ")"
+		}
+		if report.NSEC.Status == dnsquery.NSECWalked {
+			fmt.Println(styles.NewStyles().Highlight.Render(line))
+		} else {
+			fmt.Println(line)
+		}
+	}
+	fmt.Println()
+
+	if len(report.Hosts) == 0 {
+		fmt.Printf("no hosts found with %d queries, %d wildcard matches filtered out\n", report.Queries, report.Filtered)
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAME\tTYPE\tVALUES\tSOURCE")
+	for _, host := range report.Hosts {
+		source := strings.Join(host.Sources, ",")
+		if len(host.Records) == 0 {
+			fmt.Fprintf(writer, "%s\t-\t-\t%s\n", host.Name, source)
+			continue
+		}
+		for _, qtype := range slices.Sorted(maps.Keys(host.Records)) {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", host.Name, qtype, strings.Join(host.Records[qtype], ", "), source)
+		}
+	}
+	writer.Flush()
+
+	fmt.Println()
+	fmt.Printf("%d hosts found with %d queries, %d wildcard matches filtered out\n", len(report.Hosts), report.Queries, report.Filtered)
+}
@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+
+	"commandCenter/dnsquery"
+	"commandCenter/dnsserver"
+
+	"github.com/miekg/dns"
+)
+
+// cannedAnswer is the reply of a test server to one name and type.
+type cannedAnswer struct {
+	rcode  int
+	answer []string
+	ns     []string
+}
+
+// serveDNS starts a local UDP server answering with a handler.
+func serveDNS(t *testing.T, address string, handler dns.Handler) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", address, err)
+	}
+	server := &dns.Server{PacketConn: conn, Handler: handler}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+
+	return conn.LocalAddr().String()
+}
+
+// cannedServer starts a local UDP server replying from canned answers keyed
+// by name and type, NXDOMAIN for anything else.
+func cannedServer(t *testing.T, address string, answers map[string]cannedAnswer) string {
+	t.Helper()
+
+	return serveDNS(t, address, dns.HandlerFunc(func(w dns.ResponseWriter, request *dns.Msg) {
+		question := request.Question[0]
+		reply := new(dns.Msg)
+		reply.SetReply(request)
+
+		canned, ok := answers[dns.CanonicalName(question.Name)+"/"+dns.TypeToString[question.Qtype]]
+		if !ok {
+			canned = cannedAnswer{rcode: dns.RcodeNameError}
+		}
+		reply.Rcode = canned.rcode
+		reply.Answer = records(t, canned.answer)
+		reply.Ns = records(t, canned.ns)
+		_ = w.WriteMsg(reply)
+	}))
+}
+
+// records parses records written in zone file format.
+func records(t *testing.T, lines []string) []dns.RR {
+	t.Helper()
+
+	var parsed []dns.RR
+	for _, line := range lines {
+		rr, err := dns.NewRR(line)
+		if err != nil {
+			t.Errorf("parse %q: %v", line, err)
+			continue
+		}
+		parsed = append(parsed, rr)
+	}
+
+	return parsed
+}
+
+// nsecRecords returns an NSEC record with an RRSIG made by a signer. The
+// signature is not checked by the walk, only who made it.
+func nsecRecords(owner, next, types, signer string) []string {
+	return []string{
+		owner + " 300 IN NSEC " + next + " " + types,
+		owner + " 300 IN RRSIG NSEC 13 3 300 20300101000000 20200101000000 12345 " + signer + " AAAA",
+	}
+}
+
+// testUpstreams builds upstreams asking one local server.
+func testUpstreams(t *testing.T, address string) dnsquery.Upstreams {
+	t.Helper()
+
+	upstreams, err := dnsquery.NewUpstreams([]string{address}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return upstreams
+}
+
+func TestEnumerationWildcard(t *testing.T) {
+	zone := records(t, []string{
+		"example.test. 3600 IN SOA ns1.example.test. hostmaster.example.test. 1 3600 600 86400 300",
+		"example.test. 3600 IN NS ns1.example.test.",
+		"www.example.test. 3600 IN A 192.0.2.1",
+		"api.example.test. 3600 IN A 192.0.2.2",
+		"*.example.test. 3600 IN A 192.0.2.99",
+	})
+	authority, err := dnsserver.BuildAuthority([][]dns.RR{zone}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := serveDNS(t, "127.0.0.1:0", authority)
+
+	enumeration := Enumeration{
+		domain:      "example.test.",
+		words:       []string{"www", "api", "missing", "other"},
+		types:       []string{"A"},
+		resolver:    testUpstreams(t, address),
+		concurrency: 2,
+	}
+	report := enumeration.Run(context.Background())
+
+	if !slices.Equal(report.Wildcard["A"], []string{"192.0.2.99"}) {
+		t.Errorf("wildcard = %v, want the A record of *.example.test.", report.Wildcard)
+	}
+	var hosts []string
+	for _, host := range report.Hosts {
+		hosts = append(hosts, host.Name)
+	}
+	if want := []string{"api.example.test.", "www.example.test."}; !slices.Equal(hosts, want) {
+		t.Errorf("hosts = %v, want %v", hosts, want)
+	}
+	if report.Filtered != 2 {
+		t.Errorf("filtered = %d, want 2", report.Filtered)
+	}
+}
+
+func TestEnumerationNSECDelegation(t *testing.T) {
+	// example.test. delegates signed.example.test., signed with its own NSEC
+	// chain, and unsigned.example.test., as a recursive resolver sees them.
+	const (
+		parent = "example.test."
+		signed = "signed.example.test."
+	)
+	soa := parent + " 300 IN SOA ns.example.test. hostmaster.example.test. 1 3600 600 86400 300"
+	answers := map[string]cannedAnswer{
+		parent + "/NSEC":                 {answer: nsecRecords(parent, "a.example.test.", "SOA NS RRSIG NSEC DNSKEY", parent)},
+		"a.example.test./NSEC":           {answer: nsecRecords("a.example.test.", signed, "A RRSIG NSEC", parent)},
+		signed + "/NSEC":                 {answer: nsecRecords(signed, "host.signed.example.test.", "SOA NS RRSIG NSEC DNSKEY", signed)},
+		"host.signed.example.test./NSEC": {answer: nsecRecords("host.signed.example.test.", signed, "A RRSIG NSEC", signed)},
+		signed + "/DS": {answer: []string{
+			signed + " 300 IN DS 12345 13 2 0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF",
+			signed + " 300 IN RRSIG DS 13 3 300 20300101000000 20200101000000 12345 example.test. AAAA",
+		}},
+		`signed\000.example.test./NSEC`: {
+			rcode: dns.RcodeNameError,
+			ns:    append([]string{soa}, nsecRecords(signed, "unsigned.example.test.", "NS DS RRSIG NSEC", parent)...),
+		},
+		"unsigned.example.test./NSEC": {ns: []string{
+			"unsigned.example.test. 300 IN SOA ns.unsigned.example.test. hostmaster.unsigned.example.test. 1 3600 600 86400 300",
+		}},
+		"unsigned.example.test./DS": {ns: append([]string{soa}, nsecRecords("unsigned.example.test.", "www.example.test.", "NS RRSIG NSEC", parent)...)},
+		"www.example.test./NSEC":    {answer: nsecRecords("www.example.test.", parent, "A RRSIG NSEC", parent)},
+		"www.example.test./A":       {answer: []string{"www.example.test. 300 IN A 192.0.2.1"}},
+	}
+	address := cannedServer(t, "127.0.0.1:0", answers)
+
+	enumeration := Enumeration{
+		domain:      parent,
+		words:       []string{"www"},
+		types:       []string{"A"},
+		resolver:    testUpstreams(t, address),
+		concurrency: 2,
+		nsec:        true,
+		nsecLimit:   100,
+	}
+	report := enumeration.Run(context.Background())
+
+	if report.NSEC == nil || report.NSEC.Status != dnsquery.NSECWalked {
+		t.Fatalf("nsec walk = %+v, want walked", report.NSEC)
+	}
+	var walked []string
+	for _, name := range report.NSEC.Names {
+		walked = append(walked, name.Name)
+	}
+	want := []string{parent, "a.example.test.", signed, "unsigned.example.test.", "www.example.test."}
+	if !slices.Equal(walked, want) {
+		t.Errorf("walked %v, want %v", walked, want)
+	}
+	for _, host := range report.Hosts {
+		if strings.HasSuffix(host.Name, "."+signed) {
+			t.Errorf("host %s of the child zone was reported", host.Name)
+		}
+	}
+}
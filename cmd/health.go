@@ -0,0 +1,900 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"commandCenter/dnsquery"
+	"commandCenter/styles"
+	"commandCenter/validators"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+)
+
+// recursionProbe is queried with RD set to find servers that recurse for
+// anyone. Only the root server operators are authoritative for it.
+const recursionProbe = "a.root-servers.net."
+
+type DelegationHealth struct {
+	zone    string
+	names   []string
+	types   []string
+	tracer  dnsquery.Tracer
+	port    string
+	timeout time.Duration
+}
+
+type HealthServer struct {
+	Name          string   `json:"name"`
+	Address       string   `json:"address"`
+	Family        string   `json:"family"`
+	UDP           bool     `json:"udp"`
+	TCP           bool     `json:"tcp"`
+	Authoritative bool     `json:"authoritative"`
+	Rcode         string   `json:"rcode,omitempty"`
+	Serial        uint32   `json:"serial,omitempty"`
+	Nameservers   []string `json:"nameservers,omitempty"`
+	OpenRecursion bool     `json:"open_recursion"`
+	RttMs         float64  `json:"rtt_ms"`
+	Error         string   `json:"error,omitempty"`
+
+	// answers holds the sorted records of every compared name, keyed by "name TYPE".
+	answers map[string][]string
+}
+
+type HealthCheck struct {
+	Name     string    `json:"name"`
+	Status   string    `json:"status"`
+	Findings []Finding `json:"findings"`
+}
+
+type HealthReport struct {
+	Zone         string              `json:"zone"`
+	Status       string              `json:"status"`
+	Parent       string              `json:"parent"`
+	ParentServer string              `json:"parent_server"`
+	ParentNS     []string            `json:"parent_ns"`
+	ChildNS      []string            `json:"child_ns"`
+	Glue         map[string][]string `json:"glue"`
+	Servers      []HealthServer      `json:"servers"`
+	Checks       []HealthCheck       `json:"checks"`
+}
+
+var healthCmd = &cobra.Command{
+	Use:     "health",
+	Short:   "Check the delegation and the authoritative nameservers of a zone.",
+	Long:    "Compare the NS set published by the parent with the one served by the zone, query every authoritative server over IPv4 and IPv6, UDP and TCP, and report lame delegations, glue mismatches, SOA serial drift, inconsistent answers and open recursion.",
+	Aliases: []string{"delegation", "nscheck"},
+	Example: `
+      # Check the delegation of example.com and every one of its nameservers
+      ops dns health -d example.com
+
+      # Also compare the answers of every nameserver for a few names, as JSON
+      ops dns health -d example.com --names @,www,mail --types a,aaaa,mx -o json
+
+      # Check a zone in a local hierarchy of test servers listening on port 5353
+      ops dns health -d example.test --root-server 127.0.0.2 --port 5353
+
+      # Get help for the health command
+      ops dns health --help
+    `,
+
+	Run: checkHealth,
+}
+
+// init initializes the health command and its flags.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - None
+func init() {
+	dnsCmd.AddCommand(healthCmd)
+
+	healthCmd.Flags().StringP("domain", "d", "", "zone to check")
+	healthCmd.Flags().StringSlice("names", []string{}, "names compared across the nameservers, relative to the zone or fully qualified, @ for the apex")
+	healthCmd.Flags().StringSlice("types", []string{"A", "AAAA"}, "record types compared for every name given with --names")
+	healthCmd.Flags().String("root-hints", "", "named.root style file with the root servers the delegation is traced from")
+	healthCmd.Flags().StringSlice("root-server", []string{}, "root server IP address the delegation is traced from, repeatable")
+	healthCmd.Flags().String("port", dnsquery.DefaultPort, "port used for every nameserver, including the root and parent servers")
+	healthCmd.Flags().Duration("timeout", dnsquery.DefaultTimeout, "timeout for each query sent to a nameserver")
+	healthCmd.Flags().StringP("output", "o", outputPlain, "output format: plain or json")
+
+	healthCmd.MarkFlagRequired("domain")
+	healthCmd.MarkFlagsMutuallyExclusive("root-hints", "root-server")
+}
+
+// checkHealth is the main function for the health command.
+//
+// Args:
+//   - cmd: The cobra command.
+//   - args: The command arguments.
+//
+// Returns:
+//   - None
+func checkHealth(cmd *cobra.Command, args []string) {
+	zone, err := validators.VerifyStringInputs(cmd, "domain")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	names, err := validators.VerifyStringSliceInputs(cmd, "names")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	types, err := validators.VerifyStringSliceInputs(cmd, "types")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, qtype := range types {
+		if _, err := dnsquery.ParseType(qtype); err != nil {
+			log.Fatalln(styles.NewStyles().Error.Render(err.Error()))
+		}
+	}
+
+	port, err := validators.VerifyStringInputs(cmd, "port")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	timeout, err := validators.VerifyDurationInputs(cmd, "timeout")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	output, err := validators.VerifyStringInputs(cmd, "output")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	output = strings.ToLower(output)
+	if output != outputPlain && output != outputJSON {
+		log.Fatalln(styles.NewStyles().Error.Render(fmt.Sprintf("unknown output format '%s', use plain or json", output)))
+	}
+
+	roots, err := rootHintsFromFlags(cmd)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	udp, err := dnsquery.NewTransport("udp", "", dnsquery.TLSOptions{}, timeout)
+	if err != nil {
+		log.Fatalln(styles.NewStyles().Error.Render(err.Error()))
+	}
+
+	health := DelegationHealth{
+		zone:    dns.CanonicalName(zone),
+		names:   names,
+		types:   types,
+		tracer:  dnsquery.NewTracer(udp, roots, port),
+		port:    port,
+		timeout: timeout,
+	}
+
+	report, err := health.Run(cmd.Context())
+	if err != nil {
+		log.Fatalln(styles.NewStyles().Error.Render(err.Error()))
+	}
+
+	if output == outputJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Fatalln(err)
+		}
+	} else {
+		printHealthReport(report)
+	}
+
+	if report.Status == severityFail {
+		os.Exit(1)
+	}
+}
+
+// Run finds the delegation of the zone, probes every address of every
+// nameserver and checks the results.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//
+// Returns:
+//   - HealthReport: The report.
+//   - error: An error if the parent does not delegate the zone.
+func (H DelegationHealth) Run(ctx context.Context) (HealthReport, error) {
+	delegation, err := H.tracer.Delegation(ctx, H.zone)
+	if err != nil {
+		return HealthReport{}, fmt.Errorf("could not find the delegation of %s: %w", H.zone, err)
+	}
+
+	report := HealthReport{
+		Zone:         H.zone,
+		Parent:       delegation.Parent,
+		ParentServer: fmt.Sprintf("%s (%s)", delegation.Server, delegation.Address),
+		ParentNS:     slices.Sorted(slices.Values(delegation.Nameservers)),
+		Glue:         delegation.Glue,
+	}
+
+	compared := H.comparedNames(delegation.Nameservers)
+
+	delegationCheck := HealthCheck{Name: "Delegation"}
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		servers []HealthServer
+	)
+	for _, ns := range report.ParentNS {
+		// Glue often covers IPv4 only, the other family is resolved so every
+		// address of the nameserver is probed.
+		addresses := slices.Clone(delegation.Glue[ns])
+		addresses = append(addresses, H.addresses(ctx, ns, missingFamilies(addresses))...)
+		if len(addresses) == 0 {
+			delegationCheck.add(severityFail, "%s has no address, it cannot be queried", ns)
+			continue
+		}
+
+		for _, address := range addresses {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				server := H.probe(ctx, ns, address, compared)
+				mu.Lock()
+				servers = append(servers, server)
+				mu.Unlock()
+			}()
+		}
+	}
+	wg.Wait()
+
+	slices.SortFunc(servers, func(a, b HealthServer) int {
+		return strings.Compare(a.Name+" "+a.Address, b.Name+" "+b.Address)
+	})
+	report.Servers = servers
+	report.ChildNS = childNameservers(servers)
+
+	report.Checks = []HealthCheck{
+		H.checkDelegation(delegationCheck, report),
+		H.checkGlue(report),
+		checkReachability(servers),
+		checkAuthority(servers),
+		checkSerials(servers),
+		checkConsistency(servers, compared),
+		checkRecursion(servers),
+	}
+
+	report.Status = severityPass
+	for i := range report.Checks {
+		check := &report.Checks[i]
+		check.Status = severityPass
+		for _, finding := range check.Findings {
+			if severityRank(finding.Severity) > severityRank(check.Status) {
+				check.Status = finding.Severity
+			}
+		}
+		// Informational findings do not change the overall status.
+		if check.Status != severityInfo && severityRank(check.Status) > severityRank(report.Status) {
+			report.Status = check.Status
+		}
+	}
+
+	return report, nil
+}
+
+// comparedNames lists the name and type pairs compared across the servers:
+// the names asked for and the addresses of in-zone nameservers, which the
+// glue is checked against.
+//
+// Args:
+//   - nameservers: The nameservers of the delegation.
+//
+// Returns:
+//   - []string: The keys, as "name TYPE".
+func (H DelegationHealth) comparedNames(nameservers []string) []string {
+	var keys []string
+	for _, name := range H.names {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "@" || name == "":
+			name = H.zone
+		case !dns.IsFqdn(name):
+			name = dns.CanonicalName(name + "." + H.zone)
+		default:
+			name = dns.CanonicalName(name)
+		}
+		for _, qtype := range H.types {
+			keys = append(keys, name+" "+strings.ToUpper(qtype))
+		}
+	}
+
+	for _, ns := range nameservers {
+		if dns.IsSubDomain(H.zone, ns) {
+			keys = append(keys, ns+" A", ns+" AAAA")
+		}
+	}
+
+	slices.Sort(keys)
+	return slices.Compact(keys)
+}
+
+// addresses resolves the addresses of a nameserver for the families the
+// glue does not cover, tracing them from the same root hints as the
+// delegation so a local hierarchy resolves its own nameservers.
+//
+// Args:
+//   - ctx: The context bounding the queries.
+//   - ns: The nameserver name.
+//   - qtypes: The address types to resolve, A and/or AAAA.
+//
+// Returns:
+//   - []string: The addresses, empty if the name does not resolve.
+func (H DelegationHealth) addresses(ctx context.Context, ns string, qtypes []string) []string {
+	var addresses []string
+	for _, qtype := range qtypes {
+		hops, err := H.tracer.Trace(ctx, ns, dns.StringToType[qtype])
+		if err != nil || len(hops) == 0 {
+			continue
+		}
+		for _, rr := range hops[len(hops)-1].Answer {
+			switch record := rr.(type) {
+			case *dns.A:
+				addresses = append(addresses, record.A.String())
+			case *dns.AAAA:
+				addresses = append(addresses, record.AAAA.String())
+			}
+		}
+	}
+
+	return addresses
+}
+
+// missingFamilies lists the address types absent from a set of addresses.
+//
+// Args:
+//   - addresses: The IPv4 and IPv6 addresses.
+//
+// Returns:
+//   - []string: "A" without IPv4 addresses, "AAAA" without IPv6 addresses.
+func missingFamilies(addresses []string) []string {
+	ipv4, ipv6 := false, false
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+			ipv4 = true
+		} else if ip != nil {
+			ipv6 = true
+		}
+	}
+
+	var missing []string
+	if !ipv4 {
+		missing = append(missing, "A")
+	}
+	if !ipv6 {
+		missing = append(missing, "AAAA")
+	}
+
+	return missing
+}
+
+// probe queries one address of a nameserver for the SOA and NS records of the
+// zone over UDP and TCP, for every compared name, and checks whether it
+// recurses.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//   - ns: The nameserver name.
+//   - address: The address of the nameserver.
+//   - compared: The name and type pairs to query.
+//
+// Returns:
+//   - HealthServer: The results.
+func (H DelegationHealth) probe(ctx context.Context, ns, address string, compared []string) HealthServer {
+	server := HealthServer{Name: ns, Address: address, Family: "ipv4", answers: map[string][]string{}}
+	if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+		server.Family = "ipv6"
+	}
+	target := net.JoinHostPort(address, H.port)
+
+	udp, err := H.upstream(target, "udp")
+	if err != nil {
+		server.Error = err.Error()
+		return server
+	}
+
+	response, err := udp.Exchange(ctx, healthQuery(H.zone, dns.TypeSOA, false))
+	if err != nil {
+		server.Error = err.Error()
+	} else {
+		server.UDP = true
+		server.Rcode = dns.RcodeToString[response.Msg.Rcode]
+		server.Authoritative = response.Msg.Authoritative && response.Msg.Rcode == dns.RcodeSuccess
+		server.RttMs = float64(response.Rtt.Microseconds()) / 1000
+		for _, rr := range response.Msg.Answer {
+			if soa, ok := rr.(*dns.SOA); ok {
+				server.Serial = soa.Serial
+			}
+		}
+	}
+
+	tcp, err := H.upstream(target, "tcp")
+	if err == nil {
+		if _, err := tcp.Exchange(ctx, healthQuery(H.zone, dns.TypeSOA, false)); err == nil {
+			server.TCP = true
+		} else if server.Error == "" {
+			server.Error = err.Error()
+		}
+	}
+
+	// A server only reachable over TCP is still asked for the records.
+	resolver := udp
+	if !server.UDP {
+		if !server.TCP {
+			return server
+		}
+		resolver = tcp
+	}
+
+	if response, err := resolver.Exchange(ctx, healthQuery(H.zone, dns.TypeNS, false)); err == nil {
+		for _, rr := range response.Msg.Answer {
+			if record, ok := rr.(*dns.NS); ok {
+				server.Nameservers = append(server.Nameservers, dns.CanonicalName(record.Ns))
+			}
+		}
+		slices.Sort(server.Nameservers)
+	}
+
+	for _, key := range compared {
+		name, qtype, _ := strings.Cut(key, " ")
+		response, err := resolver.Exchange(ctx, healthQuery(name, dns.StringToType[qtype], false))
+		if err != nil {
+			server.answers[key] = []string{"error: " + err.Error()}
+			continue
+		}
+		server.answers[key] = answerSet(response.Msg)
+	}
+
+	if response, err := resolver.Exchange(ctx, healthQuery(recursionProbe, dns.TypeA, true)); err == nil {
+		in := response.Msg
+		server.OpenRecursion = in.RecursionAvailable && in.Rcode == dns.RcodeSuccess && !in.Authoritative && len(in.Answer) > 0
+	}
+
+	return server
+}
+
+// upstream builds an upstream set reaching a single nameserver address.
+//
+// Args:
+//   - target: The address as host:port.
+//   - transport: The transport, udp or tcp.
+//
+// Returns:
+//   - dnsquery.Upstreams: The upstream set.
+//   - error: An error if the address is invalid.
+func (H DelegationHealth) upstream(target, transport string) (dnsquery.Upstreams, error) {
+	client, err := dnsquery.NewTransport(transport, "", dnsquery.TLSOptions{}, H.timeout)
+	if err != nil {
+		return dnsquery.Upstreams{}, err
+	}
+
+	return dnsquery.NewUpstreams([]string{target}, client)
+}
+
+// healthQuery builds a query without EDNS options.
+//
+// Args:
+//   - name: The name to query.
+//   - qtype: The query type.
+//   - recursion: Whether to set the RD bit.
+//
+// Returns:
+//   - *dns.Msg: The query.
+func healthQuery(name string, qtype uint16, recursion bool) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	m.RecursionDesired = recursion
+	return m
+}
+
+// answerSet summarizes an answer as the sorted data of its records, so
+// answers from different servers can be compared whatever their TTLs.
+//
+// Args:
+//   - in: The response.
+//
+// Returns:
+//   - []string: The records, or the rcode when it is not NOERROR.
+func answerSet(in *dns.Msg) []string {
+	if in.Rcode != dns.RcodeSuccess {
+		return []string{dns.RcodeToString[in.Rcode]}
+	}
+
+	records := make([]string, 0, len(in.Answer))
+	for _, rr := range in.Answer {
+		records = append(records, strings.TrimPrefix(rr.String(), rr.Header().String()))
+	}
+	slices.Sort(records)
+
+	return records
+}
+
+// childNameservers returns the NS set served by the zone itself, taken from
+// the first authoritative server.
+//
+// Args:
+//   - servers: The probed servers.
+//
+// Returns:
+//   - []string: The NS set, empty when no server answered authoritatively.
+func childNameservers(servers []HealthServer) []string {
+	for _, server := range servers {
+		if server.Authoritative && len(server.Nameservers) > 0 {
+			return server.Nameservers
+		}
+	}
+
+	return nil
+}
+
+// checkDelegation compares the NS set of the parent with the one of the zone.
+//
+// Args:
+//   - check: The check, holding the nameservers without an address.
+//   - report: The report with both NS sets and the probed servers.
+//
+// Returns:
+//   - HealthCheck: The check.
+func (H DelegationHealth) checkDelegation(check HealthCheck, report HealthReport) HealthCheck {
+	if len(report.ParentNS) < 2 {
+		check.add(severityWarn, "%s delegates to %d nameserver(s), at least two are needed for redundancy", report.Parent, len(report.ParentNS))
+	}
+
+	if report.ChildNS == nil {
+		check.add(severityFail, "no nameserver answered authoritatively for the NS records of %s", H.zone)
+	} else {
+		for _, ns := range report.ParentNS {
+			if !slices.Contains(report.ChildNS, ns) {
+				check.add(severityWarn, "%s is listed by the parent but not in the zone's NS records", ns)
+			}
+		}
+		for _, ns := range report.ChildNS {
+			if !slices.Contains(report.ParentNS, ns) {
+				check.add(severityWarn, "%s is listed in the zone's NS records but not by the parent", ns)
+			}
+		}
+	}
+
+	ipv6 := slices.ContainsFunc(report.Servers, func(server HealthServer) bool {
+		return server.Family == "ipv6"
+	})
+	if !ipv6 {
+		check.add(severityInfo, "no nameserver has an IPv6 address")
+	}
+
+	if len(check.Findings) == 0 {
+		check.add(severityPass, "the parent and the zone agree on %d nameservers", len(report.ParentNS))
+	}
+
+	return check
+}
+
+// checkGlue compares the glue published by the parent with the addresses of
+// in-zone nameservers served by the zone.
+//
+// Args:
+//   - report: The report.
+//
+// Returns:
+//   - HealthCheck: The check.
+func (H DelegationHealth) checkGlue(report HealthReport) HealthCheck {
+	check := HealthCheck{Name: "Glue"}
+
+	var reference *HealthServer
+	for i := range report.Servers {
+		if report.Servers[i].Authoritative {
+			reference = &report.Servers[i]
+			break
+		}
+	}
+
+	inZone := 0
+	for _, ns := range report.ParentNS {
+		if !dns.IsSubDomain(H.zone, ns) {
+			continue
+		}
+		inZone++
+
+		glue := slices.Sorted(slices.Values(report.Glue[ns]))
+		if len(glue) == 0 {
+			check.add(severityFail, "%s is inside the zone but the parent has no glue for it", ns)
+			continue
+		}
+		if reference == nil {
+			continue
+		}
+
+		var served, servedIPv6 []string
+		for _, qtype := range []string{"A", "AAAA"} {
+			for _, record := range reference.answers[ns+" "+qtype] {
+				if net.ParseIP(record) == nil {
+					continue
+				}
+				served = append(served, record)
+				if qtype == "AAAA" {
+					servedIPv6 = append(servedIPv6, record)
+				}
+			}
+		}
+		slices.Sort(served)
+
+		// Missing AAAA glue only keeps IPv6-only resolvers away, other
+		// differences send resolvers to the wrong addresses.
+		if slices.Contains(missingFamilies(glue), "AAAA") && len(servedIPv6) > 0 {
+			check.add(severityWarn, "%s serves %s but the parent has no AAAA glue for it", ns, strings.Join(servedIPv6, ", "))
+			served = slices.DeleteFunc(served, func(address string) bool { return slices.Contains(servedIPv6, address) })
+		}
+		if !slices.Equal(glue, served) {
+			check.add(severityFail, "glue for %s is %s but the zone serves %s", ns, listOrNone(glue), listOrNone(served))
+		}
+	}
+
+	if len(check.Findings) == 0 {
+		if inZone == 0 {
+			check.add(severityPass, "no nameserver is inside the zone, no glue is needed")
+		} else {
+			check.add(severityPass, "glue matches the addresses served by the zone for %d nameserver(s)", inZone)
+		}
+	}
+
+	return check
+}
+
+// checkReachability reports servers that do not answer over UDP or TCP.
+//
+// Args:
+//   - servers: The probed servers.
+//
+// Returns:
+//   - HealthCheck: The check.
+func checkReachability(servers []HealthServer) HealthCheck {
+	check := HealthCheck{Name: "Reachability"}
+	for _, server := range servers {
+		switch {
+		case !server.UDP && !server.TCP:
+			check.add(severityFail, "%s (%s) does not answer: %s", server.Name, server.Address, server.Error)
+		case !server.UDP:
+			check.add(severityFail, "%s (%s) does not answer over UDP", server.Name, server.Address)
+		case !server.TCP:
+			check.add(severityFail, "%s (%s) does not answer over TCP", server.Name, server.Address)
+		}
+	}
+
+	if len(check.Findings) == 0 {
+		check.add(severityPass, "%d address(es) answer over UDP and TCP", len(servers))
+	}
+
+	return check
+}
+
+// checkAuthority reports lame delegations: servers answering without the AA
+// flag or with an error for the zone.
+//
+// Args:
+//   - servers: The probed servers.
+//
+// Returns:
+//   - HealthCheck: The check.
+func checkAuthority(servers []HealthServer) HealthCheck {
+	check := HealthCheck{Name: "Authority"}
+	for _, server := range servers {
+		if server.Rcode == "" || server.Authoritative {
+			continue
+		}
+		if server.Rcode != dns.RcodeToString[dns.RcodeSuccess] {
+			check.add(severityFail, "lame delegation: %s (%s) answered %s for the zone", server.Name, server.Address, server.Rcode)
+		} else {
+			check.add(severityFail, "lame delegation: %s (%s) answered without the AA flag", server.Name, server.Address)
+		}
+	}
+
+	if len(check.Findings) == 0 {
+		check.add(severityPass, "every reachable nameserver is authoritative for the zone")
+	}
+
+	return check
+}
+
+// checkSerials compares the SOA serials of the authoritative servers.
+//
+// Args:
+//   - servers: The probed servers.
+//
+// Returns:
+//   - HealthCheck: The check.
+func checkSerials(servers []HealthServer) HealthCheck {
+	check := HealthCheck{Name: "SOA"}
+
+	serials := map[uint32][]string{}
+	for _, server := range servers {
+		if server.Authoritative {
+			serials[server.Serial] = append(serials[server.Serial], server.Name+" ("+server.Address+")")
+		}
+	}
+
+	switch len(serials) {
+	case 0:
+	case 1:
+		for serial := range serials {
+			check.add(severityPass, "every authoritative nameserver serves serial %d", serial)
+		}
+	default:
+		for _, serial := range slices.Sorted(maps.Keys(serials)) {
+			check.add(severityWarn, "serial %d served by %s", serial, strings.Join(serials[serial], ", "))
+		}
+	}
+
+	return check
+}
+
+// checkConsistency compares the NS records and the compared names across the
+// authoritative servers.
+//
+// Args:
+//   - servers: The probed servers.
+//   - compared: The name and type pairs queried on every server.
+//
+// Returns:
+//   - HealthCheck: The check.
+func checkConsistency(servers []HealthServer, compared []string) HealthCheck {
+	check := HealthCheck{Name: "Consistency"}
+
+	var authoritative []HealthServer
+	for _, server := range servers {
+		if server.Authoritative {
+			authoritative = append(authoritative, server)
+		}
+	}
+	if len(authoritative) < 2 {
+		return check
+	}
+
+	reference := authoritative[0]
+	for _, server := range authoritative[1:] {
+		if !slices.Equal(server.Nameservers, reference.Nameservers) {
+			check.add(severityWarn, "%s (%s) serves NS %s but %s (%s) serves %s",
+				server.Name, server.Address, listOrNone(server.Nameservers),
+				reference.Name, reference.Address, listOrNone(reference.Nameservers),
+			)
+		}
+		for _, key := range compared {
+			if !slices.Equal(server.answers[key], reference.answers[key]) {
+				check.add(severityWarn, "%s: %s (%s) answers %s but %s (%s) answers %s", key,
+					server.Name, server.Address, listOrNone(server.answers[key]),
+					reference.Name, reference.Address, listOrNone(reference.answers[key]),
+				)
+			}
+		}
+	}
+
+	if len(check.Findings) == 0 {
+		check.add(severityPass, "%d authoritative address(es) give the same answers to %d queries", len(authoritative), len(compared)+1)
+	}
+
+	return check
+}
+
+// checkRecursion reports nameservers that resolve names outside their zones
+// for anyone, which makes them usable for amplification and cache poisoning.
+//
+// Args:
+//   - servers: The probed servers.
+//
+// Returns:
+//   - HealthCheck: The check.
+func checkRecursion(servers []HealthServer) HealthCheck {
+	check := HealthCheck{Name: "Recursion"}
+	for _, server := range servers {
+		if server.OpenRecursion {
+			check.add(severityFail, "%s (%s) is an open resolver, it answered %s recursively", server.Name, server.Address, recursionProbe)
+		}
+	}
+
+	if len(check.Findings) == 0 {
+		check.add(severityPass, "no nameserver recurses for outside names")
+	}
+
+	return check
+}
+
+// add records a finding.
+//
+// Args:
+//   - severity: The severity, pass, info, warn or fail.
+//   - format: The message format.
+//   - args: The message arguments.
+//
+// Returns:
+//   - None
+func (C *HealthCheck) add(severity, format string, args ...any) {
+	C.Findings = append(C.Findings, Finding{Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+// listOrNone joins values for a finding.
+//
+// Args:
+//   - values: The values.
+//
+// Returns:
+//   - string: The values separated by commas, or "nothing".
+func listOrNone(values []string) string {
+	if len(values) == 0 {
+		return "nothing"
+	}
+
+	return strings.Join(values, ", ")
+}
+
+// printHealthReport prints the nameservers and the checks.
+//
+// Args:
+//   - report: The report.
+//
+// Returns:
+//   - None
+func printHealthReport(report HealthReport) {
+	fmt.Printf(styles.NewStyles().Title.Render("🩺 Delegation health for %s: %s 🩺"), report.Zone, report.Status)
+	fmt.Println()
+	fmt.Printf("parent %s via %s delegates to %s\n", report.Parent, report.ParentServer, listOrNone(report.ParentNS))
+	fmt.Printf("zone NS records: %s\n", listOrNone(report.ChildNS))
+	fmt.Println()
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAMESERVER\tADDRESS\tUDP\tTCP\tAA\tRCODE\tSERIAL\tRTT")
+	for _, server := range report.Servers {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%.3fms\n",
+			server.Name, server.Address, yesNo(server.UDP), yesNo(server.TCP), yesNo(server.Authoritative),
+			server.Rcode, server.Serial, server.RttMs,
+		)
+	}
+	writer.Flush()
+
+	for _, check := range report.Checks {
+		fmt.Println()
+		fmt.Printf("%s %s %s\n", severityIcon(check.Status), styles.NewStyles().Title.Render(check.Name), check.Status)
+		for _, finding := range check.Findings {
+			message := fmt.Sprintf("%s %s", severityIcon(finding.Severity), finding.Message)
+			switch finding.Severity {
+			case severityFail:
+				fmt.Println(styles.NewStyles().Error.Render(message))
+			case severityPass:
+				fmt.Println("  " + styles.NewStyles().Highlight.Render(message))
+			default:
+				fmt.Println("  " + message)
+			}
+		}
+	}
+}
+
+// yesNo shows a boolean in a table.
+//
+// Args:
+//   - value: The value.
+//
+// Returns:
+//   - string: yes or no.
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+
+	return "no"
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"commandCenter/dnsquery"
+	"commandCenter/dnsserver"
+)
+
+// authorityServer serves a zone as ops server dns does, over UDP and TCP.
+func authorityServer(t *testing.T, address string, lines ...string) {
+	t.Helper()
+
+	zone, err := dnsserver.NewZone(records(t, lines))
+	if err != nil {
+		t.Fatalf("zone on %s: %v", address, err)
+	}
+	authority, err := dnsserver.NewAuthority(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := dnsserver.NewServer(authority, dnsserver.ServerOptions{Listen: []string{address}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- server.ListenAndServe(ctx, func() { close(started) }) }()
+	select {
+	case <-started:
+	case err := <-done:
+		cancel()
+		t.Skipf("cannot listen on %s: %v", address, err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestDelegationHealth(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("cannot listen on 127.0.0.2: %v", err)
+	}
+	_, port, _ := net.SplitHostPort(probe.Addr().String())
+	probe.Close()
+
+	// example.test. has an in-zone nameserver with glue and one under other.
+	// without glue, whose address only the local hierarchy knows.
+	authorityServer(t, "127.0.0.2:"+port,
+		". 300 IN SOA ns.root. hostmaster.root. 1 3600 600 86400 300",
+		". 300 IN NS ns.root.",
+		"ns.root. 300 IN A 127.0.0.2",
+		"test. 300 IN NS ns.nic.test.",
+		"ns.nic.test. 300 IN A 127.0.0.3",
+		"other. 300 IN NS ns.nic.other.",
+		"ns.nic.other. 300 IN A 127.0.0.5",
+	)
+	authorityServer(t, "127.0.0.3:"+port,
+		"test. 300 IN SOA ns.nic.test. hostmaster.nic.test. 1 3600 600 86400 300",
+		"test. 300 IN NS ns.nic.test.",
+		"ns.nic.test. 300 IN A 127.0.0.3",
+		"example.test. 300 IN NS ns1.example.test.",
+		"example.test. 300 IN NS ns.example.other.",
+		"ns1.example.test. 300 IN A 127.0.0.4",
+	)
+	authorityServer(t, "127.0.0.5:"+port,
+		"other. 300 IN SOA ns.nic.other. hostmaster.nic.other. 1 3600 600 86400 300",
+		"other. 300 IN NS ns.nic.other.",
+		"ns.nic.other. 300 IN A 127.0.0.5",
+		"ns.example.other. 300 IN A 127.0.0.6",
+	)
+	child := []string{
+		"example.test. 300 IN SOA ns1.example.test. hostmaster.example.test. 7 3600 600 86400 300",
+		"example.test. 300 IN NS ns1.example.test.",
+		"example.test. 300 IN NS ns.example.other.",
+		"ns1.example.test. 300 IN A 127.0.0.4",
+	}
+	authorityServer(t, "127.0.0.4:"+port, child...)
+	authorityServer(t, "127.0.0.6:"+port, child...)
+
+	roots, err := dnsquery.RootHintsFromAddresses([]string{"127.0.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	health := DelegationHealth{
+		zone:    "example.test.",
+		tracer:  dnsquery.NewTracer(nil, roots, port),
+		port:    port,
+		timeout: time.Second,
+	}
+	report, err := health.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	var servers []string
+	for _, server := range report.Servers {
+		servers = append(servers, server.Name+" "+server.Address)
+		if !server.UDP || !server.TCP || !server.Authoritative || server.Serial != 7 {
+			t.Errorf("server %s %s: %+v, want an authoritative answer over UDP and TCP", server.Name, server.Address, server)
+		}
+	}
+	if want := []string{"ns.example.other. 127.0.0.6", "ns1.example.test. 127.0.0.4"}; !slices.Equal(servers, want) {
+		t.Errorf("servers = %v, want %v", servers, want)
+	}
+	if report.Status != severityPass {
+		t.Errorf("status = %s, want %s: %+v", report.Status, severityPass, report.Checks)
+	}
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// openJSONLines opens a file receiving one JSON object per line, such as the
+// watch change events or the DNS server query log, "-" meaning stdout.
+//
+// Args:
+//   - path: The file, empty when nothing is written.
+//
+// Returns:
+//   - io.WriteCloser: The writer, nil when nothing is written.
+//   - error: An error if the file cannot be opened.
+func openJSONLines(path string) (io.WriteCloser, error) {
+	switch path {
+	case "":
+		return nil, nil
+	case "-":
+		return nopWriteCloser{os.Stdout}, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open '%s': %w", path, err)
+	}
+
+	return file, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close does nothing, so stdout stays open.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - error: Always nil.
+func (N nopWriteCloser) Close() error {
+	return nil
+}
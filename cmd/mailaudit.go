@@ -0,0 +1,434 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"commandCenter/dnsquery"
+	"commandCenter/styles"
+	"commandCenter/validators"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+)
+
+const (
+	severityPass = "pass"
+	severityInfo = "info"
+	severityWarn = "warn"
+	severityFail = "fail"
+
+	// maxMailRecordWidth shortens long records such as DKIM keys in the plain report.
+	maxMailRecordWidth = 120
+)
+
+// defaultDkimSelectors are the selectors commonly used by mail providers.
+// DKIM keys cannot be listed, so only known selectors can be checked.
+var defaultDkimSelectors = []string{
+	"default", "dkim", "mail", "smtp", "selector1", "selector2", "google", "k1", "k2", "k3",
+	"s1", "s2", "mandrill", "mxvault", "everlytickey1", "everlytickey2", "protonmail", "protonmail2", "protonmail3",
+}
+
+// mailWeights is the share of the score each check is worth. A warning
+// costs half of it, a failure all of it.
+var mailWeights = map[string]int{
+	"SPF":     30,
+	"DKIM":    20,
+	"DMARC":   30,
+	"MTA-STS": 10,
+	"TLS-RPT": 5,
+	"BIMI":    5,
+}
+
+type MailAudit struct {
+	domainName  string
+	selectors   []string
+	resolver    dnsquery.Resolver
+	fetchPolicy bool
+}
+
+type Finding struct {
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+type MailDetail struct {
+	Name  string `json:"name"`
+	Value any    `json:"value"`
+}
+
+type MailCheck struct {
+	Name     string       `json:"name"`
+	Status   string       `json:"status"`
+	Records  []string     `json:"records"`
+	Details  []MailDetail `json:"details,omitempty"`
+	Tree     *SPFNode     `json:"tree,omitempty"`
+	Findings []Finding    `json:"findings"`
+}
+
+type MailReport struct {
+	Domain string      `json:"domain"`
+	Score  int         `json:"score"`
+	Grade  string      `json:"grade"`
+	Checks []MailCheck `json:"checks"`
+}
+
+var mailAuditCmd = &cobra.Command{
+	Use:     "mailaudit",
+	Short:   "Audit the email security records of a domain.",
+	Long:    "Check the SPF, DKIM, DMARC, MTA-STS, TLS-RPT and BIMI records of a domain and grade them, listing every misconfiguration found.",
+	Aliases: []string{"mail", "email"},
+	Example: `
+      # Audit the mail records of example.com
+      ops dns mailaudit -d example.com
+
+      # Check DKIM keys for your own selectors and print the report as JSON
+      ops dns mailaudit -d example.com --selectors s2048,mailjet -o json
+
+      # Audit against a local test server, without fetching the MTA-STS policy over HTTPS
+      ops dns mailaudit -d example.test -s 127.0.0.1:8888 --fetch-policy=false
+
+      # Get help for the mailaudit command
+      ops dns mailaudit --help
+    `,
+
+	Run: auditMail,
+}
+
+// init initializes the mailaudit command and its flags.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - None
+func init() {
+	dnsCmd.AddCommand(mailAuditCmd)
+	addUpstreamFlags(mailAuditCmd)
+
+	mailAuditCmd.Flags().StringP("domain", "d", "", "domain to audit")
+	mailAuditCmd.Flags().StringSlice("selectors", defaultDkimSelectors, "DKIM selectors to look for")
+	mailAuditCmd.Flags().Bool("fetch-policy", true, "fetch the MTA-STS policy over HTTPS and check it against the MX records")
+	mailAuditCmd.Flags().StringP("output", "o", outputPlain, "output format: plain or json")
+
+	mailAuditCmd.MarkFlagRequired("domain")
+}
+
+// auditMail is the main function for the mailaudit command.
+//
+// Args:
+//   - cmd: The cobra command.
+//   - args: The command arguments.
+//
+// Returns:
+//   - None
+func auditMail(cmd *cobra.Command, args []string) {
+	domainName, err := validators.VerifyStringInputs(cmd, "domain")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	selectors, err := validators.VerifyStringSliceInputs(cmd, "selectors")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	fetchPolicy, err := validators.VerifyBoolInputs(cmd, "fetch-policy")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	output, err := validators.VerifyStringInputs(cmd, "output")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	output = strings.ToLower(output)
+	if output != outputPlain && output != outputJSON {
+		log.Fatalln(styles.NewStyles().Error.Render(fmt.Sprintf("unknown output format '%s', use plain or json", output)))
+	}
+
+	upstreams, err := upstreamsFromFlags(cmd)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	audit := MailAudit{
+		domainName:  strings.TrimSuffix(strings.ToLower(domainName), "."),
+		selectors:   selectors,
+		resolver:    upstreams,
+		fetchPolicy: fetchPolicy,
+	}
+
+	report := audit.Run(cmd.Context())
+
+	if output == outputJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Fatalln(err)
+		}
+	} else {
+		printMailReport(report)
+	}
+
+	for _, check := range report.Checks {
+		if check.Status == severityFail {
+			os.Exit(1)
+		}
+	}
+}
+
+// Run checks every mail record of the domain and grades the result.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//
+// Returns:
+//   - MailReport: The graded report.
+func (M MailAudit) Run(ctx context.Context) MailReport {
+	spf := M.checkSPF(ctx)
+	dkim := M.checkDKIM(ctx)
+	dmarc, policy := M.checkDMARC(ctx)
+	mtaSts, stsConfigured := M.checkMTASTS(ctx)
+	tlsRpt := M.checkTLSRPT(ctx, stsConfigured)
+	bimi := M.checkBIMI(ctx, policy)
+
+	report := MailReport{
+		Domain: M.domainName,
+		Checks: []MailCheck{spf, dkim, dmarc, mtaSts, tlsRpt, bimi},
+	}
+	for i := range report.Checks {
+		check := &report.Checks[i]
+		check.Status = severityPass
+		for _, finding := range check.Findings {
+			if severityRank(finding.Severity) > severityRank(check.Status) {
+				check.Status = finding.Severity
+			}
+		}
+
+		switch check.Status {
+		case severityPass, severityInfo:
+			report.Score += mailWeights[check.Name]
+		case severityWarn:
+			report.Score += mailWeights[check.Name] / 2
+		}
+	}
+	report.Grade = mailGrade(report.Score)
+
+	return report
+}
+
+// severityRank orders severities from pass to fail.
+//
+// Args:
+//   - severity: The severity.
+//
+// Returns:
+//   - int: The rank, higher is worse.
+func severityRank(severity string) int {
+	switch severity {
+	case severityInfo:
+		return 1
+	case severityWarn:
+		return 2
+	case severityFail:
+		return 3
+	}
+
+	return 0
+}
+
+// mailGrade converts a score out of 100 into a letter grade.
+//
+// Args:
+//   - score: The score.
+//
+// Returns:
+//   - string: The grade, A to F.
+func mailGrade(score int) string {
+	switch {
+	case score >= 90:
+		return "A"
+	case score >= 80:
+		return "B"
+	case score >= 70:
+		return "C"
+	case score >= 60:
+		return "D"
+	}
+
+	return "F"
+}
+
+// add records a finding.
+//
+// Args:
+//   - severity: The severity, pass, info, warn or fail.
+//   - format: The message format.
+//   - args: The message arguments.
+//
+// Returns:
+//   - None
+func (C *MailCheck) add(severity, format string, args ...any) {
+	C.Findings = append(C.Findings, Finding{Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+// detail records a parsed value shown with the check.
+//
+// Args:
+//   - name: The name of the value.
+//   - value: The value.
+//
+// Returns:
+//   - None
+func (C *MailCheck) detail(name string, value any) {
+	C.Details = append(C.Details, MailDetail{Name: name, Value: value})
+}
+
+// txtRecords looks up the TXT records of a name, joining the strings of each
+// record as mail records are split into 255 byte chunks.
+//
+// Args:
+//   - ctx: The context bounding the query.
+//   - name: The name to query.
+//
+// Returns:
+//   - []string: The TXT records, empty for NXDOMAIN or NODATA.
+//   - error: An error if the name could not be resolved.
+func (M MailAudit) txtRecords(ctx context.Context, name string) ([]string, error) {
+	response, err := dnsquery.Negative(M.resolver.Lookup(ctx, dns.Fqdn(name), "TXT"))
+	if err != nil {
+		return nil, err
+	}
+	if rcode := response.Msg.Rcode; rcode != dns.RcodeSuccess && rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("%s answered %s for %s", response.Server, dns.RcodeToString[rcode], dns.Fqdn(name))
+	}
+
+	var records []string
+	for _, rr := range response.Msg.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			records = append(records, strings.Join(txt.Txt, ""))
+		}
+	}
+
+	return records, nil
+}
+
+// taggedRecords returns the TXT records of a name starting with a version tag
+// such as v=DMARC1.
+//
+// Args:
+//   - ctx: The context bounding the query.
+//   - name: The name to query.
+//   - version: The version tag the records start with.
+//
+// Returns:
+//   - []string: The matching records.
+//   - error: An error if the name could not be resolved.
+func (M MailAudit) taggedRecords(ctx context.Context, name, version string) ([]string, error) {
+	records, err := M.txtRecords(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var matching []string
+	for _, record := range records {
+		head, _, _ := strings.Cut(strings.TrimSpace(record), ";")
+		if strings.EqualFold(strings.ReplaceAll(head, " ", ""), version) {
+			matching = append(matching, record)
+		}
+	}
+
+	return matching, nil
+}
+
+// printMailReport prints the graded report.
+//
+// Args:
+//   - report: The report.
+//
+// Returns:
+//   - None
+func printMailReport(report MailReport) {
+	fmt.Printf(styles.NewStyles().Title.Render("📧 Mail audit for %s: grade %s (%d/100) 📧"), report.Domain, report.Grade, report.Score)
+	fmt.Println()
+
+	for _, check := range report.Checks {
+		fmt.Println()
+		fmt.Printf("%s %s %s\n", severityIcon(check.Status), styles.NewStyles().Title.Render(check.Name), check.Status)
+		for _, record := range check.Records {
+			if len(record) > maxMailRecordWidth {
+				record = record[:maxMailRecordWidth] + "..."
+			}
+			fmt.Printf("  record: %s\n", record)
+		}
+		for _, detail := range check.Details {
+			value := fmt.Sprint(detail.Value)
+			if values, ok := detail.Value.([]string); ok {
+				value = strings.Join(values, ", ")
+			}
+			fmt.Printf("  %s: %s\n", detail.Name, value)
+		}
+		if check.Tree != nil {
+			printSPFTree(check.Tree, "  ")
+		}
+		for _, finding := range check.Findings {
+			message := fmt.Sprintf("%s %s", severityIcon(finding.Severity), finding.Message)
+			switch finding.Severity {
+			case severityFail:
+				fmt.Println(styles.NewStyles().Error.Render(message))
+			case severityPass:
+				fmt.Println("  " + styles.NewStyles().Highlight.Render(message))
+			default:
+				fmt.Println("  " + message)
+			}
+		}
+	}
+}
+
+// printSPFTree prints the includes of an SPF record with their lookup counts.
+//
+// Args:
+//   - node: The record.
+//   - indent: The indentation of the record.
+//
+// Returns:
+//   - None
+func printSPFTree(node *SPFNode, indent string) {
+	line := fmt.Sprintf("%s%s (lookups: %d)", indent, node.Domain, node.Lookups)
+	if node.Via != "" {
+		line = fmt.Sprintf("%s%s:%s (lookups: %d)", indent, node.Via, node.Domain, node.Lookups)
+	}
+	if node.Error != "" {
+		line += " " + styles.NewStyles().Error.Render(node.Error)
+	}
+	fmt.Println(line)
+
+	for _, child := range node.Children {
+		printSPFTree(child, indent+"  ")
+	}
+}
+
+// severityIcon returns the icon shown for a severity.
+//
+// Args:
+//   - severity: The severity.
+//
+// Returns:
+//   - string: The icon.
+func severityIcon(severity string) string {
+	switch severity {
+	case severityPass:
+		return "✅"
+	case severityWarn:
+		return "⚠️"
+	case severityFail:
+		return "❌"
+	}
+
+	return "ℹ️"
+}
@@ -0,0 +1,1032 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"commandCenter/dnsquery"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
+)
+
+const (
+	spfLookupLimit = 10
+	spfVoidLimit   = 2
+	spfMXLimit     = 10
+	mtaStsTimeout  = 10 * time.Second
+	mtaStsMaxAge   = 31557600
+	mtaStsMinAge   = 86400
+)
+
+// spfModifierPattern matches the name=value modifiers of an SPF record.
+var spfModifierPattern = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9._-]*)=(.*)$`)
+
+// mtaStsIDPattern matches the id of an MTA-STS record.
+var mtaStsIDPattern = regexp.MustCompile(`^[a-zA-Z0-9]{1,32}$`)
+
+type SPFNode struct {
+	Domain   string     `json:"domain"`
+	Via      string     `json:"via,omitempty"`
+	Record   string     `json:"record,omitempty"`
+	Lookups  int        `json:"lookups"`
+	Children []*SPFNode `json:"includes,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+type spfWalk struct {
+	audit   MailAudit
+	check   *MailCheck
+	lookups int
+	voids   int
+	all     string
+	stack   []string
+}
+
+type dmarcPolicy struct {
+	policy string
+	pct    int
+}
+
+// checkSPF fetches the SPF record and expands its includes and redirects,
+// counting the DNS lookups against the limit of RFC 7208.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//
+// Returns:
+//   - MailCheck: The SPF check.
+func (M MailAudit) checkSPF(ctx context.Context) MailCheck {
+	check := MailCheck{Name: "SPF", Records: []string{}}
+
+	walk := &spfWalk{audit: M, check: &check}
+	tree := walk.walk(ctx, M.domainName, "")
+	if tree.Record == "" {
+		if tree.Error == "no SPF record" {
+			check.add(severityFail, "no SPF record, any server can send mail as %s", M.domainName)
+		}
+		return check
+	}
+	check.Records = append(check.Records, tree.Record)
+	check.Tree = tree
+	check.detail("lookups", fmt.Sprintf("%d/%d", walk.lookups, spfLookupLimit))
+
+	switch {
+	case walk.lookups > spfLookupLimit:
+		check.add(severityFail, "%d DNS lookups, more than the limit of %d: SPF evaluation fails with permerror", walk.lookups, spfLookupLimit)
+	case walk.lookups > spfLookupLimit-2:
+		check.add(severityWarn, "%d DNS lookups, close to the limit of %d", walk.lookups, spfLookupLimit)
+	}
+	if walk.voids > spfVoidLimit {
+		check.add(severityFail, "%d lookups return no records, more than the limit of %d: SPF evaluation fails with permerror", walk.voids, spfVoidLimit)
+	}
+
+	switch walk.all {
+	case "+":
+		check.add(severityFail, "+all lets any server send mail as %s", M.domainName)
+	case "?":
+		check.add(severityWarn, "?all gives no verdict for unlisted servers, use ~all or -all")
+	case "~":
+		check.add(severityPass, "~all soft fails unlisted servers")
+	case "-":
+		check.add(severityPass, "-all rejects unlisted servers")
+	default:
+		check.add(severityWarn, "no all mechanism, unlisted servers get a neutral result")
+	}
+
+	return check
+}
+
+// spfRecords returns the SPF records of a domain.
+//
+// Args:
+//   - ctx: The context bounding the query.
+//   - domain: The domain.
+//
+// Returns:
+//   - []string: The TXT records starting with v=spf1.
+//   - error: An error if the domain could not be resolved.
+func (M MailAudit) spfRecords(ctx context.Context, domain string) ([]string, error) {
+	records, err := M.txtRecords(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	var spf []string
+	for _, record := range records {
+		lower := strings.ToLower(record)
+		if lower == "v=spf1" || strings.HasPrefix(lower, "v=spf1 ") {
+			spf = append(spf, record)
+		}
+	}
+
+	return spf, nil
+}
+
+// walk expands the SPF record of a domain, following includes and redirects.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//   - domain: The domain whose record is expanded.
+//   - via: How the record was reached, include or redirect, empty for the audited domain.
+//
+// Returns:
+//   - *SPFNode: The record and everything it includes.
+func (W *spfWalk) walk(ctx context.Context, domain, via string) *SPFNode {
+	node := &SPFNode{Domain: domain, Via: via}
+	if slices.Contains(W.stack, domain) {
+		node.Error = "include loop"
+		W.check.add(severityFail, "%s is included again through %s: SPF evaluation fails with permerror", domain, strings.Join(W.stack, " -> "))
+		return node
+	}
+	W.stack = append(W.stack, domain)
+	defer func() { W.stack = W.stack[:len(W.stack)-1] }()
+
+	records, err := W.audit.spfRecords(ctx, domain)
+	switch {
+	case err != nil:
+		node.Error = err.Error()
+		W.check.add(severityFail, "could not look up the SPF record of %s: %s", domain, err)
+		return node
+	case len(records) == 0:
+		node.Error = "no SPF record"
+		if via != "" {
+			W.voids++
+			W.check.add(severityFail, "%s:%s has no SPF record: SPF evaluation fails with permerror", via, domain)
+		}
+		return node
+	case len(records) > 1:
+		node.Error = "multiple SPF records"
+		if via == "" {
+			W.check.Records = records
+		}
+		W.check.add(severityFail, "%s has %d SPF records: SPF evaluation fails with permerror", domain, len(records))
+		return node
+	}
+	node.Record = records[0]
+	start := W.lookups
+
+	var (
+		redirect string
+		all      bool
+	)
+	terms := strings.Fields(node.Record)[1:]
+	for i, term := range terms {
+		if match := spfModifierPattern.FindStringSubmatch(term); match != nil {
+			switch strings.ToLower(match[1]) {
+			case "redirect":
+				redirect = strings.ToLower(strings.TrimSuffix(match[2], "."))
+			case "exp":
+			default:
+				W.check.add(severityInfo, "%s: unknown modifier %s is ignored", domain, term)
+			}
+			continue
+		}
+
+		qualifier := "+"
+		if strings.ContainsAny(term[:1], "+-~?") {
+			qualifier, term = term[:1], term[1:]
+		}
+		mechanism, target, _ := strings.Cut(term, ":")
+		mechanism, _, _ = strings.Cut(mechanism, "/")
+		target, _, _ = strings.Cut(target, "/")
+		target = strings.ToLower(strings.TrimSuffix(target, "."))
+
+		switch strings.ToLower(mechanism) {
+		case "all":
+			all = true
+			if via != "include" {
+				W.all = qualifier
+			}
+			if i < len(terms)-1 {
+				W.check.add(severityWarn, "%s: terms after all are ignored: %s", domain, strings.Join(terms[i+1:], " "))
+			}
+		case "include":
+			W.lookups++
+			if strings.Contains(target, "%") {
+				W.check.add(severityInfo, "%s: include:%s uses macros and is not expanded", domain, target)
+				continue
+			}
+			node.Children = append(node.Children, W.walk(ctx, target, "include"))
+		case "a", "mx":
+			W.lookups++
+			if target == "" {
+				target = domain
+			}
+			W.resolveTarget(ctx, domain, strings.ToLower(mechanism), target)
+		case "ptr":
+			W.lookups++
+			W.check.add(severityWarn, "%s: the ptr mechanism is slow and deprecated, use ip4/ip6 instead", domain)
+		case "exists":
+			W.lookups++
+		case "ip4", "ip6":
+			W.checkNetwork(domain, strings.ToLower(mechanism), qualifier, strings.TrimPrefix(term, mechanism+":"))
+		default:
+			W.check.add(severityFail, "%s: unknown mechanism %s: SPF evaluation fails with permerror", domain, term)
+		}
+	}
+
+	if redirect != "" {
+		if all {
+			W.check.add(severityWarn, "%s: redirect=%s is ignored because the record has an all mechanism", domain, redirect)
+		} else {
+			W.lookups++
+			node.Children = append(node.Children, W.walk(ctx, redirect, "redirect"))
+		}
+	}
+	node.Lookups = W.lookups - start
+
+	return node
+}
+
+// resolveTarget resolves the target of an a or mx mechanism to count void
+// lookups and the number of MX hosts.
+//
+// Args:
+//   - ctx: The context bounding the queries.
+//   - domain: The domain whose record holds the mechanism.
+//   - mechanism: The mechanism, a or mx.
+//   - target: The domain the mechanism points to.
+//
+// Returns:
+//   - None
+func (W *spfWalk) resolveTarget(ctx context.Context, domain, mechanism, target string) {
+	if strings.Contains(target, "%") {
+		return
+	}
+
+	qtypes := []string{"A", "AAAA"}
+	if mechanism == "mx" {
+		qtypes = []string{"MX"}
+	}
+
+	found := 0
+	for _, qtype := range qtypes {
+		answers, err := W.audit.answers(ctx, target, qtype)
+		if err != nil {
+			W.check.add(severityWarn, "%s: could not resolve %s:%s: %s", domain, mechanism, target, err)
+			return
+		}
+		found += len(answers)
+	}
+
+	if found == 0 {
+		W.voids++
+		W.check.add(severityWarn, "%s: %s:%s matches no records", domain, mechanism, target)
+	}
+	if mechanism == "mx" && found > spfMXLimit {
+		W.check.add(severityFail, "%s: mx:%s has %d MX records, more than the limit of %d: SPF evaluation fails with permerror", domain, target, found, spfMXLimit)
+	}
+}
+
+// checkNetwork validates the network of an ip4 or ip6 mechanism.
+//
+// Args:
+//   - domain: The domain whose record holds the mechanism.
+//   - mechanism: The mechanism, ip4 or ip6.
+//   - qualifier: The qualifier of the mechanism.
+//   - network: The address or CIDR block.
+//
+// Returns:
+//   - None
+func (W *spfWalk) checkNetwork(domain, mechanism, qualifier, network string) {
+	if !strings.Contains(network, "/") {
+		if mechanism == "ip4" {
+			network += "/32"
+		} else {
+			network += "/128"
+		}
+	}
+
+	ip, block, err := net.ParseCIDR(network)
+	if err != nil || (mechanism == "ip4") != (ip.To4() != nil) {
+		W.check.add(severityFail, "%s: %s:%s is not a valid %s network: SPF evaluation fails with permerror", domain, mechanism, network, mechanism)
+		return
+	}
+
+	if ones, _ := block.Mask.Size(); ones == 0 && qualifier == "+" {
+		W.check.add(severityFail, "%s: %s:%s lets any server send mail", domain, mechanism, network)
+	}
+}
+
+// checkDKIM looks up the DKIM key of every selector and checks its type and size.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//
+// Returns:
+//   - MailCheck: The DKIM check.
+func (M MailAudit) checkDKIM(ctx context.Context) MailCheck {
+	check := MailCheck{Name: "DKIM", Records: []string{}}
+
+	type lookup struct {
+		records []string
+		err     error
+	}
+	lookups := make([]lookup, len(M.selectors))
+
+	var wg sync.WaitGroup
+	for i, selector := range M.selectors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			records, err := M.txtRecords(ctx, selector+"._domainkey."+M.domainName)
+			lookups[i] = lookup{records: records, err: err}
+		}()
+	}
+	wg.Wait()
+
+	var found []string
+	for i, selector := range M.selectors {
+		if lookups[i].err != nil {
+			check.add(severityWarn, "could not look up selector %s: %s", selector, lookups[i].err)
+			continue
+		}
+		for _, record := range lookups[i].records {
+			found = append(found, selector)
+			check.Records = append(check.Records, selector+": "+record)
+			checkDkimKey(&check, selector, record)
+		}
+	}
+
+	if len(found) == 0 {
+		check.add(severityWarn, "no DKIM key found for the selectors %s, pass the selector your provider signs with to --selectors", strings.Join(M.selectors, ", "))
+	}
+
+	return check
+}
+
+// checkDkimKey parses a DKIM key record and checks the key type and size.
+//
+// Args:
+//   - check: The DKIM check the findings are added to.
+//   - selector: The selector of the key.
+//   - record: The key record.
+//
+// Returns:
+//   - None
+func checkDkimKey(check *MailCheck, selector, record string) {
+	tags, err := parseMailTags(record)
+	if err != nil {
+		check.add(severityFail, "selector %s: %s", selector, err)
+		return
+	}
+
+	if version, ok := tags["v"]; ok && version != "DKIM1" {
+		check.add(severityFail, "selector %s: unknown version v=%s", selector, version)
+		return
+	}
+
+	key, ok := tags["p"]
+	if !ok {
+		check.add(severityFail, "selector %s: no public key (p=)", selector)
+		return
+	}
+	if key == "" {
+		check.add(severityWarn, "selector %s: key is revoked (empty p=)", selector)
+		return
+	}
+
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(key), ""))
+	if err != nil {
+		check.add(severityFail, "selector %s: public key is not valid base64", selector)
+		return
+	}
+
+	keyType := strings.ToLower(tags["k"])
+	if keyType == "" {
+		keyType = "rsa"
+	}
+
+	switch keyType {
+	case "rsa":
+		bits := rsaKeyBits(der)
+		switch {
+		case bits == 0:
+			check.add(severityFail, "selector %s: public key is not a valid RSA key", selector)
+		case bits < 1024:
+			check.add(severityFail, "selector %s: %d-bit RSA key is too weak, receivers ignore it", selector, bits)
+		case bits < 2048:
+			check.add(severityWarn, "selector %s: %d-bit RSA key, use 2048 bits", selector, bits)
+		default:
+			check.add(severityPass, "selector %s: %d-bit RSA key", selector, bits)
+		}
+		if bits > 0 {
+			check.detail(selector, fmt.Sprintf("rsa %d bits", bits))
+		}
+	case "ed25519":
+		if len(der) != ed25519.PublicKeySize {
+			check.add(severityFail, "selector %s: public key is not a valid Ed25519 key", selector)
+		} else {
+			check.add(severityPass, "selector %s: Ed25519 key", selector)
+			check.detail(selector, "ed25519")
+		}
+	default:
+		check.add(severityFail, "selector %s: unknown key type k=%s", selector, keyType)
+	}
+
+	if slices.Contains(strings.Split(tags["t"], ":"), "y") {
+		check.add(severityWarn, "selector %s: key is in testing mode (t=y), receivers treat signatures as unsigned", selector)
+	}
+	if hashes, ok := tags["h"]; ok && !slices.Contains(strings.Split(strings.ToLower(hashes), ":"), "sha256") {
+		check.add(severityWarn, "selector %s: key only allows h=%s, use sha256", selector, hashes)
+	}
+}
+
+// rsaKeyBits returns the size of a DER encoded RSA public key.
+//
+// Args:
+//   - der: The key, as SubjectPublicKeyInfo or PKCS #1.
+//
+// Returns:
+//   - int: The modulus size in bits, 0 if the key cannot be parsed.
+func rsaKeyBits(der []byte) int {
+	if key, err := x509.ParsePKIXPublicKey(der); err == nil {
+		if rsaKey, ok := key.(*rsa.PublicKey); ok {
+			return rsaKey.N.BitLen()
+		}
+		return 0
+	}
+	if key, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		return key.N.BitLen()
+	}
+
+	return 0
+}
+
+// checkDMARC fetches the DMARC record, falling back to the organizational
+// domain, and checks its policy and reporting addresses.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//
+// Returns:
+//   - MailCheck: The DMARC check.
+//   - dmarcPolicy: The policy applying to the domain, used by the BIMI check.
+func (M MailAudit) checkDMARC(ctx context.Context) (MailCheck, dmarcPolicy) {
+	check := MailCheck{Name: "DMARC", Records: []string{}}
+
+	name := "_dmarc." + M.domainName
+	records, err := M.taggedRecords(ctx, name, "v=DMARC1")
+	if err != nil {
+		check.add(severityFail, "could not look up %s: %s", name, err)
+		return check, dmarcPolicy{}
+	}
+
+	inherited := false
+	if org := orgDomain(M.domainName); len(records) == 0 && org != M.domainName {
+		orgRecords, err := M.taggedRecords(ctx, "_dmarc."+org, "v=DMARC1")
+		if err == nil && len(orgRecords) > 0 {
+			check.add(severityInfo, "no record at %s, the policy of %s applies", name, org)
+			name, records, inherited = "_dmarc."+org, orgRecords, true
+		}
+	}
+
+	switch {
+	case len(records) == 0:
+		check.add(severityFail, "no DMARC record at %s, receivers do not enforce SPF and DKIM alignment", name)
+		return check, dmarcPolicy{}
+	case len(records) > 1:
+		check.Records = records
+		check.add(severityFail, "%s has %d DMARC records, receivers ignore all of them", name, len(records))
+		return check, dmarcPolicy{}
+	}
+	check.Records = records
+
+	tags, err := parseMailTags(records[0])
+	if err != nil {
+		check.add(severityFail, "%s", err)
+		return check, dmarcPolicy{}
+	}
+
+	policy := dmarcPolicy{policy: strings.ToLower(tags["p"]), pct: 100}
+	if !slices.Contains([]string{"none", "quarantine", "reject"}, policy.policy) {
+		check.add(severityFail, "missing or invalid policy p=%s, use none, quarantine or reject", tags["p"])
+		return check, dmarcPolicy{}
+	}
+
+	subdomainPolicy, ok := tags["sp"]
+	subdomainPolicy = strings.ToLower(subdomainPolicy)
+	if !ok {
+		subdomainPolicy = policy.policy
+	} else if !slices.Contains([]string{"none", "quarantine", "reject"}, subdomainPolicy) {
+		check.add(severityFail, "invalid subdomain policy sp=%s", subdomainPolicy)
+	} else if dmarcStrength(subdomainPolicy) < dmarcStrength(policy.policy) {
+		check.add(severityWarn, "subdomain policy sp=%s is weaker than p=%s, subdomains can be spoofed", subdomainPolicy, policy.policy)
+	}
+	if inherited {
+		policy.policy = subdomainPolicy
+	}
+
+	switch policy.policy {
+	case "none":
+		check.add(severityWarn, "p=none only monitors, spoofed mail is still delivered")
+	default:
+		check.add(severityPass, "p=%s is enforced", policy.policy)
+	}
+
+	if value, ok := tags["pct"]; ok {
+		pct, err := strconv.Atoi(value)
+		switch {
+		case err != nil || pct < 0 || pct > 100:
+			check.add(severityFail, "invalid pct=%s, use 0 to 100", value)
+		case pct < 100 && policy.policy != "none":
+			check.add(severityWarn, "pct=%d applies the policy to only part of the mail", pct)
+		}
+		if err == nil {
+			policy.pct = pct
+		}
+	}
+
+	for _, tag := range []string{"adkim", "aspf"} {
+		if value, ok := tags[tag]; ok && value != "r" && value != "s" {
+			check.add(severityFail, "invalid %s=%s, use r or s", tag, value)
+		}
+	}
+
+	rua := mailURIs(tags["rua"])
+	ruf := mailURIs(tags["ruf"])
+	if len(rua) == 0 {
+		check.add(severityWarn, "no aggregate report address (rua=), failures go unnoticed")
+	}
+	for _, uri := range slices.Concat(rua, ruf) {
+		M.checkReportAuthorization(ctx, &check, uri)
+	}
+
+	check.detail("policy", policy.policy)
+	check.detail("subdomain policy", subdomainPolicy)
+	check.detail("pct", policy.pct)
+	check.detail("alignment", fmt.Sprintf("dkim %s, spf %s", dmarcAlignment(tags["adkim"]), dmarcAlignment(tags["aspf"])))
+	if len(rua) > 0 {
+		check.detail("rua", rua)
+	}
+	if len(ruf) > 0 {
+		check.detail("ruf", ruf)
+	}
+
+	return check, policy
+}
+
+// checkReportAuthorization checks that a DMARC report address outside the
+// domain accepts its reports (RFC 7489 section 7.1).
+//
+// Args:
+//   - ctx: The context bounding the query.
+//   - check: The DMARC check the findings are added to.
+//   - uri: The report URI.
+//
+// Returns:
+//   - None
+func (M MailAudit) checkReportAuthorization(ctx context.Context, check *MailCheck, uri string) {
+	address, ok := strings.CutPrefix(strings.ToLower(uri), "mailto:")
+	if !ok {
+		check.add(severityFail, "report address %s is not a mailto: URI", uri)
+		return
+	}
+	address, _, _ = strings.Cut(address, "!")
+
+	_, target, ok := strings.Cut(address, "@")
+	if !ok || target == "" {
+		check.add(severityFail, "report address %s is not a valid email address", uri)
+		return
+	}
+	if orgDomain(target) == orgDomain(M.domainName) {
+		return
+	}
+
+	name := M.domainName + "._report._dmarc." + target
+	records, err := M.taggedRecords(ctx, name, "v=DMARC1")
+	switch {
+	case err != nil:
+		check.add(severityWarn, "could not check that %s accepts reports: %s", target, err)
+	case len(records) == 0:
+		check.add(severityWarn, "%s does not accept reports for %s (no record at %s), they are dropped", target, M.domainName, name)
+	}
+}
+
+// checkMTASTS fetches the MTA-STS record and policy and checks that the
+// policy covers every MX host.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//
+// Returns:
+//   - MailCheck: The MTA-STS check.
+//   - bool: Whether the domain publishes an MTA-STS record.
+func (M MailAudit) checkMTASTS(ctx context.Context) (MailCheck, bool) {
+	check := MailCheck{Name: "MTA-STS", Records: []string{}}
+
+	name := "_mta-sts." + M.domainName
+	records, err := M.taggedRecords(ctx, name, "v=STSv1")
+	switch {
+	case err != nil:
+		check.add(severityFail, "could not look up %s: %s", name, err)
+		return check, false
+	case len(records) == 0:
+		check.add(severityWarn, "not configured, mail to %s can be downgraded to plaintext", M.domainName)
+		return check, false
+	case len(records) > 1:
+		check.Records = records
+		check.add(severityFail, "%s has %d MTA-STS records, senders ignore all of them", name, len(records))
+		return check, true
+	}
+	check.Records = records
+
+	tags, err := parseMailTags(records[0])
+	if err != nil {
+		check.add(severityFail, "%s", err)
+		return check, true
+	}
+	if !mtaStsIDPattern.MatchString(tags["id"]) {
+		check.add(severityFail, "missing or invalid id=%s, use 1 to 32 letters and digits", tags["id"])
+	} else {
+		check.detail("id", tags["id"])
+	}
+
+	if !M.fetchPolicy {
+		check.add(severityInfo, "policy not fetched (--fetch-policy=false)")
+		return check, true
+	}
+
+	policy, err := M.fetchMTASTSPolicy(ctx, &check)
+	if err != nil {
+		check.add(severityFail, "could not fetch the policy: %s", err)
+		return check, true
+	}
+
+	mode := policy["mode"]
+	switch {
+	case policy["version"] == nil || policy["version"][0] != "STSv1":
+		check.add(severityFail, "policy has no version: STSv1 line")
+	case mode == nil:
+		check.add(severityFail, "policy has no mode")
+	default:
+		check.detail("mode", mode[0])
+		switch mode[0] {
+		case "enforce":
+			check.add(severityPass, "policy is enforced")
+		case "testing":
+			check.add(severityWarn, "policy is in testing mode, TLS failures are only reported")
+		case "none":
+			check.add(severityWarn, "policy mode none disables MTA-STS")
+		default:
+			check.add(severityFail, "unknown policy mode %s, use enforce, testing or none", mode[0])
+		}
+	}
+
+	if maxAge := policy["max_age"]; maxAge == nil {
+		check.add(severityFail, "policy has no max_age")
+	} else if age, err := strconv.Atoi(maxAge[0]); err != nil || age < 0 || age > mtaStsMaxAge {
+		check.add(severityFail, "invalid max_age %s, use 0 to %d seconds", maxAge[0], mtaStsMaxAge)
+	} else {
+		check.detail("max_age", age)
+		if age < mtaStsMinAge {
+			check.add(severityWarn, "max_age %d is shorter than a day, senders refetch the policy constantly", age)
+		}
+	}
+
+	patterns := policy["mx"]
+	check.detail("mx", patterns)
+	if mode == nil || mode[0] == "none" {
+		return check, true
+	}
+	if len(patterns) == 0 {
+		check.add(severityFail, "policy lists no mx hosts")
+		return check, true
+	}
+
+	hosts, err := M.answers(ctx, M.domainName, "MX")
+	if err != nil {
+		check.add(severityWarn, "could not look up the MX records: %s", err)
+		return check, true
+	}
+	for _, rr := range hosts {
+		host := strings.ToLower(strings.TrimSuffix(rr.(*dns.MX).Mx, "."))
+		if !slices.ContainsFunc(patterns, func(pattern string) bool { return mtaStsMatches(pattern, host) }) {
+			check.add(severityFail, "MX host %s is not listed in the policy, delivery to it fails once enforced", host)
+		}
+	}
+
+	return check, true
+}
+
+// fetchMTASTSPolicy downloads the MTA-STS policy of the domain. Redirects
+// are not followed, as required by RFC 8461.
+//
+// Args:
+//   - ctx: The context bounding the request.
+//   - check: The MTA-STS check the findings are added to.
+//
+// Returns:
+//   - map[string][]string: The policy fields, mx repeated once per host.
+//   - error: An error if the policy cannot be fetched.
+func (M MailAudit) fetchMTASTSPolicy(ctx context.Context, check *MailCheck) (map[string][]string, error) {
+	url := "https://mta-sts." + M.domainName + "/.well-known/mta-sts.txt"
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{
+		Timeout: mtaStsTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %s", url, response.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type")); mediaType != "text/plain" {
+		check.add(severityWarn, "policy is served as %q instead of text/plain", response.Header.Get("Content-Type"))
+	}
+
+	policy := map[string][]string{}
+	scanner := bufio.NewScanner(io.LimitReader(response.Body, 64*1024))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		policy[key] = append(policy[key], strings.TrimSpace(value))
+	}
+
+	return policy, scanner.Err()
+}
+
+// mtaStsMatches reports whether an MX host matches an MTA-STS mx pattern,
+// where a leading wildcard matches exactly one label.
+//
+// Args:
+//   - pattern: The mx pattern of the policy.
+//   - host: The MX host.
+//
+// Returns:
+//   - bool: Whether the host matches.
+func mtaStsMatches(pattern, host string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		label, rest, found := strings.Cut(host, ".")
+		return found && label != "" && rest == suffix
+	}
+
+	return pattern == host
+}
+
+// checkTLSRPT fetches the TLS-RPT record and checks its report addresses.
+//
+// Args:
+//   - ctx: The context bounding the query.
+//   - mtaSts: Whether the domain publishes an MTA-STS record.
+//
+// Returns:
+//   - MailCheck: The TLS-RPT check.
+func (M MailAudit) checkTLSRPT(ctx context.Context, mtaSts bool) MailCheck {
+	check := MailCheck{Name: "TLS-RPT", Records: []string{}}
+
+	name := "_smtp._tls." + M.domainName
+	records, err := M.taggedRecords(ctx, name, "v=TLSRPTv1")
+	switch {
+	case err != nil:
+		check.add(severityFail, "could not look up %s: %s", name, err)
+		return check
+	case len(records) == 0 && mtaSts:
+		check.add(severityWarn, "not configured, MTA-STS failures are not reported to you")
+		return check
+	case len(records) == 0:
+		check.add(severityInfo, "not configured")
+		return check
+	case len(records) > 1:
+		check.Records = records
+		check.add(severityFail, "%s has %d TLS-RPT records, senders ignore all of them", name, len(records))
+		return check
+	}
+	check.Records = records
+
+	tags, err := parseMailTags(records[0])
+	if err != nil {
+		check.add(severityFail, "%s", err)
+		return check
+	}
+
+	rua := mailURIs(tags["rua"])
+	if len(rua) == 0 {
+		check.add(severityFail, "no report address (rua=)")
+		return check
+	}
+	for _, uri := range rua {
+		if !strings.HasPrefix(strings.ToLower(uri), "mailto:") && !strings.HasPrefix(strings.ToLower(uri), "https:") {
+			check.add(severityFail, "report address %s is not a mailto: or https: URI", uri)
+		}
+	}
+	check.detail("rua", rua)
+
+	return check
+}
+
+// checkBIMI fetches the BIMI record and checks the logo and certificate URLs
+// and the DMARC enforcement BIMI requires.
+//
+// Args:
+//   - ctx: The context bounding the query.
+//   - policy: The DMARC policy applying to the domain.
+//
+// Returns:
+//   - MailCheck: The BIMI check.
+func (M MailAudit) checkBIMI(ctx context.Context, policy dmarcPolicy) MailCheck {
+	check := MailCheck{Name: "BIMI", Records: []string{}}
+
+	name := "default._bimi." + M.domainName
+	records, err := M.taggedRecords(ctx, name, "v=BIMI1")
+	switch {
+	case err != nil:
+		check.add(severityFail, "could not look up %s: %s", name, err)
+		return check
+	case len(records) == 0:
+		check.add(severityInfo, "not configured")
+		return check
+	case len(records) > 1:
+		check.Records = records
+		check.add(severityFail, "%s has %d BIMI records, receivers ignore all of them", name, len(records))
+		return check
+	}
+	check.Records = records
+
+	tags, err := parseMailTags(records[0])
+	if err != nil {
+		check.add(severityFail, "%s", err)
+		return check
+	}
+
+	logo := tags["l"]
+	switch {
+	case logo == "":
+		check.add(severityInfo, "the domain declines to show a logo (empty l=)")
+		return check
+	case !strings.HasPrefix(strings.ToLower(logo), "https://"):
+		check.add(severityFail, "logo %s is not an https URL", logo)
+	case !strings.HasSuffix(strings.ToLower(logo), ".svg"):
+		check.add(severityWarn, "logo %s is not an SVG file", logo)
+	}
+	check.detail("logo", logo)
+
+	if certificate := tags["a"]; certificate == "" {
+		check.add(severityWarn, "no Verified Mark Certificate (a=), most mailbox providers do not show the logo")
+	} else if !strings.HasPrefix(strings.ToLower(certificate), "https://") {
+		check.add(severityFail, "certificate %s is not an https URL", certificate)
+	} else {
+		check.detail("certificate", certificate)
+	}
+
+	if (policy.policy != "quarantine" && policy.policy != "reject") || policy.pct < 100 {
+		check.add(severityFail, "BIMI requires DMARC enforcement (p=quarantine or p=reject with pct=100)")
+	}
+
+	return check
+}
+
+// answers returns the records of a type in the answer section.
+//
+// Args:
+//   - ctx: The context bounding the query.
+//   - name: The name to query.
+//   - qtype: The record type.
+//
+// Returns:
+//   - []dns.RR: The matching records, empty for NXDOMAIN or NODATA.
+//   - error: An error if the name could not be resolved.
+func (M MailAudit) answers(ctx context.Context, name, qtype string) ([]dns.RR, error) {
+	response, err := dnsquery.Negative(M.resolver.Lookup(ctx, dns.Fqdn(name), qtype))
+	if err != nil {
+		return nil, err
+	}
+	if rcode := response.Msg.Rcode; rcode != dns.RcodeSuccess && rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("%s answered %s for %s", response.Server, dns.RcodeToString[rcode], dns.Fqdn(name))
+	}
+
+	code, _ := dnsquery.ParseType(qtype)
+	var answers []dns.RR
+	for _, rr := range response.Msg.Answer {
+		if rr.Header().Rrtype == code {
+			answers = append(answers, rr)
+		}
+	}
+
+	return answers, nil
+}
+
+// parseMailTags parses the tag=value list of a DKIM, DMARC, MTA-STS, TLS-RPT
+// or BIMI record.
+//
+// Args:
+//   - record: The record.
+//
+// Returns:
+//   - map[string]string: The values by lower case tag name.
+//   - error: An error if a tag is malformed or repeated.
+func parseMailTags(record string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, part := range strings.Split(record, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed tag %q in %q", part, record)
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, seen := tags[name]; seen {
+			return nil, fmt.Errorf("tag %s is repeated in %q", name, record)
+		}
+		tags[name] = strings.TrimSpace(value)
+	}
+
+	return tags, nil
+}
+
+// mailURIs splits a comma separated list of report URIs.
+//
+// Args:
+//   - value: The list.
+//
+// Returns:
+//   - []string: The URIs.
+func mailURIs(value string) []string {
+	var uris []string
+	for _, uri := range strings.Split(value, ",") {
+		if uri = strings.TrimSpace(uri); uri != "" {
+			uris = append(uris, uri)
+		}
+	}
+
+	return uris
+}
+
+// dmarcStrength orders DMARC policies from none to reject.
+//
+// Args:
+//   - policy: The policy.
+//
+// Returns:
+//   - int: The strength, higher is stricter.
+func dmarcStrength(policy string) int {
+	return slices.Index([]string{"none", "quarantine", "reject"}, policy)
+}
+
+// dmarcAlignment names an alignment mode, relaxed by default.
+//
+// Args:
+//   - mode: The adkim or aspf value.
+//
+// Returns:
+//   - string: relaxed or strict.
+func dmarcAlignment(mode string) string {
+	if mode == "s" {
+		return "strict"
+	}
+
+	return "relaxed"
+}
+
+// orgDomain finds the organizational domain of RFC 7489 section 3.2: the
+// public suffix of the domain plus one label, from the public suffix list.
+//
+// Args:
+//   - domain: The domain.
+//
+// Returns:
+//   - string: The organizational domain, the domain itself when it is a
+//     public suffix.
+func orgDomain(domain string) string {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+
+	org, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return domain
+	}
+
+	return org
+}
@@ -107,10 +107,10 @@ func executeCommand(command string, args ...string) {
 		log.Println(styles.StyliseMessage("Error encountered, cleaning up temporary resources", styles.FormatStyle.Highlight))
 		cleanUp(".venv")
 		message := fmt.Sprintf("Error executing command %s - %s\nCommand output (stderr/stdout):\n%s\n", command, err, output)
-		log.Fatalf(styles.StyliseMessage(message, styles.FormatStyle.Error))
+		log.Fatal(styles.StyliseMessage(message, styles.FormatStyle.Error))
 	}
 
-	log.Printf(styles.StyliseMessage(fmt.Sprintf("Command executed successfully: %s%s", command, args), styles.FormatStyle.Highlight))
+	log.Print(styles.StyliseMessage(fmt.Sprintf("Command executed successfully: %s%s", command, args), styles.FormatStyle.Highlight))
 }
 
 // runOpenMetaDataIngestion runs the Open-Metadata ingestion process.
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"commandCenter/dnsquery"
+	"commandCenter/styles"
+
+	"github.com/goccy/go-yaml"
+	"github.com/miekg/dns"
+)
+
+const (
+	outputPlain = "plain"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputTable = "table"
+)
+
+// validateOutput checks that an output format is supported.
+//
+// Args:
+//   - output: The output format.
+//
+// Returns:
+//   - error: An error if the format is unknown.
+func validateOutput(output string) error {
+	switch output {
+	case outputPlain, outputJSON, outputYAML, outputTable:
+		return nil
+	}
+
+	return fmt.Errorf("unknown output format '%s', use json, yaml, table or plain", output)
+}
+
+// renderResults writes results to stdout in a structured output format.
+//
+// Args:
+//   - output: The output format, json, yaml or table.
+//   - results: The results to render.
+//
+// Returns:
+//   - error: An error if the results cannot be encoded.
+func renderResults(output string, results []dnsquery.Result) error {
+	switch output {
+	case outputJSON:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if len(results) == 1 {
+			return encoder.Encode(results[0])
+		}
+		return encoder.Encode(results)
+	case outputYAML:
+		var (
+			encoded []byte
+			err     error
+		)
+		if len(results) == 1 {
+			encoded, err = yaml.Marshal(results[0])
+		} else {
+			encoded, err = yaml.Marshal(results)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(encoded)
+		return err
+	case outputTable:
+		return renderTable(results)
+	}
+
+	return validateOutput(output)
+}
+
+// renderTable writes results as an aligned table, one row per record.
+//
+// Args:
+//   - results: The results to render.
+//
+// Returns:
+//   - error: An error if the table cannot be written.
+func renderTable(results []dnsquery.Result) error {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	for _, result := range results {
+		fmt.Fprintf(writer, "%s %s  status: %s  server: %s (%s)  rtt: %.3fms\n",
+			result.Question.Name, result.Question.Type, result.Status, result.Server, result.Transport, result.RttMs,
+		)
+		fmt.Fprintln(writer, "SECTION\tNAME\tTTL\tCLASS\tTYPE\tVALUE")
+
+		sections := []struct {
+			name    string
+			records []dnsquery.Record
+		}{
+			{"answer", result.Answer},
+			{"authority", result.Authority},
+			{"additional", result.Additional},
+		}
+		for _, section := range sections {
+			for _, record := range section.records {
+				fmt.Fprintf(writer, "%s\t%s\t%d\t%s\t%s\t%s\n",
+					section.name, record.Name, record.TTL, record.Class, record.Type, record.Value,
+				)
+			}
+		}
+		fmt.Fprintln(writer)
+	}
+
+	return writer.Flush()
+}
+
+// formatRecord formats a resource record with its parsed fields, falling back
+// to the presentation format for types without a parser.
+//
+// Args:
+//   - rr: The resource record.
+//
+// Returns:
+//   - string: The formatted record.
+func formatRecord(rr dns.RR) string {
+	fields := dnsquery.Fields(rr)
+	if fields == nil {
+		return rr.String()
+	}
+
+	parts := make([]string, 0, len(fields))
+	for _, field := range fields {
+		value := fmt.Sprint(field.Value)
+		if values, ok := field.Value.([]string); ok {
+			value = strings.Join(values, ",")
+			if field.Name == "strings" {
+				value = fmt.Sprintf("%q", values)
+			}
+		}
+		parts = append(parts, fmt.Sprintf("%s: %s", field.Name, value))
+	}
+
+	header := rr.Header()
+	return fmt.Sprintf("%s %d %s  %s", header.Name, header.Ttl, dns.TypeToString[header.Rrtype], strings.Join(parts, "  "))
+}
+
+// printNoRecords prints an explicit message when a plain answer is empty.
+//
+// Args:
+//   - qtype: The query type.
+//   - response: The DNS response.
+//
+// Returns:
+//   - None
+func printNoRecords(qtype string, response dnsquery.Response) {
+	if len(response.Msg.Answer) > 0 {
+		return
+	}
+
+	status := dnsquery.NewResult(response).Status
+	fmt.Println(styles.NewStyles().Error.Render(
+		fmt.Sprintf("No %s records found (%s)", strings.ToUpper(qtype), status),
+	))
+}
@@ -0,0 +1,478 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"commandCenter/dnsquery"
+	"commandCenter/styles"
+	"commandCenter/validators"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+)
+
+type PropagationResolver struct {
+	label   string
+	address string
+}
+
+type PropagationAnswer struct {
+	resolver PropagationResolver
+	values   []string
+	ttl      uint32
+	status   string
+	err      error
+}
+
+type PropagationCheck struct {
+	domainName string
+	qtype      string
+	transport  dnsquery.Transport
+	resolvers  []PropagationResolver
+	expected   []string
+}
+
+// resolverPresets are the built-in public resolver lists for propagation checks.
+var resolverPresets = map[string][]PropagationResolver{
+	"google": {
+		{label: "Google", address: "8.8.8.8"},
+		{label: "Google", address: "8.8.4.4"},
+	},
+	"cloudflare": {
+		{label: "Cloudflare", address: "1.1.1.1"},
+		{label: "Cloudflare", address: "1.0.0.1"},
+	},
+	"quad9": {
+		{label: "Quad9", address: "9.9.9.9"},
+		{label: "Quad9", address: "149.112.112.112"},
+	},
+	"opendns": {
+		{label: "OpenDNS", address: "208.67.222.222"},
+		{label: "OpenDNS", address: "208.67.220.220"},
+	},
+	"other": {
+		{label: "AdGuard", address: "94.140.14.14"},
+		{label: "CleanBrowsing", address: "185.228.168.9"},
+		{label: "Comodo", address: "8.26.56.26"},
+		{label: "Level3", address: "4.2.2.1"},
+		{label: "Yandex", address: "77.88.8.8"},
+		{label: "DNS.Watch", address: "84.200.69.80"},
+	},
+}
+
+var propagationCmd = &cobra.Command{
+	Use:     "propagation",
+	Short:   "Check whether a DNS record has propagated across many resolvers.",
+	Long:    "Send the same question to many resolvers in parallel and group them by the answer they return.",
+	Aliases: []string{"prop", "propagate"},
+	Example: `
+      # Check the A record of example.com across all built-in public resolvers
+      ops dns propagation -d example.com -q A
+
+      # Only ask Google and Cloudflare, and expect a specific address
+      ops dns propagation -d example.com -q A -p google -p cloudflare -e 192.0.2.10
+
+      # Use your own resolver list and poll every 30s until every resolver agrees
+      ops dns propagation -d example.com -q TXT -r resolvers.txt --wait --interval 30s --max-wait 1h
+
+      # Ask the resolvers over DNS-over-TLS instead of UDP
+      ops dns propagation -d example.com -q A -p google -p cloudflare --transport tls
+
+      # Get help for the propagation command
+      ops dns propagation --help
+    `,
+
+	Run: checkPropagation,
+}
+
+// init initializes the propagation command and its flags.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - None
+func init() {
+	dnsCmd.AddCommand(propagationCmd)
+
+	propagationCmd.Flags().StringP("domain", "d", "example.com", "domain name to query for")
+	propagationCmd.Flags().StringP("qtype", "q", "A", "record type to check")
+	propagationCmd.Flags().StringSliceP("preset", "p", []string{}, "built-in resolver list: all, google, cloudflare, quad9, opendns, other (default all)")
+	propagationCmd.Flags().StringP("resolvers", "r", "", "file with one resolver per line as host[:port], optionally followed by a label")
+	propagationCmd.Flags().StringSliceP("resolver", "s", []string{}, "additional resolver as host[:port], repeatable")
+	propagationCmd.Flags().StringSliceP("expect", "e", []string{}, "value every resolver must return, repeatable")
+	propagationCmd.Flags().Bool("wait", false, "keep polling until every resolver agrees")
+	propagationCmd.Flags().Duration("interval", 30*time.Second, "time between polls used with --wait")
+	propagationCmd.Flags().Duration("max-wait", 0, "give up polling after this long, 0 to poll forever")
+	addTransportFlags(propagationCmd)
+}
+
+// checkPropagation is the main function for the propagation command.
+//
+// Args:
+//   - cmd: The cobra command.
+//   - args: The command arguments.
+//
+// Returns:
+//   - None
+func checkPropagation(cmd *cobra.Command, args []string) {
+	domainName, err := validators.VerifyStringInputs(cmd, "domain")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	qtype, err := validators.VerifyStringInputs(cmd, "qtype")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if _, err := dnsquery.ParseType(qtype); err != nil {
+		log.Fatalln(styles.NewStyles().Error.Render(err.Error()))
+	}
+
+	resolvers, err := propagationResolversFromFlags(cmd)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	expected, err := validators.VerifyStringSliceInputs(cmd, "expect")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	wait, err := validators.VerifyBoolInputs(cmd, "wait")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	interval, err := validators.VerifyDurationInputs(cmd, "interval")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	maxWait, err := validators.VerifyDurationInputs(cmd, "max-wait")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	transport, err := transportFromFlags(cmd)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	check := PropagationCheck{
+		domainName: domainName,
+		qtype:      qtype,
+		transport:  transport,
+		resolvers:  resolvers,
+		expected:   expected,
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+	defer stop()
+
+	deadline := time.Now().Add(maxWait)
+	for {
+		if check.Run(ctx) {
+			return
+		}
+
+		// The last check runs at the deadline rather than an interval past it.
+		pause := interval
+		if maxWait > 0 {
+			pause = min(interval, time.Until(deadline))
+		}
+		if !wait || pause <= 0 {
+			os.Exit(1)
+		}
+
+		fmt.Printf("not propagated yet, checking again in %s\n\n", pause.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			os.Exit(1)
+		case <-time.After(pause):
+		}
+	}
+}
+
+// propagationResolversFromFlags builds the resolver list from the --preset,
+// --resolvers and --resolver flags.
+//
+// Args:
+//   - cmd: The cobra command.
+//
+// Returns:
+//   - []PropagationResolver: The resolvers to query.
+//   - error: An error if the flags cannot be parsed or name an unknown preset.
+func propagationResolversFromFlags(cmd *cobra.Command) ([]PropagationResolver, error) {
+	presets, err := validators.VerifyStringSliceInputs(cmd, "preset")
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := validators.VerifyStringInputs(cmd, "resolvers")
+	if err != nil {
+		return nil, err
+	}
+
+	extra, err := validators.VerifyStringSliceInputs(cmd, "resolver")
+	if err != nil {
+		return nil, err
+	}
+
+	var resolvers []PropagationResolver
+	if file != "" {
+		resolvers, err = loadPropagationResolvers(file)
+		if err != nil {
+			return nil, fmt.Errorf(styles.NewStyles().Error.Render("%s"), err)
+		}
+	}
+	for _, address := range extra {
+		resolvers = append(resolvers, PropagationResolver{label: address, address: address})
+	}
+
+	if len(presets) == 0 && len(resolvers) == 0 {
+		presets = []string{"all"}
+	}
+	for _, preset := range presets {
+		preset = strings.ToLower(preset)
+		if preset == "all" {
+			for _, name := range []string{"google", "cloudflare", "quad9", "opendns", "other"} {
+				resolvers = append(resolvers, resolverPresets[name]...)
+			}
+			continue
+		}
+
+		list, ok := resolverPresets[preset]
+		if !ok {
+			return nil, fmt.Errorf(styles.NewStyles().Error.Render("unknown resolver preset '%s'"), preset)
+		}
+		resolvers = append(resolvers, list...)
+	}
+
+	return resolvers, nil
+}
+
+// loadPropagationResolvers reads resolvers from a file with one host[:port]
+// per line, optionally followed by a label. Blank lines and # comments are skipped.
+//
+// Args:
+//   - path: The path to the resolver list.
+//
+// Returns:
+//   - []PropagationResolver: The resolvers.
+//   - error: An error if the file cannot be read or is empty.
+func loadPropagationResolvers(path string) ([]PropagationResolver, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open '%s': %w", path, err)
+	}
+	defer file.Close()
+
+	var resolvers []PropagationResolver
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		label := fields[0]
+		if len(fields) > 1 {
+			label = strings.Join(fields[1:], " ")
+		}
+		resolvers = append(resolvers, PropagationResolver{label: label, address: fields[0]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read '%s': %w", path, err)
+	}
+	if len(resolvers) == 0 {
+		return nil, fmt.Errorf("no resolvers found in '%s'", path)
+	}
+
+	return resolvers, nil
+}
+
+// Run queries every resolver in parallel, prints the resolvers grouped by the
+// answer set they returned and reports whether the record has propagated.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//
+// Returns:
+//   - bool: Whether every resolver agrees, on the expected values when given.
+func (P PropagationCheck) Run(ctx context.Context) bool {
+	answers := make([]PropagationAnswer, len(P.resolvers))
+
+	var wg sync.WaitGroup
+	for i, resolver := range P.resolvers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			answers[i] = P.ask(ctx, resolver)
+		}()
+	}
+	wg.Wait()
+
+	groups := map[string][]PropagationAnswer{}
+	var keys []string
+	for _, answer := range answers {
+		key := answer.key()
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], answer)
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return len(groups[keys[i]]) > len(groups[keys[j]])
+	})
+
+	fmt.Printf(styles.NewStyles().Title.Render(
+		"%s %s across %d resolvers"), dns.Fqdn(P.domainName), strings.ToUpper(P.qtype), len(P.resolvers),
+	)
+	fmt.Println()
+
+	for _, key := range keys {
+		group := groups[key]
+		header := fmt.Sprintf("%d/%d resolvers: %s", len(group), len(answers), key)
+		if P.matches(group[0]) {
+			fmt.Println(styles.NewStyles().Highlight.Render(header))
+		} else {
+			fmt.Println(styles.NewStyles().Error.Render(header))
+		}
+
+		for _, answer := range group {
+			if answer.err != nil {
+				fmt.Printf("  %-16s %-22s %s\n", answer.resolver.label, answer.resolver.address, answer.err)
+				continue
+			}
+			if len(answer.values) == 0 {
+				fmt.Printf("  %-16s %-22s no records\n", answer.resolver.label, answer.resolver.address)
+				continue
+			}
+			fmt.Printf("  %-16s %-22s ttl %ds\n", answer.resolver.label, answer.resolver.address, answer.ttl)
+		}
+	}
+
+	propagated := len(keys) == 1 && P.matches(answers[0])
+	if propagated {
+		fmt.Println(styles.NewStyles().Highlight.Render("✅ Every resolver agrees"))
+	} else {
+		fmt.Println(styles.NewStyles().Error.Render("⏳ Resolvers disagree or do not return the expected value"))
+	}
+
+	return propagated
+}
+
+// ask sends the question to a single resolver.
+//
+// Args:
+//   - ctx: The context bounding the query.
+//   - resolver: The resolver to ask.
+//
+// Returns:
+//   - PropagationAnswer: The sorted answer values and the lowest TTL remaining.
+func (P PropagationCheck) ask(ctx context.Context, resolver PropagationResolver) PropagationAnswer {
+	answer := PropagationAnswer{resolver: resolver}
+
+	upstreams, err := dnsquery.NewUpstreams([]string{resolver.address}, P.transport)
+	if err != nil {
+		answer.err = err
+		return answer
+	}
+
+	domain := Domain{domainName: P.domainName, resolver: upstreams}
+	response, err := domain.PrepareDnsCall(ctx, P.qtype)
+	if err != nil {
+		answer.err = err
+		return answer
+	}
+
+	answer.status = dnsquery.NewResult(response).Status
+	qtype, _ := dnsquery.ParseType(P.qtype)
+	for _, rr := range response.Msg.Answer {
+		if rr.Header().Rrtype != qtype {
+			continue
+		}
+		answer.values = append(answer.values, dnsquery.NewRecord(rr).Value)
+		if answer.ttl == 0 || rr.Header().Ttl < answer.ttl {
+			answer.ttl = rr.Header().Ttl
+		}
+	}
+	slices.Sort(answer.values)
+
+	return answer
+}
+
+// key returns the answer set used to group resolvers.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - string: The sorted values, or the status when there are none.
+func (A PropagationAnswer) key() string {
+	switch {
+	case A.err != nil:
+		return "error"
+	case len(A.values) == 0:
+		return A.status
+	}
+
+	return strings.Join(A.values, ", ")
+}
+
+// matches reports whether an answer is the expected one. Without expected
+// values every successful, non-empty answer matches.
+//
+// Args:
+//   - answer: The answer to check.
+//
+// Returns:
+//   - bool: Whether the answer matches.
+func (P PropagationCheck) matches(answer PropagationAnswer) bool {
+	if answer.err != nil || len(answer.values) == 0 {
+		return false
+	}
+	if len(P.expected) == 0 {
+		return true
+	}
+
+	expected := make([]string, 0, len(P.expected))
+	for _, value := range P.expected {
+		expected = append(expected, normalizeValue(value))
+	}
+	values := make([]string, 0, len(answer.values))
+	for _, value := range answer.values {
+		values = append(values, normalizeValue(value))
+	}
+	slices.Sort(expected)
+	slices.Sort(values)
+
+	return slices.Equal(expected, values)
+}
+
+// normalizeValue makes record values comparable regardless of quoting, case
+// and trailing dots.
+//
+// Args:
+//   - value: The record value.
+//
+// Returns:
+//   - string: The normalized value.
+func normalizeValue(value string) string {
+	value = strings.ReplaceAll(value, `"`, "")
+	value = strings.TrimSuffix(strings.TrimSpace(value), ".")
+
+	return strings.ToLower(value)
+}
@@ -1,10 +1,14 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
+	"commandCenter/dnsquery"
 	"commandCenter/styles"
 	"commandCenter/validators"
 
@@ -13,15 +17,38 @@ import (
 )
 
 type DomainInterface interface {
-	Resolve()
-	ResolveAll()
-	PrepareDnsCall(qtype string) *dns.Msg
+	Resolve(ctx context.Context) error
+	ResolveAll(ctx context.Context) error
+	Trace(ctx context.Context) error
+	ResolveBulk(ctx context.Context) error
+	Reverse(ctx context.Context) error
+	Watch(ctx context.Context) error
+	PrepareDnsCall(ctx context.Context, qtype string) (dnsquery.Response, error)
 }
 
 type Domain struct {
 	domainName  string
 	qtype       string
 	recordTypes []string
+	resolver    dnsquery.Resolver
+	tracer      dnsquery.Tracer
+	output      string
+	all         bool
+	bulk        BulkOptions
+	dnssec      bool
+	anchors     []dns.RR
+	reverse     string
+	watch       WatchOptions
+	follow      bool
+}
+
+// defaultRecordTypes are the main record types resolved by --all.
+var defaultRecordTypes = []string{"ns", "a", "txt", "cname", "aaaa"}
+
+// allRecordTypes are every record type resolved by --all-types.
+var allRecordTypes = []string{
+	"a", "aaaa", "cname", "ns", "soa", "mx", "txt", "srv", "caa", "ptr",
+	"ds", "dnskey", "tlsa", "naptr", "https", "svcb",
 }
 
 var resolve = &cobra.Command{
@@ -40,6 +67,58 @@ var resolve = &cobra.Command{
       # Resolve all main record types (A, AAAA, CNAME, NS, TXT) for example.com
       ops resolve -d example.com -a
 
+      # Resolve a chosen list of record types, or every supported type
+      ops resolve -d example.com --types mx,soa,caa,https
+      ops resolve -d example.com --all-types -o json
+
+      # Ask an internal resolver first and fall back to a public one
+      ops resolve -d example.com -s 10.0.0.2 -s 1.1.1.1:53
+
+      # Use the nameservers, search domains and options from /etc/resolv.conf
+      ops resolve -d intranet --system
+
+      # Query over DNS-over-TLS, DNS-over-HTTPS (GET) or DNS-over-QUIC
+      ops resolve -d example.com -q a --transport tls -s 1.1.1.1 --tls-server-name one.one.one.one
+      ops resolve -d example.com -q a --transport https --doh-method GET -s https://dns.google/dns-query
+      ops resolve -d example.com -q a --transport quic -s dns.adguard-dns.com
+
+      # Keep truncated UDP answers instead of retrying over TCP, or retry slow servers twice
+      ops resolve -d example.com -q txt --ignore-tc
+      ops resolve -d example.com -q a --timeout 500ms --retries 2
+
+      # Print the full response as JSON for scripts (also yaml, table or plain)
+      ops resolve -d example.com -q mx -o json
+
+      # Resolve a list of domains (one per line, optionally followed by types) from a file or stdin
+      ops resolve -i domains.txt -w 50 --qps 200 --timeout 1s
+      cat domains.txt | ops resolve -i - -q mx -o json
+
+      # Validate the DNSSEC chain of trust up to the root, or up to a local trust anchor
+      ops resolve -d example.com -q a --dnssec
+      ops resolve -d www.example.test -q a --dnssec --trust-anchor ./example.test.ds
+
+      # Look up the PTR record of an address and check forward-confirmed reverse DNS
+      ops resolve -x 192.0.2.10
+
+      # Sweep a whole CIDR block to build an inventory of hostnames
+      ops resolve -x 192.0.2.0/24 -w 32 -o table
+
+      # Watch a record during a cutover, re-querying when its TTL expires
+      ops resolve -d www.example.com -q a --watch
+
+      # Poll every 10s and run a hook on every change, also logging JSON events
+      ops resolve -d www.example.com -q a --watch --interval 10s --on-change './notify.sh' --events changes.jsonl
+
+      # Follow a CNAME chain across zones and flag dangling targets (subdomain takeover risk)
+      ops resolve -d shop.example.com -q a --follow
+      ops resolve -i subdomains.txt -q cname --follow -o json
+
+      # Walk the delegations from the root servers, like dig +trace
+      ops resolve -d www.example.com -q a --trace
+
+      # Trace through a local hierarchy of test servers listening on port 5353
+      ops resolve -d www.example.test -q a --trace --root-server 127.0.0.2 --trace-port 5353
+
       # Get help for the resolve command
       ops resolve --help
     `,
@@ -56,12 +135,62 @@ var resolve = &cobra.Command{
 //   - None
 func init() {
 	dnsCmd.AddCommand(resolve)
+	addUpstreamFlags(resolve)
 
 	resolve.Flags().StringP("domain", "d", "example.com", "domain name to query for")
 	resolve.Flags().StringP("qtype", "q", "AAAA", "record type to search for A/AAAA/cname/txt")
 	resolve.Flags().BoolP("all", "a", false, "get information for all main records")
+	resolve.Flags().StringSlice("types", []string{}, "comma separated record types to resolve, e.g. mx,soa,srv,caa,https")
+	resolve.Flags().Bool("all-types", false, "resolve every supported record type ("+strings.ToUpper(strings.Join(allRecordTypes, ", "))+")")
+	resolve.Flags().StringP("output", "o", outputPlain, "output format: json, yaml, table or plain")
+	resolve.Flags().StringP("input", "i", "", "file with one domain per line, optionally followed by record types, or - for stdin")
+	resolve.Flags().IntP("workers", "w", 10, "number of concurrent queries used with --input and CIDR sweeps")
+	resolve.Flags().Float64("qps", 0, "maximum queries per second used with --input and CIDR sweeps, 0 for unlimited")
+	resolve.Flags().StringP("reverse", "x", "", "IPv4/IPv6 address or CIDR block to look up PTR records for")
+	resolve.Flags().Bool("watch", false, "re-query the record until interrupted and report every change")
+	resolve.Flags().Duration("interval", 0, "time between queries used with --watch, 0 to follow the record TTL")
+	resolve.Flags().Int("count", 0, "number of queries made by --watch, 0 for no limit")
+	resolve.Flags().Int("history", 20, "number of answer sets kept and printed when --watch stops")
+	resolve.Flags().String("on-change", "", "shell command run by --watch on every change, with the event as JSON on stdin")
+	resolve.Flags().String("events", "", "file receiving one JSON event per change with --watch, or - for stdout")
+	resolve.Flags().Bool("follow", false, "follow CNAME and DNAME chains across zones and flag loops, long chains and dangling targets")
+	resolve.Flags().Bool("dnssec", false, "set the DO bit and validate the DNSSEC chain of trust")
+	resolve.Flags().String("trust-anchor", "", "zone file with DS or DNSKEY trust anchors used by --dnssec (default root KSKs)")
+	resolve.Flags().Bool("trace", false, "resolve iteratively from the root servers and print every delegation")
+	resolve.Flags().String("root-hints", "", "named.root style file with the root servers used by --trace")
+	resolve.Flags().StringSlice("root-server", []string{}, "root server IP address used by --trace, repeatable")
+	resolve.Flags().String("trace-port", dnsquery.DefaultPort, "port used for every nameserver queried by --trace")
+	resolve.Flags().Bool("no-edns", false, "send queries without an OPT record")
+	resolve.Flags().Int("edns-size", dnsquery.DefaultEDNSBufferSize, "EDNS UDP buffer size advertised to the upstream (4096 with --dnssec unless set)")
+	resolve.Flags().String("ecs", "", "EDNS Client Subnet sent to the upstream as an address or CIDR block, e.g. 192.0.2.0/24")
+	resolve.Flags().Bool("nsid", false, "ask the upstream for its name server identifier (NSID)")
+	resolve.Flags().String("cookie", "", "DNS cookie in hex, client cookie optionally followed by server cookie (random client cookie without a value)")
+	resolve.Flags().Lookup("cookie").NoOptDefVal = "random"
+	resolve.Flags().Int("padding", 0, "pad queries to a multiple of this block size (128 without a value)")
+	resolve.Flags().Lookup("padding").NoOptDefVal = "128"
+	resolve.Flags().StringSlice("edns-opt", []string{}, "arbitrary EDNS option as code[:hex-value], repeatable")
 
-	resolve.MarkFlagsMutuallyExclusive("qtype", "all")
+	resolve.MarkFlagsMutuallyExclusive("qtype", "all", "types", "all-types")
+	resolve.MarkFlagsMutuallyExclusive("trace", "all")
+	resolve.MarkFlagsMutuallyExclusive("trace", "types")
+	resolve.MarkFlagsMutuallyExclusive("trace", "all-types")
+	resolve.MarkFlagsMutuallyExclusive("input", "domain")
+	resolve.MarkFlagsMutuallyExclusive("input", "trace")
+	resolve.MarkFlagsMutuallyExclusive("reverse", "domain")
+	resolve.MarkFlagsMutuallyExclusive("reverse", "input")
+	resolve.MarkFlagsMutuallyExclusive("reverse", "trace")
+	resolve.MarkFlagsMutuallyExclusive("reverse", "all")
+	resolve.MarkFlagsMutuallyExclusive("reverse", "qtype")
+	resolve.MarkFlagsMutuallyExclusive("reverse", "types")
+	resolve.MarkFlagsMutuallyExclusive("reverse", "all-types")
+	resolve.MarkFlagsMutuallyExclusive("root-hints", "root-server")
+	resolve.MarkFlagsMutuallyExclusive("follow", "trace")
+	resolve.MarkFlagsMutuallyExclusive("follow", "reverse")
+	resolve.MarkFlagsMutuallyExclusive("follow", "watch")
+	resolve.MarkFlagsMutuallyExclusive("watch", "all", "types", "all-types", "trace", "input", "reverse")
+	for _, option := range []string{"edns-size", "ecs", "nsid", "cookie", "padding", "edns-opt", "dnssec"} {
+		resolve.MarkFlagsMutuallyExclusive("no-edns", option)
+	}
 }
 
 // resolveDomain is the main function for the resolve command.
@@ -83,109 +212,953 @@ func resolveDomain(cmd *cobra.Command, args []string) {
 		log.Fatalln(err)
 	}
 
+	upstreams, err := upstreamsFromFlags(cmd)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	tracer, err := tracerFromFlags(cmd)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	output, err := validators.VerifyStringInputs(cmd, "output")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	output = strings.ToLower(output)
+	if err := validateOutput(output); err != nil {
+		log.Fatalln(styles.NewStyles().Error.Render(err.Error()))
+	}
+
+	all, err := validators.VerifyBoolInputs(cmd, "all")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	recordTypes, selected, err := recordTypesFromFlags(cmd)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	all = all || selected
+
+	bulk, err := bulkOptionsFromFlags(cmd)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	dnssec, err := validators.VerifyBoolInputs(cmd, "dnssec")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	anchors, err := trustAnchorsFromFlags(cmd)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	reverse, err := validators.VerifyStringInputs(cmd, "reverse")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	edns, err := ednsFromFlags(cmd)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
 	domain := Domain{
 		domainName:  domainName,
 		qtype:       qtype,
-		recordTypes: []string{"ns", "a", "txt", "cname", "aaaa"},
+		recordTypes: recordTypes,
+		resolver:    upstreams.WithDnssec(dnssec).WithEDNS(edns),
+		tracer:      tracer,
+		output:      output,
+		all:         all,
+		bulk:        bulk,
+		dnssec:      dnssec,
+		anchors:     anchors,
+		reverse:     reverse,
 	}
 
-	all, err := validators.VerifyBoolInputs(cmd, "all")
+	domain.follow, err = validators.VerifyBoolInputs(cmd, "follow")
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	if !all {
-		ResolveDomain(domain)
-	} else {
-		ResolveAllRecords(domain)
+	trace, err := validators.VerifyBoolInputs(cmd, "trace")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	domain.watch, err = watchOptionsFromFlags(cmd)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	ctx := cmd.Context()
+	switch {
+	case domain.watch.enabled:
+		err = WatchDomain(ctx, domain)
+	case reverse != "":
+		err = ReverseLookup(ctx, domain)
+	case bulk.input != "":
+		err = ResolveBulkRecords(ctx, domain)
+	case trace:
+		err = TraceDomain(ctx, domain)
+	case all:
+		err = ResolveAllRecords(ctx, domain)
+	default:
+		err = ResolveDomain(ctx, domain)
+	}
+	if err != nil {
+		log.Fatalln(styles.NewStyles().Error.Render(err.Error()))
+	}
+}
+
+// recordTypesFromFlags builds the record types resolved by --all from the
+// --types and --all-types flags.
+//
+// Args:
+//   - cmd: The cobra command.
+//
+// Returns:
+//   - []string: The record types, the main types when neither flag is set.
+//   - bool: Whether --types or --all-types selected the types.
+//   - error: An error if the flags cannot be parsed or name an unknown type.
+func recordTypesFromFlags(cmd *cobra.Command) ([]string, bool, error) {
+	types, err := validators.VerifyStringSliceInputs(cmd, "types")
+	if err != nil {
+		return nil, false, err
+	}
+
+	allTypes, err := validators.VerifyBoolInputs(cmd, "all-types")
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch {
+	case allTypes:
+		return allRecordTypes, true, nil
+	case len(types) == 0:
+		return defaultRecordTypes, false, nil
+	}
+
+	for _, qtype := range types {
+		if _, err := dnsquery.ParseType(qtype); err != nil {
+			return nil, false, fmt.Errorf(styles.NewStyles().Error.Render("%s"), err)
+		}
 	}
+
+	return types, true, nil
+}
+
+// watchOptionsFromFlags builds the watch options from the --watch, --interval,
+// --count, --history, --on-change and --events flags.
+//
+// Args:
+//   - cmd: The cobra command.
+//
+// Returns:
+//   - WatchOptions: The watch options.
+//   - error: An error if the flags cannot be parsed.
+func watchOptionsFromFlags(cmd *cobra.Command) (WatchOptions, error) {
+	enabled, err := validators.VerifyBoolInputs(cmd, "watch")
+	if err != nil {
+		return WatchOptions{}, err
+	}
+
+	interval, err := validators.VerifyDurationInputs(cmd, "interval")
+	if err != nil {
+		return WatchOptions{}, err
+	}
+
+	count, err := validators.VerifyIntInputs(cmd, "count")
+	if err != nil {
+		return WatchOptions{}, err
+	}
+
+	history, err := validators.VerifyIntInputs(cmd, "history")
+	if err != nil {
+		return WatchOptions{}, err
+	}
+
+	hook, err := validators.VerifyStringInputs(cmd, "on-change")
+	if err != nil {
+		return WatchOptions{}, err
+	}
+
+	events, err := validators.VerifyStringInputs(cmd, "events")
+	if err != nil {
+		return WatchOptions{}, err
+	}
+
+	return WatchOptions{
+		enabled:  enabled,
+		interval: interval,
+		count:    count,
+		history:  history,
+		hook:     hook,
+		events:   events,
+	}, nil
+}
+
+// bulkOptionsFromFlags builds the bulk options from the --input, --workers and --qps flags.
+//
+// Args:
+//   - cmd: The cobra command.
+//
+// Returns:
+//   - BulkOptions: The bulk resolution options.
+//   - error: An error if the flags cannot be parsed.
+func bulkOptionsFromFlags(cmd *cobra.Command) (BulkOptions, error) {
+	input, err := validators.VerifyStringInputs(cmd, "input")
+	if err != nil {
+		return BulkOptions{}, err
+	}
+
+	workers, err := validators.VerifyIntInputs(cmd, "workers")
+	if err != nil {
+		return BulkOptions{}, err
+	}
+
+	qps, err := qpsFromFlags(cmd)
+	if err != nil {
+		return BulkOptions{}, err
+	}
+
+	return BulkOptions{input: input, workers: workers, qps: qps}, nil
+}
+
+// trustAnchorsFromFlags loads the trust anchors from the --trust-anchor flag.
+//
+// Args:
+//   - cmd: The cobra command.
+//
+// Returns:
+//   - []dns.RR: The trust anchors, nil to use the root KSKs.
+//   - error: An error if the flag cannot be parsed or the file cannot be loaded.
+func trustAnchorsFromFlags(cmd *cobra.Command) ([]dns.RR, error) {
+	path, err := validators.VerifyStringInputs(cmd, "trust-anchor")
+	if err != nil {
+		return nil, err
+	}
+
+	if path == "" {
+		return nil, nil
+	}
+
+	anchors, err := dnsquery.LoadTrustAnchors(path)
+	if err != nil {
+		return nil, fmt.Errorf(styles.NewStyles().Error.Render("%s"), err)
+	}
+
+	return anchors, nil
+}
+
+// ednsFromFlags builds the EDNS options from the --no-edns, --edns-size,
+// --ecs, --nsid, --cookie, --padding and --edns-opt flags.
+//
+// Args:
+//   - cmd: The cobra command.
+//
+// Returns:
+//   - dnsquery.EDNS: The EDNS options sent with every lookup.
+//   - error: An error if the flags cannot be parsed or hold invalid options.
+func ednsFromFlags(cmd *cobra.Command) (dnsquery.EDNS, error) {
+	disabled, err := validators.VerifyBoolInputs(cmd, "no-edns")
+	if err != nil {
+		return dnsquery.EDNS{}, err
+	}
+	if disabled {
+		return dnsquery.EDNS{}, nil
+	}
+
+	edns := dnsquery.EDNS{Enabled: true}
+
+	if cmd.Flags().Changed("edns-size") {
+		size, err := validators.VerifyIntInputs(cmd, "edns-size")
+		if err != nil {
+			return dnsquery.EDNS{}, err
+		}
+		if size < 512 || size > 65535 {
+			return dnsquery.EDNS{}, errors.New(styles.NewStyles().Error.Render("--edns-size must be between 512 and 65535"))
+		}
+		edns.BufferSize = uint16(size)
+	}
+
+	subnet, err := validators.VerifyStringInputs(cmd, "ecs")
+	if err != nil {
+		return dnsquery.EDNS{}, err
+	}
+	if subnet != "" {
+		edns.ClientSubnet, err = dnsquery.ParseClientSubnet(subnet)
+		if err != nil {
+			return dnsquery.EDNS{}, fmt.Errorf(styles.NewStyles().Error.Render("%s"), err)
+		}
+	}
+
+	edns.NSID, err = validators.VerifyBoolInputs(cmd, "nsid")
+	if err != nil {
+		return dnsquery.EDNS{}, err
+	}
+
+	cookie, err := validators.VerifyStringInputs(cmd, "cookie")
+	if err != nil {
+		return dnsquery.EDNS{}, err
+	}
+	if cookie != "" {
+		edns.Cookie, err = dnsquery.ParseCookie(cookie)
+		if err != nil {
+			return dnsquery.EDNS{}, fmt.Errorf(styles.NewStyles().Error.Render("%s"), err)
+		}
+	}
+
+	edns.Padding, err = validators.VerifyIntInputs(cmd, "padding")
+	if err != nil {
+		return dnsquery.EDNS{}, err
+	}
+	if edns.Padding < 0 || edns.Padding > 512 {
+		return dnsquery.EDNS{}, errors.New(styles.NewStyles().Error.Render("--padding must be a block size between 1 and 512"))
+	}
+
+	options, err := validators.VerifyStringSliceInputs(cmd, "edns-opt")
+	if err != nil {
+		return dnsquery.EDNS{}, err
+	}
+	for _, value := range options {
+		option, err := dnsquery.ParseEDNSOption(value)
+		if err != nil {
+			return dnsquery.EDNS{}, fmt.Errorf(styles.NewStyles().Error.Render("%s"), err)
+		}
+		edns.Options = append(edns.Options, option)
+	}
+
+	return edns, nil
+}
+
+// tracerFromFlags builds the tracer from the --root-hints, --root-server,
+// --trace-port, --transport and --timeout flags. Root and TLD servers only
+// speak plain DNS, so --trace is limited to the udp and tcp transports.
+//
+// Args:
+//   - cmd: The cobra command.
+//
+// Returns:
+//   - dnsquery.Tracer: The tracer used by --trace.
+//   - error: An error if the flags cannot be parsed, the root hints cannot be
+//     loaded or --trace is used with an encrypted transport.
+func tracerFromFlags(cmd *cobra.Command) (dnsquery.Tracer, error) {
+	roots, err := rootHintsFromFlags(cmd)
+	if err != nil {
+		return dnsquery.Tracer{}, err
+	}
+
+	name, err := validators.VerifyStringInputs(cmd, "transport")
+	if err != nil {
+		return dnsquery.Tracer{}, err
+	}
+	name = strings.ToLower(name)
+
+	trace, err := validators.VerifyBoolInputs(cmd, "trace")
+	if err != nil {
+		return dnsquery.Tracer{}, err
+	}
+	if trace && name != "udp" && name != "tcp" {
+		return dnsquery.Tracer{}, fmt.Errorf(styles.NewStyles().Error.Render("--trace queries root and TLD servers over plain DNS, use --transport udp or tcp instead of %s"), name)
+	}
+	if name != "tcp" {
+		name = "udp"
+	}
+
+	timeout, err := validators.VerifyDurationInputs(cmd, "timeout")
+	if err != nil {
+		return dnsquery.Tracer{}, err
+	}
+
+	transport, err := dnsquery.NewTransport(name, "", dnsquery.TLSOptions{}, timeout)
+	if err != nil {
+		return dnsquery.Tracer{}, fmt.Errorf(styles.NewStyles().Error.Render("%s"), err)
+	}
+
+	port, err := validators.VerifyStringInputs(cmd, "trace-port")
+	if err != nil {
+		return dnsquery.Tracer{}, err
+	}
+
+	return dnsquery.NewTracer(transport, roots, port), nil
+}
+
+// rootHintsFromFlags reads the root servers from the --root-hints or --root-server flags.
+//
+// Args:
+//   - cmd: The cobra command.
+//
+// Returns:
+//   - []dnsquery.Nameserver: The root servers, empty for the built-in ones.
+//   - error: An error if the flags cannot be parsed or the root hints cannot be read.
+func rootHintsFromFlags(cmd *cobra.Command) ([]dnsquery.Nameserver, error) {
+	rootHints, err := validators.VerifyStringInputs(cmd, "root-hints")
+	if err != nil {
+		return nil, err
+	}
+
+	rootServers, err := validators.VerifyStringSliceInputs(cmd, "root-server")
+	if err != nil {
+		return nil, err
+	}
+
+	var roots []dnsquery.Nameserver
+	switch {
+	case rootHints != "":
+		roots, err = dnsquery.LoadRootHints(rootHints)
+	case len(rootServers) > 0:
+		roots, err = dnsquery.RootHintsFromAddresses(rootServers)
+	}
+	if err != nil {
+		return nil, fmt.Errorf(styles.NewStyles().Error.Render("%s"), err)
+	}
+
+	return roots, nil
+}
+
+// addUpstreamFlags registers the flags read by upstreamsFromFlags.
+//
+// Args:
+//   - command: The command the flags are added to.
+//
+// Returns:
+//   - None
+func addUpstreamFlags(command *cobra.Command) {
+	addTransportFlags(command)
+
+	command.Flags().StringSliceP("server", "s", []string{}, "upstream resolver as host[:port] or DoH URL, repeat to fall back in order (default 8.8.8.8)")
+	command.Flags().Bool("system", false, "use the nameservers, search domains and options from resolv.conf, on the default port of --transport")
+	command.Flags().String("resolv-conf", dnsquery.DefaultResolvConf, "path to the resolv.conf used with --system")
+	command.Flags().Int("retries", 0, "number of times every upstream is asked again after a timeout or SERVFAIL (default from resolv.conf with --system)")
+	command.Flags().Bool("ignore-tc", false, "keep truncated UDP answers instead of retrying them over TCP")
+
+	command.MarkFlagsMutuallyExclusive("server", "system")
+}
+
+// addTransportFlags registers the flags read by transportFromFlags.
+//
+// Args:
+//   - command: The command the flags are added to.
+//
+// Returns:
+//   - None
+func addTransportFlags(command *cobra.Command) {
+	command.Flags().String("transport", "udp", "transport used to reach the upstreams: udp, tcp, tls, https or quic")
+	command.Flags().String("doh-method", "POST", "HTTP method used with --transport https: GET or POST")
+	command.Flags().String("tls-server-name", "", "TLS server name (SNI) used to verify the upstream certificate")
+	command.Flags().String("tls-ca", "", "PEM CA bundle used to verify the upstream certificate")
+	command.Flags().Bool("tls-insecure", false, "skip verification of the upstream certificate")
+	command.Flags().Duration("timeout", dnsquery.DefaultTimeout, "timeout for each query, including the TCP retry of truncated answers")
+}
+
+// transportFromFlags builds the transport from the --transport, --doh-method and TLS flags.
+//
+// Args:
+//   - cmd: The cobra command.
+//
+// Returns:
+//   - dnsquery.Transport: The transport used to reach the upstreams.
+//   - error: An error if the flags cannot be parsed or describe an unknown transport.
+func transportFromFlags(cmd *cobra.Command) (dnsquery.Transport, error) {
+	name, err := validators.VerifyStringInputs(cmd, "transport")
+	if err != nil {
+		return nil, err
+	}
+
+	method, err := validators.VerifyStringInputs(cmd, "doh-method")
+	if err != nil {
+		return nil, err
+	}
+
+	serverName, err := validators.VerifyStringInputs(cmd, "tls-server-name")
+	if err != nil {
+		return nil, err
+	}
+
+	caFile, err := validators.VerifyStringInputs(cmd, "tls-ca")
+	if err != nil {
+		return nil, err
+	}
+
+	insecure, err := validators.VerifyBoolInputs(cmd, "tls-insecure")
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := validators.VerifyDurationInputs(cmd, "timeout")
+	if err != nil {
+		return nil, err
+	}
+
+	tlsOptions := dnsquery.TLSOptions{ServerName: serverName, CAFile: caFile, Insecure: insecure}
+	transport, err := dnsquery.NewTransport(name, method, tlsOptions, timeout)
+	if err != nil {
+		return nil, fmt.Errorf(styles.NewStyles().Error.Render("%s"), err)
+	}
+
+	return transport, nil
+}
+
+// upstreamsFromFlags builds the upstream set from the --server or --system
+// flags, with the retries and truncation handling asked for.
+//
+// Args:
+//   - cmd: The cobra command.
+//
+// Returns:
+//   - dnsquery.Upstreams: The upstream resolvers to query.
+//   - error: An error if the flags cannot be parsed or resolv.conf cannot be read.
+func upstreamsFromFlags(cmd *cobra.Command) (dnsquery.Upstreams, error) {
+	upstreams, err := serversFromFlags(cmd)
+	if err != nil {
+		return dnsquery.Upstreams{}, err
+	}
+
+	ignoreTC, err := validators.VerifyBoolInputs(cmd, "ignore-tc")
+	if err != nil {
+		return dnsquery.Upstreams{}, err
+	}
+	upstreams = upstreams.WithIgnoreTC(ignoreTC)
+
+	if cmd.Flags().Changed("retries") {
+		retries, err := validators.VerifyIntInputs(cmd, "retries")
+		if err != nil {
+			return dnsquery.Upstreams{}, err
+		}
+		if retries < 0 {
+			return dnsquery.Upstreams{}, errors.New(styles.NewStyles().Error.Render("--retries cannot be negative"))
+		}
+		upstreams = upstreams.WithRetries(retries)
+	}
+
+	return upstreams, nil
+}
+
+// serversFromFlags builds the upstream servers from the --server or --system flags.
+//
+// Args:
+//   - cmd: The cobra command.
+//
+// Returns:
+//   - dnsquery.Upstreams: The upstream resolvers to query.
+//   - error: An error if the flags cannot be parsed or resolv.conf cannot be read.
+func serversFromFlags(cmd *cobra.Command) (dnsquery.Upstreams, error) {
+	transport, err := transportFromFlags(cmd)
+	if err != nil {
+		return dnsquery.Upstreams{}, err
+	}
+
+	system, err := validators.VerifyBoolInputs(cmd, "system")
+	if err != nil {
+		return dnsquery.Upstreams{}, err
+	}
+
+	if system {
+		path, err := validators.VerifyStringInputs(cmd, "resolv-conf")
+		if err != nil {
+			return dnsquery.Upstreams{}, err
+		}
+
+		upstreams, err := dnsquery.LoadResolvConf(path, transport)
+		if err != nil {
+			return dnsquery.Upstreams{}, fmt.Errorf(styles.NewStyles().Error.Render("%s"), err)
+		}
+
+		return upstreams, nil
+	}
+
+	servers, err := validators.VerifyStringSliceInputs(cmd, "server")
+	if err != nil {
+		return dnsquery.Upstreams{}, err
+	}
+
+	upstreams, err := dnsquery.NewUpstreams(servers, transport)
+	if err != nil {
+		return dnsquery.Upstreams{}, fmt.Errorf(styles.NewStyles().Error.Render("%s"), err)
+	}
+
+	return upstreams, nil
 }
 
 // ResolveDomain resolves a domain name.
 //
 // Args:
+//   - ctx: The context bounding every query.
 //   - D: The DomainInterface.
 //
 // Returns:
-//   - None
-func ResolveDomain(D DomainInterface) {
-	D.Resolve()
+//   - error: An error if the domain cannot be resolved.
+func ResolveDomain(ctx context.Context, D DomainInterface) error {
+	return D.Resolve(ctx)
+}
+
+// TraceDomain resolves a domain name iteratively from the root servers.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//   - D: The DomainInterface.
+//
+// Returns:
+//   - error: An error if the delegation chain is broken.
+func TraceDomain(ctx context.Context, D DomainInterface) error {
+	return D.Trace(ctx)
+}
+
+// ResolveBulkRecords resolves a list of domain names concurrently.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//   - D: The DomainInterface.
+//
+// Returns:
+//   - error: An error if the list cannot be opened.
+func ResolveBulkRecords(ctx context.Context, D DomainInterface) error {
+	return D.ResolveBulk(ctx)
+}
+
+// ReverseLookup looks up the hostnames of an address or CIDR block.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//   - D: The DomainInterface.
+//
+// Returns:
+//   - error: An error if the address or block is invalid.
+func ReverseLookup(ctx context.Context, D DomainInterface) error {
+	return D.Reverse(ctx)
+}
+
+// WatchDomain watches a record and reports every change of its answer set.
+//
+// Args:
+//   - ctx: The context bounding the watch.
+//   - D: The DomainInterface.
+//
+// Returns:
+//   - error: An error if the watch cannot start.
+func WatchDomain(ctx context.Context, D DomainInterface) error {
+	return D.Watch(ctx)
 }
 
 // ResolveAllRecords resolves all records for a domain name.
 //
 // Args:
+//   - ctx: The context bounding every query.
 //   - D: The DomainInterface.
 //
 // Returns:
-//   - None
-func ResolveAllRecords(D DomainInterface) {
-	D.ResolveAll()
+//   - error: An error if any of the records cannot be resolved.
+func ResolveAllRecords(ctx context.Context, D DomainInterface) error {
+	return D.ResolveAll(ctx)
 }
 
 // Resolve resolves a domain name.
 //
 // Args:
-//   - None
+//   - ctx: The context bounding every query.
 //
 // Returns:
-//   - None
-func (D Domain) Resolve() {
-	in := D.PrepareDnsCall(D.qtype)
+//   - error: An error if the domain cannot be resolved or the results rendered.
+func (D Domain) Resolve(ctx context.Context) error {
+	response, err := D.PrepareDnsCall(ctx, D.qtype)
+	if err != nil {
+		return err
+	}
 
-	for _, ans := range in.Answer {
+	if D.output != outputPlain {
+		if err := renderResults(D.output, []dnsquery.Result{D.result(ctx, response)}); err != nil {
+			return fmt.Errorf("could not render results: %w", err)
+		}
+		return nil
+	}
+
+	printNoRecords(D.qtype, response)
+	for _, ans := range response.Msg.Answer {
 		fmt.Printf(styles.NewStyles().Title.Render(
 			"🛠️ %s Records 🛠️"), strings.ToUpper(D.qtype),
 		)
 		fmt.Println()
-		fmt.Println(styles.NewStyles().Highlight.Render(ans.String()))
+		fmt.Println(styles.NewStyles().Highlight.Render(formatRecord(ans)))
 	}
+	printHeader(response)
+	printAnsweredBy(response)
+	printEDNS(response)
+	D.printValidation(ctx, response)
+	D.printChain(ctx, response)
+
+	return nil
 }
 
 // ResolveAll resolves all records for a domain name.
 //
 // Args:
-//   - None
+//   - ctx: The context bounding every query.
 //
 // Returns:
-//   - None
-func (D Domain) ResolveAll() {
+//   - error: An error if any of the records cannot be resolved or the results rendered.
+func (D Domain) ResolveAll(ctx context.Context) error {
+	if D.output != outputPlain {
+		results := make([]dnsquery.Result, 0, len(D.recordTypes))
+		for _, dnsRecord := range D.recordTypes {
+			response, err := D.PrepareDnsCall(ctx, dnsRecord)
+			if err != nil {
+				return err
+			}
+			results = append(results, D.result(ctx, response))
+		}
+
+		if err := renderResults(D.output, results); err != nil {
+			return fmt.Errorf("could not render results: %w", err)
+		}
+		return nil
+	}
+
 	for _, dnsRecord := range D.recordTypes {
-		in := D.PrepareDnsCall(dnsRecord)
+		response, err := D.PrepareDnsCall(ctx, dnsRecord)
+		if err != nil {
+			return err
+		}
 
 		fmt.Printf(styles.NewStyles().Title.Render(
 			"%s Records"), strings.ToUpper(dnsRecord),
 		)
 		fmt.Println()
-		for _, ans := range in.Answer {
-			fmt.Println(styles.NewStyles().Highlight.Render(ans.String()))
+		printNoRecords(dnsRecord, response)
+		for _, ans := range response.Msg.Answer {
+			fmt.Println(styles.NewStyles().Highlight.Render(formatRecord(ans)))
 		}
+		printHeader(response)
+		printAnsweredBy(response)
+		printEDNS(response)
+		D.printValidation(ctx, response)
+		D.printChain(ctx, response)
 	}
+
+	return nil
 }
 
-// PrepareDnsCall prepares a DNS call.
+// result converts a response into a structured result, validating it when
+// --dnssec is set and following its aliases when --follow is set.
 //
 // Args:
-//   - qtype: The query type.
+//   - ctx: The context bounding the validation queries.
+//   - response: The DNS response.
+//
+// Returns:
+//   - dnsquery.Result: The structured result.
+func (D Domain) result(ctx context.Context, response dnsquery.Response) dnsquery.Result {
+	result := dnsquery.NewResult(response)
+	if D.dnssec {
+		validation := dnsquery.NewValidator(D.resolver, D.anchors).Validate(ctx, response.Msg)
+		result.Dnssec = &validation
+	}
+	if D.follow {
+		if chain, err := D.followChain(ctx, response); err == nil {
+			result.Chain = &chain
+		} else {
+			result.Error = err.Error()
+		}
+	}
+
+	return result
+}
+
+// printValidation validates a response and prints every link of its chain of
+// trust when --dnssec is set.
+//
+// Args:
+//   - ctx: The context bounding the validation queries.
+//   - response: The DNS response.
 //
 // Returns:
-//   - *dns.Msg: The DNS message.
-func (D Domain) PrepareDnsCall(qtype string) *dns.Msg {
-	qtypeToUpper := strings.ToUpper(qtype)
-	record, ok := dns.StringToType[qtypeToUpper]
-	if !ok {
-		log.Fatalf(styles.NewStyles().Error.Render("Unknown record type: %d"), record)
+//   - None
+func (D Domain) printValidation(ctx context.Context, response dnsquery.Response) {
+	if !D.dnssec {
+		return
+	}
+
+	validation := dnsquery.NewValidator(D.resolver, D.anchors).Validate(ctx, response.Msg)
+	for _, link := range validation.Links {
+		line := fmt.Sprintf("%-9s %s %s: %s", link.Status, link.Zone, link.Record, link.Detail)
+		if link.Status == dnsquery.DnssecBogus || link.Status == dnsquery.DnssecIndeterminate {
+			fmt.Println(styles.NewStyles().Error.Render(line))
+		} else {
+			fmt.Println(line)
+		}
+	}
+
+	message := "DNSSEC: " + validation.Status
+	if validation.Reason != "" {
+		message += " (" + validation.Reason + ")"
 	}
+	if validation.Status == dnsquery.DnssecSecure || validation.Status == dnsquery.DnssecInsecure {
+		fmt.Println(styles.NewStyles().Highlight.Render(message))
+	} else {
+		fmt.Println(styles.NewStyles().Error.Render(message))
+	}
+}
 
-	m := new(dns.Msg)
-	m.SetQuestion(dns.Fqdn(D.domainName), record)
-	m.RecursionDesired = true
+// Trace resolves a domain name iteratively and prints every hop with the
+// server queried, the referral it returned and the round trip time.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//
+// Returns:
+//   - error: An error if the type is unknown or the delegation chain is broken.
+func (D Domain) Trace(ctx context.Context) error {
+	record, err := dnsquery.ParseType(D.qtype)
+	if err != nil {
+		return err
+	}
 
-	c := new(dns.Client)
-	in, _, err := c.Exchange(m, "8.8.8.8:53")
+	hops, err := D.tracer.Trace(ctx, D.domainName, record)
+	for i, hop := range hops {
+		fmt.Printf(styles.NewStyles().Title.Render(
+			"Hop %d: %s @%s (%s)"), i+1, hop.Zone, hop.Server, hop.Address,
+		)
+		fmt.Println()
+
+		for _, rr := range hop.Referral {
+			fmt.Println(styles.NewStyles().Highlight.Render(rr.String()))
+		}
+		for _, rr := range hop.Glue {
+			fmt.Println(rr.String())
+		}
+		for ns, addresses := range hop.Resolved {
+			fmt.Printf("%s resolved to %s\n", ns, strings.Join(addresses, ", "))
+		}
+		for _, rr := range hop.Answer {
+			fmt.Println(styles.NewStyles().Highlight.Render(rr.String()))
+		}
+
+		if hop.ReferralZone != "" {
+			fmt.Printf("referral to %s in %s\n", hop.ReferralZone, hop.Rtt.Round(time.Microsecond))
+		} else {
+			fmt.Printf("%s in %s\n", dns.RcodeToString[hop.Rcode], hop.Rtt.Round(time.Microsecond))
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("trace failed: %w", err)
+	}
+
+	return nil
+}
+
+// printAnsweredBy prints which upstream server gave the answer, over which
+// transport and how long the handshake and query took.
+//
+// Args:
+//   - response: The DNS response.
+//
+// Returns:
+//   - None
+func printAnsweredBy(response dnsquery.Response) {
+	transport := response.Transport
+	if response.TCPFallback {
+		transport += " (retried after a truncated udp answer)"
+	}
+	fmt.Printf("answered by %s over %s in %s (handshake %s) (%s)\n",
+		response.Server, transport, response.Rtt.Round(time.Microsecond),
+		response.Handshake.Round(time.Microsecond), dns.RcodeToString[response.Msg.Rcode],
+	)
+	if response.Msg.Truncated {
+		fmt.Println(styles.NewStyles().Error.Render("the answer is truncated, some records may be missing"))
+	}
+}
+
+// printHeader prints the header of a response like dig does: opcode, rcode,
+// id, flags and the number of records in each section.
+//
+// Args:
+//   - response: The DNS response.
+//
+// Returns:
+//   - None
+func printHeader(response dnsquery.Response) {
+	in := response.Msg
+	flags := []string{}
+	for _, flag := range []struct {
+		name string
+		set  bool
+	}{
+		{"qr", in.Response},
+		{"aa", in.Authoritative},
+		{"tc", in.Truncated},
+		{"rd", in.RecursionDesired},
+		{"ra", in.RecursionAvailable},
+		{"ad", in.AuthenticatedData},
+		{"cd", in.CheckingDisabled},
+	} {
+		if flag.set {
+			flags = append(flags, flag.name)
+		}
+	}
+
+	fmt.Printf("header: opcode %s, status %s, id %d, flags: %s; QUERY: %d, ANSWER: %d, AUTHORITY: %d, ADDITIONAL: %d\n",
+		dns.OpcodeToString[in.Opcode], dns.RcodeToString[in.Rcode], in.Id, strings.Join(flags, " "),
+		len(in.Question), len(in.Answer), len(in.Ns), len(in.Extra),
+	)
+}
+
+// printEDNS prints the OPT pseudo-section of a response with its options,
+// extended DNS errors in red.
+//
+// Args:
+//   - response: The DNS response.
+//
+// Returns:
+//   - None
+func printEDNS(response dnsquery.Response) {
+	info := dnsquery.NewEDNSInfo(response.Msg)
+	if info == nil {
+		return
+	}
+
+	flags := ""
+	if info.DO {
+		flags = " do"
+	}
+	fmt.Printf("OPT: EDNS version %d, udp %d, flags:%s\n", info.Version, info.UDPSize, flags)
+
+	for _, option := range info.Options {
+		if option.Code == dns.EDNS0EDE {
+			continue
+		}
+		fmt.Printf("  %s: %s\n", option.Name, option.Value)
+	}
+	for _, ede := range info.ExtendedErrors {
+		line := fmt.Sprintf("EDE %d (%s)", ede.Code, ede.Name)
+		if ede.Text != "" {
+			line += ": " + ede.Text
+		}
+		fmt.Println(styles.NewStyles().Error.Render(line))
+	}
+}
+
+// PrepareDnsCall resolves the domain name. Negative answers such as NXDOMAIN
+// are returned as responses so they can be printed.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//   - qtype: The query type.
+//
+// Returns:
+//   - dnsquery.Response: The DNS message and the server that answered it.
+//   - error: An error if the type is unknown or no upstream answered.
+func (D Domain) PrepareDnsCall(ctx context.Context, qtype string) (dnsquery.Response, error) {
+	response, err := dnsquery.Negative(D.resolver.Lookup(ctx, D.domainName, qtype))
 	if err != nil {
-		log.Fatalf(styles.NewStyles().Error.Render("An error occurred while resolving domain: %s"), err)
+		return dnsquery.Response{}, fmt.Errorf("an error occurred while resolving domain: %w", err)
 	}
 
-	return in
+	return response, nil
 }
@@ -0,0 +1,300 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"commandCenter/dnsquery"
+	"commandCenter/styles"
+
+	"github.com/goccy/go-yaml"
+	"github.com/miekg/dns"
+)
+
+const maxSweepAddresses = 1 << 16
+
+type ReverseResult struct {
+	Address   string          `json:"address" yaml:"address"`
+	Name      string          `json:"name" yaml:"name"`
+	Status    string          `json:"status" yaml:"status"`
+	Hostnames []string        `json:"hostnames" yaml:"hostnames"`
+	Forward   map[string]bool `json:"forward_confirmed" yaml:"forward_confirmed"`
+	FCrDNS    bool            `json:"fcrdns" yaml:"fcrdns"`
+	Server    string          `json:"server,omitempty" yaml:"server,omitempty"`
+	Error     string          `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// parseReverseTarget parses an IP address or CIDR block.
+//
+// Args:
+//   - target: The IPv4 or IPv6 address or CIDR block.
+//
+// Returns:
+//   - netip.Prefix: The block, a single-address prefix for plain addresses.
+//   - error: An error if the target is invalid or the block is too large to sweep.
+func parseReverseTarget(target string) (netip.Prefix, error) {
+	if !strings.Contains(target, "/") {
+		address, err := netip.ParseAddr(target)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("'%s' is not an IP address or CIDR block", target)
+		}
+		return netip.PrefixFrom(address, address.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(target)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("'%s' is not an IP address or CIDR block", target)
+	}
+
+	if hostBits := prefix.Addr().BitLen() - prefix.Bits(); hostBits > 16 {
+		return netip.Prefix{}, fmt.Errorf("'%s' has more than %d addresses to sweep", target, maxSweepAddresses)
+	}
+
+	return prefix.Masked(), nil
+}
+
+// Reverse looks up the PTR records of an address, or of every address of a
+// CIDR block concurrently, and checks forward-confirmed reverse DNS.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//
+// Returns:
+//   - error: An error if the target is invalid or the results cannot be rendered.
+func (D Domain) Reverse(ctx context.Context) error {
+	prefix, err := parseReverseTarget(D.reverse)
+	if err != nil {
+		return err
+	}
+
+	if prefix.IsSingleIP() {
+		if err := D.printReverse(D.reverseLookup(ctx, prefix.Addr()), false); err != nil {
+			return fmt.Errorf("could not render results: %w", err)
+		}
+		return nil
+	}
+
+	// Cancelled when rendering fails, so the generator and the workers stop.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	addresses := make(chan netip.Addr)
+	go func() {
+		defer close(addresses)
+		for address := prefix.Addr(); prefix.Contains(address); address = address.Next() {
+			select {
+			case addresses <- address:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	limiter, stop := newRateLimiter(D.bulk.qps)
+	defer stop()
+
+	results := make(chan ReverseResult)
+	var wg sync.WaitGroup
+	for range max(D.bulk.workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for address := range addresses {
+				if limiter != nil {
+					select {
+					case <-limiter:
+					case <-ctx.Done():
+						return
+					}
+				}
+				results <- D.reverseLookup(ctx, address)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Addresses without PTR records are left out, failed lookups are kept so
+	// a sweep hitting a broken server does not look like an empty block.
+	var inventory, mismatches, failures, timeouts int
+	for result := range results {
+		switch result.Status {
+		case dns.RcodeToString[dns.RcodeSuccess], dnsquery.StatusNoData, dns.RcodeToString[dns.RcodeNameError]:
+			if len(result.Hostnames) == 0 {
+				continue
+			}
+			inventory++
+			if !result.FCrDNS {
+				mismatches++
+			}
+		case statusTimeout:
+			timeouts++
+		default:
+			failures++
+		}
+
+		if err := D.printReverse(result, true); err != nil {
+			cancel()
+			for range results {
+			}
+			return fmt.Errorf("could not render results: %w", err)
+		}
+	}
+
+	if D.output == outputPlain || D.output == outputTable {
+		fmt.Println(styles.NewStyles().Title.Render("Summary"))
+		fmt.Printf("swept %s: %d addresses with PTR records, %d FCrDNS mismatches, %d errors, %d timeouts\n",
+			prefix, inventory, mismatches, failures, timeouts,
+		)
+	}
+
+	return nil
+}
+
+// reverseLookup queries the PTR records of an address and resolves every
+// hostname forward to check that it points back to the address.
+//
+// Args:
+//   - ctx: The context bounding the queries.
+//   - address: The IP address.
+//
+// Returns:
+//   - ReverseResult: The hostnames and their forward confirmation.
+func (D Domain) reverseLookup(ctx context.Context, address netip.Addr) ReverseResult {
+	arpa, _ := dns.ReverseAddr(address.String())
+	result := ReverseResult{
+		Address:   address.String(),
+		Name:      arpa,
+		Hostnames: []string{},
+		Forward:   map[string]bool{},
+	}
+
+	response, err := dnsquery.Negative(D.resolver.Lookup(ctx, arpa, "PTR"))
+	if err != nil {
+		result.Status = statusError
+		result.Error = err.Error()
+		var timeoutErr *dnsquery.TimeoutError
+		if errors.As(err, &timeoutErr) {
+			result.Status = statusTimeout
+		}
+		return result
+	}
+
+	result.Status = dnsquery.NewResult(response).Status
+	result.Server = response.Server
+	for _, rr := range response.Msg.Answer {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			result.Hostnames = append(result.Hostnames, ptr.Ptr)
+		}
+	}
+
+	forwardType := "A"
+	if address.Is6() && !address.Is4In6() {
+		forwardType = "AAAA"
+	}
+
+	result.FCrDNS = len(result.Hostnames) > 0
+	for _, hostname := range result.Hostnames {
+		result.Forward[hostname] = false
+
+		response, err := D.resolver.Lookup(ctx, hostname, forwardType)
+		if err != nil {
+			result.FCrDNS = false
+			continue
+		}
+
+		for _, rr := range response.Msg.Answer {
+			var ip string
+			switch record := rr.(type) {
+			case *dns.A:
+				ip = record.A.String()
+			case *dns.AAAA:
+				ip = record.AAAA.String()
+			}
+			if parsed, err := netip.ParseAddr(ip); err == nil && parsed.Unmap() == address.Unmap() {
+				result.Forward[hostname] = true
+			}
+		}
+
+		if !result.Forward[hostname] {
+			result.FCrDNS = false
+		}
+	}
+
+	return result
+}
+
+// printReverse prints a reverse lookup result in the selected output format.
+// Sweeps write JSON as one object per line and YAML as one document per result.
+//
+// Args:
+//   - result: The result to print.
+//   - sweep: Whether the result is part of a CIDR sweep.
+//
+// Returns:
+//   - error: An error if the result cannot be encoded.
+func (D Domain) printReverse(result ReverseResult, sweep bool) error {
+	switch D.output {
+	case outputJSON:
+		encoder := json.NewEncoder(os.Stdout)
+		if !sweep {
+			encoder.SetIndent("", "  ")
+		}
+		return encoder.Encode(result)
+	case outputYAML:
+		encoded, err := yaml.Marshal(result)
+		if err != nil {
+			return err
+		}
+		if sweep {
+			fmt.Println("---")
+		}
+		_, err = os.Stdout.Write(encoded)
+		return err
+	case outputTable:
+		fcrdns := "mismatch"
+		if result.FCrDNS {
+			fcrdns = "ok"
+		}
+		if len(result.Hostnames) == 0 {
+			fcrdns = "-"
+		}
+		values := result.Hostnames
+		if result.Error != "" {
+			values = append(values, result.Error)
+		}
+		_, err := fmt.Printf("%-40s %-9s %-8s %s\n", result.Address, result.Status, fcrdns, strings.Join(values, ", "))
+		return err
+	}
+
+	fmt.Printf(styles.NewStyles().Title.Render("🛠️ PTR %s (%s) 🛠️"), result.Address, result.Name)
+	fmt.Println()
+	if result.Error != "" {
+		fmt.Println(styles.NewStyles().Error.Render(result.Error))
+		return nil
+	}
+	if len(result.Hostnames) == 0 {
+		fmt.Println(styles.NewStyles().Error.Render(fmt.Sprintf("No PTR records found (%s)", result.Status)))
+		return nil
+	}
+
+	for _, hostname := range slices.Sorted(maps.Keys(result.Forward)) {
+		if result.Forward[hostname] {
+			fmt.Println(styles.NewStyles().Highlight.Render(fmt.Sprintf("%s (forward confirmed)", hostname)))
+		} else {
+			fmt.Println(styles.NewStyles().Error.Render(fmt.Sprintf("%s (FCrDNS mismatch: does not resolve back to %s)", hostname, result.Address)))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,570 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"commandCenter/dnsquery"
+	"commandCenter/dnsserver"
+	"commandCenter/styles"
+	"commandCenter/validators"
+
+	"github.com/goccy/go-yaml"
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+)
+
+const (
+	driftOK    = "ok"
+	driftDrift = "drift"
+	driftError = "error"
+)
+
+// Snapshot is the file written by snapshot and read by check. Hand-written
+// expectation files use the same format: status defaults to NOERROR and
+// values are only compared when given.
+type Snapshot struct {
+	Created string           `json:"created,omitempty" yaml:"created,omitempty"`
+	Server  string           `json:"server,omitempty" yaml:"server,omitempty"`
+	Records []SnapshotRecord `json:"records" yaml:"records"`
+}
+
+// SnapshotRecord holds the answer for a name and type. Values of the queried
+// type are kept as they are, other records such as the CNAMEs leading to them
+// are prefixed with their type.
+type SnapshotRecord struct {
+	Name   string   `json:"name" yaml:"name"`
+	Type   string   `json:"type" yaml:"type"`
+	Status string   `json:"status,omitempty" yaml:"status,omitempty"`
+	TTL    uint32   `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+	Values []string `json:"values" yaml:"values"`
+}
+
+type DriftResult struct {
+	Name           string   `json:"name"`
+	Type           string   `json:"type"`
+	Result         string   `json:"result"`
+	ExpectedStatus string   `json:"expected_status"`
+	Status         string   `json:"status"`
+	Missing        []string `json:"missing,omitempty"`
+	Unexpected     []string `json:"unexpected,omitempty"`
+	Error          string   `json:"error,omitempty"`
+}
+
+type DriftReport struct {
+	File    string        `json:"file"`
+	Checked int           `json:"checked"`
+	Drifted int           `json:"drifted"`
+	Errors  int           `json:"errors"`
+	Results []DriftResult `json:"results"`
+}
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Record the current answers for a list of domains into a file.",
+	Long:  "Resolve every domain for the chosen record types and save the answers as YAML or JSON, to be compared later with ops dns check.",
+	Example: `
+      # Record the main record types of two domains
+      ops dns snapshot -d example.com -d www.example.com -f dns.yaml
+
+      # Record chosen types for a list of domains (one per line, optionally followed by types) as JSON
+      ops dns snapshot -i domains.txt --types a,aaaa,mx,txt -f dns.json
+
+      # Get help for the snapshot command
+      ops dns snapshot --help
+    `,
+
+	Run: takeSnapshot,
+}
+
+var checkCmd = &cobra.Command{
+	Use:     "check",
+	Short:   "Compare live answers against a snapshot or expectations file.",
+	Long:    "Resolve every record of a snapshot or hand-written expectations file, print a diff of what changed and exit with status 1 on drift so deployments can be gated on it.",
+	Aliases: []string{"drift", "expect"},
+	Example: `
+      # Compare live answers with a snapshot
+      ops dns check -f dns.yaml
+
+      # Check hand-written expectations against an internal resolver, as JSON
+      ops dns check -f expectations.yaml -s 10.0.0.2 -o json
+
+      # Get help for the check command
+      ops dns check --help
+    `,
+
+	Run: checkSnapshot,
+}
+
+// init initializes the snapshot and check commands and their flags.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - None
+func init() {
+	dnsCmd.AddCommand(snapshotCmd)
+	dnsCmd.AddCommand(checkCmd)
+	addUpstreamFlags(snapshotCmd)
+	addUpstreamFlags(checkCmd)
+
+	snapshotCmd.Flags().StringSliceP("domain", "d", []string{}, "domain to record, repeatable")
+	snapshotCmd.Flags().StringP("input", "i", "", "file with one domain per line, optionally followed by record types, or - for stdin")
+	snapshotCmd.Flags().StringSlice("types", []string{}, "comma separated record types to record (default "+strings.ToUpper(strings.Join(defaultRecordTypes, ", "))+")")
+	snapshotCmd.Flags().Bool("all-types", false, "record every supported record type")
+	snapshotCmd.Flags().StringP("file", "f", "", "file the snapshot is written to, or - for stdout")
+	snapshotCmd.Flags().String("format", "", "snapshot format: yaml or json (default from the file extension, yaml for stdout)")
+
+	snapshotCmd.MarkFlagRequired("file")
+	snapshotCmd.MarkFlagsOneRequired("domain", "input")
+	snapshotCmd.MarkFlagsMutuallyExclusive("types", "all-types")
+
+	checkCmd.Flags().StringP("file", "f", "", "snapshot or expectations file to compare against")
+	checkCmd.Flags().StringP("output", "o", outputPlain, "output format: plain or json")
+
+	checkCmd.MarkFlagRequired("file")
+}
+
+// takeSnapshot is the main function for the snapshot command.
+//
+// Args:
+//   - cmd: The cobra command.
+//   - args: The command arguments.
+//
+// Returns:
+//   - None
+func takeSnapshot(cmd *cobra.Command, args []string) {
+	domains, err := validators.VerifyStringSliceInputs(cmd, "domain")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	input, err := validators.VerifyStringInputs(cmd, "input")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	recordTypes, _, err := recordTypesFromFlags(cmd)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	file, err := validators.VerifyStringInputs(cmd, "file")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	format, err := validators.VerifyStringInputs(cmd, "format")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	format = strings.ToLower(format)
+	if format == "" {
+		format = outputYAML
+		if strings.EqualFold(filepath.Ext(file), ".json") {
+			format = outputJSON
+		}
+	}
+	if format != outputYAML && format != outputJSON {
+		log.Fatalln(styles.NewStyles().Error.Render(fmt.Sprintf("unknown snapshot format '%s', use yaml or json", format)))
+	}
+
+	upstreams, err := upstreamsFromFlags(cmd)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	queries := make([]BulkQuery, 0, len(domains)*len(recordTypes))
+	for _, domain := range domains {
+		for _, qtype := range recordTypes {
+			queries = append(queries, BulkQuery{name: domain, qtype: qtype})
+		}
+	}
+	if input != "" {
+		reader, err := openBulkInput(input)
+		if err != nil {
+			log.Fatalln(styles.NewStyles().Error.Render(err.Error()))
+		}
+		defer reader.Close()
+
+		listed := make(chan BulkQuery)
+		readErr := make(chan error, 1)
+		go func() {
+			readErr <- readBulkQueries(reader, recordTypes, listed)
+		}()
+		for query := range listed {
+			queries = append(queries, query)
+		}
+		if err := <-readErr; err != nil {
+			log.Fatalln(styles.NewStyles().Error.Render(fmt.Sprintf("could not read '%s': %s", input, err)))
+		}
+	}
+
+	snapshot := Snapshot{
+		Created: time.Now().UTC().Format(time.RFC3339),
+		Server:  strings.Join(upstreams.Servers(), ", "),
+	}
+	for _, query := range queries {
+		domain := Domain{domainName: query.name, resolver: upstreams}
+		record, err := domain.snapshotRecord(cmd.Context(), query.qtype)
+		if err != nil {
+			log.Fatalln(styles.NewStyles().Error.Render(err.Error()))
+		}
+		snapshot.Records = append(snapshot.Records, record)
+	}
+
+	if err := writeSnapshot(snapshot, file, format); err != nil {
+		log.Fatalln(styles.NewStyles().Error.Render(err.Error()))
+	}
+	if file != "-" {
+		fmt.Printf("recorded %d answers in %s\n", len(snapshot.Records), file)
+	}
+}
+
+// checkSnapshot is the main function for the check command.
+//
+// Args:
+//   - cmd: The cobra command.
+//   - args: The command arguments.
+//
+// Returns:
+//   - None
+func checkSnapshot(cmd *cobra.Command, args []string) {
+	file, err := validators.VerifyStringInputs(cmd, "file")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	output, err := validators.VerifyStringInputs(cmd, "output")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	output = strings.ToLower(output)
+	if output != outputPlain && output != outputJSON {
+		log.Fatalln(styles.NewStyles().Error.Render(fmt.Sprintf("unknown output format '%s', use plain or json", output)))
+	}
+
+	upstreams, err := upstreamsFromFlags(cmd)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	snapshot, err := readSnapshot(file)
+	if err != nil {
+		log.Fatalln(styles.NewStyles().Error.Render(err.Error()))
+	}
+
+	report := DriftReport{File: file, Results: make([]DriftResult, 0, len(snapshot.Records))}
+	for _, expected := range snapshot.Records {
+		domain := Domain{domainName: expected.Name, resolver: upstreams}
+		result := domain.compareRecord(cmd.Context(), expected)
+
+		report.Checked++
+		switch result.Result {
+		case driftDrift:
+			report.Drifted++
+		case driftError:
+			report.Errors++
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	if output == outputJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Fatalln(err)
+		}
+	} else {
+		printDriftReport(report)
+	}
+
+	if report.Drifted > 0 || report.Errors > 0 {
+		os.Exit(1)
+	}
+}
+
+// snapshotRecord resolves the domain for a record type and records the answer.
+//
+// Args:
+//   - ctx: The context bounding the query.
+//   - qtype: The record type.
+//
+// Returns:
+//   - SnapshotRecord: The recorded answer.
+//   - error: An error if the type is unknown or no upstream answered.
+func (D Domain) snapshotRecord(ctx context.Context, qtype string) (SnapshotRecord, error) {
+	response, err := D.PrepareDnsCall(ctx, qtype)
+	if err != nil {
+		return SnapshotRecord{}, err
+	}
+
+	record := SnapshotRecord{
+		Name:   dns.CanonicalName(D.domainName),
+		Type:   strings.ToUpper(qtype),
+		Status: dns.RcodeToString[response.Msg.Rcode],
+		Values: []string{},
+	}
+	for _, rr := range response.Msg.Answer {
+		value := snapshotValue(rr, record.Type)
+		if !slices.Contains(record.Values, value) {
+			record.Values = append(record.Values, value)
+		}
+		if rr.Header().Ttl > record.TTL {
+			record.TTL = rr.Header().Ttl
+		}
+	}
+	slices.Sort(record.Values)
+
+	return record, nil
+}
+
+// compareRecord resolves an expected record and lists what changed.
+//
+// Args:
+//   - ctx: The context bounding the query.
+//   - expected: The expected answer.
+//
+// Returns:
+//   - DriftResult: The comparison.
+func (D Domain) compareRecord(ctx context.Context, expected SnapshotRecord) DriftResult {
+	result := DriftResult{
+		Name:           dns.CanonicalName(expected.Name),
+		Type:           strings.ToUpper(expected.Type),
+		ExpectedStatus: strings.ToUpper(expected.Status),
+		Result:         driftOK,
+	}
+	if result.ExpectedStatus == "" {
+		result.ExpectedStatus = dns.RcodeToString[dns.RcodeSuccess]
+	}
+
+	actual, err := D.snapshotRecord(ctx, result.Type)
+	if err != nil {
+		result.Result = driftError
+		result.Error = err.Error()
+		return result
+	}
+	result.Status = actual.Status
+
+	if result.Status != result.ExpectedStatus {
+		result.Result = driftDrift
+	}
+
+	// Expectation files may leave out the values to only check the status.
+	if expected.Values == nil {
+		return result
+	}
+
+	wanted := make([]string, 0, len(expected.Values))
+	for _, value := range expected.Values {
+		wanted = append(wanted, normalizeSnapshotValue(result.Name, result.Type, value))
+	}
+	for _, value := range wanted {
+		if !slices.Contains(actual.Values, value) {
+			result.Missing = append(result.Missing, value)
+		}
+	}
+	for _, value := range actual.Values {
+		if !slices.Contains(wanted, value) {
+			result.Unexpected = append(result.Unexpected, value)
+		}
+	}
+	if len(result.Missing) > 0 || len(result.Unexpected) > 0 {
+		result.Result = driftDrift
+	}
+
+	return result
+}
+
+// snapshotValue formats the data of a record, prefixed with its type when it
+// is not the queried one.
+//
+// Args:
+//   - rr: The record.
+//   - qtype: The queried type.
+//
+// Returns:
+//   - string: The value.
+func snapshotValue(rr dns.RR, qtype string) string {
+	record := dnsquery.NewRecord(rr)
+	if record.Type == qtype {
+		return record.Value
+	}
+
+	return record.Type + " " + record.Value
+}
+
+// normalizeSnapshotValue formats a hand-written value the way records are
+// recorded, so "mail.example.com" matches "mail.example.com.", extra spaces
+// do not matter and an unquoted TXT value is read as one string.
+//
+// Args:
+//   - name: The owner name.
+//   - qtype: The queried type.
+//   - value: The value as written.
+//
+// Returns:
+//   - string: The normalized value, unchanged if it cannot be parsed.
+func normalizeSnapshotValue(name, qtype, value string) string {
+	value = strings.TrimSpace(value)
+
+	// Values of other types, such as the CNAMEs of a chain, start with their type.
+	if rtype, data, ok := strings.Cut(value, " "); ok {
+		rtype = strings.ToUpper(rtype)
+		if _, known := dns.StringToType[rtype]; known && rtype != qtype {
+			if rr, err := dns.NewRR(fmt.Sprintf("%s 0 IN %s %s", name, rtype, data)); err == nil && rr != nil {
+				return snapshotValue(rr, qtype)
+			}
+		}
+	}
+
+	// An unquoted TXT value is a single string, as in a records file, where
+	// the zone file syntax would split it at every space.
+	if qtype == "TXT" && !strings.HasPrefix(value, `"`) {
+		txt := &dns.TXT{
+			Hdr: dns.RR_Header{Name: dns.Fqdn(name), Rrtype: dns.TypeTXT, Class: dns.ClassINET},
+			Txt: dnsserver.SplitTXT(value),
+		}
+		return snapshotValue(txt, qtype)
+	}
+
+	if rr, err := dns.NewRR(fmt.Sprintf("%s 0 IN %s %s", name, qtype, value)); err == nil && rr != nil {
+		return snapshotValue(rr, qtype)
+	}
+
+	return value
+}
+
+// writeSnapshot writes a snapshot as YAML or JSON.
+//
+// Args:
+//   - snapshot: The snapshot.
+//   - file: The path, or - for stdout.
+//   - format: yaml or json.
+//
+// Returns:
+//   - error: An error if the snapshot cannot be encoded or written.
+func writeSnapshot(snapshot Snapshot, file, format string) error {
+	var (
+		encoded []byte
+		err     error
+	)
+	if format == outputJSON {
+		encoded, err = json.MarshalIndent(snapshot, "", "  ")
+		encoded = append(encoded, '\n')
+	} else {
+		encoded, err = yaml.Marshal(snapshot)
+	}
+	if err != nil {
+		return fmt.Errorf("could not encode the snapshot: %w", err)
+	}
+
+	if file == "-" {
+		_, err = os.Stdout.Write(encoded)
+		return err
+	}
+
+	if err := os.WriteFile(file, encoded, 0o644); err != nil {
+		return fmt.Errorf("could not write '%s': %w", file, err)
+	}
+
+	return nil
+}
+
+// readSnapshot reads a snapshot or expectations file. JSON is read by the
+// YAML parser as it is a subset of YAML.
+//
+// Args:
+//   - file: The path, or - for stdin.
+//
+// Returns:
+//   - Snapshot: The snapshot.
+//   - error: An error if the file cannot be read or parsed, or lists no records.
+func readSnapshot(file string) (Snapshot, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("could not read '%s': %w", file, err)
+	}
+
+	var snapshot Snapshot
+	if err := yaml.Unmarshal(data, &snapshot); err != nil {
+		return Snapshot{}, fmt.Errorf("could not parse '%s': %w", file, err)
+	}
+	if len(snapshot.Records) == 0 {
+		return Snapshot{}, fmt.Errorf("'%s' lists no records", file)
+	}
+
+	for i, record := range snapshot.Records {
+		if record.Name == "" || record.Type == "" {
+			return Snapshot{}, fmt.Errorf("record %d of '%s' needs a name and a type", i+1, file)
+		}
+		if _, err := dnsquery.ParseType(record.Type); err != nil {
+			return Snapshot{}, fmt.Errorf("record %d of '%s': %w", i+1, file, err)
+		}
+		if record.Status != "" {
+			if _, ok := dns.StringToRcode[strings.ToUpper(record.Status)]; !ok {
+				return Snapshot{}, fmt.Errorf("record %d of '%s' has an unknown status '%s'", i+1, file, record.Status)
+			}
+		}
+	}
+
+	return snapshot, nil
+}
+
+// printDriftReport prints the result of every record, with a diff of the
+// values that changed, and a summary.
+//
+// Args:
+//   - report: The report.
+//
+// Returns:
+//   - None
+func printDriftReport(report DriftReport) {
+	for _, result := range report.Results {
+		line := fmt.Sprintf("%s %s", result.Name, result.Type)
+		switch result.Result {
+		case driftOK:
+			fmt.Println(styles.NewStyles().Highlight.Render("✅ " + line))
+			continue
+		case driftError:
+			fmt.Println(styles.NewStyles().Error.Render(fmt.Sprintf("❌ %s: %s", line, result.Error)))
+			continue
+		}
+
+		if result.Status != result.ExpectedStatus {
+			line += fmt.Sprintf(" status %s, expected %s", result.Status, result.ExpectedStatus)
+		}
+		fmt.Println(styles.NewStyles().Error.Render("❌ " + line))
+		for _, value := range result.Missing {
+			fmt.Println(styles.NewStyles().Error.Render("  - " + value))
+		}
+		for _, value := range result.Unexpected {
+			fmt.Println(styles.NewStyles().Highlight.Render("  + " + value))
+		}
+	}
+
+	fmt.Println()
+	summary := fmt.Sprintf("%d checked, %d drifted, %d errors against %s", report.Checked, report.Drifted, report.Errors, report.File)
+	if report.Drifted > 0 || report.Errors > 0 {
+		fmt.Println(styles.NewStyles().Error.Render(summary))
+	} else {
+		fmt.Println(styles.NewStyles().Title.Render(summary))
+	}
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestNormalizeSnapshotValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		qtype    string
+		value    string
+		recorded string
+	}{
+		{name: "unquoted txt", qtype: "TXT", value: "hello world", recorded: `www.example.test. 300 IN TXT "hello world"`},
+		{name: "quoted txt", qtype: "TXT", value: `"v=spf1 -all"`, recorded: `www.example.test. 300 IN TXT "v=spf1 -all"`},
+		{name: "txt strings", qtype: "TXT", value: `"two" "strings"`, recorded: `www.example.test. 300 IN TXT "two" "strings"`},
+		{name: "long unquoted txt", qtype: "TXT", value: strings.Repeat("x", 300), recorded: `www.example.test. 300 IN TXT "` + strings.Repeat("x", 255) + `" "` + strings.Repeat("x", 45) + `"`},
+		{name: "extra spaces", qtype: "MX", value: " 10   mail.example.test ", recorded: "www.example.test. 300 IN MX 10 mail.example.test."},
+		{name: "other type", qtype: "A", value: "cname target.example.test", recorded: "www.example.test. 300 IN CNAME target.example.test."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rr, err := dns.NewRR(test.recorded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := snapshotValue(rr, test.qtype)
+			if got := normalizeSnapshotValue("www.example.test.", test.qtype, test.value); got != want {
+				t.Errorf("normalized = %s, want %s", got, want)
+			}
+		})
+	}
+}
@@ -1,10 +1,21 @@
 package cmd
 
 import (
+	"commandCenter/dnsserver"
 	"commandCenter/styles"
 	"commandCenter/validators"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"maps"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/spf13/cobra"
@@ -12,19 +23,40 @@ import (
 
 var startServerCmd = &cobra.Command{
 	Use:        "dns",
-	Short:      "Start DNS server on specified port.",
-	Long:       "Start a DNS server on specified port that can be used for DNS testing.",
+	Short:      "Start an authoritative DNS server on specified port.",
+	Long:       "Start a DNS server on specified port that answers authoritatively from RFC 1035 zone files and a YAML records file, for DNS testing and integration fixtures. The records file is watched and its records are swapped in without dropping queries whenever it changes. With --forward, questions outside of the zones are forwarded to upstream resolvers and their answers cached, making the server a local caching resolver. The server listens on UDP and TCP on every --listen address, and all listeners start and stop together. Queries can be logged as JSON Lines and captured to a pcap file.",
 	Aliases:    []string{"server", "init", "initialize"},
 	SuggestFor: []string{"serve", "ser", "serve"},
 	Example: `
-      # Start a DNS server on the default port 8888
-      ops server dns
+      # Serve a zone file on the default port 8888
+      ops server dns -z example.test.zone
 
-      # Start a DNS server on the standard DNS port 53
-      ops server dns -p 53
+      # Serve several zones on the standard DNS port 53
+      ops server dns -p 53 -z example.test.zone -z sub.example.test.zone
 
-      # Start a DNS server on a custom port, e.g., 5353 for mDNS testing
-      ops server dns -p 5353
+      # Serve a zone file without $ORIGIN, its relative names starting from example.test
+      ops server dns -z example.test=db.example
+
+      # Serve records from a YAML file, reloaded whenever it changes
+      ops server dns --records records.yaml
+
+      # Act as a local caching resolver, failing over from Quad9 to Cloudflare over DoT
+      ops server dns -p 53 --forward -s 9.9.9.9 -s 1.1.1.1 --transport tls
+
+      # Serve local zones, forward the rest and keep serving expired answers for an hour when upstreams are down
+      ops server dns -z example.test.zone --forward --serve-stale 1h --prefetch 5
+
+      # Listen on UDP and TCP on the IPv4 and IPv6 loopback addresses only
+      ops server dns -z example.test.zone --listen 127.0.0.1 --listen ::1 -p 5353
+
+      # Only listen on TCP, keeping idle connections open for 30s
+      ops server dns -z example.test.zone --net tcp --tcp-idle-timeout 30s
+
+      # Log every query as JSON Lines on stdout
+      ops server dns -z example.test.zone --query-log -
+
+      # Log 10% of the queries below example.test to a file and capture their packets for Wireshark
+      ops server dns -z example.test.zone --query-log queries.jsonl --pcap queries.pcap --log-sample 0.1 --log-name example.test
 
       # Get help for the DNS server command
       ops server dns --help
@@ -41,12 +73,30 @@ var startServerCmd = &cobra.Command{
 // Returns:
 //   - None
 func init() {
-	startServerCmd.Flags().StringP("port", "p", "8888", "port for the DNS server")
+	startServerCmd.Flags().StringP("port", "p", "8888", "port for the DNS server, used by listen addresses without one")
+	startServerCmd.Flags().StringSliceP("listen", "l", []string{}, "address to listen on as host[:port], repeat for several addresses (default every IPv4 and IPv6 address)")
+	startServerCmd.Flags().StringSlice("net", dnsserver.Networks, "transports to listen on: udp, tcp or both")
+	startServerCmd.Flags().Duration("tcp-idle-timeout", dnsserver.DefaultTCPIdleTimeout, "how long an idle TCP connection is kept open for more queries")
+	startServerCmd.Flags().Int("tcp-max-queries", dnsserver.DefaultTCPMaxQueries, "queries answered on one TCP connection, pipelined ones in order; 1 disables pipelining, -1 for unlimited")
+	startServerCmd.Flags().StringSliceP("zone", "z", []string{}, "zone file to serve as [origin=]path, repeat for several zones")
+	startServerCmd.Flags().StringP("records", "r", "", "YAML file of A, AAAA, CNAME, TXT, MX and SRV records to serve, reloaded on change; names outside of the zone files are served from zones named after their registered domain (example.co.uk for www.example.co.uk)")
+	startServerCmd.Flags().Duration("reload-interval", 2*time.Second, "how often the records file is checked for changes")
+	startServerCmd.Flags().Bool("forward", false, "forward questions outside of the zones to the upstream resolvers instead of refusing them")
+	startServerCmd.Flags().Int("cache-size", dnsserver.DefaultCacheSize, "number of forwarded answers cached, 0 to disable the cache")
+	startServerCmd.Flags().Int("prefetch", 0, "refresh cached answers hit this many times before they expire, 0 to disable")
+	startServerCmd.Flags().Duration("serve-stale", 0, "how long expired answers are served when no upstream answers (RFC 8767), 0 to disable")
+	startServerCmd.Flags().Duration("cache-stats", time.Minute, "how often the cache statistics are logged, 0 to disable")
+	startServerCmd.Flags().String("query-log", "", "log every query as JSON Lines to this file, - for stdout")
+	startServerCmd.Flags().String("pcap", "", "write the request and response packets to this pcap file")
+	startServerCmd.Flags().Float64("log-sample", 1, "share of the queries logged and captured, between 0 and 1")
+	startServerCmd.Flags().StringSlice("log-name", []string{}, "only log and capture questions for this name and the names below it, repeat for several names")
+	addUpstreamFlags(startServerCmd)
 
 	connectCmd.AddCommand(startServerCmd)
 }
 
-// startDNSServer starts a DNS server on the specified port.
+// startDNSServer loads the zone files and records file and starts a DNS
+// server answering authoritatively for them on the specified port.
 //
 // Args:
 //   - cmd: The cobra command.
@@ -60,16 +110,404 @@ func startDNSServer(cmd *cobra.Command, args []string) {
 		log.Fatalln(err)
 	}
 
-	server := &dns.Server{
-		Addr:      fmt.Sprintf(":%s", port),
-		Net:       "udp",
-		UDPSize:   65535,
-		ReusePort: true,
+	zoneFiles, err := validators.VerifyStringSliceInputs(cmd, "zone")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	recordsFile, err := validators.VerifyStringInputs(cmd, "records")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	interval, err := validators.VerifyDurationInputs(cmd, "reload-interval")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if interval <= 0 {
+		log.Fatalln(styles.NewStyles().Error.Render("--reload-interval must be positive"))
+	}
+
+	zones, err := readZoneFiles(zoneFiles)
+	if err != nil {
+		log.Fatalln(styles.NewStyles().Error.Render(err.Error()))
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+	defer stop()
+
+	var records []dns.RR
+	if recordsFile != "" {
+		records, err = dnsserver.LoadRecords(recordsFile)
+		if err != nil {
+			log.Fatalln(styles.NewStyles().Error.Render(err.Error()))
+		}
+	}
+
+	authority, err := dnsserver.BuildAuthority(zones, records)
+	if err != nil {
+		log.Fatalln(styles.NewStyles().Error.Render(err.Error()))
+	}
+
+	forwarder, err := forwarderFromFlags(cmd)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	printZones(authority, forwarder != nil)
+	if forwarder != nil && forwarder.Cache() != nil {
+		statsInterval, err := validators.VerifyDurationInputs(cmd, "cache-stats")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if statsInterval > 0 {
+			go logCacheStats(ctx, forwarder.Cache(), statsInterval)
+		}
+	}
+
+	handler := dnsserver.NewHandler(authority, forwarder)
+	if recordsFile != "" {
+		go watchRecords(ctx, handler, zones, recordsFile, records, interval)
+	}
+
+	queryLog, closeLogs, err := queryLogFromFlags(cmd)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer closeLogs()
+
+	var served dns.Handler = handler
+	if queryLog != nil {
+		served = queryLog.Wrap(handler)
+	}
+
+	server, err := serverFromFlags(cmd, port, served)
+	if err != nil {
+		log.Fatalln(err)
 	}
 
-	fmt.Printf(styles.NewStyles().Highlight.Render("Startiung DNS server on port %s"), port)
-	err = server.ListenAndServe()
+	err = server.ListenAndServe(ctx, func() {
+		for _, listener := range server.Listeners() {
+			fmt.Printf(styles.NewStyles().Highlight.Render("Listening on %s %s"), listener.Network, listener.Address)
+			fmt.Println()
+		}
+	})
 	if err != nil {
 		fmt.Printf(styles.NewStyles().Error.Render("Failed to start server: %s\n"), err.Error())
 	}
 }
+
+// serverFromFlags builds the listeners from the --listen, --net and TCP flags.
+//
+// Args:
+//   - cmd: The cobra command.
+//   - port: The port of listen addresses without one.
+//   - handler: The handler answering the queries.
+//
+// Returns:
+//   - *dnsserver.Server: The server.
+//   - error: An error if the flags cannot be parsed or are invalid.
+func serverFromFlags(cmd *cobra.Command, port string, handler dns.Handler) (*dnsserver.Server, error) {
+	listen, err := validators.VerifyStringSliceInputs(cmd, "listen")
+	if err != nil {
+		return nil, err
+	}
+	if len(listen) == 0 {
+		listen = []string{""}
+	}
+
+	addresses := make([]string, 0, len(listen))
+	for _, address := range listen {
+		if ip := net.ParseIP(strings.Trim(address, "[]")); ip != nil || !strings.Contains(address, ":") {
+			address = net.JoinHostPort(strings.Trim(address, "[]"), port)
+		}
+		addresses = append(addresses, address)
+	}
+
+	networks, err := validators.VerifyStringSliceInputs(cmd, "net")
+	if err != nil {
+		return nil, err
+	}
+
+	idleTimeout, err := validators.VerifyDurationInputs(cmd, "tcp-idle-timeout")
+	if err != nil {
+		return nil, err
+	}
+
+	maxQueries, err := validators.VerifyIntInputs(cmd, "tcp-max-queries")
+	if err != nil {
+		return nil, err
+	}
+	if maxQueries == 0 || maxQueries < -1 {
+		return nil, errors.New(styles.NewStyles().Error.Render("--tcp-max-queries must be positive or -1"))
+	}
+
+	server, err := dnsserver.NewServer(handler, dnsserver.ServerOptions{
+		Listen:         addresses,
+		Networks:       networks,
+		TCPIdleTimeout: idleTimeout,
+		TCPMaxQueries:  maxQueries,
+	})
+	if err != nil {
+		return nil, errors.New(styles.NewStyles().Error.Render(err.Error()))
+	}
+
+	return server, nil
+}
+
+// queryLogFromFlags builds the query log from the --query-log, --pcap,
+// --log-sample and --log-name flags.
+//
+// Args:
+//   - cmd: The cobra command.
+//
+// Returns:
+//   - *dnsserver.QueryLog: The query log, nil when nothing is logged.
+//   - func(): Closes the files opened for the log.
+//   - error: An error if the flags cannot be parsed or a file cannot be opened.
+func queryLogFromFlags(cmd *cobra.Command) (*dnsserver.QueryLog, func(), error) {
+	var files []io.Closer
+	closeFiles := func() {
+		for _, file := range files {
+			_ = file.Close()
+		}
+	}
+
+	jsonPath, err := validators.VerifyStringInputs(cmd, "query-log")
+	if err != nil {
+		return nil, closeFiles, err
+	}
+
+	pcapPath, err := validators.VerifyStringInputs(cmd, "pcap")
+	if err != nil {
+		return nil, closeFiles, err
+	}
+
+	sample, err := validators.VerifyFloatInputs(cmd, "log-sample")
+	if err != nil {
+		return nil, closeFiles, err
+	}
+
+	names, err := validators.VerifyStringSliceInputs(cmd, "log-name")
+	if err != nil {
+		return nil, closeFiles, err
+	}
+
+	if jsonPath == "" && pcapPath == "" {
+		return nil, closeFiles, nil
+	}
+
+	options := dnsserver.QueryLogOptions{
+		Sample: sample,
+		Names:  names,
+		Errors: func(err error) { log.Println(styles.NewStyles().Error.Render(err.Error())) },
+	}
+
+	queries, err := openJSONLines(jsonPath)
+	if err != nil {
+		return nil, closeFiles, errors.New(styles.NewStyles().Error.Render(err.Error()))
+	}
+	if queries != nil {
+		files = append(files, queries)
+		options.JSON = queries
+	}
+
+	if pcapPath != "" {
+		file, err := os.Create(pcapPath)
+		if err != nil {
+			return nil, closeFiles, fmt.Errorf(styles.NewStyles().Error.Render("could not create pcap file '%s': %s"), pcapPath, err)
+		}
+		files = append(files, file)
+
+		options.Pcap, err = dnsserver.NewPcapWriter(file)
+		if err != nil {
+			return nil, closeFiles, errors.New(styles.NewStyles().Error.Render(err.Error()))
+		}
+	}
+
+	queryLog, err := dnsserver.NewQueryLog(options)
+	if err != nil {
+		return nil, closeFiles, errors.New(styles.NewStyles().Error.Render(err.Error()))
+	}
+
+	return queryLog, closeFiles, nil
+}
+
+// readZoneFiles reads the records of the zone files given as [origin=]path.
+//
+// Args:
+//   - zoneFiles: The zone files.
+//
+// Returns:
+//   - [][]dns.RR: The records of every zone file.
+//   - error: An error if a zone file cannot be read or parsed.
+func readZoneFiles(zoneFiles []string) ([][]dns.RR, error) {
+	var zones [][]dns.RR
+	for _, zoneFile := range zoneFiles {
+		origin, path, found := strings.Cut(zoneFile, "=")
+		if !found {
+			origin, path = "", zoneFile
+		}
+
+		records, err := dnsserver.ReadZoneFile(path, origin)
+		if err != nil {
+			return nil, err
+		}
+		zones = append(zones, records)
+	}
+
+	return zones, nil
+}
+
+// printZones prints the zones an authority serves.
+//
+// Args:
+//   - authority: The authority.
+//   - forward: Whether other questions are forwarded.
+//
+// Returns:
+//   - None
+func printZones(authority *dnsserver.Authority, forward bool) {
+	served := authority.Zones()
+	if len(served) == 0 && !forward {
+		fmt.Println("no zone files or records given, every query will be REFUSED")
+	}
+	for _, origin := range slices.Sorted(maps.Keys(served)) {
+		fmt.Printf("serving %s with %d records (serial %d)\n", origin, served[origin].Len(), served[origin].SOA.Serial)
+	}
+}
+
+// forwarderFromFlags builds the forwarder from the --forward, upstream and
+// cache flags.
+//
+// Args:
+//   - cmd: The cobra command.
+//
+// Returns:
+//   - *dnsserver.Forwarder: The forwarder, nil without --forward.
+//   - error: An error if the flags cannot be parsed or are invalid.
+func forwarderFromFlags(cmd *cobra.Command) (*dnsserver.Forwarder, error) {
+	forward, err := validators.VerifyBoolInputs(cmd, "forward")
+	if err != nil || !forward {
+		return nil, err
+	}
+
+	upstreams, err := upstreamsFromFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := validators.VerifyIntInputs(cmd, "cache-size")
+	if err != nil {
+		return nil, err
+	}
+
+	prefetch, err := validators.VerifyIntInputs(cmd, "prefetch")
+	if err != nil {
+		return nil, err
+	}
+
+	serveStale, err := validators.VerifyDurationInputs(cmd, "serve-stale")
+	if err != nil {
+		return nil, err
+	}
+
+	if size < 0 || prefetch < 0 || serveStale < 0 {
+		return nil, errors.New(styles.NewStyles().Error.Render("--cache-size, --prefetch and --serve-stale cannot be negative"))
+	}
+
+	var cache *dnsserver.Cache
+	if size > 0 {
+		cache = dnsserver.NewCache(dnsserver.CacheOptions{Size: size, Prefetch: prefetch, ServeStale: serveStale})
+	}
+
+	cached := "without a cache"
+	if cache != nil {
+		cached = fmt.Sprintf("caching up to %d answers", size)
+	}
+	fmt.Printf("forwarding other questions to %s over %s, %s\n", strings.Join(upstreams.Servers(), ", "), upstreams.Transport().Name(), cached)
+
+	return dnsserver.NewForwarder(upstreams, cache), nil
+}
+
+// logCacheStats logs the cache statistics at an interval.
+//
+// Args:
+//   - ctx: The context stopping the logging.
+//   - cache: The cache.
+//   - interval: The time between two logs.
+//
+// Returns:
+//   - None
+func logCacheStats(ctx context.Context, cache *dnsserver.Cache, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			log.Printf("cache: %s", cache.Stats())
+		}
+	}
+}
+
+// watchRecords polls the records file and swaps the records served when it
+// changes. A file that fails to load is reported and the previous records
+// keep being served.
+//
+// Args:
+//   - ctx: The context stopping the watch.
+//   - handler: The handler whose authority is swapped.
+//   - zones: The records of the zone files, served alongside.
+//   - path: The path to the records file.
+//   - records: The records loaded at startup.
+//   - interval: The time between two checks.
+//
+// Returns:
+//   - None
+func watchRecords(ctx context.Context, handler *dnsserver.Handler, zones [][]dns.RR, path string, records []dns.RR, interval time.Duration) {
+	last, _ := os.Stat(path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Println(styles.NewStyles().Error.Render(fmt.Sprintf("could not check records file: %s", err)))
+			continue
+		}
+		if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+			continue
+		}
+		last = info
+
+		current, err := dnsserver.LoadRecords(path)
+		if err != nil {
+			log.Println(styles.NewStyles().Error.Render(fmt.Sprintf("reload failed, still serving the previous records: %s", err)))
+			continue
+		}
+
+		authority, err := dnsserver.BuildAuthority(zones, current)
+		if err != nil {
+			log.Println(styles.NewStyles().Error.Render(fmt.Sprintf("reload failed, still serving the previous records: invalid records file '%s': %s", path, err)))
+			continue
+		}
+		handler.Swap(authority)
+
+		added, removed := dnsserver.DiffRecords(records, current)
+		records = current
+		log.Printf("reloaded %s: %d added, %d removed", path, len(added), len(removed))
+		for _, rr := range removed {
+			log.Println("- " + rr.String())
+		}
+		for _, rr := range added {
+			log.Println("+ " + rr.String())
+		}
+	}
+}
@@ -97,7 +97,7 @@ func testConnection(cmd *cobra.Command, args []string) {
 // Returns:
 //   - None
 func (D Destination) telnet() {
-	destination := fmt.Sprintf("%s:%s", D.host, D.port)
+	destination := net.JoinHostPort(D.host, D.port)
 
 	conn, err := net.DialTimeout("tcp", destination, 5*time.Second)
 	if err != nil {
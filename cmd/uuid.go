@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"log"
+	"strconv"
 
 	"commandCenter/styles"
 	"commandCenter/validators"
@@ -66,7 +67,7 @@ func createUUID() {
 // Returns:
 // - int: the clock sequence UUID
 func createClockUUID() {
-	log.Println(styles.StyliseMessage("UUID🕕: "+string(uuid.ClockSequence()), styles.FormatStyle.Highlight))
+	log.Println(styles.StyliseMessage("UUID🕕: "+strconv.Itoa(uuid.ClockSequence()), styles.FormatStyle.Highlight))
 }
 
 // validateUUID validates a UUID string.
@@ -89,7 +90,7 @@ func validateUUID(uuidString string) error {
 // - string: the version 6 UUID
 func createv6UUID() {
 	if newUUID, err := uuid.NewV6(); err != nil {
-		log.Fatalf(styles.StyliseMessage(fmt.Sprintf("Could not generate UUID: %s", err), styles.FormatStyle.Error))
+		log.Fatal(styles.StyliseMessage(fmt.Sprintf("Could not generate UUID: %s", err), styles.FormatStyle.Error))
 	} else {
 		log.Println(styles.StyliseMessage("UUID❻:: "+newUUID.String(), styles.FormatStyle.Highlight))
 	}
@@ -104,7 +105,7 @@ func createv6UUID() {
 // - string: the version 7 UUID
 func createv7UUID() {
 	if newUUID, err := uuid.NewV6(); err != nil {
-		log.Fatalf(styles.StyliseMessage(fmt.Sprintf("Could not generate UUID: %s", err), styles.FormatStyle.Error))
+		log.Fatal(styles.StyliseMessage(fmt.Sprintf("Could not generate UUID: %s", err), styles.FormatStyle.Error))
 	} else {
 		log.Println(styles.StyliseMessage("UUID❼:: "+newUUID.String(), styles.FormatStyle.Highlight))
 	}
@@ -0,0 +1,381 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
+
+	"commandCenter/dnsquery"
+	"commandCenter/styles"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	minWatchInterval     = time.Second
+	defaultWatchInterval = 30 * time.Second
+)
+
+type WatchOptions struct {
+	enabled  bool
+	interval time.Duration
+	count    int
+	history  int
+	hook     string
+	events   string
+}
+
+type WatchSnapshot struct {
+	Time   time.Time
+	Status string
+	Values []string
+	TTL    uint32
+	Server string
+}
+
+type WatchEvent struct {
+	Time     time.Time `json:"time"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Status   string    `json:"status"`
+	Previous []string  `json:"previous"`
+	Current  []string  `json:"current"`
+	Added    []string  `json:"added"`
+	Removed  []string  `json:"removed"`
+	Server   string    `json:"server"`
+}
+
+// Watch re-queries the record until interrupted, either on a fixed interval or
+// when its TTL expires, and reports every change of the answer set.
+//
+// Args:
+//   - ctx: The context bounding the watch, stopped early by Ctrl-C.
+//
+// Returns:
+//   - error: An error if the type is unknown or the events file cannot be opened.
+func (D Domain) Watch(ctx context.Context) error {
+	if _, err := dnsquery.ParseType(D.qtype); err != nil {
+		return err
+	}
+
+	events, err := openJSONLines(D.watch.events)
+	if err != nil {
+		return err
+	}
+	if events != nil {
+		defer events.Close()
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	fmt.Printf(styles.NewStyles().Title.Render("👀 Watching %s %s 👀"), dns.Fqdn(D.domainName), strings.ToUpper(D.qtype))
+	fmt.Println()
+
+	var (
+		history []WatchSnapshot
+		last    *WatchSnapshot
+	)
+	for polls := 1; ; polls++ {
+		response, err := D.PrepareDnsCall(ctx, D.qtype)
+		if ctx.Err() != nil {
+			break
+		}
+
+		wait := D.watch.interval
+		if err != nil {
+			fmt.Println(styles.NewStyles().Error.Render(fmt.Sprintf("%s %s", time.Now().Format(time.TimeOnly), err)))
+			if wait <= 0 {
+				wait = defaultWatchInterval
+			}
+		} else {
+			snapshot := newWatchSnapshot(response)
+			if wait <= 0 {
+				wait = watchInterval(response, snapshot.TTL)
+			}
+
+			switch {
+			case last == nil:
+				printWatchSnapshot(snapshot)
+				history = append(history, snapshot)
+			case snapshot.changed(*last):
+				event := D.watchEvent(*last, snapshot)
+				printWatchDiff(event)
+				history = append(history, snapshot)
+				if err := D.notify(ctx, event, events); err != nil {
+					fmt.Println(styles.NewStyles().Error.Render(err.Error()))
+				}
+			default:
+				fmt.Printf("%s unchanged (ttl %ds)\n", snapshot.Time.Format(time.TimeOnly), snapshot.TTL)
+			}
+			last = &snapshot
+
+			if D.watch.history > 0 && len(history) > D.watch.history {
+				history = history[len(history)-D.watch.history:]
+			}
+		}
+
+		if D.watch.count > 0 && polls >= D.watch.count {
+			break
+		}
+		if !countdown(ctx, wait, last) {
+			break
+		}
+	}
+
+	printWatchHistory(history)
+
+	return nil
+}
+
+// newWatchSnapshot captures the answer set of a response.
+//
+// Args:
+//   - response: The DNS response.
+//
+// Returns:
+//   - WatchSnapshot: The sorted record values and the lowest TTL.
+func newWatchSnapshot(response dnsquery.Response) WatchSnapshot {
+	snapshot := WatchSnapshot{
+		Time:   time.Now(),
+		Status: dnsquery.NewResult(response).Status,
+		Values: []string{},
+		Server: response.Server,
+	}
+
+	for _, rr := range response.Msg.Answer {
+		record := dnsquery.NewRecord(rr)
+		snapshot.Values = append(snapshot.Values, record.Type+" "+record.Value)
+		if snapshot.TTL == 0 || record.TTL < snapshot.TTL {
+			snapshot.TTL = record.TTL
+		}
+	}
+	slices.Sort(snapshot.Values)
+
+	return snapshot
+}
+
+// changed reports whether the status or the answer set differ from a previous snapshot.
+//
+// Args:
+//   - previous: The previous snapshot.
+//
+// Returns:
+//   - bool: Whether the answer changed.
+func (W WatchSnapshot) changed(previous WatchSnapshot) bool {
+	return W.Status != previous.Status || !slices.Equal(W.Values, previous.Values)
+}
+
+// watchInterval follows the TTL of the answer, or the negative caching TTL
+// from the SOA record for empty answers.
+//
+// Args:
+//   - response: The DNS response.
+//   - ttl: The lowest TTL of the answer.
+//
+// Returns:
+//   - time.Duration: The time to wait before the next query.
+func watchInterval(response dnsquery.Response, ttl uint32) time.Duration {
+	if len(response.Msg.Answer) == 0 {
+		for _, rr := range response.Msg.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				ttl = min(soa.Hdr.Ttl, soa.Minttl)
+			}
+		}
+		if ttl == 0 {
+			return defaultWatchInterval
+		}
+	}
+
+	return max(time.Duration(ttl)*time.Second, minWatchInterval)
+}
+
+// countdown waits for the next query, showing the remaining time and TTL on
+// a terminal.
+//
+// Args:
+//   - ctx: The context stopping the wait.
+//   - wait: The time to wait.
+//   - last: The last snapshot, used for the TTL countdown.
+//
+// Returns:
+//   - bool: Whether the wait completed, false when the context was stopped.
+func countdown(ctx context.Context, wait time.Duration, last *WatchSnapshot) bool {
+	info, err := os.Stdout.Stat()
+	live := err == nil && info.Mode()&os.ModeCharDevice != 0
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	deadline := time.Now().Add(wait)
+	for {
+		if live {
+			line := fmt.Sprintf("next query in %s", time.Until(deadline).Round(time.Second))
+			if last != nil {
+				expires := last.Time.Add(time.Duration(last.TTL) * time.Second)
+				line += fmt.Sprintf(", ttl %s", max(time.Until(expires), 0).Round(time.Second))
+			}
+			fmt.Printf("\r\033[K%s", line)
+		}
+
+		select {
+		case <-ctx.Done():
+			if live {
+				fmt.Print("\r\033[K")
+			}
+			return false
+		case <-timer.C:
+			if live {
+				fmt.Print("\r\033[K")
+			}
+			return true
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchEvent describes the change between two snapshots.
+//
+// Args:
+//   - previous: The previous snapshot.
+//   - current: The current snapshot.
+//
+// Returns:
+//   - WatchEvent: The change event.
+func (D Domain) watchEvent(previous, current WatchSnapshot) WatchEvent {
+	event := WatchEvent{
+		Time:     current.Time,
+		Name:     dns.Fqdn(D.domainName),
+		Type:     strings.ToUpper(D.qtype),
+		Status:   current.Status,
+		Previous: previous.Values,
+		Current:  current.Values,
+		Added:    []string{},
+		Removed:  []string{},
+		Server:   current.Server,
+	}
+
+	for _, value := range current.Values {
+		if !slices.Contains(previous.Values, value) {
+			event.Added = append(event.Added, value)
+		}
+	}
+	for _, value := range previous.Values {
+		if !slices.Contains(current.Values, value) {
+			event.Removed = append(event.Removed, value)
+		}
+	}
+
+	return event
+}
+
+// notify writes a change event to the events file and runs the hook command.
+// The hook gets the event as JSON on stdin and the main fields as
+// OPS_DNS_* environment variables.
+//
+// Args:
+//   - ctx: The context bounding the hook.
+//   - event: The change event.
+//   - events: The events writer, nil when no events are written.
+//
+// Returns:
+//   - error: An error if the event cannot be written or the hook fails.
+func (D Domain) notify(ctx context.Context, event WatchEvent, events io.Writer) error {
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if events != nil {
+		if _, err := fmt.Fprintf(events, "%s\n", encoded); err != nil {
+			return fmt.Errorf("could not write event: %w", err)
+		}
+	}
+
+	if D.watch.hook == "" {
+		return nil
+	}
+
+	hook := exec.CommandContext(ctx, "sh", "-c", D.watch.hook)
+	hook.Stdin = strings.NewReader(string(encoded) + "\n")
+	hook.Stdout = os.Stdout
+	hook.Stderr = os.Stderr
+	hook.Env = append(os.Environ(),
+		"OPS_DNS_NAME="+event.Name,
+		"OPS_DNS_TYPE="+event.Type,
+		"OPS_DNS_STATUS="+event.Status,
+		"OPS_DNS_PREVIOUS="+strings.Join(event.Previous, "\n"),
+		"OPS_DNS_CURRENT="+strings.Join(event.Current, "\n"),
+	)
+	if err := hook.Run(); err != nil {
+		return fmt.Errorf("hook '%s' failed: %w", D.watch.hook, err)
+	}
+
+	return nil
+}
+
+// printWatchSnapshot prints the first answer set seen.
+//
+// Args:
+//   - snapshot: The snapshot to print.
+//
+// Returns:
+//   - None
+func printWatchSnapshot(snapshot WatchSnapshot) {
+	fmt.Printf("%s %s (ttl %ds) from %s\n", snapshot.Time.Format(time.TimeOnly), snapshot.Status, snapshot.TTL, snapshot.Server)
+	for _, value := range snapshot.Values {
+		fmt.Println(styles.NewStyles().Highlight.Render(value))
+	}
+}
+
+// printWatchDiff prints a change with removed values in red and added ones highlighted.
+//
+// Args:
+//   - event: The change event.
+//
+// Returns:
+//   - None
+func printWatchDiff(event WatchEvent) {
+	fmt.Println(styles.NewStyles().Title.Render(fmt.Sprintf("%s changed (%s)", event.Time.Format(time.TimeOnly), event.Status)))
+	for _, value := range event.Removed {
+		fmt.Println(styles.NewStyles().Error.Render("- " + value))
+	}
+	for _, value := range event.Current {
+		if slices.Contains(event.Added, value) {
+			fmt.Println(styles.NewStyles().Highlight.Render("+ " + value))
+		} else {
+			fmt.Println("  " + value)
+		}
+	}
+}
+
+// printWatchHistory prints every answer set seen while watching.
+//
+// Args:
+//   - history: The snapshots, oldest first.
+//
+// Returns:
+//   - None
+func printWatchHistory(history []WatchSnapshot) {
+	if len(history) == 0 {
+		return
+	}
+
+	fmt.Println(styles.NewStyles().Title.Render("History"))
+	for _, snapshot := range history {
+		values := strings.Join(snapshot.Values, ", ")
+		if values == "" {
+			values = "no records"
+		}
+		fmt.Printf("%s  %-8s %s\n", snapshot.Time.Format(time.DateTime), snapshot.Status, values)
+	}
+}
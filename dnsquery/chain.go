@@ -0,0 +1,169 @@
+package dnsquery
+
+import (
+	"context"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// MaxChainLength is the number of CNAME and DNAME hops followed before a
+// chain is reported as too long, the limit used by BIND and Unbound.
+const MaxChainLength = 16
+
+type ChainHop struct {
+	Name   string `json:"name" yaml:"name"`
+	Type   string `json:"type" yaml:"type"`
+	Target string `json:"target" yaml:"target"`
+	TTL    uint32 `json:"ttl" yaml:"ttl"`
+	Server string `json:"server" yaml:"server"`
+}
+
+// Chain is the path from a name to its records through CNAME and DNAME
+// aliases. Dangling is set when an alias points to a name that does not
+// exist, which lets anyone who registers that name take the alias over.
+type Chain struct {
+	Name     string     `json:"name" yaml:"name"`
+	Type     string     `json:"type" yaml:"type"`
+	Hops     []ChainHop `json:"hops" yaml:"hops"`
+	Final    string     `json:"final" yaml:"final"`
+	Rcode    string     `json:"rcode" yaml:"rcode"`
+	Answer   []Record   `json:"answer" yaml:"answer"`
+	Loop     bool       `json:"loop" yaml:"loop"`
+	TooLong  bool       `json:"too_long" yaml:"too_long"`
+	Dangling bool       `json:"dangling" yaml:"dangling"`
+}
+
+// FollowChain resolves a name and follows its CNAME and DNAME aliases across
+// zones, asking again for targets the resolver did not chase itself.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//   - resolver: The resolver used for every query.
+//   - name: The name to resolve.
+//   - qtype: The type of the records at the end of the chain. CNAME and DNAME
+//     are followed down to A records.
+//
+// Returns:
+//   - Chain: The hops and the final records.
+//   - error: A *TypeError for unknown types or an error if a query fails.
+func FollowChain(ctx context.Context, resolver Resolver, name, qtype string) (Chain, error) {
+	record, err := ParseType(qtype)
+	if err != nil {
+		return Chain{}, err
+	}
+	if record == dns.TypeCNAME || record == dns.TypeDNAME {
+		record = dns.TypeA
+	}
+
+	chain := Chain{Name: dns.CanonicalName(name), Type: dns.TypeToString[record], Hops: []ChainHop{}}
+	seen := map[string]bool{chain.Name: true}
+	current := chain.Name
+
+	for queries := 0; queries <= MaxChainLength; queries++ {
+		response, err := Negative(resolver.Lookup(ctx, current, chain.Type))
+		if err != nil {
+			return chain, err
+		}
+
+		next := chain.walk(response, current, seen)
+		if chain.Loop || chain.TooLong {
+			chain.Final = next
+			return chain, nil
+		}
+
+		var answer []dns.RR
+		for _, rr := range response.Msg.Answer {
+			if rr.Header().Rrtype == record && dns.CanonicalName(rr.Header().Name) == next {
+				answer = append(answer, rr)
+			}
+		}
+
+		// A NOERROR answer ending on an alias without its records means the
+		// resolver stopped at a zone boundary, so ask for the target itself.
+		if next != current && len(answer) == 0 && response.Msg.Rcode == dns.RcodeSuccess {
+			current = next
+			continue
+		}
+
+		chain.Final = next
+		chain.Rcode = dns.RcodeToString[response.Msg.Rcode]
+		chain.Answer = newRecords(answer)
+		chain.Dangling = len(chain.Hops) > 0 && response.Msg.Rcode == dns.RcodeNameError
+		return chain, nil
+	}
+
+	chain.TooLong = true
+	chain.Final = current
+	return chain, nil
+}
+
+// walk follows the aliases of a response from a name, preferring a DNAME over
+// the CNAME synthesized from it.
+//
+// Args:
+//   - response: The response.
+//   - name: The name the walk starts at.
+//   - seen: The names already visited, updated with every target.
+//
+// Returns:
+//   - string: The last name reached.
+func (C *Chain) walk(response Response, name string, seen map[string]bool) string {
+	for {
+		hop, ok := nextHop(response.Msg.Answer, name)
+		if !ok {
+			return name
+		}
+		hop.Server = response.Server
+		C.Hops = append(C.Hops, hop)
+
+		if len(C.Hops) > MaxChainLength {
+			C.TooLong = true
+			return hop.Target
+		}
+		if seen[hop.Target] {
+			C.Loop = true
+			return hop.Target
+		}
+		seen[hop.Target] = true
+		name = hop.Target
+	}
+}
+
+// nextHop finds the alias that applies to a name in an answer section.
+//
+// Args:
+//   - answer: The answer section.
+//   - name: The name.
+//
+// Returns:
+//   - ChainHop: The hop to the alias target.
+//   - bool: Whether an alias applies to the name.
+func nextHop(answer []dns.RR, name string) (ChainHop, bool) {
+	for _, rr := range answer {
+		dname, ok := rr.(*dns.DNAME)
+		if !ok {
+			continue
+		}
+		owner := dns.CanonicalName(dname.Hdr.Name)
+		if owner == name || !dns.IsSubDomain(owner, name) {
+			continue
+		}
+		return ChainHop{
+			Name:   owner,
+			Type:   "DNAME",
+			Target: strings.TrimSuffix(name, owner) + dns.CanonicalName(dname.Target),
+			TTL:    dname.Hdr.Ttl,
+		}, true
+	}
+
+	for _, rr := range answer {
+		cname, ok := rr.(*dns.CNAME)
+		if !ok || dns.CanonicalName(cname.Hdr.Name) != name {
+			continue
+		}
+		return ChainHop{Name: name, Type: "CNAME", Target: dns.CanonicalName(cname.Target), TTL: cname.Hdr.Ttl}, true
+	}
+
+	return ChainHop{}, false
+}
@@ -0,0 +1,1171 @@
+package dnsquery
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	DnssecSecure        = "secure"
+	DnssecInsecure      = "insecure"
+	DnssecBogus         = "bogus"
+	DnssecIndeterminate = "indeterminate"
+
+	DnssecBufferSize = 4096
+)
+
+// defaultTrustAnchors are the DS records of the root zone KSKs (KSK-2017 and KSK-2024).
+var defaultTrustAnchors = []string{
+	". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+type ValidationLink struct {
+	Zone   string `json:"zone" yaml:"zone"`
+	Record string `json:"record" yaml:"record"`
+	Status string `json:"status" yaml:"status"`
+	Detail string `json:"detail" yaml:"detail"`
+}
+
+type Validation struct {
+	Status string           `json:"status" yaml:"status"`
+	Reason string           `json:"reason,omitempty" yaml:"reason,omitempty"`
+	Links  []ValidationLink `json:"links" yaml:"links"`
+}
+
+type zoneState struct {
+	keys   []*dns.DNSKEY
+	status string
+}
+
+type rrsetKey struct {
+	name   string
+	rrtype uint16
+}
+
+type Validator struct {
+	resolver Resolver
+	anchors  map[string][]dns.RR
+	now      func() time.Time
+	zones    map[string]zoneState
+	links    []ValidationLink
+}
+
+// NewValidator creates a DNSSEC validator that fetches DNSKEY and DS records
+// through a resolver and trusts the given anchors.
+//
+// Args:
+//   - resolver: The resolver used to fetch the chain of trust.
+//   - anchors: The trust anchors as DS or DNSKEY records, the root KSKs when empty.
+//
+// Returns:
+//   - *Validator: The validator.
+func NewValidator(resolver Resolver, anchors []dns.RR) *Validator {
+	if len(anchors) == 0 {
+		for _, anchor := range defaultTrustAnchors {
+			rr, _ := dns.NewRR(anchor)
+			anchors = append(anchors, rr)
+		}
+	}
+
+	byZone := map[string][]dns.RR{}
+	for _, anchor := range anchors {
+		zone := dns.CanonicalName(anchor.Header().Name)
+		byZone[zone] = append(byZone[zone], anchor)
+	}
+
+	return &Validator{
+		resolver: resolver,
+		anchors:  byZone,
+		now:      time.Now,
+		zones:    map[string]zoneState{},
+	}
+}
+
+// LoadTrustAnchors reads DS or DNSKEY trust anchors from a zone file.
+//
+// Args:
+//   - path: The path to the trust anchor file.
+//
+// Returns:
+//   - []dns.RR: The DS and DNSKEY records found in the file.
+//   - error: An error if the file cannot be parsed or has no anchors.
+func LoadTrustAnchors(path string) ([]dns.RR, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open trust anchors '%s': %w", path, err)
+	}
+	defer file.Close()
+
+	var anchors []dns.RR
+	parser := dns.NewZoneParser(file, ".", path)
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		switch rr.(type) {
+		case *dns.DS, *dns.DNSKEY:
+			anchors = append(anchors, rr)
+		}
+	}
+	if err := parser.Err(); err != nil {
+		return nil, fmt.Errorf("could not parse trust anchors '%s': %w", path, err)
+	}
+	if len(anchors) == 0 {
+		return nil, fmt.Errorf("no DS or DNSKEY records found in '%s'", path)
+	}
+
+	return anchors, nil
+}
+
+// Validate checks the signatures of a response and walks the chain of trust
+// from its signer up to a trust anchor.
+//
+// Args:
+//   - ctx: The context bounding the queries.
+//   - in: The response, fetched with the DO bit set.
+//
+// Returns:
+//   - Validation: The overall status and every link that was checked.
+func (V *Validator) Validate(ctx context.Context, in *dns.Msg) Validation {
+	V.links = nil
+
+	status := V.validateResponse(ctx, in)
+	validation := Validation{Status: status, Links: V.links}
+	for _, link := range V.links {
+		if link.Status == status && status != DnssecSecure {
+			validation.Reason = fmt.Sprintf("%s %s: %s", link.Zone, link.Record, link.Detail)
+			break
+		}
+	}
+
+	return validation
+}
+
+// validateResponse validates the answer section, or the denial of existence
+// for negative answers.
+//
+// Args:
+//   - ctx: The context bounding the queries.
+//   - in: The response.
+//
+// Returns:
+//   - string: The validation status.
+func (V *Validator) validateResponse(ctx context.Context, in *dns.Msg) string {
+	if len(in.Question) == 0 {
+		V.link(".", "response", DnssecIndeterminate, "response has no question")
+		return DnssecIndeterminate
+	}
+	question := in.Question[0]
+
+	if in.Rcode != dns.RcodeSuccess && in.Rcode != dns.RcodeNameError {
+		V.link(question.Name, dns.TypeToString[question.Qtype], DnssecIndeterminate,
+			fmt.Sprintf("cannot validate a %s response", dns.RcodeToString[in.Rcode]))
+		return DnssecIndeterminate
+	}
+
+	keys, sets, sigs := groupRRsets(in.Answer)
+	if len(keys) == 0 {
+		return V.validateDenial(ctx, in)
+	}
+
+	status := DnssecSecure
+	for _, key := range keys {
+		rrsetStatus := V.validateRRset(ctx, key, sets[key], sigs[key])
+		if rrsetStatus == DnssecSecure {
+			if labels, expanded := wildcardExpansion(key.name, sigs[key]); expanded {
+				rrsetStatus = V.validateWildcard(ctx, in, key, labels)
+			}
+		}
+		status = worstStatus(status, rrsetStatus)
+	}
+
+	return status
+}
+
+// validateRRset verifies the signatures of an RRset with the validated keys of its signer.
+//
+// Args:
+//   - ctx: The context bounding the queries.
+//   - key: The owner name and type of the RRset.
+//   - set: The records of the RRset.
+//   - sigs: The RRSIGs covering the RRset.
+//
+// Returns:
+//   - string: The validation status.
+func (V *Validator) validateRRset(ctx context.Context, key rrsetKey, set []dns.RR, sigs []*dns.RRSIG) string {
+	record := dns.TypeToString[key.rrtype]
+
+	if len(sigs) == 0 {
+		status := V.unsignedStatus(ctx, key.name)
+		if status == DnssecSecure {
+			V.link(key.name, record, DnssecBogus, "missing RRSIG in a signed zone")
+			return DnssecBogus
+		}
+
+		V.link(key.name, record, status, "unsigned, zone is "+status)
+		return status
+	}
+
+	signer := dns.CanonicalName(sigs[0].SignerName)
+	if !dns.IsSubDomain(signer, dns.CanonicalName(key.name)) {
+		V.link(key.name, record, DnssecBogus, fmt.Sprintf("signer %s is not an ancestor of the owner", signer))
+		return DnssecBogus
+	}
+
+	keys, status := V.zoneKeys(ctx, signer)
+	if status != DnssecSecure {
+		V.link(key.name, record, status, fmt.Sprintf("signer %s is %s", signer, status))
+		return status
+	}
+
+	tag, err := V.verifyRRset(set, sigs, keys)
+	if err != nil {
+		V.link(key.name, record, DnssecBogus, err.Error())
+		return DnssecBogus
+	}
+
+	V.link(key.name, record, DnssecSecure, fmt.Sprintf("signed by %s key tag %d", signer, tag))
+	return DnssecSecure
+}
+
+// validateDenial validates the NSEC or NSEC3 proof of a negative answer.
+//
+// Args:
+//   - ctx: The context bounding the queries.
+//   - in: The NXDOMAIN or NODATA response.
+//
+// Returns:
+//   - string: The validation status.
+func (V *Validator) validateDenial(ctx context.Context, in *dns.Msg) string {
+	question := in.Question[0]
+	record := dns.TypeToString[question.Qtype]
+
+	proofs, signer, status := V.denialProofs(ctx, in, true)
+	if len(proofs) == 0 {
+		zoneStatus := V.unsignedStatus(ctx, question.Name)
+		if zoneStatus == DnssecSecure {
+			V.link(question.Name, record, DnssecBogus, "negative answer without NSEC or NSEC3 proof in a signed zone")
+			return DnssecBogus
+		}
+
+		V.link(question.Name, record, zoneStatus, "negative answer, zone is "+zoneStatus)
+		return zoneStatus
+	}
+	if status != DnssecSecure {
+		return status
+	}
+
+	switch denialStatus(proofs, signer, question.Name, question.Qtype, in.Rcode == dns.RcodeNameError) {
+	case DnssecInsecure:
+		V.link(question.Name, record, DnssecInsecure, "denial of existence relies on an opt-out NSEC3")
+		return DnssecInsecure
+	case DnssecBogus:
+		V.link(question.Name, record, DnssecBogus, "NSEC/NSEC3 records do not prove the denial of existence")
+		return DnssecBogus
+	}
+
+	denial := StatusNoData
+	if in.Rcode == dns.RcodeNameError {
+		denial = dns.RcodeToString[in.Rcode]
+	}
+	V.link(question.Name, record, DnssecSecure, denial+" proven by NSEC/NSEC3")
+	return DnssecSecure
+}
+
+// validateWildcard checks the proof that comes with an answer expanded from
+// a wildcard: an NSEC covering the name (RFC 4035 section 5.3.4) or an NSEC3
+// covering the next closer name (RFC 5155 section 8.8), so no closer match
+// was hidden behind the wildcard.
+//
+// Args:
+//   - ctx: The context bounding the queries.
+//   - in: The response.
+//   - key: The owner name and type of the expanded RRset.
+//   - labels: The label count of the wildcard owner, from its RRSIG.
+//
+// Returns:
+//   - string: The validation status.
+func (V *Validator) validateWildcard(ctx context.Context, in *dns.Msg, key rrsetKey, labels int) string {
+	record := dns.TypeToString[key.rrtype]
+
+	proofs, signer, status := V.denialProofs(ctx, in, false)
+	if status != DnssecSecure {
+		return status
+	}
+	if len(proofs) == 0 || !wildcardProof(proofs, signer, key.name, labels) {
+		V.link(key.name, record, DnssecBogus, "wildcard expansion without a proof that no closer name exists")
+		return DnssecBogus
+	}
+
+	V.link(key.name, record, DnssecSecure, "wildcard expansion proven by NSEC/NSEC3")
+	return DnssecSecure
+}
+
+// denialProofs validates the NSEC and NSEC3 RRsets of the authority section.
+//
+// Args:
+//   - ctx: The context bounding the queries.
+//   - in: The response.
+//   - soa: Whether the SOA RRset is validated along with them.
+//
+// Returns:
+//   - []dns.RR: The NSEC and NSEC3 records.
+//   - string: The signer of the proofs.
+//   - string: The worst validation status of the RRsets.
+func (V *Validator) denialProofs(ctx context.Context, in *dns.Msg, soa bool) ([]dns.RR, string, string) {
+	keys, sets, sigs := groupRRsets(in.Ns)
+	var (
+		proofs []dns.RR
+		signer string
+	)
+	status := DnssecSecure
+	for _, key := range keys {
+		if key.rrtype != dns.TypeNSEC && key.rrtype != dns.TypeNSEC3 && (key.rrtype != dns.TypeSOA || !soa) {
+			continue
+		}
+		status = worstStatus(status, V.validateRRset(ctx, key, sets[key], sigs[key]))
+		if key.rrtype != dns.TypeSOA {
+			proofs = append(proofs, sets[key]...)
+			if len(sigs[key]) > 0 {
+				signer = sigs[key][0].SignerName
+			}
+		}
+	}
+
+	return proofs, signer, status
+}
+
+// zoneKeys returns the validated DNSKEYs of a zone, walking up the chain of
+// trust when the zone is not a trust anchor.
+//
+// Args:
+//   - ctx: The context bounding the queries.
+//   - zone: The zone.
+//
+// Returns:
+//   - []*dns.DNSKEY: The validated keys, nil unless the zone is secure.
+//   - string: The validation status of the zone.
+func (V *Validator) zoneKeys(ctx context.Context, zone string) ([]*dns.DNSKEY, string) {
+	zone = dns.CanonicalName(zone)
+	if state, ok := V.zones[zone]; ok {
+		return state.keys, state.status
+	}
+
+	// Mark the zone before walking up so a broken chain cannot loop.
+	V.zones[zone] = zoneState{status: DnssecIndeterminate}
+	keys, status := V.fetchZoneKeys(ctx, zone)
+	V.zones[zone] = zoneState{keys: keys, status: status}
+
+	return keys, status
+}
+
+// fetchZoneKeys fetches the DNSKEY RRset of a zone and validates it against
+// the zone's trust anchor or its DS records.
+//
+// Args:
+//   - ctx: The context bounding the queries.
+//   - zone: The canonical zone name.
+//
+// Returns:
+//   - []*dns.DNSKEY: The validated keys, nil unless the zone is secure.
+//   - string: The validation status of the zone.
+func (V *Validator) fetchZoneKeys(ctx context.Context, zone string) ([]*dns.DNSKEY, string) {
+	anchors, anchored := V.anchors[zone]
+
+	var dsSet []*dns.DS
+	if !anchored {
+		var status string
+		dsSet, status = V.delegationSigner(ctx, zone)
+		if status != DnssecSecure {
+			return nil, status
+		}
+	}
+
+	in, err := V.query(ctx, zone, dns.TypeDNSKEY)
+	if err != nil {
+		V.link(zone, "DNSKEY", DnssecIndeterminate, err.Error())
+		return nil, DnssecIndeterminate
+	}
+
+	var (
+		keys   []*dns.DNSKEY
+		keyRRs []dns.RR
+		sigs   []*dns.RRSIG
+	)
+	for _, rr := range in.Answer {
+		switch record := rr.(type) {
+		case *dns.DNSKEY:
+			if dns.CanonicalName(record.Hdr.Name) == zone {
+				keys = append(keys, record)
+				keyRRs = append(keyRRs, record)
+			}
+		case *dns.RRSIG:
+			if record.TypeCovered == dns.TypeDNSKEY {
+				sigs = append(sigs, record)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		V.link(zone, "DNSKEY", DnssecBogus, "missing DNSKEY records")
+		return nil, DnssecBogus
+	}
+
+	trusted := trustedKeys(keys, dsSet, anchors)
+	if len(trusted) == 0 {
+		detail := "DS mismatch: no DNSKEY matches the parent DS records"
+		if anchored {
+			detail = "no DNSKEY matches the trust anchor"
+		}
+		V.link(zone, "DNSKEY", DnssecBogus, detail)
+		return nil, DnssecBogus
+	}
+
+	tag, err := V.verifyRRset(keyRRs, sigs, trusted)
+	if err != nil {
+		V.link(zone, "DNSKEY", DnssecBogus, err.Error())
+		return nil, DnssecBogus
+	}
+
+	source := "DS"
+	if anchored {
+		source = "trust anchor"
+	}
+	V.link(zone, "DNSKEY", DnssecSecure, fmt.Sprintf("self-signed by key tag %d matching the %s", tag, source))
+
+	return keys, DnssecSecure
+}
+
+// delegationSigner fetches and validates the DS records of a zone from its
+// parent, or proves that the zone has none.
+//
+// Args:
+//   - ctx: The context bounding the queries.
+//   - zone: The canonical zone name.
+//
+// Returns:
+//   - []*dns.DS: The validated DS records.
+//   - string: The validation status of the delegation.
+func (V *Validator) delegationSigner(ctx context.Context, zone string) ([]*dns.DS, string) {
+	if zone == "." {
+		V.link(zone, "DS", DnssecIndeterminate, "no trust anchor for the root zone")
+		return nil, DnssecIndeterminate
+	}
+
+	in, err := V.query(ctx, zone, dns.TypeDS)
+	if err != nil {
+		V.link(zone, "DS", DnssecIndeterminate, err.Error())
+		return nil, DnssecIndeterminate
+	}
+
+	var (
+		dsSet  []*dns.DS
+		dsRRs  []dns.RR
+		dsSigs []*dns.RRSIG
+	)
+	for _, rr := range in.Answer {
+		switch record := rr.(type) {
+		case *dns.DS:
+			if dns.CanonicalName(record.Hdr.Name) == zone {
+				dsSet = append(dsSet, record)
+				dsRRs = append(dsRRs, record)
+			}
+		case *dns.RRSIG:
+			if record.TypeCovered == dns.TypeDS {
+				dsSigs = append(dsSigs, record)
+			}
+		}
+	}
+
+	if len(dsSet) > 0 {
+		status := V.validateRRset(ctx, rrsetKey{name: zone, rrtype: dns.TypeDS}, dsRRs, dsSigs)
+		if status != DnssecSecure {
+			return nil, status
+		}
+		return dsSet, DnssecSecure
+	}
+
+	if in.Rcode != dns.RcodeSuccess && in.Rcode != dns.RcodeNameError {
+		V.link(zone, "DS", DnssecIndeterminate, "parent answered "+dns.RcodeToString[in.Rcode])
+		return nil, DnssecIndeterminate
+	}
+
+	keys, sets, sigs := groupRRsets(in.Ns)
+	var (
+		proofs []dns.RR
+		signer string
+	)
+	for _, key := range keys {
+		if key.rrtype != dns.TypeNSEC && key.rrtype != dns.TypeNSEC3 {
+			continue
+		}
+		if status := V.validateRRset(ctx, key, sets[key], sigs[key]); status != DnssecSecure {
+			return nil, status
+		}
+		proofs = append(proofs, sets[key]...)
+		signer = sigs[key][0].SignerName
+	}
+
+	if len(proofs) == 0 {
+		status := V.unsignedStatus(ctx, parentName(zone))
+		if status == DnssecSecure {
+			V.link(zone, "DS", DnssecBogus, "missing DS and no proof of its absence from a signed parent")
+			return nil, DnssecBogus
+		}
+
+		V.link(zone, "DS", status, "no DS, parent zone is "+status)
+		return nil, status
+	}
+
+	// Opt-out leaves the delegation unsigned either way.
+	if denialStatus(proofs, signer, zone, dns.TypeDS, false) == DnssecBogus {
+		V.link(zone, "DS", DnssecBogus, "missing DS not proven by NSEC/NSEC3")
+		return nil, DnssecBogus
+	}
+
+	V.link(zone, "DS", DnssecInsecure, "parent proves there is no DS, delegation is unsigned")
+	return nil, DnssecInsecure
+}
+
+// unsignedStatus finds the zone a name belongs to and returns its status,
+// which tells whether unsigned data from it is expected.
+//
+// Args:
+//   - ctx: The context bounding the queries.
+//   - name: The name.
+//
+// Returns:
+//   - string: The validation status of the enclosing zone.
+func (V *Validator) unsignedStatus(ctx context.Context, name string) string {
+	_, status := V.zoneKeys(ctx, V.enclosingZone(ctx, name))
+
+	return status
+}
+
+// enclosingZone finds the zone a name belongs to from the SOA record.
+//
+// Args:
+//   - ctx: The context bounding the queries.
+//   - name: The name.
+//
+// Returns:
+//   - string: The canonical name of the enclosing zone.
+func (V *Validator) enclosingZone(ctx context.Context, name string) string {
+	name = dns.CanonicalName(name)
+
+	for {
+		in, err := V.query(ctx, name, dns.TypeSOA)
+		if err == nil {
+			for _, rr := range append(in.Answer, in.Ns...) {
+				if soa, ok := rr.(*dns.SOA); ok && dns.IsSubDomain(dns.CanonicalName(soa.Hdr.Name), name) {
+					return dns.CanonicalName(soa.Hdr.Name)
+				}
+			}
+		}
+
+		if name == "." {
+			return name
+		}
+		name = parentName(name)
+	}
+}
+
+// query sends a DNSSEC query with the DO and CD bits set so signatures are
+// returned even when the upstream considers them bogus.
+//
+// Args:
+//   - ctx: The context bounding the queries.
+//   - name: The name to query.
+//   - qtype: The query type.
+//
+// Returns:
+//   - *dns.Msg: The response.
+//   - error: An error if no upstream answered.
+func (V *Validator) query(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	m.RecursionDesired = true
+	m.CheckingDisabled = true
+	m.SetEdns0(DnssecBufferSize, true)
+
+	response, err := V.resolver.Exchange(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Msg, nil
+}
+
+// verifyRRset checks that one of the signatures over an RRset verifies with
+// one of the keys and is inside its validity period.
+//
+// Args:
+//   - set: The records of the RRset.
+//   - sigs: The RRSIGs covering the RRset.
+//   - keys: The keys allowed to sign it.
+//
+// Returns:
+//   - uint16: The key tag of the key that verified the signature.
+//   - error: The most specific reason why no signature verified.
+func (V *Validator) verifyRRset(set []dns.RR, sigs []*dns.RRSIG, keys []*dns.DNSKEY) (uint16, error) {
+	if len(sigs) == 0 {
+		return 0, fmt.Errorf("missing RRSIG")
+	}
+
+	now := V.now()
+	var lastErr error
+	for _, sig := range sigs {
+		matched := false
+		for _, key := range keys {
+			if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+				continue
+			}
+			matched = true
+
+			if err := sig.Verify(key, set); err != nil {
+				lastErr = fmt.Errorf("signature by key tag %d failed to verify: %w", sig.KeyTag, err)
+				continue
+			}
+			if !sig.ValidityPeriod(now) {
+				if now.Before(time.Unix(int64(sig.Inception), 0)) {
+					lastErr = fmt.Errorf("signature by key tag %d is not valid before %s",
+						sig.KeyTag, time.Unix(int64(sig.Inception), 0).UTC().Format(time.RFC3339))
+				} else {
+					lastErr = fmt.Errorf("expired signature by key tag %d, expired %s",
+						sig.KeyTag, time.Unix(int64(sig.Expiration), 0).UTC().Format(time.RFC3339))
+				}
+				continue
+			}
+
+			return sig.KeyTag, nil
+		}
+
+		if !matched && lastErr == nil {
+			lastErr = fmt.Errorf("missing key: no DNSKEY with key tag %d and algorithm %s for %s",
+				sig.KeyTag, dns.AlgorithmToString[sig.Algorithm], sig.SignerName)
+		}
+	}
+
+	return 0, lastErr
+}
+
+// link records a checked link of the chain of trust.
+//
+// Args:
+//   - zone: The zone or owner name of the link.
+//   - record: The record type of the link.
+//   - status: The validation status.
+//   - detail: What was checked or why it failed.
+//
+// Returns:
+//   - None
+func (V *Validator) link(zone, record, status, detail string) {
+	V.links = append(V.links, ValidationLink{Zone: zone, Record: record, Status: status, Detail: detail})
+}
+
+// trustedKeys returns the keys that match a DS record or a trust anchor.
+//
+// Args:
+//   - keys: The DNSKEYs of the zone.
+//   - dsSet: The validated DS records of the zone.
+//   - anchors: The trust anchors of the zone.
+//
+// Returns:
+//   - []*dns.DNSKEY: The keys allowed to sign the DNSKEY RRset.
+func trustedKeys(keys []*dns.DNSKEY, dsSet []*dns.DS, anchors []dns.RR) []*dns.DNSKEY {
+	for _, anchor := range anchors {
+		switch record := anchor.(type) {
+		case *dns.DS:
+			dsSet = append(dsSet, record)
+		case *dns.DNSKEY:
+			for _, key := range keys {
+				if key.Algorithm == record.Algorithm && key.PublicKey == record.PublicKey {
+					return []*dns.DNSKEY{key}
+				}
+			}
+		}
+	}
+
+	var trusted []*dns.DNSKEY
+	for _, key := range keys {
+		for _, ds := range dsSet {
+			if key.KeyTag() != ds.KeyTag || key.Algorithm != ds.Algorithm {
+				continue
+			}
+			if digest := key.ToDS(ds.DigestType); digest != nil && strings.EqualFold(digest.Digest, ds.Digest) {
+				trusted = append(trusted, key)
+			}
+		}
+	}
+
+	return trusted
+}
+
+// groupRRsets groups records into RRsets and collects the RRSIGs covering each.
+//
+// Args:
+//   - rrs: The records of a section.
+//
+// Returns:
+//   - []rrsetKey: The RRsets in the order they first appear.
+//   - map[rrsetKey][]dns.RR: The records of each RRset.
+//   - map[rrsetKey][]*dns.RRSIG: The signatures of each RRset.
+func groupRRsets(rrs []dns.RR) ([]rrsetKey, map[rrsetKey][]dns.RR, map[rrsetKey][]*dns.RRSIG) {
+	var keys []rrsetKey
+	sets := map[rrsetKey][]dns.RR{}
+	sigs := map[rrsetKey][]*dns.RRSIG{}
+
+	for _, rr := range rrs {
+		header := rr.Header()
+		if header.Rrtype == dns.TypeOPT {
+			continue
+		}
+
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			key := rrsetKey{name: dns.CanonicalName(header.Name), rrtype: sig.TypeCovered}
+			sigs[key] = append(sigs[key], sig)
+			continue
+		}
+
+		key := rrsetKey{name: dns.CanonicalName(header.Name), rrtype: header.Rrtype}
+		if _, ok := sets[key]; !ok {
+			keys = append(keys, key)
+		}
+		sets[key] = append(sets[key], rr)
+	}
+
+	return keys, sets, sigs
+}
+
+// denialStatus checks that NSEC or NSEC3 records prove that a name does not
+// exist and that no wildcard could have answered in its place (RFC 4035
+// section 5.4, RFC 5155 section 8.4), or that it exists without the queried
+// type (RFC 5155 sections 8.5 to 8.7).
+//
+// Args:
+//   - proofs: The NSEC or NSEC3 records, validated.
+//   - zone: The zone that signed the proofs.
+//   - name: The queried name.
+//   - qtype: The queried type.
+//   - nxdomain: Whether the name itself must not exist.
+//
+// Returns:
+//   - string: Secure when the denial is proven, insecure when it relies on an
+//     opt-out NSEC3, bogus otherwise.
+func denialStatus(proofs []dns.RR, zone, name string, qtype uint16, nxdomain bool) string {
+	zone, name = dns.CanonicalName(zone), dns.CanonicalName(name)
+	if !dns.IsSubDomain(zone, name) {
+		return DnssecBogus
+	}
+
+	var (
+		nsecs  []*dns.NSEC
+		nsec3s []*dns.NSEC3
+	)
+	for _, rr := range proofs {
+		switch record := rr.(type) {
+		case *dns.NSEC:
+			nsecs = append(nsecs, record)
+		case *dns.NSEC3:
+			nsec3s = append(nsec3s, record)
+		}
+	}
+
+	if len(nsec3s) > 0 {
+		return nsec3Denial(nsec3s, zone, name, qtype, nxdomain)
+	}
+	if nsecDenial(nsecs, zone, name, qtype, nxdomain) {
+		return DnssecSecure
+	}
+
+	return DnssecBogus
+}
+
+// wildcardExpansion reports whether an RRset was synthesized from a
+// wildcard: its RRSIG counts fewer labels than the owner name, not counting
+// the asterisk of a wildcard owner asked for directly.
+//
+// Args:
+//   - name: The owner name of the RRset.
+//   - sigs: The RRSIGs covering the RRset.
+//
+// Returns:
+//   - int: The label count of the wildcard owner.
+//   - bool: Whether the RRset is a wildcard expansion.
+func wildcardExpansion(name string, sigs []*dns.RRSIG) (int, bool) {
+	owner := dns.CountLabel(name)
+	if strings.HasPrefix(name, "*.") {
+		owner--
+	}
+	for _, sig := range sigs {
+		if int(sig.Labels) < owner {
+			return int(sig.Labels), true
+		}
+	}
+
+	return 0, false
+}
+
+// wildcardProof checks that NSEC or NSEC3 records prove that no name closer
+// than the source of a wildcard expansion exists: an NSEC covering the name,
+// or an NSEC3 covering the next closer name, the ancestor one label below
+// the wildcard.
+//
+// Args:
+//   - proofs: The NSEC or NSEC3 records, validated.
+//   - zone: The zone that signed the proofs.
+//   - name: The owner name of the expanded RRset.
+//   - labels: The label count of the wildcard owner.
+//
+// Returns:
+//   - bool: Whether the expansion is proven.
+func wildcardProof(proofs []dns.RR, zone, name string, labels int) bool {
+	zone, name = dns.CanonicalName(zone), dns.CanonicalName(name)
+	names := dns.SplitDomainName(name)
+	if !dns.IsSubDomain(zone, name) || labels >= len(names) {
+		return false
+	}
+	nextCloser := dns.Fqdn(strings.Join(names[len(names)-labels-1:], "."))
+
+	var nsec3s []*dns.NSEC3
+	for _, rr := range proofs {
+		switch record := rr.(type) {
+		case *dns.NSEC:
+			if nsecCovers(dns.CanonicalName(record.Hdr.Name), dns.CanonicalName(record.NextDomain), name) {
+				return true
+			}
+		case *dns.NSEC3:
+			nsec3s = append(nsec3s, record)
+		}
+	}
+
+	return nsec3Covering(nsec3s, nextCloser) != nil
+}
+
+// nsecDenial checks an NSEC denial of existence. NXDOMAIN needs an NSEC
+// covering the name and one covering the wildcard at its closest encloser;
+// NODATA needs an NSEC at the name, or at the wildcard for a name that does
+// not exist, without the type, or one proving the name is an empty
+// non-terminal.
+//
+// Args:
+//   - records: The NSEC records.
+//   - zone: The zone that signed them.
+//   - name: The queried name.
+//   - qtype: The queried type.
+//   - nxdomain: Whether the name itself must not exist.
+//
+// Returns:
+//   - bool: Whether the denial is proven.
+func nsecDenial(records []*dns.NSEC, zone, name string, qtype uint16, nxdomain bool) bool {
+	for _, record := range records {
+		if dns.CanonicalName(record.Hdr.Name) == name {
+			return !nxdomain && deniesType(record.TypeBitMap, qtype)
+		}
+	}
+
+	var cover *dns.NSEC
+	for _, record := range records {
+		if nsecCovers(dns.CanonicalName(record.Hdr.Name), dns.CanonicalName(record.NextDomain), name) {
+			cover = record
+			break
+		}
+	}
+	if cover == nil {
+		return false
+	}
+
+	owner, next := dns.CanonicalName(cover.Hdr.Name), dns.CanonicalName(cover.NextDomain)
+	// An NSEC at a delegation above the name comes from the parent side of a
+	// zone cut and says nothing about names in the child zone.
+	if owner != zone && dns.IsSubDomain(owner, name) && delegation(cover.TypeBitMap) {
+		return false
+	}
+
+	// A next name below the queried one makes it an empty non-terminal.
+	if next != name && dns.IsSubDomain(name, next) {
+		return !nxdomain
+	}
+
+	encloser := commonAncestor(name, owner)
+	if other := commonAncestor(name, next); dns.CountLabel(other) > dns.CountLabel(encloser) {
+		encloser = other
+	}
+	if !dns.IsSubDomain(zone, encloser) {
+		return false
+	}
+
+	wildcard := "*." + encloser
+	if encloser == "." {
+		wildcard = "*."
+	}
+	for _, record := range records {
+		owner, next := dns.CanonicalName(record.Hdr.Name), dns.CanonicalName(record.NextDomain)
+		if nxdomain && nsecCovers(owner, next, wildcard) {
+			return true
+		}
+		if !nxdomain && owner == wildcard {
+			return deniesType(record.TypeBitMap, qtype)
+		}
+	}
+
+	return false
+}
+
+// nsec3Denial checks an NSEC3 denial of existence: a matching NSEC3 without
+// the type for NODATA (RFC 5155 sections 8.5 and 8.6), otherwise the closest
+// encloser proof with the wildcard covered for NXDOMAIN (section 8.4) or
+// matched without the type for wildcard NODATA (section 8.7).
+//
+// Args:
+//   - records: The NSEC3 records.
+//   - zone: The zone that signed them.
+//   - name: The queried name.
+//   - qtype: The queried type.
+//   - nxdomain: Whether the name itself must not exist.
+//
+// Returns:
+//   - string: Secure when the denial is proven, insecure when the next closer
+//     name is covered by an opt-out NSEC3, bogus otherwise.
+func nsec3Denial(records []*dns.NSEC3, zone, name string, qtype uint16, nxdomain bool) string {
+	if match := nsec3Matching(records, name); match != nil {
+		if !nxdomain && deniesType(match.TypeBitMap, qtype) {
+			return DnssecSecure
+		}
+		return DnssecBogus
+	}
+
+	encloser, cover, ok := closestEncloser(records, zone, name)
+	if !ok {
+		return DnssecBogus
+	}
+	optOut := cover.Flags&1 == 1
+
+	wildcard := "*." + encloser
+	if encloser == "." {
+		wildcard = "*."
+	}
+	if nxdomain {
+		if nsec3Covering(records, wildcard) == nil {
+			return DnssecBogus
+		}
+		if optOut {
+			return DnssecInsecure
+		}
+		return DnssecSecure
+	}
+
+	if match := nsec3Matching(records, wildcard); match != nil && deniesType(match.TypeBitMap, qtype) {
+		return DnssecSecure
+	}
+	// An opt-out NSEC3 over the next closer name of a DS question means an
+	// unsigned delegation may sit there (RFC 5155 section 8.6).
+	if qtype == dns.TypeDS && optOut {
+		return DnssecInsecure
+	}
+
+	return DnssecBogus
+}
+
+// closestEncloser finds the closest encloser of a name that does not exist
+// (RFC 5155 section 8.3): the longest ancestor matched by an NSEC3, with the
+// name one label longer covered by another NSEC3.
+//
+// Args:
+//   - records: The NSEC3 records.
+//   - zone: The zone that signed them.
+//   - name: The queried name.
+//
+// Returns:
+//   - string: The closest encloser.
+//   - *dns.NSEC3: The NSEC3 covering the next closer name.
+//   - bool: Whether the proof is complete.
+func closestEncloser(records []*dns.NSEC3, zone, name string) (string, *dns.NSEC3, bool) {
+	next := name
+	for candidate := parentName(name); dns.IsSubDomain(zone, candidate); candidate = parentName(candidate) {
+		if match := nsec3Matching(records, candidate); match != nil {
+			// The closest encloser cannot be below a zone cut or a DNAME.
+			if slices.Contains(match.TypeBitMap, dns.TypeDNAME) || (candidate != zone && delegation(match.TypeBitMap)) {
+				return "", nil, false
+			}
+			cover := nsec3Covering(records, next)
+			return candidate, cover, cover != nil
+		}
+		if candidate == "." {
+			break
+		}
+		next = candidate
+	}
+
+	return "", nil, false
+}
+
+// nsec3Matching finds the NSEC3 whose hash is the hash of a name.
+//
+// Args:
+//   - records: The NSEC3 records.
+//   - name: The name.
+//
+// Returns:
+//   - *dns.NSEC3: The matching record, nil if there is none.
+func nsec3Matching(records []*dns.NSEC3, name string) *dns.NSEC3 {
+	for _, record := range records {
+		if record.Match(name) {
+			return record
+		}
+	}
+
+	return nil
+}
+
+// nsec3Covering finds the NSEC3 whose hash range covers the hash of a name.
+//
+// Args:
+//   - records: The NSEC3 records.
+//   - name: The name.
+//
+// Returns:
+//   - *dns.NSEC3: The covering record, nil if there is none.
+func nsec3Covering(records []*dns.NSEC3, name string) *dns.NSEC3 {
+	for _, record := range records {
+		if record.Cover(name) {
+			return record
+		}
+	}
+
+	return nil
+}
+
+// deniesType reports whether a type bitmap proves a name has no records of a
+// type: neither the type nor a CNAME is present, and a delegation point only
+// answers for its DS records while a zone apex never does.
+//
+// Args:
+//   - bitmap: The types present at the name.
+//   - qtype: The queried type.
+//
+// Returns:
+//   - bool: Whether the type is proven absent.
+func deniesType(bitmap []uint16, qtype uint16) bool {
+	if slices.Contains(bitmap, qtype) || slices.Contains(bitmap, dns.TypeCNAME) {
+		return false
+	}
+	if qtype == dns.TypeDS {
+		return !slices.Contains(bitmap, dns.TypeSOA)
+	}
+
+	return !delegation(bitmap)
+}
+
+// delegation reports whether a type bitmap is the one of a zone cut seen from
+// the parent: NS records without an SOA.
+//
+// Args:
+//   - bitmap: The types present at the name.
+//
+// Returns:
+//   - bool: Whether the name is a delegation point.
+func delegation(bitmap []uint16) bool {
+	return slices.Contains(bitmap, dns.TypeNS) && !slices.Contains(bitmap, dns.TypeSOA)
+}
+
+// commonAncestor returns the longest name both names are equal to or below.
+//
+// Args:
+//   - a: A name.
+//   - b: Another name.
+//
+// Returns:
+//   - string: The common ancestor, "." when they only share the root.
+func commonAncestor(a, b string) string {
+	labels := dns.SplitDomainName(a)
+	shared := dns.CompareDomainName(a, b)
+	if shared == 0 {
+		return "."
+	}
+
+	return dns.Fqdn(strings.Join(labels[len(labels)-shared:], "."))
+}
+
+// nsecCovers checks whether a name falls between an NSEC owner and its next
+// name in canonical order, including the wrap at the end of the zone.
+//
+// Args:
+//   - owner: The NSEC owner name.
+//   - next: The NSEC next domain name.
+//   - name: The name to check.
+//
+// Returns:
+//   - bool: Whether the NSEC record covers the name.
+func nsecCovers(owner, next, name string) bool {
+	if canonicalCompare(owner, next) < 0 {
+		return canonicalCompare(owner, name) < 0 && canonicalCompare(name, next) < 0
+	}
+
+	return canonicalCompare(owner, name) < 0 || canonicalCompare(name, next) < 0
+}
+
+// canonicalCompare orders two names by the DNSSEC canonical order (RFC 4034
+// section 6.1), comparing labels from the right.
+//
+// Args:
+//   - a: The first name.
+//   - b: The second name.
+//
+// Returns:
+//   - int: -1, 0 or 1 when a sorts before, equal to or after b.
+func canonicalCompare(a, b string) int {
+	labelsA := dns.SplitDomainName(strings.ToLower(a))
+	labelsB := dns.SplitDomainName(strings.ToLower(b))
+
+	for i, j := len(labelsA)-1, len(labelsB)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if result := strings.Compare(labelsA[i], labelsB[j]); result != 0 {
+			return result
+		}
+	}
+
+	switch {
+	case len(labelsA) < len(labelsB):
+		return -1
+	case len(labelsA) > len(labelsB):
+		return 1
+	}
+
+	return 0
+}
+
+// parentName strips the first label of a name.
+//
+// Args:
+//   - name: The name.
+//
+// Returns:
+//   - string: The parent name, "." for top-level names.
+func parentName(name string) string {
+	labels := dns.SplitDomainName(name)
+	if len(labels) <= 1 {
+		return "."
+	}
+
+	return dns.Fqdn(strings.Join(labels[1:], "."))
+}
+
+// worstStatus combines two validation statuses, bogus being the worst.
+//
+// Args:
+//   - a: The first status.
+//   - b: The second status.
+//
+// Returns:
+//   - string: The worse of the two statuses.
+func worstStatus(a, b string) string {
+	rank := map[string]int{DnssecSecure: 0, DnssecInsecure: 1, DnssecIndeterminate: 2, DnssecBogus: 3}
+	if rank[b] > rank[a] {
+		return b
+	}
+
+	return a
+}
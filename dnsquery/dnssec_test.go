@@ -0,0 +1,403 @@
+package dnsquery
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const testZone = "example.test."
+
+// testNames are the names of the signed test zone with their types: a host,
+// a wildcard, an unsigned delegation and an empty non-terminal above x.y.
+var testNames = map[string][]uint16{
+	testZone:          {dns.TypeSOA, dns.TypeNS, dns.TypeDNSKEY},
+	"a." + testZone:   {dns.TypeA},
+	"*.w." + testZone: {dns.TypeTXT},
+	"sub." + testZone: {dns.TypeNS},
+	"x.y." + testZone: {dns.TypeA},
+}
+
+// signedZone signs records with a freshly generated key.
+type signedZone struct {
+	key    *dns.DNSKEY
+	signer crypto.Signer
+}
+
+// fakeResolver answers from canned messages keyed by name and type.
+type fakeResolver struct {
+	answers map[string]*dns.Msg
+}
+
+// newSignedZone generates the key of the test zone.
+func newSignedZone(t *testing.T) *signedZone {
+	t.Helper()
+
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: testZone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	private, err := key.Generate(256)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	return &signedZone{key: key, signer: private.(crypto.Signer)}
+}
+
+// sign returns the records of an RRset followed by their RRSIG.
+func (Z *signedZone) sign(t *testing.T, set ...dns.RR) []dns.RR {
+	t.Helper()
+
+	owner := set[0].Header().Name
+	labels := uint8(dns.CountLabel(owner))
+	if strings.HasPrefix(owner, "*.") {
+		labels--
+	}
+	sig := &dns.RRSIG{
+		Hdr:         dns.RR_Header{Name: owner, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: set[0].Header().Ttl},
+		TypeCovered: set[0].Header().Rrtype,
+		Algorithm:   Z.key.Algorithm,
+		Labels:      labels,
+		OrigTtl:     set[0].Header().Ttl,
+		Inception:   uint32(time.Now().Add(-time.Hour).Unix()),
+		Expiration:  uint32(time.Now().Add(time.Hour).Unix()),
+		KeyTag:      Z.key.KeyTag(),
+		SignerName:  testZone,
+	}
+	if err := sig.Sign(Z.signer, set); err != nil {
+		t.Fatalf("sign %s: %v", owner, err)
+	}
+
+	return append(slices.Clone(set), sig)
+}
+
+// validator builds a validator trusting the zone key, which serves the
+// signed DNSKEY RRset.
+func (Z *signedZone) validator(t *testing.T) *Validator {
+	t.Helper()
+
+	keys := new(dns.Msg)
+	keys.SetQuestion(testZone, dns.TypeDNSKEY)
+	keys.Answer = Z.sign(t, Z.key)
+	resolver := &fakeResolver{answers: map[string]*dns.Msg{testZone + "/DNSKEY": keys}}
+
+	return NewValidator(resolver, []dns.RR{Z.key})
+}
+
+// negative builds a signed negative answer holding the SOA and the proofs.
+func (Z *signedZone) negative(t *testing.T, name string, qtype uint16, rcode int, proofs []dns.RR) *dns.Msg {
+	t.Helper()
+
+	soa := &dns.SOA{
+		Hdr:     dns.RR_Header{Name: testZone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 300},
+		Ns:      "ns." + testZone,
+		Mbox:    "hostmaster." + testZone,
+		Serial:  1,
+		Refresh: 3600, Retry: 600, Expire: 86400, Minttl: 300,
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.Rcode = rcode
+	m.Ns = Z.sign(t, soa)
+	for _, proof := range proofs {
+		m.Ns = append(m.Ns, Z.sign(t, proof)...)
+	}
+
+	return m
+}
+
+// expanded builds a signed answer synthesized from the wildcard of the test
+// zone for a name, with the proofs in the authority section.
+func (Z *signedZone) expanded(t *testing.T, name string, proofs []dns.RR) *dns.Msg {
+	t.Helper()
+
+	txt := &dns.TXT{
+		Hdr: dns.RR_Header{Name: "*.w." + testZone, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 300},
+		Txt: []string{"wild"},
+	}
+	answer := Z.sign(t, txt)
+	for _, rr := range answer {
+		rr.Header().Name = name
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeTXT)
+	m.Answer = answer
+	for _, proof := range proofs {
+		m.Ns = append(m.Ns, Z.sign(t, proof)...)
+	}
+
+	return m
+}
+
+// Lookup is not used by the validator.
+func (F *fakeResolver) Lookup(ctx context.Context, name, qtype string) (Response, error) {
+	return Response{}, errors.New("not implemented")
+}
+
+// Exchange answers a canned message, NXDOMAIN for anything else.
+func (F *fakeResolver) Exchange(ctx context.Context, m *dns.Msg) (Response, error) {
+	question := m.Question[0]
+	if answer, ok := F.answers[dns.CanonicalName(question.Name)+"/"+dns.TypeToString[question.Qtype]]; ok {
+		return Response{Msg: answer, Server: "fake"}, nil
+	}
+
+	reply := new(dns.Msg)
+	reply.SetRcode(m, dns.RcodeNameError)
+
+	return Response{Msg: reply, Server: "fake"}, nil
+}
+
+// nsecChain builds the NSEC chain of the test zone in canonical order.
+func nsecChain() []*dns.NSEC {
+	var names []string
+	for name := range testNames {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, canonicalCompare)
+
+	chain := make([]*dns.NSEC, 0, len(names))
+	for i, name := range names {
+		types := append(slices.Clone(testNames[name]), dns.TypeRRSIG, dns.TypeNSEC)
+		slices.Sort(types)
+		chain = append(chain, &dns.NSEC{
+			Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 300},
+			NextDomain: names[(i+1)%len(names)],
+			TypeBitMap: types,
+		})
+	}
+
+	return chain
+}
+
+// nsec3Chain builds the NSEC3 chain of the test zone, leaving out the
+// unsigned delegation and flagging every record when optOut is set.
+func nsec3Chain(optOut bool) []*dns.NSEC3 {
+	type hashed struct {
+		hash  string
+		types []uint16
+	}
+
+	var entries []hashed
+	for name, types := range testNames {
+		if optOut && name == "sub."+testZone {
+			continue
+		}
+		types = append(slices.Clone(types), dns.TypeRRSIG)
+		if name == testZone {
+			types = append(types, dns.TypeNSEC3PARAM)
+		}
+		slices.Sort(types)
+		entries = append(entries, hashed{hash: dns.HashName(name, dns.SHA1, 0, ""), types: types})
+	}
+	// The empty non-terminals have an NSEC3 without types.
+	for _, name := range []string{"w." + testZone, "y." + testZone} {
+		entries = append(entries, hashed{hash: dns.HashName(name, dns.SHA1, 0, "")})
+	}
+	slices.SortFunc(entries, func(a, b hashed) int { return strings.Compare(a.hash, b.hash) })
+
+	var flags uint8
+	if optOut {
+		flags = 1
+	}
+	chain := make([]*dns.NSEC3, 0, len(entries))
+	for i, entry := range entries {
+		chain = append(chain, &dns.NSEC3{
+			Hdr:        dns.RR_Header{Name: strings.ToLower(entry.hash) + "." + testZone, Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 300},
+			Hash:       dns.SHA1,
+			Flags:      flags,
+			HashLength: 20,
+			NextDomain: entries[(i+1)%len(entries)].hash,
+			TypeBitMap: entry.types,
+		})
+	}
+
+	return chain
+}
+
+// nsecCovering picks the NSEC records matching or covering each name.
+func nsecCovering(chain []*dns.NSEC, names ...string) []dns.RR {
+	var proofs []dns.RR
+	for _, name := range names {
+		for _, record := range chain {
+			owner, next := dns.CanonicalName(record.Hdr.Name), dns.CanonicalName(record.NextDomain)
+			if (owner == name || nsecCovers(owner, next, name)) && !slices.Contains(proofs, dns.RR(record)) {
+				proofs = append(proofs, record)
+			}
+		}
+	}
+
+	return proofs
+}
+
+// nsec3Proofs picks the NSEC3 records matching or covering each name.
+func nsec3Proofs(chain []*dns.NSEC3, names ...string) []dns.RR {
+	var proofs []dns.RR
+	for _, name := range names {
+		for _, record := range chain {
+			if (record.Match(name) || record.Cover(name)) && !slices.Contains(proofs, dns.RR(record)) {
+				proofs = append(proofs, record)
+			}
+		}
+	}
+
+	return proofs
+}
+
+// uncoveredTogether finds a missing name below the apex whose NSEC3 is not
+// the one covering the wildcard of the apex, so dropping the wildcard proof
+// really removes it.
+func uncoveredTogether(t *testing.T, chain []*dns.NSEC3) string {
+	t.Helper()
+
+	wildcard := nsec3Covering(chain, "*."+testZone)
+	for _, label := range []string{"b", "c", "d", "e", "f", "g", "h", "i", "j", "k"} {
+		name := label + "." + testZone
+		if cover := nsec3Covering(chain, name); cover != nil && cover != wildcard {
+			return name
+		}
+	}
+	t.Fatal("no name covered apart from the wildcard")
+
+	return ""
+}
+
+func TestValidateNSECDenial(t *testing.T) {
+	zone := newSignedZone(t)
+	chain := nsecChain()
+
+	tests := []struct {
+		name   string
+		qname  string
+		qtype  uint16
+		rcode  int
+		proofs []dns.RR
+		want   string
+	}{
+		{"nxdomain with wildcard proof", "b." + testZone, dns.TypeA, dns.RcodeNameError, nsecCovering(chain, "b."+testZone, "*."+testZone), DnssecSecure},
+		{"nxdomain without wildcard proof", "b." + testZone, dns.TypeA, dns.RcodeNameError, nsecCovering(chain, "b."+testZone), DnssecBogus},
+		{"nxdomain for an existing name", "a." + testZone, dns.TypeA, dns.RcodeNameError, nsecCovering(chain, "a."+testZone), DnssecBogus},
+		{"nodata", "a." + testZone, dns.TypeTXT, dns.RcodeSuccess, nsecCovering(chain, "a."+testZone), DnssecSecure},
+		{"nodata for a present type", "a." + testZone, dns.TypeA, dns.RcodeSuccess, nsecCovering(chain, "a."+testZone), DnssecBogus},
+		{"nodata from a delegation nsec", "sub." + testZone, dns.TypeA, dns.RcodeSuccess, nsecCovering(chain, "sub."+testZone), DnssecBogus},
+		{"nodata below a delegation", "host.sub." + testZone, dns.TypeA, dns.RcodeNameError, nsecCovering(chain, "host.sub."+testZone, "*.sub."+testZone), DnssecBogus},
+		{"no ds at a delegation", "sub." + testZone, dns.TypeDS, dns.RcodeSuccess, nsecCovering(chain, "sub."+testZone), DnssecSecure},
+		{"no ds at the apex", testZone, dns.TypeDS, dns.RcodeSuccess, nsecCovering(chain, testZone), DnssecBogus},
+		{"wildcard nodata", "q.w." + testZone, dns.TypeMX, dns.RcodeSuccess, nsecCovering(chain, "q.w."+testZone, "*.w."+testZone), DnssecSecure},
+		{"wildcard nodata for a present type", "q.w." + testZone, dns.TypeTXT, dns.RcodeSuccess, nsecCovering(chain, "q.w."+testZone, "*.w."+testZone), DnssecBogus},
+		{"empty non-terminal nodata", "y." + testZone, dns.TypeA, dns.RcodeSuccess, nsecCovering(chain, "y."+testZone), DnssecSecure},
+		{"nxdomain for an empty non-terminal", "y." + testZone, dns.TypeA, dns.RcodeNameError, nsecCovering(chain, "y."+testZone, "*."+testZone), DnssecBogus},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			in := zone.negative(t, test.qname, test.qtype, test.rcode, test.proofs)
+			validation := zone.validator(t).Validate(context.Background(), in)
+			if validation.Status != test.want {
+				t.Errorf("status = %s, want %s (%s)", validation.Status, test.want, validation.Reason)
+			}
+		})
+	}
+}
+
+func TestValidateNSEC3Denial(t *testing.T) {
+	zone := newSignedZone(t)
+	chain := nsec3Chain(false)
+	optOut := nsec3Chain(true)
+	missing := uncoveredTogether(t, chain)
+
+	tests := []struct {
+		name   string
+		qname  string
+		qtype  uint16
+		rcode  int
+		proofs []dns.RR
+		want   string
+	}{
+		{"nxdomain with closest encloser proof", missing, dns.TypeA, dns.RcodeNameError, nsec3Proofs(chain, testZone, missing, "*."+testZone), DnssecSecure},
+		{"nxdomain without wildcard proof", missing, dns.TypeA, dns.RcodeNameError, nsec3Proofs(chain, testZone, missing), DnssecBogus},
+		{"nxdomain without closest encloser", missing, dns.TypeA, dns.RcodeNameError, nsec3Proofs(chain, missing, "*."+testZone), DnssecBogus},
+		{"nxdomain under opt-out", missing, dns.TypeA, dns.RcodeNameError, nsec3Proofs(optOut, testZone, missing, "*."+testZone), DnssecInsecure},
+		{"nodata", "a." + testZone, dns.TypeTXT, dns.RcodeSuccess, nsec3Proofs(chain, "a."+testZone), DnssecSecure},
+		{"nodata for a present type", "a." + testZone, dns.TypeA, dns.RcodeSuccess, nsec3Proofs(chain, "a."+testZone), DnssecBogus},
+		{"nodata from a delegation nsec3", "sub." + testZone, dns.TypeA, dns.RcodeSuccess, nsec3Proofs(chain, "sub."+testZone), DnssecBogus},
+		{"no ds at a delegation", "sub." + testZone, dns.TypeDS, dns.RcodeSuccess, nsec3Proofs(chain, "sub."+testZone), DnssecSecure},
+		{"no ds under opt-out", "sub." + testZone, dns.TypeDS, dns.RcodeSuccess, nsec3Proofs(optOut, testZone, "sub."+testZone), DnssecInsecure},
+		{"wildcard nodata", "q.w." + testZone, dns.TypeMX, dns.RcodeSuccess, nsec3Proofs(chain, "w."+testZone, "q.w."+testZone, "*.w."+testZone), DnssecSecure},
+		{"wildcard nodata for a present type", "q.w." + testZone, dns.TypeTXT, dns.RcodeSuccess, nsec3Proofs(chain, "w."+testZone, "q.w."+testZone, "*.w."+testZone), DnssecBogus},
+		{"empty non-terminal nodata", "y." + testZone, dns.TypeA, dns.RcodeSuccess, nsec3Proofs(chain, "y."+testZone), DnssecSecure},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			in := zone.negative(t, test.qname, test.qtype, test.rcode, test.proofs)
+			validation := zone.validator(t).Validate(context.Background(), in)
+			if validation.Status != test.want {
+				t.Errorf("status = %s, want %s (%s)", validation.Status, test.want, validation.Reason)
+			}
+		})
+	}
+}
+
+func TestValidateUntrustedKey(t *testing.T) {
+	zone := newSignedZone(t)
+	other := newSignedZone(t)
+
+	in := zone.negative(t, "a."+testZone, dns.TypeTXT, dns.RcodeSuccess, nsecCovering(nsecChain(), "a."+testZone))
+	validator := zone.validator(t)
+	validator.anchors = map[string][]dns.RR{testZone: {other.key}}
+
+	if validation := validator.Validate(context.Background(), in); validation.Status != DnssecBogus {
+		t.Errorf("status = %s, want %s", validation.Status, DnssecBogus)
+	}
+}
+
+func TestValidateWildcardExpansion(t *testing.T) {
+	zone := newSignedZone(t)
+	nsecs := nsecChain()
+	nsec3s := nsec3Chain(false)
+	qname := "q.w." + testZone
+
+	tests := []struct {
+		name   string
+		proofs []dns.RR
+		want   string
+	}{
+		{"nsec covering the name", nsecCovering(nsecs, qname), DnssecSecure},
+		{"nsec3 covering the next closer name", nsec3Proofs(nsec3s, qname), DnssecSecure},
+		{"no proof", nil, DnssecBogus},
+		{"nsec not covering the name", nsecCovering(nsecs, "a."+testZone), DnssecBogus},
+		{"nsec3 matching the wildcard only", nsec3Proofs(nsec3s, "*.w."+testZone), DnssecBogus},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			in := zone.expanded(t, qname, test.proofs)
+			validation := zone.validator(t).Validate(context.Background(), in)
+			if validation.Status != test.want {
+				t.Errorf("status = %s, want %s (%s)", validation.Status, test.want, validation.Reason)
+			}
+		})
+	}
+
+	direct := zone.sign(t, &dns.TXT{
+		Hdr: dns.RR_Header{Name: "*.w." + testZone, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 300},
+		Txt: []string{"wild"},
+	})
+	in := new(dns.Msg)
+	in.SetQuestion("*.w."+testZone, dns.TypeTXT)
+	in.Answer = direct
+	if validation := zone.validator(t).Validate(context.Background(), in); validation.Status != DnssecSecure {
+		t.Errorf("wildcard owner asked directly: status = %s, want %s (%s)", validation.Status, DnssecSecure, validation.Reason)
+	}
+}
@@ -0,0 +1,269 @@
+package dnsquery
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultEDNSBufferSize is the UDP payload size advertised by default, as
+// recommended by the DNS flag day 2020.
+const DefaultEDNSBufferSize = 1232
+
+// ednsOptionNames names the EDNS option codes shown in responses.
+var ednsOptionNames = map[uint16]string{
+	dns.EDNS0LLQ:          "LLQ",
+	dns.EDNS0UL:           "UL",
+	dns.EDNS0NSID:         "NSID",
+	dns.EDNS0DAU:          "DAU",
+	dns.EDNS0DHU:          "DHU",
+	dns.EDNS0N3U:          "N3U",
+	dns.EDNS0SUBNET:       "CLIENT-SUBNET",
+	dns.EDNS0EXPIRE:       "EXPIRE",
+	dns.EDNS0COOKIE:       "COOKIE",
+	dns.EDNS0TCPKEEPALIVE: "TCP-KEEPALIVE",
+	dns.EDNS0PADDING:      "PADDING",
+	dns.EDNS0EDE:          "EDE",
+}
+
+// EDNS describes the OPT record sent with queries. The zero value sends no
+// OPT record unless DNSSEC records are requested.
+type EDNS struct {
+	Enabled      bool
+	BufferSize   uint16
+	ClientSubnet *dns.EDNS0_SUBNET
+	NSID         bool
+	Cookie       string
+	Padding      int
+	Options      []*dns.EDNS0_LOCAL
+}
+
+type EDNSOption struct {
+	Code  uint16 `json:"code" yaml:"code"`
+	Name  string `json:"name" yaml:"name"`
+	Value string `json:"value" yaml:"value"`
+}
+
+type ExtendedError struct {
+	Code uint16 `json:"code" yaml:"code"`
+	Name string `json:"name" yaml:"name"`
+	Text string `json:"text,omitempty" yaml:"text,omitempty"`
+}
+
+type EDNSInfo struct {
+	Version        uint8           `json:"version" yaml:"version"`
+	UDPSize        uint16          `json:"udp_size" yaml:"udp_size"`
+	DO             bool            `json:"do" yaml:"do"`
+	NSID           string          `json:"nsid,omitempty" yaml:"nsid,omitempty"`
+	ClientSubnet   string          `json:"client_subnet,omitempty" yaml:"client_subnet,omitempty"`
+	Cookie         string          `json:"cookie,omitempty" yaml:"cookie,omitempty"`
+	ExtendedErrors []ExtendedError `json:"extended_errors,omitempty" yaml:"extended_errors,omitempty"`
+	Options        []EDNSOption    `json:"options" yaml:"options"`
+}
+
+// ParseClientSubnet parses an EDNS Client Subnet (RFC 7871) as an address or
+// CIDR block. A bare address uses a /24 source prefix for IPv4 and /56 for IPv6.
+//
+// Args:
+//   - subnet: The address or CIDR block.
+//
+// Returns:
+//   - *dns.EDNS0_SUBNET: The option.
+//   - error: An error if the subnet is invalid.
+func ParseClientSubnet(subnet string) (*dns.EDNS0_SUBNET, error) {
+	address, prefix, hasPrefix := strings.Cut(strings.TrimSpace(subnet), "/")
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid client subnet '%s'", subnet)
+	}
+
+	option := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: ip.To4()}
+	bits := 32
+	if ip.To4() == nil {
+		option.Family, option.SourceNetmask, option.Address, bits = 2, 56, ip, 128
+	}
+
+	if hasPrefix {
+		length, err := strconv.Atoi(prefix)
+		if err != nil || length < 0 || length > bits {
+			return nil, fmt.Errorf("invalid prefix length in client subnet '%s'", subnet)
+		}
+		option.SourceNetmask = uint8(length)
+	}
+	option.Address = option.Address.Mask(net.CIDRMask(int(option.SourceNetmask), bits))
+
+	return option, nil
+}
+
+// ParseEDNSOption parses an arbitrary EDNS option given as code[:hex-value].
+//
+// Args:
+//   - option: The option, e.g. 65001:cafe.
+//
+// Returns:
+//   - *dns.EDNS0_LOCAL: The option.
+//   - error: An error if the code or the value is invalid.
+func ParseEDNSOption(option string) (*dns.EDNS0_LOCAL, error) {
+	code, value, _ := strings.Cut(strings.TrimSpace(option), ":")
+	number, err := strconv.ParseUint(code, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid EDNS option code in '%s'", option)
+	}
+
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex value in EDNS option '%s'", option)
+	}
+
+	return &dns.EDNS0_LOCAL{Code: uint16(number), Data: data}, nil
+}
+
+// ParseCookie validates a DNS cookie (RFC 7873) given in hex, an 8 byte
+// client cookie optionally followed by an 8 to 32 byte server cookie.
+//
+// Args:
+//   - cookie: The cookie, or "random" for a new client cookie.
+//
+// Returns:
+//   - string: The cookie in lower case hex.
+//   - error: An error if the cookie is not valid hex or has the wrong length.
+func ParseCookie(cookie string) (string, error) {
+	if cookie == "random" {
+		client := make([]byte, 8)
+		if _, err := rand.Read(client); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(client), nil
+	}
+
+	data, err := hex.DecodeString(cookie)
+	if err != nil || (len(data) != 8 && (len(data) < 16 || len(data) > 40)) {
+		return "", fmt.Errorf("invalid cookie '%s', use 8 bytes of client cookie optionally followed by 8 to 32 bytes of server cookie, in hex", cookie)
+	}
+
+	return strings.ToLower(cookie), nil
+}
+
+// apply adds the OPT record to a query. Padding (RFC 7830) is added last so
+// the padded message is a multiple of the block size.
+//
+// Args:
+//   - m: The query.
+//   - dnssec: Whether to set the DO bit.
+//
+// Returns:
+//   - None
+func (E EDNS) apply(m *dns.Msg, dnssec bool) {
+	if !E.Enabled && !dnssec {
+		return
+	}
+
+	size := E.BufferSize
+	if size == 0 {
+		size = DefaultEDNSBufferSize
+		if dnssec {
+			size = DnssecBufferSize
+		}
+	}
+	m.SetEdns0(size, dnssec)
+	opt := m.IsEdns0()
+
+	if E.ClientSubnet != nil {
+		opt.Option = append(opt.Option, E.ClientSubnet)
+	}
+	if E.NSID {
+		opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+	}
+	if E.Cookie != "" {
+		opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: E.Cookie})
+	}
+	for _, option := range E.Options {
+		opt.Option = append(opt.Option, option)
+	}
+
+	if E.Padding > 0 {
+		// The padding option adds a 4 byte header before its data.
+		length := m.Len() + 4
+		padding := (E.Padding - length%E.Padding) % E.Padding
+		opt.Option = append(opt.Option, &dns.EDNS0_PADDING{Padding: make([]byte, padding)})
+	}
+}
+
+// NewEDNSInfo extracts the OPT pseudo-section of a response.
+//
+// Args:
+//   - in: The response.
+//
+// Returns:
+//   - *EDNSInfo: The OPT record with its options decoded, nil when the response has none.
+func NewEDNSInfo(in *dns.Msg) *EDNSInfo {
+	opt := in.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+
+	info := &EDNSInfo{
+		Version: opt.Version(),
+		UDPSize: opt.UDPSize(),
+		DO:      opt.Do(),
+		Options: make([]EDNSOption, 0, len(opt.Option)),
+	}
+
+	for _, option := range opt.Option {
+		value := option.String()
+		switch option := option.(type) {
+		case *dns.EDNS0_NSID:
+			info.NSID = nsidText(option.Nsid)
+			value = info.NSID
+		case *dns.EDNS0_SUBNET:
+			info.ClientSubnet = fmt.Sprintf("%s/%d/%d", option.Address, option.SourceNetmask, option.SourceScope)
+			value = info.ClientSubnet
+		case *dns.EDNS0_COOKIE:
+			info.Cookie = option.Cookie
+		case *dns.EDNS0_EDE:
+			info.ExtendedErrors = append(info.ExtendedErrors, ExtendedError{
+				Code: option.InfoCode,
+				Name: dns.ExtendedErrorCodeToString[option.InfoCode],
+				Text: option.ExtraText,
+			})
+		case *dns.EDNS0_PADDING:
+			value = fmt.Sprintf("%d bytes", len(option.Padding))
+		}
+
+		name, ok := ednsOptionNames[option.Option()]
+		if !ok {
+			name = fmt.Sprintf("OPT%d", option.Option())
+		}
+		info.Options = append(info.Options, EDNSOption{Code: option.Option(), Name: name, Value: value})
+	}
+
+	return info
+}
+
+// nsidText shows an NSID as hex followed by its text when it is printable.
+//
+// Args:
+//   - nsid: The NSID in hex.
+//
+// Returns:
+//   - string: The NSID.
+func nsidText(nsid string) string {
+	data, err := hex.DecodeString(nsid)
+	if err != nil || len(data) == 0 {
+		return nsid
+	}
+
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) {
+			return nsid
+		}
+	}
+
+	return fmt.Sprintf("%s (%q)", nsid, data)
+}
@@ -0,0 +1,238 @@
+package dnsquery
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	NSECWalked     = "walked"
+	NSECIncomplete = "incomplete"
+	NSECHashed     = "nsec3"
+	NSECMinimal    = "minimal"
+	NSECUnsigned   = "unsigned"
+)
+
+type NSECName struct {
+	Name  string   `json:"name" yaml:"name"`
+	Types []string `json:"types" yaml:"types"`
+}
+
+// NSECWalk is the list of names found by following the NSEC chain of a zone.
+// Zones signed with NSEC3 hash their names and zones signed online with
+// minimal NSEC records ("black lies") cannot be walked.
+type NSECWalk struct {
+	Status string     `json:"status" yaml:"status"`
+	Reason string     `json:"reason,omitempty" yaml:"reason,omitempty"`
+	Names  []NSECName `json:"names" yaml:"names"`
+	// Queries is the number of queries the walk made.
+	Queries int `json:"queries" yaml:"queries"`
+}
+
+// WalkNSEC enumerates a zone by asking for the NSEC record of every name,
+// each one pointing to the next name of the zone in canonical order. Only
+// NSEC records signed by the zone are followed: at a delegation the resolver
+// answers from the child zone, so the NSEC of the parent is taken from the
+// answer to a DS query instead.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//   - resolver: The resolver used for every query.
+//   - zone: The zone to walk.
+//   - limit: The maximum number of names to walk.
+//
+// Returns:
+//   - NSECWalk: The names found and how the walk ended.
+func WalkNSEC(ctx context.Context, resolver Resolver, zone string, limit int) NSECWalk {
+	zone = dns.CanonicalName(zone)
+	walk := NSECWalk{Status: NSECIncomplete, Names: []NSECName{}}
+	seen := map[string]bool{}
+
+	current := zone
+	for len(walk.Names) < limit {
+		nsec, reason := walk.zoneNSEC(ctx, resolver, zone, current)
+		if nsec == nil {
+			if current == zone && reason == "" {
+				walk.Status, walk.Reason = unwalkable(ctx, resolver, zone)
+				walk.Queries++
+			} else {
+				walk.Reason = reason
+			}
+			return walk
+		}
+
+		next := dns.CanonicalName(nsec.NextDomain)
+		// Online signers answer with an NSEC record covering only the queried
+		// name, pointing to \000.name, so the chain cannot be followed.
+		if strings.HasPrefix(next, `\000.`) {
+			walk.Status = NSECMinimal
+			walk.Reason = "the zone is signed online with minimal NSEC records that do not reveal the next name"
+			return walk
+		}
+
+		types := make([]string, 0, len(nsec.TypeBitMap))
+		for _, rtype := range nsec.TypeBitMap {
+			types = append(types, dns.TypeToString[rtype])
+		}
+		walk.Names = append(walk.Names, NSECName{Name: current, Types: types})
+		seen[current] = true
+
+		if next == zone || !dns.IsSubDomain(zone, next) || seen[next] {
+			walk.Status = NSECWalked
+			return walk
+		}
+		current = next
+	}
+
+	walk.Reason = fmt.Sprintf("stopped after %d names", limit)
+	return walk
+}
+
+// zoneNSEC finds the NSEC record the zone holds for one of its names. When
+// the NSEC query is answered by another zone, the name is a delegation: its
+// NSEC comes with the NODATA answer to a DS query, or, when the delegation
+// has DS records, with the NXDOMAIN answer for the name sorting right after
+// it, which the parent covers with that same NSEC.
+//
+// Args:
+//   - ctx: The context bounding the queries.
+//   - resolver: The resolver.
+//   - zone: The canonical zone name.
+//   - name: The canonical name in the zone.
+//
+// Returns:
+//   - *dns.NSEC: The NSEC record owned by the name, nil if none was found.
+//   - string: Why no NSEC record was found, empty when the zone has none.
+func (W *NSECWalk) zoneNSEC(ctx context.Context, resolver Resolver, zone, name string) (*dns.NSEC, string) {
+	questions := []dns.Question{{Name: name, Qtype: dns.TypeNSEC}}
+	if name != zone {
+		questions = append(questions, dns.Question{Name: name, Qtype: dns.TypeDS})
+		if successor, ok := nextName(name); ok {
+			questions = append(questions, dns.Question{Name: successor, Qtype: dns.TypeNSEC})
+		}
+	}
+
+	var in *dns.Msg
+	for _, question := range questions {
+		var err error
+		in, err = nsecQuery(ctx, resolver, question.Name, question.Qtype)
+		W.Queries++
+		if err != nil {
+			return nil, err.Error()
+		}
+		if nsec := signedNSEC(in, zone, name); nsec != nil {
+			return nsec, ""
+		}
+	}
+
+	if name == zone {
+		return nil, ""
+	}
+	return nil, fmt.Sprintf("no NSEC record of %s for %s (%s)", zone, name, dns.RcodeToString[in.Rcode])
+}
+
+// nsecQuery sends a query with the DO and CD bits set so NSEC records come
+// with their signatures.
+//
+// Args:
+//   - ctx: The context bounding the query.
+//   - resolver: The resolver.
+//   - name: The name to query.
+//   - qtype: The query type.
+//
+// Returns:
+//   - *dns.Msg: The response.
+//   - error: An error if no upstream answered.
+func nsecQuery(ctx context.Context, resolver Resolver, name string, qtype uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.RecursionDesired = true
+	m.CheckingDisabled = true
+	m.SetEdns0(DnssecBufferSize, true)
+
+	response, err := resolver.Exchange(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Msg, nil
+}
+
+// signedNSEC finds, in the answer or authority section, the NSEC record
+// owned by a name and signed by the zone.
+//
+// Args:
+//   - in: The response.
+//   - zone: The canonical zone name.
+//   - name: The canonical owner name.
+//
+// Returns:
+//   - *dns.NSEC: The NSEC record, nil if there is none.
+func signedNSEC(in *dns.Msg, zone, name string) *dns.NSEC {
+	records := append(slices.Clone(in.Answer), in.Ns...)
+
+	signed := false
+	for _, rr := range records {
+		if sig, ok := rr.(*dns.RRSIG); ok && sig.TypeCovered == dns.TypeNSEC &&
+			dns.CanonicalName(sig.Hdr.Name) == name && dns.CanonicalName(sig.SignerName) == zone {
+			signed = true
+		}
+	}
+	if !signed {
+		return nil
+	}
+
+	for _, rr := range records {
+		if record, ok := rr.(*dns.NSEC); ok && dns.CanonicalName(record.Hdr.Name) == name {
+			return record
+		}
+	}
+
+	return nil
+}
+
+// nextName returns the name sorting right after a name and all the names
+// below it in canonical order, its first label followed by a zero byte.
+//
+// Args:
+//   - name: The canonical name.
+//
+// Returns:
+//   - string: The next name.
+//   - bool: Whether the first label has room for one more byte.
+func nextName(name string) (string, bool) {
+	labels := dns.SplitDomainName(name)
+	if len(labels) == 0 || len(labels[0]) >= 63 {
+		return "", false
+	}
+	labels[0] += `\000`
+
+	return dns.Fqdn(strings.Join(labels, ".")), true
+}
+
+// unwalkable explains why a zone has no NSEC record at its apex.
+//
+// Args:
+//   - ctx: The context bounding the query.
+//   - resolver: The resolver.
+//   - zone: The zone.
+//
+// Returns:
+//   - string: The walk status, NSEC3 or unsigned.
+//   - string: The reason.
+func unwalkable(ctx context.Context, resolver Resolver, zone string) (string, string) {
+	response, err := Negative(resolver.Lookup(ctx, zone, "NSEC3PARAM"))
+	if err == nil {
+		for _, rr := range response.Msg.Answer {
+			if _, ok := rr.(*dns.NSEC3PARAM); ok {
+				return NSECHashed, "the zone uses NSEC3, its names are hashed"
+			}
+		}
+	}
+
+	return NSECUnsigned, "the zone has no NSEC record at its apex, it is not signed with NSEC"
+}
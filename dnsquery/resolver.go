@@ -0,0 +1,166 @@
+// Package dnsquery resolves DNS names over pluggable transports and returns
+// typed results and errors, so it can be used outside of the ops commands.
+package dnsquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ErrNoAnswer is returned when no upstream could be asked at all.
+var ErrNoAnswer = errors.New("no upstream answered")
+
+// Resolver answers DNS questions. Upstreams implements it, tests can inject
+// their own.
+type Resolver interface {
+	Lookup(ctx context.Context, name, qtype string) (Response, error)
+	Exchange(ctx context.Context, m *dns.Msg) (Response, error)
+}
+
+// Response is an answer with the server and transport that gave it.
+// TCPFallback is set when a truncated UDP answer was retried over TCP.
+type Response struct {
+	Msg         *dns.Msg
+	Server      string
+	Transport   string
+	Handshake   time.Duration
+	Rtt         time.Duration
+	TCPFallback bool
+}
+
+// TypeError reports a query type that is not a known record type.
+type TypeError struct {
+	Type string
+}
+
+// TimeoutError reports a server that did not answer in time.
+type TimeoutError struct {
+	Server string
+	Err    error
+}
+
+// RcodeError reports an answer whose rcode is not NOERROR. The answer is kept
+// so callers can still inspect negative responses.
+type RcodeError struct {
+	Rcode    int
+	Response Response
+}
+
+// Error returns the error message.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - string: The error message.
+func (E *TypeError) Error() string {
+	return fmt.Sprintf("unknown record type: %s", E.Type)
+}
+
+// Error returns the error message.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - string: The error message.
+func (E *TimeoutError) Error() string {
+	return fmt.Sprintf("%s: timed out: %s", E.Server, E.Err)
+}
+
+// Unwrap returns the underlying error.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - error: The transport or context error.
+func (E *TimeoutError) Unwrap() error {
+	return E.Err
+}
+
+// Timeout reports that the error is a timeout, like net.Error.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - bool: Always true.
+func (E *TimeoutError) Timeout() bool {
+	return true
+}
+
+// Error returns the error message.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - string: The error message.
+func (E *RcodeError) Error() string {
+	name := ""
+	if msg := E.Response.Msg; msg != nil && len(msg.Question) > 0 {
+		name = msg.Question[0].Name
+	}
+
+	return fmt.Sprintf("%s answered %s for %s", E.Response.Server, dns.RcodeToString[E.Rcode], name)
+}
+
+// ParseType converts a record type name such as "mx" into its code.
+//
+// Args:
+//   - qtype: The record type name, case insensitive.
+//
+// Returns:
+//   - uint16: The record type code.
+//   - error: A *TypeError if the type is unknown.
+func ParseType(qtype string) (uint16, error) {
+	record, ok := dns.StringToType[strings.ToUpper(qtype)]
+	if !ok {
+		return 0, &TypeError{Type: qtype}
+	}
+
+	return record, nil
+}
+
+// Negative returns the response carried by an *RcodeError, so callers that
+// treat NXDOMAIN or SERVFAIL as data rather than failure can keep going.
+//
+// Args:
+//   - response: The response returned by Lookup.
+//   - err: The error returned by Lookup.
+//
+// Returns:
+//   - Response: The response, including negative answers.
+//   - error: The error unless it was an *RcodeError.
+func Negative(response Response, err error) (Response, error) {
+	var rcodeErr *RcodeError
+	if errors.As(err, &rcodeErr) {
+		return rcodeErr.Response, nil
+	}
+
+	return response, err
+}
+
+// classify wraps timeouts from a transport in a *TimeoutError.
+//
+// Args:
+//   - server: The server that was queried.
+//   - err: The transport error.
+//
+// Returns:
+//   - error: A *TimeoutError for timeouts and deadlines, the error itself otherwise.
+func classify(server string, err error) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &TimeoutError{Server: server, Err: err}
+	}
+
+	return fmt.Errorf("%s: %w", server, err)
+}
@@ -0,0 +1,303 @@
+package dnsquery
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const StatusNoData = "NODATA"
+
+type Question struct {
+	Name  string `json:"name" yaml:"name"`
+	Type  string `json:"type" yaml:"type"`
+	Class string `json:"class" yaml:"class"`
+}
+
+type Flags struct {
+	Authoritative      bool `json:"aa" yaml:"aa"`
+	Truncated          bool `json:"tc" yaml:"tc"`
+	RecursionDesired   bool `json:"rd" yaml:"rd"`
+	RecursionAvailable bool `json:"ra" yaml:"ra"`
+	AuthenticatedData  bool `json:"ad" yaml:"ad"`
+	CheckingDisabled   bool `json:"cd" yaml:"cd"`
+}
+
+type Counts struct {
+	Question   int `json:"question" yaml:"question"`
+	Answer     int `json:"answer" yaml:"answer"`
+	Authority  int `json:"authority" yaml:"authority"`
+	Additional int `json:"additional" yaml:"additional"`
+}
+
+type Record struct {
+	Name  string         `json:"name" yaml:"name"`
+	Type  string         `json:"type" yaml:"type"`
+	Class string         `json:"class" yaml:"class"`
+	TTL   uint32         `json:"ttl" yaml:"ttl"`
+	Value string         `json:"value" yaml:"value"`
+	Data  map[string]any `json:"data,omitempty" yaml:"data,omitempty"`
+}
+
+type Field struct {
+	Name  string
+	Value any
+}
+
+type Result struct {
+	Question   Question    `json:"question" yaml:"question"`
+	Status     string      `json:"status" yaml:"status"`
+	Rcode      string      `json:"rcode" yaml:"rcode"`
+	ID         uint16      `json:"id" yaml:"id"`
+	Opcode     string      `json:"opcode" yaml:"opcode"`
+	Flags      Flags       `json:"flags" yaml:"flags"`
+	Counts     Counts      `json:"counts" yaml:"counts"`
+	Answer     []Record    `json:"answer" yaml:"answer"`
+	Authority  []Record    `json:"authority" yaml:"authority"`
+	Additional []Record    `json:"additional" yaml:"additional"`
+	Server     string      `json:"server" yaml:"server"`
+	Transport  string      `json:"transport" yaml:"transport"`
+	Fallback   bool        `json:"tcp_fallback" yaml:"tcp_fallback"`
+	RttMs      float64     `json:"rtt_ms" yaml:"rtt_ms"`
+	Error      string      `json:"error,omitempty" yaml:"error,omitempty"`
+	Dnssec     *Validation `json:"dnssec,omitempty" yaml:"dnssec,omitempty"`
+	EDNS       *EDNSInfo   `json:"edns,omitempty" yaml:"edns,omitempty"`
+	Chain      *Chain      `json:"chain,omitempty" yaml:"chain,omitempty"`
+}
+
+// NewResult converts a DNS response into a structured result.
+//
+// Args:
+//   - response: The DNS response.
+//
+// Returns:
+//   - Result: The structured result.
+func NewResult(response Response) Result {
+	in := response.Msg
+	result := Result{
+		ID:         in.Id,
+		Opcode:     dns.OpcodeToString[in.Opcode],
+		Rcode:      dns.RcodeToString[in.Rcode],
+		Answer:     newRecords(in.Answer),
+		Authority:  newRecords(in.Ns),
+		Additional: newRecords(in.Extra),
+		Server:     response.Server,
+		Transport:  response.Transport,
+		Fallback:   response.TCPFallback,
+		RttMs:      float64(response.Rtt) / float64(time.Millisecond),
+		EDNS:       NewEDNSInfo(in),
+		Flags: Flags{
+			Authoritative:      in.Authoritative,
+			Truncated:          in.Truncated,
+			RecursionDesired:   in.RecursionDesired,
+			RecursionAvailable: in.RecursionAvailable,
+			AuthenticatedData:  in.AuthenticatedData,
+			CheckingDisabled:   in.CheckingDisabled,
+		},
+		Counts: Counts{
+			Question:   len(in.Question),
+			Answer:     len(in.Answer),
+			Authority:  len(in.Ns),
+			Additional: len(in.Extra),
+		},
+	}
+
+	if len(in.Question) > 0 {
+		question := in.Question[0]
+		result.Question = Question{
+			Name:  question.Name,
+			Type:  dns.TypeToString[question.Qtype],
+			Class: dns.ClassToString[question.Qclass],
+		}
+	}
+
+	result.Status = result.Rcode
+	if in.Rcode == dns.RcodeSuccess && len(in.Answer) == 0 {
+		result.Status = StatusNoData
+	}
+
+	return result
+}
+
+// newRecords converts resource records into structured records.
+//
+// Args:
+//   - rrs: The resource records.
+//
+// Returns:
+//   - []Record: The structured records, never nil so empty sections stay explicit.
+func newRecords(rrs []dns.RR) []Record {
+	records := make([]Record, 0, len(rrs))
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+		records = append(records, NewRecord(rr))
+	}
+
+	return records
+}
+
+// NewRecord converts a resource record into a structured record.
+//
+// Args:
+//   - rr: The resource record.
+//
+// Returns:
+//   - Record: The structured record with its parsed fields.
+func NewRecord(rr dns.RR) Record {
+	header := rr.Header()
+
+	return Record{
+		Name:  header.Name,
+		Type:  dns.TypeToString[header.Rrtype],
+		Class: dns.ClassToString[header.Class],
+		TTL:   header.Ttl,
+		Value: strings.TrimPrefix(rr.String(), header.String()),
+		Data:  recordData(rr),
+	}
+}
+
+// Fields extracts the typed fields of a resource record in presentation order.
+//
+// Args:
+//   - rr: The resource record.
+//
+// Returns:
+//   - []Field: The parsed fields, nil for types without a parser.
+func Fields(rr dns.RR) []Field {
+	switch record := rr.(type) {
+	case *dns.A:
+		return []Field{{"address", record.A.String()}}
+	case *dns.AAAA:
+		return []Field{{"address", record.AAAA.String()}}
+	case *dns.CNAME:
+		return []Field{{"target", record.Target}}
+	case *dns.NS:
+		return []Field{{"host", record.Ns}}
+	case *dns.PTR:
+		return []Field{{"target", record.Ptr}}
+	case *dns.TXT:
+		return []Field{{"strings", record.Txt}}
+	case *dns.MX:
+		return []Field{{"preference", record.Preference}, {"exchange", record.Mx}}
+	case *dns.SRV:
+		return []Field{{"priority", record.Priority}, {"weight", record.Weight}, {"port", record.Port}, {"target", record.Target}}
+	case *dns.SOA:
+		return []Field{
+			{"mname", record.Ns},
+			{"rname", record.Mbox},
+			{"serial", record.Serial},
+			{"refresh", record.Refresh},
+			{"retry", record.Retry},
+			{"expire", record.Expire},
+			{"minimum", record.Minttl},
+		}
+	case *dns.CAA:
+		return []Field{{"flag", record.Flag}, {"tag", record.Tag}, {"value", record.Value}}
+	case *dns.DS:
+		return []Field{
+			{"key_tag", record.KeyTag},
+			{"algorithm", dns.AlgorithmToString[record.Algorithm]},
+			{"digest_type", dns.HashToString[record.DigestType]},
+			{"digest", strings.ToLower(record.Digest)},
+		}
+	case *dns.DNSKEY:
+		return []Field{
+			{"flags", record.Flags},
+			{"protocol", record.Protocol},
+			{"algorithm", dns.AlgorithmToString[record.Algorithm]},
+			{"key_tag", record.KeyTag()},
+			{"public_key", record.PublicKey},
+		}
+	case *dns.TLSA:
+		return []Field{
+			{"usage", record.Usage},
+			{"selector", record.Selector},
+			{"matching_type", record.MatchingType},
+			{"certificate", record.Certificate},
+		}
+	case *dns.NAPTR:
+		return []Field{
+			{"order", record.Order},
+			{"preference", record.Preference},
+			{"flags", record.Flags},
+			{"service", record.Service},
+			{"regexp", record.Regexp},
+			{"replacement", record.Replacement},
+		}
+	case *dns.SVCB:
+		return svcbFields(record)
+	case *dns.HTTPS:
+		return svcbFields(&record.SVCB)
+	}
+
+	return nil
+}
+
+// svcbFields extracts the priority, target and parameters of an SVCB or
+// HTTPS record, one field per parameter such as alpn or ipv4hint.
+//
+// Args:
+//   - record: The SVCB record.
+//
+// Returns:
+//   - []Field: The parsed fields.
+func svcbFields(record *dns.SVCB) []Field {
+	fields := []Field{{"priority", record.Priority}, {"target", record.Target}}
+	for _, param := range record.Value {
+		var value any = param.String()
+		switch param := param.(type) {
+		case *dns.SVCBAlpn:
+			value = param.Alpn
+		case *dns.SVCBIPv4Hint:
+			value = ipStrings(param.Hint)
+		case *dns.SVCBIPv6Hint:
+			value = ipStrings(param.Hint)
+		case *dns.SVCBPort:
+			value = param.Port
+		}
+		fields = append(fields, Field{param.Key().String(), value})
+	}
+
+	return fields
+}
+
+// ipStrings formats a list of IP addresses.
+//
+// Args:
+//   - ips: The addresses.
+//
+// Returns:
+//   - []string: The addresses in text form.
+func ipStrings(ips []net.IP) []string {
+	hints := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		hints = append(hints, ip.String())
+	}
+
+	return hints
+}
+
+// recordData collects the typed fields of a resource record into a map.
+//
+// Args:
+//   - rr: The resource record.
+//
+// Returns:
+//   - map[string]any: The parsed fields, nil for types without a parser.
+func recordData(rr dns.RR) map[string]any {
+	fields := Fields(rr)
+	if fields == nil {
+		return nil
+	}
+
+	data := make(map[string]any, len(fields))
+	for _, field := range fields {
+		data[field.Name] = field.Value
+	}
+
+	return data
+}
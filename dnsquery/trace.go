@@ -0,0 +1,490 @@
+package dnsquery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	maxTraceHops  = 32
+	maxTraceDepth = 4
+)
+
+type Nameserver struct {
+	Name      string
+	Addresses []string
+}
+
+type TraceHop struct {
+	Zone         string
+	Server       string
+	Address      string
+	Rcode        int
+	Rtt          time.Duration
+	ReferralZone string
+	Referral     []dns.RR
+	Glue         []dns.RR
+	Resolved     map[string][]string
+	Answer       []dns.RR
+}
+
+// Delegation is a zone as seen from its parent: the NS records of the
+// referral and the glue that came with it.
+type Delegation struct {
+	Zone        string
+	Parent      string
+	Server      string
+	Address     string
+	Nameservers []string
+	Glue        map[string][]string
+}
+
+type Tracer struct {
+	transport Transport
+	roots     []Nameserver
+	port      string
+}
+
+// defaultRootHints are the IPv4 addresses of the root servers from named.root.
+var defaultRootHints = []Nameserver{
+	{Name: "a.root-servers.net.", Addresses: []string{"198.41.0.4"}},
+	{Name: "b.root-servers.net.", Addresses: []string{"170.247.170.2"}},
+	{Name: "c.root-servers.net.", Addresses: []string{"192.33.4.12"}},
+	{Name: "d.root-servers.net.", Addresses: []string{"199.7.91.13"}},
+	{Name: "e.root-servers.net.", Addresses: []string{"192.203.230.10"}},
+	{Name: "f.root-servers.net.", Addresses: []string{"192.5.5.241"}},
+	{Name: "g.root-servers.net.", Addresses: []string{"192.112.36.4"}},
+	{Name: "h.root-servers.net.", Addresses: []string{"198.97.190.53"}},
+	{Name: "i.root-servers.net.", Addresses: []string{"192.36.148.17"}},
+	{Name: "j.root-servers.net.", Addresses: []string{"192.58.128.30"}},
+	{Name: "k.root-servers.net.", Addresses: []string{"193.0.14.129"}},
+	{Name: "l.root-servers.net.", Addresses: []string{"199.7.83.42"}},
+	{Name: "m.root-servers.net.", Addresses: []string{"202.12.27.33"}},
+}
+
+// NewTracer creates a tracer that walks delegations from the given root hints.
+//
+// Args:
+//   - transport: The transport used to query each nameserver, UDP when nil.
+//     Truncated UDP answers are asked again over TCP.
+//   - roots: The root hints, the built-in root servers when empty.
+//   - port: The port used for every nameserver address, 53 when empty.
+//
+// Returns:
+//   - Tracer: The tracer.
+func NewTracer(transport Transport, roots []Nameserver, port string) Tracer {
+	if transport == nil {
+		transport = plainTransport{timeout: DefaultTimeout}
+	}
+	if len(roots) == 0 {
+		roots = defaultRootHints
+	}
+	if port == "" {
+		port = DefaultPort
+	}
+
+	return Tracer{transport: transport, roots: roots, port: port}
+}
+
+// LoadRootHints reads root hints from a named.root style zone file. NS
+// records name the root servers and A/AAAA records give their addresses.
+//
+// Args:
+//   - path: The path to the root hints file.
+//
+// Returns:
+//   - []Nameserver: The root servers with their addresses.
+//   - error: An error if the file cannot be parsed or has no usable servers.
+func LoadRootHints(path string) ([]Nameserver, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open root hints '%s': %w", path, err)
+	}
+	defer file.Close()
+
+	var (
+		names     []string
+		addresses = map[string][]string{}
+	)
+	parser := dns.NewZoneParser(file, ".", path)
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		switch record := rr.(type) {
+		case *dns.NS:
+			names = append(names, dns.CanonicalName(record.Ns))
+		case *dns.A:
+			owner := dns.CanonicalName(record.Hdr.Name)
+			addresses[owner] = append(addresses[owner], record.A.String())
+		case *dns.AAAA:
+			owner := dns.CanonicalName(record.Hdr.Name)
+			addresses[owner] = append(addresses[owner], record.AAAA.String())
+		}
+	}
+	if err := parser.Err(); err != nil {
+		return nil, fmt.Errorf("could not parse root hints '%s': %w", path, err)
+	}
+
+	var roots []Nameserver
+	for _, name := range names {
+		if len(addresses[name]) > 0 {
+			roots = append(roots, Nameserver{Name: name, Addresses: addresses[name]})
+		}
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no root servers with addresses found in '%s'", path)
+	}
+
+	return roots, nil
+}
+
+// RootHintsFromAddresses builds root hints from bare addresses, naming each
+// server after its address.
+//
+// Args:
+//   - addresses: The root server IP addresses.
+//
+// Returns:
+//   - []Nameserver: The root servers.
+//   - error: An error if an address is not an IP.
+func RootHintsFromAddresses(addresses []string) ([]Nameserver, error) {
+	roots := make([]Nameserver, 0, len(addresses))
+	for _, address := range addresses {
+		ip := net.ParseIP(strings.Trim(address, "[]"))
+		if ip == nil {
+			return nil, fmt.Errorf("root server '%s' is not an IP address", address)
+		}
+		roots = append(roots, Nameserver{Name: ip.String(), Addresses: []string{ip.String()}})
+	}
+
+	return roots, nil
+}
+
+// Trace resolves a name iteratively, starting at the root hints and
+// following referrals until an authoritative answer or a negative response.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//   - name: The name to resolve.
+//   - qtype: The query type.
+//
+// Returns:
+//   - []TraceHop: Every server queried on the way, in order.
+//   - error: An error if the delegation chain is broken.
+func (T Tracer) Trace(ctx context.Context, name string, qtype uint16) ([]TraceHop, error) {
+	return T.trace(ctx, dns.Fqdn(name), qtype, 0)
+}
+
+// trace walks the delegation chain for a name.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//   - name: The fully qualified name to resolve.
+//   - qtype: The query type.
+//   - depth: The nesting level of out-of-bailiwick nameserver lookups.
+//
+// Returns:
+//   - []TraceHop: Every server queried on the way, in order.
+//   - error: An error if the delegation chain is broken.
+func (T Tracer) trace(ctx context.Context, name string, qtype uint16, depth int) ([]TraceHop, error) {
+	var hops []TraceHop
+
+	zone := "."
+	servers := T.roots
+	for len(hops) < maxTraceHops {
+		hop, in, err := T.queryZone(ctx, zone, servers, name, qtype)
+		if err != nil {
+			return hops, err
+		}
+
+		if len(in.Answer) > 0 || in.Rcode != dns.RcodeSuccess {
+			hop.Answer = in.Answer
+			hops = append(hops, hop)
+			return hops, nil
+		}
+
+		referralZone, referral := referralFrom(in, zone, name)
+		if referralZone == "" {
+			hops = append(hops, hop)
+			if in.Authoritative {
+				return hops, nil
+			}
+			return hops, fmt.Errorf("%s (%s) gave neither an answer nor a referral for %s", hop.Server, hop.Address, name)
+		}
+
+		hop.ReferralZone = referralZone
+		hop.Referral = referral
+		hop.Glue, servers = glueFor(in, referral, zone)
+		hop.Resolved = map[string][]string{}
+
+		if len(servers) == 0 {
+			servers = T.resolveNameservers(ctx, referral, depth, hop.Resolved)
+		}
+		hops = append(hops, hop)
+
+		if len(servers) == 0 {
+			return hops, fmt.Errorf("no reachable address for any nameserver of %s", referralZone)
+		}
+		zone = referralZone
+	}
+
+	return hops, fmt.Errorf("gave up on %s after %d hops", name, maxTraceHops)
+}
+
+// Delegation finds the referral to a zone by tracing its NS records from the
+// root, returning the nameservers and glue its parent publishes.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//   - zone: The zone.
+//
+// Returns:
+//   - Delegation: The delegation as published by the parent.
+//   - error: An error if the trace fails or the parent does not delegate the zone.
+func (T Tracer) Delegation(ctx context.Context, zone string) (Delegation, error) {
+	zone = dns.CanonicalName(zone)
+	if zone == "." {
+		return Delegation{}, fmt.Errorf("the root zone has no parent")
+	}
+
+	hops, err := T.Trace(ctx, zone, dns.TypeNS)
+	for _, hop := range hops {
+		if hop.ReferralZone != zone {
+			continue
+		}
+
+		delegation := Delegation{
+			Zone:    zone,
+			Parent:  hop.Zone,
+			Server:  hop.Server,
+			Address: hop.Address,
+			Glue:    map[string][]string{},
+		}
+		for _, rr := range hop.Referral {
+			delegation.Nameservers = append(delegation.Nameservers, dns.CanonicalName(rr.(*dns.NS).Ns))
+		}
+		for _, rr := range hop.Glue {
+			owner := dns.CanonicalName(rr.Header().Name)
+			switch record := rr.(type) {
+			case *dns.A:
+				delegation.Glue[owner] = append(delegation.Glue[owner], record.A.String())
+			case *dns.AAAA:
+				delegation.Glue[owner] = append(delegation.Glue[owner], record.AAAA.String())
+			}
+		}
+
+		return delegation, nil
+	}
+
+	if err != nil {
+		return Delegation{}, err
+	}
+	if len(hops) > 0 {
+		last := hops[len(hops)-1]
+		if last.Rcode != dns.RcodeSuccess {
+			return Delegation{}, fmt.Errorf("%s (%s) answered %s for %s", last.Server, last.Address, dns.RcodeToString[last.Rcode], zone)
+		}
+		return Delegation{}, fmt.Errorf("%s is not delegated from %s, it is served by the servers of its parent", zone, last.Zone)
+	}
+
+	return Delegation{}, fmt.Errorf("no delegation found for %s", zone)
+}
+
+// queryZone sends a non-recursive query to the servers of a zone until one answers.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//   - zone: The zone the servers are authoritative for.
+//   - servers: The nameservers of the zone.
+//   - name: The name to resolve.
+//   - qtype: The query type.
+//
+// Returns:
+//   - TraceHop: The hop describing the server that answered.
+//   - *dns.Msg: The answer.
+//   - error: An error if none of the servers answered.
+func (T Tracer) queryZone(ctx context.Context, zone string, servers []Nameserver, name string, qtype uint16) (TraceHop, *dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.RecursionDesired = false
+
+	var (
+		lame    TraceHop
+		lameMsg *dns.Msg
+		lastErr error
+	)
+	for _, server := range servers {
+		for _, address := range server.Addresses {
+			target := net.JoinHostPort(address, T.port)
+			in, timings, err := T.transport.Exchange(ctx, m, target)
+			if err == nil && in.Truncated {
+				if plain, ok := T.transport.(plainTransport); ok {
+					tcp := streamTransport{timeout: plain.timeout}
+					if retried, retriedTimings, retryErr := tcp.Exchange(ctx, m, target); retryErr == nil {
+						in, timings = retried, retriedTimings
+					}
+				}
+			}
+			if err != nil {
+				lastErr = fmt.Errorf("%s: %w", server.Name, classify(target, err))
+				continue
+			}
+
+			hop := TraceHop{
+				Zone:    zone,
+				Server:  server.Name,
+				Address: target,
+				Rcode:   in.Rcode,
+				Rtt:     timings.Handshake + timings.Query,
+			}
+			// A lame server refuses or fails the query, so ask the next one.
+			if in.Rcode == dns.RcodeServerFailure || in.Rcode == dns.RcodeRefused {
+				lame, lameMsg = hop, in
+				continue
+			}
+			return hop, in, nil
+		}
+	}
+
+	if lameMsg != nil {
+		return lame, lameMsg, nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no nameservers to query for zone %s", zone)
+	}
+
+	return TraceHop{Zone: zone}, nil, lastErr
+}
+
+// resolveNameservers looks up the addresses of nameservers that came without
+// glue by tracing them from the root.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//   - referral: The NS records of the delegation.
+//   - depth: The current nesting level.
+//   - resolved: Filled with the addresses found for each nameserver name.
+//
+// Returns:
+//   - []Nameserver: The nameservers that could be resolved.
+func (T Tracer) resolveNameservers(ctx context.Context, referral []dns.RR, depth int, resolved map[string][]string) []Nameserver {
+	if depth >= maxTraceDepth {
+		return nil
+	}
+
+	var servers []Nameserver
+	for _, rr := range referral {
+		ns := rr.(*dns.NS).Ns
+
+		var addresses []string
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			hops, err := T.trace(ctx, dns.CanonicalName(ns), qtype, depth+1)
+			if err != nil || len(hops) == 0 {
+				continue
+			}
+			for _, answer := range hops[len(hops)-1].Answer {
+				switch record := answer.(type) {
+				case *dns.A:
+					addresses = append(addresses, record.A.String())
+				case *dns.AAAA:
+					addresses = append(addresses, record.AAAA.String())
+				}
+			}
+		}
+
+		if len(addresses) > 0 {
+			resolved[ns] = addresses
+			servers = append(servers, Nameserver{Name: ns, Addresses: addresses})
+			// One working nameserver is enough to follow the delegation.
+			break
+		}
+	}
+
+	return servers
+}
+
+// referralFrom extracts a delegation from the authority section, accepting
+// only zones below the current one that contain the queried name.
+//
+// Args:
+//   - in: The response.
+//   - zone: The zone of the server that answered.
+//   - name: The queried name.
+//
+// Returns:
+//   - string: The delegated zone, empty when the response is no referral.
+//   - []dns.RR: The NS records of the delegated zone.
+func referralFrom(in *dns.Msg, zone, name string) (string, []dns.RR) {
+	var (
+		referralZone string
+		referral     []dns.RR
+	)
+	for _, rr := range in.Ns {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+
+		owner := dns.CanonicalName(ns.Hdr.Name)
+		if owner == zone || !dns.IsSubDomain(zone, owner) || !dns.IsSubDomain(owner, dns.CanonicalName(name)) {
+			continue
+		}
+		if referralZone != "" && owner != referralZone {
+			continue
+		}
+
+		referralZone = owner
+		referral = append(referral, ns)
+	}
+
+	return referralZone, referral
+}
+
+// glueFor collects the glue records for a delegation, ignoring records for
+// nameservers outside the bailiwick of the zone that sent the referral.
+//
+// Args:
+//   - in: The referral response.
+//   - referral: The NS records of the delegation.
+//   - zone: The zone of the server that sent the referral.
+//
+// Returns:
+//   - []dns.RR: The glue records that were accepted.
+//   - []Nameserver: The nameservers that have glue.
+func glueFor(in *dns.Msg, referral []dns.RR, zone string) ([]dns.RR, []Nameserver) {
+	var (
+		glue    []dns.RR
+		servers []Nameserver
+	)
+	for _, rr := range referral {
+		ns := dns.CanonicalName(rr.(*dns.NS).Ns)
+		if !dns.IsSubDomain(zone, ns) {
+			continue
+		}
+
+		var addresses []string
+		for _, extra := range in.Extra {
+			if dns.CanonicalName(extra.Header().Name) != ns {
+				continue
+			}
+			switch record := extra.(type) {
+			case *dns.A:
+				addresses = append(addresses, record.A.String())
+				glue = append(glue, record)
+			case *dns.AAAA:
+				addresses = append(addresses, record.AAAA.String())
+				glue = append(glue, record)
+			}
+		}
+
+		if len(addresses) > 0 {
+			servers = append(servers, Nameserver{Name: ns, Addresses: addresses})
+		}
+	}
+
+	return glue, servers
+}
@@ -0,0 +1,150 @@
+package dnsquery
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// serveUDP starts a local UDP server answering with a handler.
+func serveUDP(t *testing.T, address string, handler dns.HandlerFunc) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", address, err)
+	}
+	server := &dns.Server{PacketConn: conn, Handler: handler}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+
+	return conn.LocalAddr().String()
+}
+
+// zoneHandler answers authoritatively for a zone: referrals with the glue
+// it holds below its delegations, records, NODATA or NXDOMAIN otherwise.
+func zoneHandler(t *testing.T, zone string, lines ...string) dns.HandlerFunc {
+	t.Helper()
+
+	var records []dns.RR
+	for _, line := range lines {
+		rr, err := dns.NewRR(line)
+		if err != nil {
+			t.Fatalf("parse %q: %v", line, err)
+		}
+		records = append(records, rr)
+	}
+
+	return func(w dns.ResponseWriter, request *dns.Msg) {
+		question := request.Question[0]
+		name := dns.CanonicalName(question.Name)
+		reply := new(dns.Msg)
+		reply.SetReply(request)
+
+		for _, rr := range records {
+			owner := dns.CanonicalName(rr.Header().Name)
+			if ns, ok := rr.(*dns.NS); ok && owner != zone && dns.IsSubDomain(owner, name) {
+				reply.Ns = append(reply.Ns, ns)
+				for _, glue := range records {
+					if glue.Header().Rrtype != dns.TypeNS && dns.CanonicalName(glue.Header().Name) == dns.CanonicalName(ns.Ns) {
+						reply.Extra = append(reply.Extra, glue)
+					}
+				}
+			}
+		}
+		if len(reply.Ns) > 0 {
+			_ = w.WriteMsg(reply)
+			return
+		}
+
+		reply.Authoritative = true
+		reply.Rcode = dns.RcodeNameError
+		for _, rr := range records {
+			if dns.CanonicalName(rr.Header().Name) != name {
+				continue
+			}
+			reply.Rcode = dns.RcodeSuccess
+			if rr.Header().Rrtype == question.Qtype {
+				reply.Answer = append(reply.Answer, rr)
+			}
+		}
+		_ = w.WriteMsg(reply)
+	}
+}
+
+// lameHandler fails every query with an rcode.
+func lameHandler(rcode int) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, request *dns.Msg) {
+		reply := new(dns.Msg)
+		reply.SetRcode(request, rcode)
+		_ = w.WriteMsg(reply)
+	}
+}
+
+func TestTracerTrace(t *testing.T) {
+	// The root delegates test. to two lame servers before a working one, and
+	// other. to a server that holds the address of the out-of-bailiwick
+	// nameserver of example.test., which test. delegates without glue.
+	root := serveUDP(t, "127.0.0.2:0", zoneHandler(t, ".",
+		"test. 300 IN NS refused.nic.test.",
+		"test. 300 IN NS servfail.nic.test.",
+		"test. 300 IN NS ns.nic.test.",
+		"refused.nic.test. 300 IN A 127.0.0.3",
+		"servfail.nic.test. 300 IN A 127.0.0.4",
+		"ns.nic.test. 300 IN A 127.0.0.5",
+		"other. 300 IN NS ns.nic.other.",
+		"ns.nic.other. 300 IN A 127.0.0.6",
+	))
+	_, port, _ := net.SplitHostPort(root)
+	serveUDP(t, "127.0.0.3:"+port, lameHandler(dns.RcodeRefused))
+	serveUDP(t, "127.0.0.4:"+port, lameHandler(dns.RcodeServerFailure))
+	serveUDP(t, "127.0.0.5:"+port, zoneHandler(t, "test.",
+		"example.test. 300 IN NS ns.example.other.",
+	))
+	serveUDP(t, "127.0.0.6:"+port, zoneHandler(t, "other.",
+		"ns.example.other. 300 IN A 127.0.0.7",
+	))
+	serveUDP(t, "127.0.0.7:"+port, zoneHandler(t, "example.test.",
+		"example.test. 300 IN NS ns.example.other.",
+		"www.example.test. 300 IN A 192.0.2.1",
+	))
+
+	roots, err := RootHintsFromAddresses([]string{"127.0.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hops, err := NewTracer(nil, roots, port).Trace(context.Background(), "www.example.test", dns.TypeA)
+	if err != nil {
+		t.Fatalf("trace: %v", err)
+	}
+
+	var path []string
+	for _, hop := range hops {
+		path = append(path, hop.Zone+" "+hop.Server+" "+strings.TrimSuffix(hop.Address, ":"+port)+" "+hop.ReferralZone)
+	}
+	want := []string{
+		". 127.0.0.2 127.0.0.2 test.",
+		"test. ns.nic.test. 127.0.0.5 example.test.",
+		"example.test. ns.example.other. 127.0.0.7 ",
+	}
+	if !slices.Equal(path, want) {
+		t.Fatalf("hops = %q, want %q", path, want)
+	}
+
+	if len(hops[0].Glue) != 3 {
+		t.Errorf("root glue = %v, want the addresses of the three test. servers", hops[0].Glue)
+	}
+	if len(hops[1].Glue) != 0 || !slices.Equal(hops[1].Resolved["ns.example.other."], []string{"127.0.0.7"}) {
+		t.Errorf("test. referral glue %v resolved %v, want ns.example.other. resolved from the root", hops[1].Glue, hops[1].Resolved)
+	}
+	if answer := hops[2].Answer; len(answer) != 1 || answer[0].(*dns.A).A.String() != "192.0.2.1" {
+		t.Errorf("answer = %v, want the A record of www.example.test.", answer)
+	}
+}
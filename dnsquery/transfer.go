@@ -0,0 +1,288 @@
+package dnsquery
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const tsigFudge = 300
+
+// tsigAlgorithms maps the short algorithm names accepted on the command line
+// to their TSIG names.
+var tsigAlgorithms = map[string]string{
+	"hmac-sha1":   dns.HmacSHA1,
+	"hmac-sha224": dns.HmacSHA224,
+	"hmac-sha256": dns.HmacSHA256,
+	"hmac-sha384": dns.HmacSHA384,
+	"hmac-sha512": dns.HmacSHA512,
+}
+
+// firstMessageConn keeps a copy of the first DNS message read from a TCP
+// connection, so the rcode of a refused transfer can be read from the answer
+// itself rather than from the error of dns.Transfer.
+type firstMessageConn struct {
+	net.Conn
+	data []byte
+}
+
+type TSIG struct {
+	Name      string
+	Algorithm string
+	Secret    string
+}
+
+type TransferRequest struct {
+	Zone    string
+	Server  string
+	Serial  uint32
+	IXFR    bool
+	TSIG    *TSIG
+	Timeout time.Duration
+}
+
+type IXFRChange struct {
+	FromSerial uint32
+	ToSerial   uint32
+	Deleted    []dns.RR
+	Added      []dns.RR
+}
+
+type Transfer struct {
+	Zone          string
+	Server        string
+	Type          string
+	Serial        uint32
+	Records       []dns.RR
+	Incremental   bool
+	Changes       []IXFRChange
+	Envelopes     int
+	Duration      time.Duration
+	Authenticated bool
+}
+
+// ParseTSIG parses a TSIG key in the dig -y format [algorithm:]name:secret.
+//
+// Args:
+//   - key: The key, the algorithm defaulting to hmac-sha256.
+//
+// Returns:
+//   - *TSIG: The TSIG key.
+//   - error: An error if the key is malformed or the algorithm is unknown.
+func ParseTSIG(key string) (*TSIG, error) {
+	parts := strings.Split(key, ":")
+	algorithm := "hmac-sha256"
+	switch len(parts) {
+	case 2:
+	case 3:
+		algorithm, parts = strings.ToLower(parts[0]), parts[1:]
+	default:
+		return nil, fmt.Errorf("TSIG key must be [algorithm:]name:secret")
+	}
+
+	name, ok := tsigAlgorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown TSIG algorithm '%s', use hmac-sha1, hmac-sha224, hmac-sha256, hmac-sha384 or hmac-sha512", algorithm)
+	}
+	if parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("TSIG key must be [algorithm:]name:secret")
+	}
+
+	return &TSIG{Name: dns.Fqdn(parts[0]), Algorithm: name, Secret: parts[1]}, nil
+}
+
+// ZoneTransfer requests a full (AXFR) or incremental (IXFR) transfer of a zone
+// over TCP, signing the request with TSIG when a key is given.
+//
+// Args:
+//   - ctx: The context bounding the transfer.
+//   - request: The zone, server, transfer type and key.
+//
+// Returns:
+//   - Transfer: Every record received and, for IXFR, the parsed changes.
+//   - error: A *RcodeError if the server refuses the transfer, a *TimeoutError
+//     if it does not answer in time, or an error for malformed or badly signed answers.
+func ZoneTransfer(ctx context.Context, request TransferRequest) (Transfer, error) {
+	zone := dns.Fqdn(request.Zone)
+	server, err := normalizeUpstream(request.Server, DefaultPort)
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	timeout := request.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	m := new(dns.Msg)
+	transferType := "AXFR"
+	if request.IXFR {
+		transferType = "IXFR"
+		m.SetIxfr(zone, request.Serial, ".", ".")
+	} else {
+		m.SetAxfr(zone)
+	}
+
+	transfer := &dns.Transfer{DialTimeout: timeout, ReadTimeout: timeout, WriteTimeout: timeout}
+	if request.TSIG != nil {
+		transfer.TsigSecret = map[string]string{request.TSIG.Name: request.TSIG.Secret}
+		m.SetTsig(request.TSIG.Name, request.TSIG.Algorithm, tsigFudge, time.Now().Unix())
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return Transfer{}, classify(server, err)
+	}
+	defer conn.Close()
+	// The Transfer API has no context, so closing the connection is what
+	// interrupts a transfer in progress.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	first := &firstMessageConn{Conn: conn}
+	transfer.Conn = &dns.Conn{Conn: first}
+
+	start := time.Now()
+	envelopes, err := transfer.In(m, server)
+	if err != nil {
+		return Transfer{}, classify(server, err)
+	}
+
+	result := Transfer{
+		Zone:          zone,
+		Server:        server,
+		Type:          transferType,
+		Authenticated: request.TSIG != nil,
+	}
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			if ctx.Err() != nil {
+				return Transfer{}, classify(server, ctx.Err())
+			}
+
+			if reply := first.message(); reply != nil && reply.Rcode != dns.RcodeSuccess {
+				return Transfer{}, &RcodeError{Rcode: reply.Rcode, Response: Response{Msg: reply, Server: server, Transport: "tcp"}}
+			}
+			return Transfer{}, fmt.Errorf("%s of %s from %s: %w", transferType, zone, server, envelope.Error)
+		}
+		result.Envelopes++
+		result.Records = append(result.Records, envelope.RR...)
+	}
+	result.Duration = time.Since(start)
+
+	if len(result.Records) == 0 {
+		return Transfer{}, fmt.Errorf("%s of %s from %s returned no records", transferType, zone, server)
+	}
+	if soa, ok := result.Records[0].(*dns.SOA); ok {
+		result.Serial = soa.Serial
+	}
+	if request.IXFR {
+		result.Incremental, result.Changes = parseIXFR(result.Records)
+	}
+
+	return result, nil
+}
+
+// Read reads from the connection, copying the bytes of the first message.
+//
+// Args:
+//   - b: The buffer.
+//
+// Returns:
+//   - int: The number of bytes read.
+//   - error: The error of the connection.
+func (F *firstMessageConn) Read(b []byte) (int, error) {
+	n, err := F.Conn.Read(b)
+	if !F.complete() {
+		F.data = append(F.data, b[:n]...)
+	}
+
+	return n, err
+}
+
+// complete reports whether the first message has been read in full.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - bool: Whether the length prefix and the whole message were read.
+func (F *firstMessageConn) complete() bool {
+	return len(F.data) >= 2 && len(F.data) >= 2+int(binary.BigEndian.Uint16(F.data))
+}
+
+// message unpacks the first message read.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - *dns.Msg: The message, nil if it was not read in full or is malformed.
+func (F *firstMessageConn) message() *dns.Msg {
+	if !F.complete() {
+		return nil
+	}
+
+	m := new(dns.Msg)
+	if err := m.Unpack(F.data[2 : 2+int(binary.BigEndian.Uint16(F.data))]); err != nil {
+		return nil
+	}
+
+	return m
+}
+
+// parseIXFR splits an incremental transfer (RFC 1995) into its changes. A
+// server may answer an IXFR with the full zone, which is reported as not
+// incremental.
+//
+// Args:
+//   - records: The records of the transfer, starting and ending with the new SOA.
+//
+// Returns:
+//   - bool: Whether the answer is an incremental transfer.
+//   - []IXFRChange: The changes from the requested serial to the current one.
+func parseIXFR(records []dns.RR) (bool, []IXFRChange) {
+	// A single SOA means the requested serial is already current.
+	if len(records) == 1 {
+		return true, nil
+	}
+	if _, ok := records[1].(*dns.SOA); !ok {
+		return false, nil
+	}
+
+	// Between the opening and closing SOA, each change starts with the old
+	// SOA followed by the deleted records, then the new SOA followed by the
+	// added records.
+	var (
+		changes []IXFRChange
+		soas    int
+	)
+	for _, rr := range records[1 : len(records)-1] {
+		if soa, ok := rr.(*dns.SOA); ok {
+			if soas%2 == 0 {
+				changes = append(changes, IXFRChange{FromSerial: soa.Serial})
+			} else {
+				changes[len(changes)-1].ToSerial = soa.Serial
+			}
+			soas++
+			continue
+		}
+		if len(changes) == 0 {
+			continue
+		}
+
+		change := &changes[len(changes)-1]
+		if soas%2 == 1 {
+			change.Deleted = append(change.Deleted, rr)
+		} else {
+			change.Added = append(change.Added, rr)
+		}
+	}
+
+	return true, changes
+}
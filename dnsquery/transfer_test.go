@@ -0,0 +1,92 @@
+package dnsquery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// transferServer starts a local TCP server answering transfers of
+// example.test. over two messages and refusing or failing the others.
+func transferServer(t *testing.T) string {
+	t.Helper()
+
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, request *dns.Msg) {
+		reply := new(dns.Msg)
+		reply.SetReply(request)
+		switch request.Question[0].Name {
+		case "refused.test.":
+			reply.Rcode = dns.RcodeRefused
+		case "notauth.test.":
+			reply.Rcode = dns.RcodeNotAuth
+		case "example.test.":
+			soa, _ := dns.NewRR("example.test. 300 IN SOA ns1.example.test. hostmaster.example.test. 7 3600 600 86400 300")
+			a, _ := dns.NewRR("www.example.test. 300 IN A 192.0.2.1")
+			reply.Answer = []dns.RR{soa, a}
+			_ = w.WriteMsg(reply)
+			reply = new(dns.Msg)
+			reply.SetReply(request)
+			reply.Answer = []dns.RR{soa}
+		}
+		_ = w.WriteMsg(reply)
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen on tcp: %v", err)
+	}
+	server := &dns.Server{Listener: listener, Handler: handler}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+
+	return listener.Addr().String()
+}
+
+func TestZoneTransfer(t *testing.T) {
+	address := transferServer(t)
+
+	tests := []struct {
+		zone      string
+		wantRcode int
+		wantRRs   int
+	}{
+		{zone: "example.test", wantRRs: 3},
+		{zone: "refused.test", wantRcode: dns.RcodeRefused},
+		{zone: "notauth.test", wantRcode: dns.RcodeNotAuth},
+	}
+
+	for _, test := range tests {
+		t.Run(test.zone, func(t *testing.T) {
+			request := TransferRequest{Zone: test.zone, Server: address, Timeout: 2 * time.Second}
+			transfer, err := ZoneTransfer(context.Background(), request)
+
+			if test.wantRcode != dns.RcodeSuccess {
+				var rcodeErr *RcodeError
+				if !errors.As(err, &rcodeErr) {
+					t.Fatalf("error = %v, want a *RcodeError", err)
+				}
+				if rcodeErr.Rcode != test.wantRcode || rcodeErr.Response.Msg.Rcode != test.wantRcode {
+					t.Errorf("rcode = %s, want %s", dns.RcodeToString[rcodeErr.Rcode], dns.RcodeToString[test.wantRcode])
+				}
+				if rcodeErr.Response.Msg.Question[0].Name != test.zone+"." {
+					t.Errorf("question = %s, want the one of the server answer", rcodeErr.Response.Msg.Question[0].Name)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if len(transfer.Records) != test.wantRRs || transfer.Envelopes != 2 || transfer.Serial != 7 {
+				t.Errorf("got %d records in %d envelopes with serial %d", len(transfer.Records), transfer.Envelopes, transfer.Serial)
+			}
+		})
+	}
+}
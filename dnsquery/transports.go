@@ -0,0 +1,494 @@
+package dnsquery
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	dohPath          = "/dns-query"
+	dohContentType   = "application/dns-message"
+	maxDnsMessageLen = 65535
+)
+
+// Transport sends a single DNS message to a server and returns its answer.
+type Transport interface {
+	Name() string
+	DefaultPort() string
+	Exchange(ctx context.Context, m *dns.Msg, server string) (*dns.Msg, Timings, error)
+}
+
+type Timings struct {
+	Handshake time.Duration
+	Query     time.Duration
+}
+
+type TLSOptions struct {
+	ServerName string
+	CAFile     string
+	Insecure   bool
+}
+
+type plainTransport struct {
+	timeout time.Duration
+}
+
+type streamTransport struct {
+	timeout    time.Duration
+	tlsOptions *TLSOptions
+}
+
+type httpsTransport struct {
+	timeout time.Duration
+	method  string
+	client  *http.Client
+}
+
+type quicTransport struct {
+	timeout    time.Duration
+	tlsOptions TLSOptions
+}
+
+// NewTransport returns the transport registered under the given name.
+//
+// Args:
+//   - name: One of udp, tcp, tls, https or quic.
+//   - method: The HTTP method used by the https transport, GET or POST.
+//   - tlsOptions: The TLS options used by the tls, https and quic transports.
+//   - timeout: The timeout applied to dialing, handshakes and queries.
+//
+// Returns:
+//   - Transport: The transport.
+//   - error: An error if the name or method is unknown or the CA bundle cannot be read.
+func NewTransport(name, method string, tlsOptions TLSOptions, timeout time.Duration) (Transport, error) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	switch strings.ToLower(name) {
+	case "", "udp":
+		return plainTransport{timeout: timeout}, nil
+	case "tcp":
+		return streamTransport{timeout: timeout}, nil
+	case "tls", "dot":
+		if _, err := tlsOptions.config("", nil); err != nil {
+			return nil, err
+		}
+		return streamTransport{timeout: timeout, tlsOptions: &tlsOptions}, nil
+	case "https", "doh":
+		method = strings.ToUpper(method)
+		if method != http.MethodGet && method != http.MethodPost {
+			return nil, fmt.Errorf("unsupported DoH method '%s', use GET or POST", method)
+		}
+
+		config, err := tlsOptions.config("", nil)
+		if err != nil {
+			return nil, err
+		}
+		config.ServerName = tlsOptions.ServerName
+
+		client := &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				TLSClientConfig:   config,
+				ForceAttemptHTTP2: true,
+				Proxy:             http.ProxyFromEnvironment,
+			},
+		}
+		return httpsTransport{timeout: timeout, method: method, client: client}, nil
+	case "quic", "doq":
+		if _, err := tlsOptions.config("", nil); err != nil {
+			return nil, err
+		}
+		return quicTransport{timeout: timeout, tlsOptions: tlsOptions}, nil
+	}
+
+	return nil, fmt.Errorf("unknown transport '%s', use udp, tcp, tls, https or quic", name)
+}
+
+// config builds the TLS client configuration for a server.
+//
+// Args:
+//   - server: The host:port being dialed, used for SNI when no server name is set.
+//   - nextProtos: The ALPN protocols to offer.
+//
+// Returns:
+//   - *tls.Config: The TLS configuration.
+//   - error: An error if the CA bundle cannot be read or contains no certificates.
+func (T TLSOptions) config(server string, nextProtos []string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         T.ServerName,
+		InsecureSkipVerify: T.Insecure,
+		NextProtos:         nextProtos,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if config.ServerName == "" && server != "" {
+		if host, _, err := net.SplitHostPort(server); err == nil {
+			config.ServerName = host
+		}
+	}
+
+	if T.CAFile != "" {
+		bundle, err := os.ReadFile(T.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA bundle '%s': %w", T.CAFile, err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates found in CA bundle '%s'", T.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
+
+// Name returns the transport name.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - string: The transport name.
+func (P plainTransport) Name() string {
+	return "udp"
+}
+
+// DefaultPort returns the port used when a server has none.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - string: The default port.
+func (P plainTransport) DefaultPort() string {
+	return DefaultPort
+}
+
+// Exchange sends the message over UDP.
+//
+// Args:
+//   - ctx: The context bounding the query.
+//   - m: The DNS message.
+//   - server: The server address as host:port.
+//
+// Returns:
+//   - *dns.Msg: The answer.
+//   - Timings: The query time.
+//   - error: An error if the query failed.
+func (P plainTransport) Exchange(ctx context.Context, m *dns.Msg, server string) (*dns.Msg, Timings, error) {
+	c := &dns.Client{Net: "udp", Timeout: P.timeout}
+	in, rtt, err := c.ExchangeContext(ctx, m, server)
+
+	return in, Timings{Query: rtt}, err
+}
+
+// Name returns the transport name.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - string: The transport name.
+func (S streamTransport) Name() string {
+	if S.tlsOptions != nil {
+		return "tls"
+	}
+	return "tcp"
+}
+
+// DefaultPort returns the port used when a server has none.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - string: The default port.
+func (S streamTransport) DefaultPort() string {
+	if S.tlsOptions != nil {
+		return "853"
+	}
+	return DefaultPort
+}
+
+// Exchange sends the message over TCP, or DNS-over-TLS (RFC 7858) when TLS
+// options are set. The handshake time covers the TCP connect and, for TLS,
+// the TLS handshake.
+//
+// Args:
+//   - ctx: The context bounding the query.
+//   - m: The DNS message.
+//   - server: The server address as host:port.
+//
+// Returns:
+//   - *dns.Msg: The answer.
+//   - Timings: The handshake and query times.
+//   - error: An error if the connection or query failed.
+func (S streamTransport) Exchange(ctx context.Context, m *dns.Msg, server string) (*dns.Msg, Timings, error) {
+	var timings Timings
+
+	ctx, cancel := context.WithTimeout(ctx, S.timeout)
+	defer cancel()
+
+	var dialer interface {
+		DialContext(ctx context.Context, network, address string) (net.Conn, error)
+	} = &net.Dialer{}
+	if S.tlsOptions != nil {
+		config, err := S.tlsOptions.config(server, []string{"dot"})
+		if err != nil {
+			return nil, timings, err
+		}
+		dialer = &tls.Dialer{Config: config}
+	}
+
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, timings, err
+	}
+	defer conn.Close()
+	timings.Handshake = time.Since(start)
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, timings, err
+	}
+
+	co := &dns.Conn{Conn: conn}
+	start = time.Now()
+	if err := co.WriteMsg(m); err != nil {
+		return nil, timings, err
+	}
+
+	in, err := co.ReadMsg()
+	timings.Query = time.Since(start)
+	if err != nil {
+		return nil, timings, err
+	}
+	if in.Id != m.Id {
+		return nil, timings, dns.ErrId
+	}
+
+	return in, timings, nil
+}
+
+// Name returns the transport name.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - string: The transport name.
+func (H httpsTransport) Name() string {
+	return "https"
+}
+
+// DefaultPort returns the port used when a server has none.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - string: The default port.
+func (H httpsTransport) DefaultPort() string {
+	return "443"
+}
+
+// Exchange sends the message as a DNS-over-HTTPS (RFC 8484) GET or POST
+// request. The server may be a full URL or a host:port, in which case the
+// /dns-query path is used.
+//
+// Args:
+//   - ctx: The context bounding the query.
+//   - m: The DNS message.
+//   - server: The server URL or address.
+//
+// Returns:
+//   - *dns.Msg: The answer.
+//   - Timings: The connection setup and query times.
+//   - error: An error if the request failed or the reply is not a DNS message.
+func (H httpsTransport) Exchange(ctx context.Context, m *dns.Msg, server string) (*dns.Msg, Timings, error) {
+	var timings Timings
+
+	query := m.Copy()
+	query.Id = 0
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, timings, err
+	}
+
+	url := server
+	if !strings.Contains(url, "://") {
+		url = "https://" + server + dohPath
+	}
+
+	var request *http.Request
+	if H.method == http.MethodGet {
+		separator := "?"
+		if strings.Contains(url, "?") {
+			separator = "&"
+		}
+		request, err = http.NewRequestWithContext(ctx, http.MethodGet, url+separator+"dns="+base64.RawURLEncoding.EncodeToString(packed), nil)
+	} else {
+		request, err = http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(packed))
+		if err == nil {
+			request.Header.Set("Content-Type", dohContentType)
+		}
+	}
+	if err != nil {
+		return nil, timings, err
+	}
+	request.Header.Set("Accept", dohContentType)
+
+	start := time.Now()
+	var connected time.Time
+	trace := &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) { connected = time.Now() },
+	}
+	request = request.WithContext(httptrace.WithClientTrace(request.Context(), trace))
+
+	response, err := H.client.Do(request)
+	if err != nil {
+		return nil, timings, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxDnsMessageLen))
+	total := time.Since(start)
+	if !connected.IsZero() {
+		timings.Handshake = connected.Sub(start)
+	}
+	timings.Query = total - timings.Handshake
+	if err != nil {
+		return nil, timings, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, timings, fmt.Errorf("DoH server returned HTTP %d", response.StatusCode)
+	}
+	if contentType := response.Header.Get("Content-Type"); !strings.HasPrefix(contentType, dohContentType) {
+		return nil, timings, fmt.Errorf("DoH server returned content type '%s'", contentType)
+	}
+
+	in := new(dns.Msg)
+	if err := in.Unpack(body); err != nil {
+		return nil, timings, err
+	}
+	in.Id = m.Id
+
+	return in, timings, nil
+}
+
+// Name returns the transport name.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - string: The transport name.
+func (Q quicTransport) Name() string {
+	return "quic"
+}
+
+// DefaultPort returns the port used when a server has none.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - string: The default port.
+func (Q quicTransport) DefaultPort() string {
+	return "853"
+}
+
+// Exchange sends the message over DNS-over-QUIC (RFC 9250) on a fresh
+// connection, one query per stream.
+//
+// Args:
+//   - ctx: The context bounding the query.
+//   - m: The DNS message.
+//   - server: The server address as host:port.
+//
+// Returns:
+//   - *dns.Msg: The answer.
+//   - Timings: The handshake and query times.
+//   - error: An error if the connection or query failed.
+func (Q quicTransport) Exchange(ctx context.Context, m *dns.Msg, server string) (*dns.Msg, Timings, error) {
+	var timings Timings
+
+	config, err := Q.tlsOptions.config(server, []string{"doq"})
+	if err != nil {
+		return nil, timings, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, Q.timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := quic.DialAddr(ctx, server, config, &quic.Config{HandshakeIdleTimeout: Q.timeout})
+	if err != nil {
+		return nil, timings, err
+	}
+	defer conn.CloseWithError(0, "")
+	timings.Handshake = time.Since(start)
+
+	query := m.Copy()
+	query.Id = 0
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, timings, err
+	}
+
+	start = time.Now()
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, timings, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	frame := make([]byte, 2, 2+len(packed))
+	binary.BigEndian.PutUint16(frame, uint16(len(packed)))
+	if _, err := stream.Write(append(frame, packed...)); err != nil {
+		return nil, timings, err
+	}
+	// Closing the stream only closes our sending side, which tells the
+	// server the query is complete.
+	stream.Close()
+
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(stream, length); err != nil {
+		return nil, timings, err
+	}
+	body := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(stream, body); err != nil {
+		return nil, timings, err
+	}
+	timings.Query = time.Since(start)
+
+	in := new(dns.Msg)
+	if err := in.Unpack(body); err != nil {
+		return nil, timings, err
+	}
+	in.Id = m.Id
+
+	return in, timings, nil
+}
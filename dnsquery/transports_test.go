@@ -0,0 +1,208 @@
+package dnsquery
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// serverDelay is how long the test servers wait before answering, so the
+// query timing can be told apart from the handshake.
+const serverDelay = 100 * time.Millisecond
+
+// writeCA writes a certificate as a PEM CA bundle and returns its path.
+func writeCA(t *testing.T, der []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// selfSigned generates a certificate for 127.0.0.1 and dns.test and the CA
+// bundle holding it.
+func selfSigned(t *testing.T) (tls.Certificate, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dns.test"},
+		DNSNames:              []string{"dns.test"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, writeCA(t, der)
+}
+
+// answer replies to a query with an A record after the server delay.
+func answer(request *dns.Msg) *dns.Msg {
+	time.Sleep(serverDelay)
+
+	reply := new(dns.Msg)
+	reply.SetReply(request)
+	reply.Answer = append(reply.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: request.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.IPv4(192, 0, 2, 1),
+	})
+
+	return reply
+}
+
+// checkExchange sends a query through a transport and checks the answer and
+// the timings it reports.
+func checkExchange(t *testing.T, transport Transport, server string) {
+	t.Helper()
+
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.test.", dns.TypeA)
+	in, timings, err := transport.Exchange(context.Background(), m, server)
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if in.Id != m.Id || len(in.Answer) != 1 {
+		t.Errorf("answer id %d with %d records, want id %d with 1 record", in.Id, len(in.Answer), m.Id)
+	}
+	if timings.Handshake <= 0 || timings.Handshake >= serverDelay {
+		t.Errorf("handshake = %s, want a positive time below the server delay", timings.Handshake)
+	}
+	if timings.Query < serverDelay {
+		t.Errorf("query = %s, want at least the server delay %s", timings.Query, serverDelay)
+	}
+}
+
+func TestHTTPSTransport(t *testing.T) {
+	methods := make(chan string, 1)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			packed []byte
+			err    error
+		)
+		switch {
+		case r.URL.Path != dohPath:
+			http.NotFound(w, r)
+			return
+		case r.Method == http.MethodGet:
+			packed, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		case r.Header.Get("Content-Type") == dohContentType:
+			packed, err = io.ReadAll(r.Body)
+		default:
+			http.Error(w, "unsupported media type", http.StatusUnsupportedMediaType)
+			return
+		}
+		request := new(dns.Msg)
+		if err == nil {
+			err = request.Unpack(packed)
+		}
+		if err != nil || request.Id != 0 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		methods <- r.Method
+
+		reply, _ := answer(request).Pack()
+		w.Header().Set("Content-Type", dohContentType)
+		_, _ = w.Write(reply)
+	}))
+	defer server.Close()
+	ca := writeCA(t, server.Certificate().Raw)
+	address := strings.TrimPrefix(server.URL, "https://")
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		t.Run(method, func(t *testing.T) {
+			transport, err := NewTransport("https", method, TLSOptions{CAFile: ca}, 2*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkExchange(t, transport, address)
+			if got := <-methods; got != method {
+				t.Errorf("the server got a %s request, want %s", got, method)
+			}
+		})
+	}
+}
+
+func TestTLSTransport(t *testing.T) {
+	certificate, ca := selfSigned(t)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{certificate}})
+	if err != nil {
+		t.Skipf("cannot listen on tcp: %v", err)
+	}
+	server := &dns.Server{
+		Net:      "tcp-tls",
+		Listener: listener,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, request *dns.Msg) {
+			_ = w.WriteMsg(answer(request))
+		}),
+	}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+	defer server.Shutdown()
+	address := listener.Addr().String()
+
+	tests := []struct {
+		name    string
+		options TLSOptions
+		wantErr bool
+	}{
+		{name: "ca bundle", options: TLSOptions{CAFile: ca}},
+		{name: "server name", options: TLSOptions{CAFile: ca, ServerName: "dns.test"}},
+		{name: "wrong server name", options: TLSOptions{CAFile: ca, ServerName: "other.test"}, wantErr: true},
+		{name: "untrusted", options: TLSOptions{}, wantErr: true},
+		{name: "insecure", options: TLSOptions{Insecure: true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transport, err := NewTransport("tls", "", test.options, 2*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.wantErr {
+				m := new(dns.Msg)
+				m.SetQuestion("www.example.test.", dns.TypeA)
+				if _, _, err := transport.Exchange(context.Background(), m, address); err == nil {
+					t.Error("exchange succeeded without a trusted certificate")
+				}
+				return
+			}
+			checkExchange(t, transport, address)
+		})
+	}
+}
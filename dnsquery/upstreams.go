@@ -0,0 +1,425 @@
+package dnsquery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	DefaultUpstream   = "8.8.8.8"
+	DefaultResolvConf = "/etc/resolv.conf"
+	DefaultPort       = "53"
+	DefaultTimeout    = 2 * time.Second
+)
+
+type Upstreams struct {
+	transport Transport
+	servers   []string
+	search    []string
+	ndots     int
+	attempts  int
+	dnssec    bool
+	edns      EDNS
+	ignoreTC  bool
+	timeout   time.Duration
+}
+
+// NewUpstreams builds the upstream set from a list of host[:port] servers.
+//
+// Args:
+//   - servers: The upstream servers, tried in order.
+//   - transport: The transport used to reach them, UDP when nil.
+//
+// Returns:
+//   - Upstreams: The upstream set with a single attempt per server.
+//   - error: An error if any of the servers is not a valid host[:port].
+func NewUpstreams(servers []string, transport Transport) (Upstreams, error) {
+	if transport == nil {
+		transport = plainTransport{timeout: DefaultTimeout}
+	}
+	if len(servers) == 0 {
+		servers = []string{DefaultUpstream}
+	}
+
+	normalized := make([]string, 0, len(servers))
+	for _, server := range servers {
+		address, err := normalizeUpstream(server, transport.DefaultPort())
+		if err != nil {
+			return Upstreams{}, err
+		}
+		normalized = append(normalized, address)
+	}
+
+	return Upstreams{
+		transport: transport,
+		servers:   normalized,
+		ndots:     1,
+		attempts:  1,
+	}, nil
+}
+
+// LoadResolvConf builds the upstream set from a resolv.conf file, including
+// its search domains and the ndots, timeout and attempts options. The
+// nameservers are reached on the default port of the transport, and the
+// timeout option also bounds every query when a transport is given.
+//
+// Args:
+//   - path: The path to the resolv.conf file.
+//   - transport: The transport used to reach the nameservers, UDP when nil.
+//
+// Returns:
+//   - Upstreams: The upstream set described by the file.
+//   - error: An error if the file cannot be read or lists no nameservers.
+func LoadResolvConf(path string, transport Transport) (Upstreams, error) {
+	config, err := dns.ClientConfigFromFile(path)
+	if err != nil {
+		return Upstreams{}, fmt.Errorf("could not read '%s': %w", path, err)
+	}
+
+	if len(config.Servers) == 0 {
+		return Upstreams{}, fmt.Errorf("no nameservers found in '%s'", path)
+	}
+
+	timeout := time.Duration(config.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	port := config.Port
+	if transport == nil {
+		transport = plainTransport{timeout: timeout}
+	} else if _, plain := transport.(plainTransport); !plain {
+		port = transport.DefaultPort()
+	}
+
+	servers := make([]string, 0, len(config.Servers))
+	for _, server := range config.Servers {
+		servers = append(servers, net.JoinHostPort(server, port))
+	}
+
+	upstreams := Upstreams{
+		transport: transport,
+		servers:   servers,
+		search:    config.Search,
+		ndots:     config.Ndots,
+		attempts:  config.Attempts,
+		timeout:   timeout,
+	}
+
+	if upstreams.attempts <= 0 {
+		upstreams.attempts = 1
+	}
+
+	return upstreams, nil
+}
+
+// normalizeUpstream turns a host[:port] string into a dialable address.
+// URLs are kept as they are so DoH servers can use custom paths.
+//
+// Args:
+//   - server: The upstream server as host, host:port, IPv6, [IPv6]:port or URL.
+//   - defaultPort: The port used when the server has none.
+//
+// Returns:
+//   - string: The address in host:port form.
+//   - error: An error if the server or its port is invalid.
+func normalizeUpstream(server, defaultPort string) (string, error) {
+	server = strings.TrimSpace(server)
+	if server == "" {
+		return "", fmt.Errorf("empty upstream server")
+	}
+
+	if strings.Contains(server, "://") {
+		return server, nil
+	}
+
+	if ip := net.ParseIP(strings.Trim(server, "[]")); ip != nil {
+		return net.JoinHostPort(ip.String(), defaultPort), nil
+	}
+
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		return net.JoinHostPort(server, defaultPort), nil
+	}
+
+	if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+		return "", fmt.Errorf("invalid port '%s' for upstream server '%s'", port, server)
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+// candidateNames expands a name with the search domains, following the
+// resolv.conf ndots rule.
+//
+// Args:
+//   - name: The name to expand.
+//
+// Returns:
+//   - []string: The fully qualified names to try, in order.
+func (U Upstreams) candidateNames(name string) []string {
+	if dns.IsFqdn(name) || len(U.search) == 0 {
+		return []string{dns.Fqdn(name)}
+	}
+
+	names := make([]string, 0, len(U.search)+1)
+	hasNdots := dns.CountLabel(name) > U.ndots
+	if hasNdots {
+		names = append(names, dns.Fqdn(name))
+	}
+	for _, search := range U.search {
+		names = append(names, dns.Fqdn(name+"."+strings.TrimSuffix(search, ".")))
+	}
+	if !hasNdots {
+		names = append(names, dns.Fqdn(name))
+	}
+
+	return names
+}
+
+// Transport returns the transport used to reach the upstreams.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - Transport: The transport.
+func (U Upstreams) Transport() Transport {
+	return U.transport
+}
+
+// Servers returns the upstream addresses in the order they are tried.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - []string: The normalized host:port addresses or DoH URLs.
+func (U Upstreams) Servers() []string {
+	return slices.Clone(U.servers)
+}
+
+// WithDnssec returns a copy of the upstreams that sets the DO bit on lookups.
+//
+// Args:
+//   - dnssec: Whether to request DNSSEC records.
+//
+// Returns:
+//   - Upstreams: The upstream set.
+func (U Upstreams) WithDnssec(dnssec bool) Upstreams {
+	U.dnssec = dnssec
+	return U
+}
+
+// WithRetries returns a copy of the upstreams that asks every server again
+// after a timeout, an error or SERVFAIL.
+//
+// Args:
+//   - retries: The number of retries after the first attempt.
+//
+// Returns:
+//   - Upstreams: The upstream set.
+func (U Upstreams) WithRetries(retries int) Upstreams {
+	U.attempts = max(retries, 0) + 1
+	return U
+}
+
+// WithIgnoreTC returns a copy of the upstreams that keeps truncated UDP
+// answers instead of retrying them over TCP.
+//
+// Args:
+//   - ignoreTC: Whether to keep truncated answers.
+//
+// Returns:
+//   - Upstreams: The upstream set.
+func (U Upstreams) WithIgnoreTC(ignoreTC bool) Upstreams {
+	U.ignoreTC = ignoreTC
+	return U
+}
+
+// WithEDNS returns a copy of the upstreams that adds an OPT record with the
+// given options to lookups.
+//
+// Args:
+//   - edns: The EDNS options.
+//
+// Returns:
+//   - Upstreams: The upstream set.
+func (U Upstreams) WithEDNS(edns EDNS) Upstreams {
+	U.edns = edns
+	return U
+}
+
+// Lookup resolves a name through the upstreams.
+//
+// The query is sent to each upstream in order, moving on to the next one on
+// timeout or SERVFAIL. With search domains configured, every candidate name
+// is tried until one of them does not return NXDOMAIN.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//   - name: The name to resolve.
+//   - qtype: The query type.
+//
+// Returns:
+//   - Response: The DNS message and the server that answered it.
+//   - error: A *TypeError for unknown types, a *TimeoutError if no upstream
+//     answered in time, or a *RcodeError carrying the answer when its rcode is
+//     not NOERROR.
+func (U Upstreams) Lookup(ctx context.Context, name, qtype string) (Response, error) {
+	record, err := ParseType(qtype)
+	if err != nil {
+		return Response{}, err
+	}
+
+	if len(U.servers) == 0 {
+		U, _ = NewUpstreams(nil, nil)
+	}
+
+	var (
+		last    Response
+		lastErr error
+	)
+	for _, candidate := range U.candidateNames(name) {
+		m := new(dns.Msg)
+		m.SetQuestion(candidate, record)
+		m.RecursionDesired = true
+		U.edns.apply(m, U.dnssec)
+
+		response, err := U.Exchange(ctx, m)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		last = response
+		if response.Msg.Rcode != dns.RcodeNameError {
+			break
+		}
+	}
+
+	if last.Msg == nil {
+		return Response{}, lastErr
+	}
+	if last.Msg.Rcode != dns.RcodeSuccess {
+		return last, &RcodeError{Rcode: last.Msg.Rcode, Response: last}
+	}
+
+	return last, nil
+}
+
+// Exchange sends a message to the upstreams in order until one of them answers
+// with something other than SERVFAIL. Unlike Lookup it returns answers
+// whatever their rcode. Truncated UDP answers are retried over TCP.
+//
+// Args:
+//   - ctx: The context bounding every query.
+//   - m: The DNS message to send.
+//
+// Returns:
+//   - Response: The first usable answer, or the last SERVFAIL if none was usable.
+//   - error: An error if no upstream answered at all, a *TimeoutError when the
+//     last one timed out.
+func (U Upstreams) Exchange(ctx context.Context, m *dns.Msg) (Response, error) {
+	var (
+		servFail Response
+		lastErr  error = ErrNoAnswer
+	)
+	for attempt := 0; attempt < max(U.attempts, 1); attempt++ {
+		for _, server := range U.servers {
+			if err := ctx.Err(); err != nil {
+				return Response{}, classify(server, err)
+			}
+
+			in, timings, err := U.exchangeOnce(ctx, m, server)
+			if err != nil {
+				lastErr = classify(server, err)
+				continue
+			}
+
+			response := Response{
+				Msg:       in,
+				Server:    server,
+				Transport: U.transport.Name(),
+				Handshake: timings.Handshake,
+				Rtt:       timings.Query,
+			}
+			if in.Truncated && !U.ignoreTC {
+				response = U.retryOverTCP(ctx, m, response)
+			}
+			if response.Msg.Rcode == dns.RcodeServerFailure {
+				servFail = response
+				continue
+			}
+
+			return response, nil
+		}
+	}
+
+	if servFail.Msg != nil {
+		return servFail, nil
+	}
+
+	return Response{}, lastErr
+}
+
+// exchangeOnce sends a message to one server, bounded by the timeout of the
+// upstream set when it has one.
+//
+// Args:
+//   - ctx: The context bounding the query.
+//   - m: The DNS message.
+//   - server: The server.
+//
+// Returns:
+//   - *dns.Msg: The answer.
+//   - Timings: The handshake and query times.
+//   - error: An error if the query failed.
+func (U Upstreams) exchangeOnce(ctx context.Context, m *dns.Msg, server string) (*dns.Msg, Timings, error) {
+	if U.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, U.timeout)
+		defer cancel()
+	}
+
+	return U.transport.Exchange(ctx, m, server)
+}
+
+// retryOverTCP asks the server again over TCP after a truncated UDP answer.
+//
+// Args:
+//   - ctx: The context bounding the query.
+//   - m: The DNS message.
+//   - truncated: The truncated UDP response.
+//
+// Returns:
+//   - Response: The TCP response, or the truncated one if the transport is not
+//     UDP or the server cannot be reached over TCP.
+func (U Upstreams) retryOverTCP(ctx context.Context, m *dns.Msg, truncated Response) Response {
+	plain, ok := U.transport.(plainTransport)
+	if !ok {
+		return truncated
+	}
+
+	tcp := streamTransport{timeout: plain.timeout}
+	in, timings, err := tcp.Exchange(ctx, m, truncated.Server)
+	if err != nil {
+		return truncated
+	}
+
+	return Response{
+		Msg:         in,
+		Server:      truncated.Server,
+		Transport:   tcp.Name(),
+		Handshake:   timings.Handshake,
+		Rtt:         timings.Query,
+		TCPFallback: true,
+	}
+}
@@ -0,0 +1,409 @@
+package dnsquery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// fakeTransport answers every server with a scripted behaviour and records
+// the servers and names asked, in order.
+type fakeTransport struct {
+	behaviours map[string]string
+	asked      []string
+	names      []string
+}
+
+// Name returns the transport name.
+func (F *fakeTransport) Name() string {
+	return "fake"
+}
+
+// DefaultPort returns the port used when a server has none.
+func (F *fakeTransport) DefaultPort() string {
+	return DefaultPort
+}
+
+// Exchange answers as scripted for the server: "timeout" fails with a
+// deadline, "hang" waits for the context, "refused" fails, otherwise the
+// behaviour is the rcode name of the answer.
+func (F *fakeTransport) Exchange(ctx context.Context, m *dns.Msg, server string) (*dns.Msg, Timings, error) {
+	F.asked = append(F.asked, server)
+	F.names = append(F.names, m.Question[0].Name)
+
+	switch behaviour := F.behaviours[server]; behaviour {
+	case "timeout":
+		return nil, Timings{}, context.DeadlineExceeded
+	case "hang":
+		<-ctx.Done()
+		return nil, Timings{}, ctx.Err()
+	case "refused":
+		return nil, Timings{}, errors.New("connection refused")
+	default:
+		reply := new(dns.Msg)
+		reply.SetRcode(m, dns.StringToRcode[behaviour])
+		if behaviour == "NOERROR" {
+			reply.Answer = append(reply.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.IPv4(192, 0, 2, 1),
+			})
+		}
+		return reply, Timings{}, nil
+	}
+}
+
+// fakeUpstreams builds upstreams asking the servers in order over a fake
+// transport.
+func fakeUpstreams(behaviours ...string) (Upstreams, *fakeTransport) {
+	transport := &fakeTransport{behaviours: map[string]string{}}
+	var servers []string
+	for i, behaviour := range behaviours {
+		server := net.JoinHostPort(net.IPv4(192, 0, 2, byte(i+1)).String(), DefaultPort)
+		transport.behaviours[server] = behaviour
+		servers = append(servers, server)
+	}
+
+	upstreams, _ := NewUpstreams(servers, transport)
+
+	return upstreams, transport
+}
+
+func TestUpstreamsExchange(t *testing.T) {
+	tests := []struct {
+		name       string
+		behaviours []string
+		attempts   int
+		wantRcode  int
+		wantServer int
+		wantAsked  int
+		wantErr    func(error) bool
+	}{
+		{name: "first answers", behaviours: []string{"NOERROR", "NOERROR"}, wantRcode: dns.RcodeSuccess, wantServer: 0, wantAsked: 1},
+		{name: "timeout falls back", behaviours: []string{"timeout", "NOERROR"}, wantRcode: dns.RcodeSuccess, wantServer: 1, wantAsked: 2},
+		{name: "error falls back", behaviours: []string{"refused", "NOERROR"}, wantRcode: dns.RcodeSuccess, wantServer: 1, wantAsked: 2},
+		{name: "servfail falls back", behaviours: []string{"SERVFAIL", "NOERROR"}, wantRcode: dns.RcodeSuccess, wantServer: 1, wantAsked: 2},
+		{name: "nxdomain is an answer", behaviours: []string{"NXDOMAIN", "NOERROR"}, wantRcode: dns.RcodeNameError, wantServer: 0, wantAsked: 1},
+		{name: "last servfail is kept", behaviours: []string{"SERVFAIL", "timeout"}, wantRcode: dns.RcodeServerFailure, wantServer: 0, wantAsked: 2},
+		{name: "attempts go round again", behaviours: []string{"SERVFAIL", "timeout"}, attempts: 2, wantRcode: dns.RcodeServerFailure, wantServer: 0, wantAsked: 4},
+		{
+			name:       "every upstream timing out",
+			behaviours: []string{"refused", "timeout"},
+			wantAsked:  2,
+			wantErr: func(err error) bool {
+				var timeout *TimeoutError
+				return errors.As(err, &timeout) && timeout.Server == "192.0.2.2:53" && timeout.Timeout()
+			},
+		},
+		{
+			name:       "every upstream failing",
+			behaviours: []string{"timeout", "refused"},
+			wantAsked:  2,
+			wantErr: func(err error) bool {
+				var timeout *TimeoutError
+				return err != nil && !errors.As(err, &timeout)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			upstreams, transport := fakeUpstreams(test.behaviours...)
+			upstreams.attempts = max(test.attempts, 1)
+
+			m := new(dns.Msg)
+			m.SetQuestion("example.test.", dns.TypeA)
+			response, err := upstreams.Exchange(context.Background(), m)
+
+			if len(transport.asked) != test.wantAsked {
+				t.Errorf("asked %v, want %d queries", transport.asked, test.wantAsked)
+			}
+			if test.wantErr != nil {
+				if !test.wantErr(err) {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if response.Msg.Rcode != test.wantRcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[response.Msg.Rcode], dns.RcodeToString[test.wantRcode])
+			}
+			if want := upstreams.servers[test.wantServer]; response.Server != want {
+				t.Errorf("server = %s, want %s", response.Server, want)
+			}
+		})
+	}
+}
+
+func TestUpstreamsExchangeTimeout(t *testing.T) {
+	upstreams, transport := fakeUpstreams("hang", "NOERROR")
+	upstreams.timeout = 20 * time.Millisecond
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.test.", dns.TypeA)
+	response, err := upstreams.Exchange(context.Background(), m)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if response.Server != upstreams.servers[1] || len(transport.asked) != 2 {
+		t.Errorf("answered by %s after asking %v, want the second upstream", response.Server, transport.asked)
+	}
+}
+
+func TestUpstreamsExchangeCancelled(t *testing.T) {
+	upstreams, transport := fakeUpstreams("NOERROR")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.test.", dns.TypeA)
+	if _, err := upstreams.Exchange(ctx, m); !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want context.Canceled", err)
+	}
+	if len(transport.asked) != 0 {
+		t.Errorf("asked %v after the context was done", transport.asked)
+	}
+}
+
+func TestUpstreamsExchangeTruncated(t *testing.T) {
+	address := truncatingServer(t)
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.test.", dns.TypeA)
+
+	tests := []struct {
+		name          string
+		ignoreTC      bool
+		wantTruncated bool
+		wantTransport string
+	}{
+		{name: "retried over tcp", wantTransport: "tcp"},
+		{name: "kept when ignoring tc", ignoreTC: true, wantTruncated: true, wantTransport: "udp"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			upstreams, err := NewUpstreams([]string{address}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			response, err := upstreams.WithIgnoreTC(test.ignoreTC).Exchange(context.Background(), m)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if response.Msg.Truncated != test.wantTruncated || response.TCPFallback == test.wantTruncated {
+				t.Errorf("truncated = %t, fallback = %t", response.Msg.Truncated, response.TCPFallback)
+			}
+			if response.Transport != test.wantTransport {
+				t.Errorf("transport = %s, want %s", response.Transport, test.wantTransport)
+			}
+			if !test.wantTruncated && len(response.Msg.Answer) != 1 {
+				t.Errorf("answers = %d, want the full TCP answer", len(response.Msg.Answer))
+			}
+		})
+	}
+}
+
+func TestUpstreamsLookup(t *testing.T) {
+	tests := []struct {
+		name       string
+		behaviours []string
+		qtype      string
+		wantRcode  int
+		wantErr    func(error) bool
+	}{
+		{name: "answer", behaviours: []string{"NOERROR"}, qtype: "a", wantRcode: dns.RcodeSuccess},
+		{name: "servfail then answer", behaviours: []string{"SERVFAIL", "NOERROR"}, qtype: "A", wantRcode: dns.RcodeSuccess},
+		{
+			name:       "nxdomain",
+			behaviours: []string{"NXDOMAIN"},
+			qtype:      "A",
+			wantRcode:  dns.RcodeNameError,
+			wantErr: func(err error) bool {
+				var rcodeErr *RcodeError
+				return errors.As(err, &rcodeErr) && rcodeErr.Rcode == dns.RcodeNameError
+			},
+		},
+		{
+			name:       "only servfail",
+			behaviours: []string{"SERVFAIL", "SERVFAIL"},
+			qtype:      "A",
+			wantRcode:  dns.RcodeServerFailure,
+			wantErr: func(err error) bool {
+				var rcodeErr *RcodeError
+				return errors.As(err, &rcodeErr) && rcodeErr.Rcode == dns.RcodeServerFailure
+			},
+		},
+		{
+			name:       "timeout",
+			behaviours: []string{"timeout"},
+			qtype:      "A",
+			wantErr: func(err error) bool {
+				var timeout *TimeoutError
+				return errors.As(err, &timeout) && errors.Is(err, context.DeadlineExceeded)
+			},
+		},
+		{
+			name:       "unknown type",
+			behaviours: []string{"NOERROR"},
+			qtype:      "BOGUS",
+			wantErr: func(err error) bool {
+				var typeErr *TypeError
+				return errors.As(err, &typeErr) && typeErr.Type == "BOGUS"
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			upstreams, _ := fakeUpstreams(test.behaviours...)
+
+			response, err := upstreams.Lookup(context.Background(), "example.test", test.qtype)
+			if test.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if test.wantErr != nil && !test.wantErr(err) {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			response, err = Negative(response, err)
+			if response.Msg == nil {
+				if err == nil {
+					t.Error("Negative dropped the error of a failed lookup")
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Negative kept the error %v", err)
+			}
+			if response.Msg.Rcode != test.wantRcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[response.Msg.Rcode], dns.RcodeToString[test.wantRcode])
+			}
+		})
+	}
+}
+
+func TestUpstreamsLookupSearch(t *testing.T) {
+	upstreams, transport := fakeUpstreams("NXDOMAIN")
+	upstreams.search = []string{"corp.test", "example.test"}
+
+	if _, err := upstreams.Lookup(context.Background(), "host", "A"); err == nil {
+		t.Error("expected the NXDOMAIN of the last candidate")
+	}
+	want := []string{"host.corp.test.", "host.example.test.", "host."}
+	if !slices.Equal(transport.names, want) {
+		t.Errorf("asked %v, want %v", transport.names, want)
+	}
+}
+
+func TestParseType(t *testing.T) {
+	tests := []struct {
+		qtype string
+		want  uint16
+		err   bool
+	}{
+		{qtype: "A", want: dns.TypeA},
+		{qtype: "mx", want: dns.TypeMX},
+		{qtype: "Https", want: dns.TypeHTTPS},
+		{qtype: "", err: true},
+		{qtype: "NOPE", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.qtype, func(t *testing.T) {
+			got, err := ParseType(test.qtype)
+			var typeErr *TypeError
+			if test.err != errors.As(err, &typeErr) {
+				t.Fatalf("error = %v, want a *TypeError: %t", err, test.err)
+			}
+			if got != test.want {
+				t.Errorf("type = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	reply := new(dns.Msg)
+	reply.SetQuestion("example.test.", dns.TypeA)
+	rcodeErr := &RcodeError{Rcode: dns.RcodeNameError, Response: Response{Msg: reply, Server: "192.0.2.1:53"}}
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "type", err: &TypeError{Type: "NOPE"}, want: "unknown record type: NOPE"},
+		{name: "timeout", err: &TimeoutError{Server: "192.0.2.1:53", Err: context.DeadlineExceeded}, want: "192.0.2.1:53: timed out: context deadline exceeded"},
+		{name: "rcode", err: rcodeErr, want: "192.0.2.1:53 answered NXDOMAIN for example.test."},
+		{name: "classified timeout", err: classify("192.0.2.1:53", context.DeadlineExceeded), want: "192.0.2.1:53: timed out: context deadline exceeded"},
+		{name: "classified error", err: classify("192.0.2.1:53", errors.New("refused")), want: "192.0.2.1:53: refused"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.err.Error(); got != test.want {
+				t.Errorf("error = %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	var netErr net.Error
+	if !errors.As(classify("192.0.2.1:53", context.DeadlineExceeded), &netErr) || !netErr.Timeout() {
+		t.Error("a classified deadline is not a net.Error timeout")
+	}
+	if response, err := Negative(Response{}, rcodeErr); err != nil || response.Msg != reply {
+		t.Errorf("Negative = %v, %v, want the response of the *RcodeError", response, err)
+	}
+	if _, err := Negative(Response{}, ErrNoAnswer); !errors.Is(err, ErrNoAnswer) {
+		t.Errorf("Negative = %v, want ErrNoAnswer", err)
+	}
+}
+
+// truncatingServer starts a local server answering truncated over UDP and
+// in full over TCP, on the same port.
+func truncatingServer(t *testing.T) string {
+	t.Helper()
+
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, request *dns.Msg) {
+		reply := new(dns.Msg)
+		reply.SetReply(request)
+		if _, udp := w.LocalAddr().(*net.UDPAddr); udp {
+			reply.Truncated = true
+		} else {
+			reply.Answer = append(reply.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: request.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.IPv4(192, 0, 2, 1),
+			})
+		}
+		_ = w.WriteMsg(reply)
+	})
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen on tcp: %v", err)
+	}
+	udp, err := net.ListenPacket("udp", tcp.Addr().String())
+	if err != nil {
+		tcp.Close()
+		t.Skipf("cannot listen on udp: %v", err)
+	}
+
+	for _, server := range []*dns.Server{{Listener: tcp, Handler: handler}, {PacketConn: udp, Handler: handler}} {
+		started := make(chan struct{})
+		server.NotifyStartedFunc = func() { close(started) }
+		go func() { _ = server.ActivateAndServe() }()
+		<-started
+		t.Cleanup(func() { _ = server.Shutdown() })
+	}
+
+	return tcp.Addr().String()
+}
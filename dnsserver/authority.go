@@ -0,0 +1,318 @@
+package dnsserver
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEFollow is the number of CNAMEs followed inside the served zones
+// before the answer is returned as is.
+const maxCNAMEFollow = 8
+
+// Authority answers authoritatively for a set of zones, picking for every
+// question the zone with the longest origin that contains the name.
+type Authority struct {
+	zones map[string]*Zone
+}
+
+// NewAuthority builds an authority serving zones.
+//
+// Args:
+//   - zones: The zones, with distinct origins.
+//
+// Returns:
+//   - *Authority: The authority.
+//   - error: An error if two zones have the same origin.
+func NewAuthority(zones ...*Zone) (*Authority, error) {
+	authority := &Authority{zones: map[string]*Zone{}}
+	for _, zone := range zones {
+		if _, ok := authority.zones[zone.Origin]; ok {
+			return nil, fmt.Errorf("zone %s is loaded twice", zone.Origin)
+		}
+		authority.zones[zone.Origin] = zone
+	}
+
+	return authority, nil
+}
+
+// Zones returns the zones served.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - map[string]*Zone: The zones by origin.
+func (A *Authority) Zones() map[string]*Zone {
+	return A.zones
+}
+
+// Zone finds the zone a name belongs to.
+//
+// Args:
+//   - name: The name.
+//
+// Returns:
+//   - *Zone: The closest enclosing zone, nil when no zone contains the name.
+func (A *Authority) Zone(name string) *Zone {
+	for name = dns.CanonicalName(name); ; name = parent(name) {
+		if zone, ok := A.zones[name]; ok {
+			return zone
+		}
+		if name == "." {
+			return nil
+		}
+	}
+}
+
+// Answer builds the reply to a request. Questions outside of the served zones
+// are REFUSED, opcodes other than QUERY are NOTIMP.
+//
+// Args:
+//   - request: The request.
+//
+// Returns:
+//   - *dns.Msg: The reply.
+func (A *Authority) Answer(request *dns.Msg) *dns.Msg {
+	reply := new(dns.Msg)
+	reply.SetReply(request)
+	if opt := request.IsEdns0(); opt != nil {
+		reply.SetEdns0(max(opt.UDPSize(), dns.MinMsgSize), false)
+	}
+
+	switch {
+	case request.Opcode != dns.OpcodeQuery:
+		reply.Rcode = dns.RcodeNotImplemented
+		return reply
+	case len(request.Question) != 1:
+		reply.Rcode = dns.RcodeFormatError
+		return reply
+	}
+
+	question := request.Question[0]
+	name := dns.CanonicalName(question.Name)
+	zone := A.Zone(name)
+	if zone == nil || (question.Qclass != dns.ClassINET && question.Qclass != dns.ClassANY) {
+		reply.Rcode = dns.RcodeRefused
+		return reply
+	}
+
+	reply.Authoritative = true
+	for i := 0; i <= maxCNAMEFollow; i++ {
+		target, follow := zone.answer(reply, name, question.Qtype)
+		if !follow {
+			break
+		}
+		// The resolver chases targets outside of the served zones itself.
+		if zone = A.Zone(target); zone == nil {
+			break
+		}
+		name = target
+	}
+
+	return reply
+}
+
+// ServeDNS implements dns.Handler.
+//
+// Args:
+//   - w: The response writer.
+//   - request: The request.
+//
+// Returns:
+//   - None
+func (A *Authority) ServeDNS(w dns.ResponseWriter, request *dns.Msg) {
+	write(w, request, A.Answer(request))
+}
+
+// write sends a reply. UDP replies larger than the buffer size of the client,
+// 512 bytes without EDNS, are truncated.
+//
+// Args:
+//   - w: The response writer.
+//   - request: The request.
+//   - reply: The reply.
+//
+// Returns:
+//   - None
+func write(w dns.ResponseWriter, request, reply *dns.Msg) {
+	if _, ok := w.LocalAddr().(*net.UDPAddr); ok {
+		size := dns.MinMsgSize
+		if opt := request.IsEdns0(); opt != nil {
+			size = max(int(opt.UDPSize()), dns.MinMsgSize)
+		}
+		reply.Truncate(size)
+	}
+
+	_ = w.WriteMsg(reply)
+}
+
+// answer adds to a reply what the zone holds for a name, following RFC 1034
+// section 4.3.2: referrals with glue below zone cuts, the records or a CNAME
+// at the name, wildcard records, then NODATA or NXDOMAIN with the SOA.
+//
+// Args:
+//   - reply: The reply being built.
+//   - name: The name asked, inside the zone.
+//   - qtype: The type asked.
+//
+// Returns:
+//   - string: The target of a CNAME found at the name.
+//   - bool: Whether the target should be followed.
+func (Z *Zone) answer(reply *dns.Msg, name string, qtype uint16) (string, bool) {
+	if cut, ok := Z.zoneCut(name, qtype); ok {
+		// A referral from a CNAME followed keeps the AA flag of the CNAME.
+		reply.Authoritative = len(reply.Answer) > 0
+		reply.Ns = append(reply.Ns, Z.records[cut][dns.TypeNS]...)
+		for _, ns := range Z.records[cut][dns.TypeNS] {
+			reply.Extra = appendNew(reply.Extra, Z.addresses(ns.(*dns.NS).Ns)...)
+		}
+		return "", false
+	}
+
+	rrsets, owner := Z.records[name], name
+	if rrsets == nil && !Z.names[name] {
+		rrsets, owner = Z.wildcard(name)
+	}
+	if rrsets == nil {
+		if !Z.names[name] {
+			reply.Rcode = dns.RcodeNameError
+		}
+		reply.Ns = append(reply.Ns, Z.negativeSOA())
+		return "", false
+	}
+
+	if cname, ok := rrsets[dns.TypeCNAME]; ok && qtype != dns.TypeCNAME {
+		reply.Answer = append(reply.Answer, synthesize(cname, name, owner)...)
+		target := dns.CanonicalName(cname[0].(*dns.CNAME).Target)
+		return target, true
+	}
+
+	var answer []dns.RR
+	if qtype == dns.TypeANY {
+		for _, rtype := range slices.Sorted(maps.Keys(rrsets)) {
+			answer = append(answer, rrsets[rtype]...)
+		}
+	} else {
+		answer = rrsets[qtype]
+	}
+	if len(answer) == 0 {
+		reply.Ns = append(reply.Ns, Z.negativeSOA())
+		return "", false
+	}
+
+	answer = synthesize(answer, name, owner)
+	reply.Answer = append(reply.Answer, answer...)
+	for _, rr := range answer {
+		switch record := rr.(type) {
+		case *dns.NS:
+			reply.Extra = appendNew(reply.Extra, Z.addresses(record.Ns)...)
+		case *dns.MX:
+			reply.Extra = appendNew(reply.Extra, Z.addresses(record.Mx)...)
+		case *dns.SRV:
+			reply.Extra = appendNew(reply.Extra, Z.addresses(record.Target)...)
+		}
+	}
+
+	return "", false
+}
+
+// zoneCut finds the delegation a name falls under, walking down from the
+// origin. DS records live on the parent side of a cut, so a DS question for
+// the delegated name itself is answered by the zone.
+//
+// Args:
+//   - name: The name asked.
+//   - qtype: The type asked.
+//
+// Returns:
+//   - string: The delegated name.
+//   - bool: Whether the name is delegated.
+func (Z *Zone) zoneCut(name string, qtype uint16) (string, bool) {
+	var below []string
+	for ; name != Z.Origin; name = parent(name) {
+		below = append(below, name)
+	}
+
+	for i := len(below) - 1; i >= 0; i-- {
+		cut := below[i]
+		if _, ok := Z.records[cut][dns.TypeNS]; !ok {
+			continue
+		}
+		if i == 0 && qtype == dns.TypeDS {
+			return "", false
+		}
+		return cut, true
+	}
+
+	return "", false
+}
+
+// wildcard finds the wildcard records matching a name that does not exist,
+// the ones at *.closest-encloser (RFC 4592).
+//
+// Args:
+//   - name: The name asked.
+//
+// Returns:
+//   - map[uint16][]dns.RR: The records of the wildcard, nil if there is none.
+//   - string: The owner of the wildcard.
+func (Z *Zone) wildcard(name string) (map[uint16][]dns.RR, string) {
+	encloser := name
+	for !Z.names[encloser] {
+		encloser = parent(encloser)
+	}
+
+	owner := "*." + encloser
+	if encloser == "." {
+		owner = "*."
+	}
+
+	return Z.records[owner], owner
+}
+
+// synthesize copies records found at a wildcard with the name asked as
+// owner, and returns other records unchanged.
+//
+// Args:
+//   - records: The records.
+//   - name: The name asked.
+//   - owner: The owner of the records.
+//
+// Returns:
+//   - []dns.RR: The records to answer with.
+func synthesize(records []dns.RR, name, owner string) []dns.RR {
+	if name == owner {
+		return records
+	}
+
+	synthesized := make([]dns.RR, 0, len(records))
+	for _, rr := range records {
+		copied := dns.Copy(rr)
+		copied.Header().Name = name
+		synthesized = append(synthesized, copied)
+	}
+
+	return synthesized
+}
+
+// appendNew appends records not already in a section.
+//
+// Args:
+//   - section: The section.
+//   - records: The records to add.
+//
+// Returns:
+//   - []dns.RR: The section.
+func appendNew(section []dns.RR, records ...dns.RR) []dns.RR {
+	for _, rr := range records {
+		if !containsRR(section, rr) {
+			section = append(section, rr)
+		}
+	}
+
+	return section
+}
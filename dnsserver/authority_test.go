@@ -0,0 +1,274 @@
+package dnsserver
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// testZone holds a delegation with glue and a DS, a wildcard, an empty
+// non-terminal above x.ent and CNAMEs inside and outside of the zone.
+var testZone = []string{
+	"example.test. 3600 IN SOA ns1.example.test. hostmaster.example.test. 1 3600 600 86400 300",
+	"example.test. 3600 IN NS ns1.example.test.",
+	"ns1.example.test. 3600 IN A 192.0.2.53",
+	"www.example.test. 3600 IN A 192.0.2.1",
+	"www.example.test. 3600 IN AAAA 2001:db8::1",
+	"alias.example.test. 3600 IN CNAME www.example.test.",
+	"away.example.test. 3600 IN CNAME www.elsewhere.test.",
+	"example.test. 3600 IN MX 10 mx.example.test.",
+	"mx.example.test. 3600 IN A 192.0.2.25",
+	"*.wild.example.test. 3600 IN TXT \"wild\"",
+	"x.ent.example.test. 3600 IN A 192.0.2.7",
+	"sub.example.test. 3600 IN NS ns.sub.example.test.",
+	"sub.example.test. 3600 IN DS 12345 13 2 0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF",
+	"ns.sub.example.test. 3600 IN A 192.0.2.54",
+}
+
+// newTestAuthority builds an authority serving the test zone.
+func newTestAuthority(t *testing.T) *Authority {
+	t.Helper()
+
+	records := make([]dns.RR, 0, len(testZone))
+	for _, line := range testZone {
+		rr, err := dns.NewRR(line)
+		if err != nil {
+			t.Fatalf("parse %q: %v", line, err)
+		}
+		records = append(records, rr)
+	}
+
+	zone, err := NewZone(records)
+	if err != nil {
+		t.Fatalf("build zone: %v", err)
+	}
+	authority, err := NewAuthority(zone)
+	if err != nil {
+		t.Fatalf("build authority: %v", err)
+	}
+
+	return authority
+}
+
+// summary lists the owner and type of every record of a section.
+func summary(section []dns.RR) []string {
+	var records []string
+	for _, rr := range section {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+		records = append(records, rr.Header().Name+" "+dns.TypeToString[rr.Header().Rrtype])
+	}
+
+	return records
+}
+
+func TestAuthorityAnswer(t *testing.T) {
+	tests := []struct {
+		name          string
+		qname         string
+		qtype         uint16
+		wantRcode     int
+		wantAA        bool
+		wantAnswer    []string
+		wantAuthority []string
+		wantExtra     []string
+	}{
+		{
+			name:       "records",
+			qname:      "www.example.test.",
+			qtype:      dns.TypeA,
+			wantAA:     true,
+			wantAnswer: []string{"www.example.test. A"},
+		},
+		{
+			name:       "case insensitive",
+			qname:      "WWW.Example.TEST.",
+			qtype:      dns.TypeAAAA,
+			wantAA:     true,
+			wantAnswer: []string{"www.example.test. AAAA"},
+		},
+		{
+			name:          "nodata",
+			qname:         "www.example.test.",
+			qtype:         dns.TypeTXT,
+			wantAA:        true,
+			wantAuthority: []string{"example.test. SOA"},
+		},
+		{
+			name:          "nxdomain",
+			qname:         "missing.example.test.",
+			qtype:         dns.TypeA,
+			wantRcode:     dns.RcodeNameError,
+			wantAA:        true,
+			wantAuthority: []string{"example.test. SOA"},
+		},
+		{
+			name:          "empty non-terminal",
+			qname:         "ent.example.test.",
+			qtype:         dns.TypeA,
+			wantAA:        true,
+			wantAuthority: []string{"example.test. SOA"},
+		},
+		{
+			name:          "below an empty non-terminal",
+			qname:         "y.ent.example.test.",
+			qtype:         dns.TypeA,
+			wantRcode:     dns.RcodeNameError,
+			wantAA:        true,
+			wantAuthority: []string{"example.test. SOA"},
+		},
+		{
+			name:       "wildcard",
+			qname:      "anything.wild.example.test.",
+			qtype:      dns.TypeTXT,
+			wantAA:     true,
+			wantAnswer: []string{"anything.wild.example.test. TXT"},
+		},
+		{
+			name:          "wildcard nodata",
+			qname:         "anything.wild.example.test.",
+			qtype:         dns.TypeA,
+			wantAA:        true,
+			wantAuthority: []string{"example.test. SOA"},
+		},
+		{
+			name:          "wildcard only one label deep",
+			qname:         "wild.example.test.",
+			qtype:         dns.TypeTXT,
+			wantAA:        true,
+			wantAuthority: []string{"example.test. SOA"},
+		},
+		{
+			name:       "cname followed",
+			qname:      "alias.example.test.",
+			qtype:      dns.TypeA,
+			wantAA:     true,
+			wantAnswer: []string{"alias.example.test. CNAME", "www.example.test. A"},
+		},
+		{
+			name:       "cname asked",
+			qname:      "alias.example.test.",
+			qtype:      dns.TypeCNAME,
+			wantAA:     true,
+			wantAnswer: []string{"alias.example.test. CNAME"},
+		},
+		{
+			name:       "cname out of the zones",
+			qname:      "away.example.test.",
+			qtype:      dns.TypeA,
+			wantAA:     true,
+			wantAnswer: []string{"away.example.test. CNAME"},
+		},
+		{
+			name:       "additional addresses",
+			qname:      "example.test.",
+			qtype:      dns.TypeMX,
+			wantAA:     true,
+			wantAnswer: []string{"example.test. MX"},
+			wantExtra:  []string{"mx.example.test. A"},
+		},
+		{
+			name:          "referral with glue",
+			qname:         "host.sub.example.test.",
+			qtype:         dns.TypeA,
+			wantAuthority: []string{"sub.example.test. NS"},
+			wantExtra:     []string{"ns.sub.example.test. A"},
+		},
+		{
+			name:          "referral at the cut",
+			qname:         "sub.example.test.",
+			qtype:         dns.TypeNS,
+			wantAuthority: []string{"sub.example.test. NS"},
+			wantExtra:     []string{"ns.sub.example.test. A"},
+		},
+		{
+			name:       "ds at the cut",
+			qname:      "sub.example.test.",
+			qtype:      dns.TypeDS,
+			wantAA:     true,
+			wantAnswer: []string{"sub.example.test. DS"},
+		},
+		{
+			name:          "ds below the cut",
+			qname:         "host.sub.example.test.",
+			qtype:         dns.TypeDS,
+			wantAuthority: []string{"sub.example.test. NS"},
+			wantExtra:     []string{"ns.sub.example.test. A"},
+		},
+		{
+			name:      "outside of the zones",
+			qname:     "www.elsewhere.test.",
+			qtype:     dns.TypeA,
+			wantRcode: dns.RcodeRefused,
+		},
+	}
+
+	authority := newTestAuthority(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := new(dns.Msg)
+			request.SetQuestion(test.qname, test.qtype)
+
+			reply := authority.Answer(request)
+			if reply.Rcode != test.wantRcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[reply.Rcode], dns.RcodeToString[test.wantRcode])
+			}
+			if reply.Authoritative != test.wantAA {
+				t.Errorf("aa = %t, want %t", reply.Authoritative, test.wantAA)
+			}
+			if got := summary(reply.Answer); !slices.Equal(got, test.wantAnswer) {
+				t.Errorf("answer = %v, want %v", got, test.wantAnswer)
+			}
+			if got := summary(reply.Ns); !slices.Equal(got, test.wantAuthority) {
+				t.Errorf("authority = %v, want %v", got, test.wantAuthority)
+			}
+			if got := summary(reply.Extra); !slices.Equal(got, test.wantExtra) {
+				t.Errorf("additional = %v, want %v", got, test.wantExtra)
+			}
+		})
+	}
+}
+
+func TestAuthorityNegativeSOATTL(t *testing.T) {
+	request := new(dns.Msg)
+	request.SetQuestion("missing.example.test.", dns.TypeA)
+
+	reply := newTestAuthority(t).Answer(request)
+	if len(reply.Ns) != 1 || reply.Ns[0].Header().Ttl != 300 {
+		t.Fatalf("authority = %v, want the SOA with the TTL of its minimum field", reply.Ns)
+	}
+	if zone := newTestAuthority(t).Zone("example.test."); zone.SOA.Hdr.Ttl != 3600 {
+		t.Errorf("the SOA of the zone was changed to TTL %d", zone.SOA.Hdr.Ttl)
+	}
+}
+
+func TestAuthorityRequests(t *testing.T) {
+	authority := newTestAuthority(t)
+
+	notify := new(dns.Msg)
+	notify.SetNotify("example.test.")
+	if reply := authority.Answer(notify); reply.Rcode != dns.RcodeNotImplemented {
+		t.Errorf("notify rcode = %s, want NOTIMP", dns.RcodeToString[reply.Rcode])
+	}
+
+	empty := new(dns.Msg)
+	if reply := authority.Answer(empty); reply.Rcode != dns.RcodeFormatError {
+		t.Errorf("no question rcode = %s, want FORMERR", dns.RcodeToString[reply.Rcode])
+	}
+
+	chaos := new(dns.Msg)
+	chaos.SetQuestion("www.example.test.", dns.TypeA)
+	chaos.Question[0].Qclass = dns.ClassCHAOS
+	if reply := authority.Answer(chaos); reply.Rcode != dns.RcodeRefused {
+		t.Errorf("chaos rcode = %s, want REFUSED", dns.RcodeToString[reply.Rcode])
+	}
+
+	edns := new(dns.Msg)
+	edns.SetQuestion("www.example.test.", dns.TypeA)
+	edns.SetEdns0(1232, true)
+	if opt := authority.Answer(edns).IsEdns0(); opt == nil || opt.UDPSize() != 1232 {
+		t.Errorf("opt = %v, want the buffer size of the request", opt)
+	}
+}
@@ -0,0 +1,361 @@
+package dnsserver
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// DefaultCacheSize is the number of answers cached when no size is set.
+	DefaultCacheSize = 10000
+	// maxCacheTTL caps the time an answer is cached, whatever its TTL.
+	maxCacheTTL = 86400
+	// maxNegativeTTL caps the time a negative answer is cached (RFC 2308
+	// section 5).
+	maxNegativeTTL = 10800
+	// staleTTL is the TTL of the records in a stale answer (RFC 8767 section 4).
+	staleTTL = 30
+	// prefetchWindow is the share of its TTL left on an answer when a hit
+	// refreshes it in the background.
+	prefetchWindow = 10
+)
+
+// CacheOptions configures a cache.
+type CacheOptions struct {
+	// Size is the number of answers kept, the least recently used being
+	// evicted first.
+	Size int
+	// Prefetch is the number of hits after which an answer about to expire
+	// is refreshed in the background, 0 to never prefetch.
+	Prefetch int
+	// ServeStale is how long an expired answer is still served when no
+	// upstream answers, 0 to never serve stale answers.
+	ServeStale time.Duration
+}
+
+// CacheStats counts what a cache did since it was created.
+type CacheStats struct {
+	Entries    int    `json:"entries" yaml:"entries"`
+	Hits       uint64 `json:"hits" yaml:"hits"`
+	Misses     uint64 `json:"misses" yaml:"misses"`
+	Negative   uint64 `json:"negative" yaml:"negative"`
+	Stale      uint64 `json:"stale" yaml:"stale"`
+	Prefetches uint64 `json:"prefetches" yaml:"prefetches"`
+	Evictions  uint64 `json:"evictions" yaml:"evictions"`
+}
+
+// Cache keeps upstream answers until their TTL runs out. It is safe for
+// concurrent use.
+type Cache struct {
+	options CacheOptions
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	recent  *list.List
+	stats   CacheStats
+}
+
+// cacheKey identifies a question. Answers asked with the DO or CD bits hold
+// DNSSEC records or unvalidated data, so they are cached apart.
+type cacheKey struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+	do     bool
+	cd     bool
+}
+
+type cacheEntry struct {
+	key         cacheKey
+	msg         *dns.Msg
+	stored      time.Time
+	ttl         time.Duration
+	hits        int
+	prefetching bool
+}
+
+// NewCache builds an empty cache.
+//
+// Args:
+//   - options: The cache options.
+//
+// Returns:
+//   - *Cache: The cache.
+func NewCache(options CacheOptions) *Cache {
+	if options.Size <= 0 {
+		options.Size = DefaultCacheSize
+	}
+
+	return &Cache{options: options, entries: map[cacheKey]*list.Element{}, recent: list.New()}
+}
+
+// Get looks up the cached answer to a request. Fresh answers have their TTLs
+// lowered by the time spent in the cache; stale answers, only returned when
+// asked for and within the serve-stale window, carry a 30 second TTL.
+//
+// Args:
+//   - request: The request.
+//   - stale: Whether an expired answer may be returned.
+//   - now: The current time.
+//
+// Returns:
+//   - *dns.Msg: A copy of the answer, nil on a miss.
+//   - bool: Whether the answer should be refreshed in the background. The
+//     caller must then call Set or Release.
+func (C *Cache) Get(request *dns.Msg, stale bool, now time.Time) (*dns.Msg, bool) {
+	key := newCacheKey(request)
+
+	C.mu.Lock()
+	defer C.mu.Unlock()
+
+	element, ok := C.entries[key]
+	if !ok {
+		if !stale {
+			C.stats.Misses++
+		}
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+
+	age := now.Sub(entry.stored)
+	if age >= entry.ttl {
+		expired := age >= entry.ttl+C.options.ServeStale
+		if expired {
+			C.remove(element)
+		}
+		if !stale {
+			C.stats.Misses++
+			return nil, false
+		}
+		if expired {
+			return nil, false
+		}
+		C.stats.Stale++
+		return entry.answer(age, true), false
+	}
+
+	C.recent.MoveToFront(element)
+	C.stats.Hits++
+	entry.hits++
+
+	prefetch := C.options.Prefetch > 0 && entry.hits >= C.options.Prefetch && !entry.prefetching &&
+		entry.ttl-age < entry.ttl/prefetchWindow
+	if prefetch {
+		entry.prefetching = true
+		C.stats.Prefetches++
+	}
+
+	return entry.answer(age, false), prefetch
+}
+
+// Set caches the answer to a request for the lowest TTL of its records.
+// Negative answers are cached for the TTL of the SOA in their authority
+// section (RFC 2308 section 5) and are not cached without one. Truncated
+// answers and answers with other rcodes are not cached.
+//
+// Args:
+//   - request: The request.
+//   - reply: The upstream answer.
+//   - now: The current time.
+//
+// Returns:
+//   - None
+func (C *Cache) Set(request *dns.Msg, reply *dns.Msg, now time.Time) {
+	key := newCacheKey(request)
+
+	ttl, negative, ok := cacheTTL(reply)
+	if !ok || reply.Truncated {
+		C.Release(request)
+		return
+	}
+
+	msg := reply.Copy()
+	msg.Id = 0
+	msg.Extra = withoutOPT(msg.Extra)
+
+	C.mu.Lock()
+	defer C.mu.Unlock()
+
+	if element, ok := C.entries[key]; ok {
+		C.remove(element)
+	}
+	if negative {
+		C.stats.Negative++
+	}
+	entry := &cacheEntry{key: key, msg: msg, stored: now, ttl: time.Duration(ttl) * time.Second}
+	C.entries[key] = C.recent.PushFront(entry)
+
+	for C.recent.Len() > C.options.Size {
+		C.remove(C.recent.Back())
+		C.stats.Evictions++
+	}
+}
+
+// Release marks a prefetch that did not get a new answer as done, so a later
+// hit can try again.
+//
+// Args:
+//   - request: The request that was prefetched.
+//
+// Returns:
+//   - None
+func (C *Cache) Release(request *dns.Msg) {
+	C.mu.Lock()
+	defer C.mu.Unlock()
+
+	if element, ok := C.entries[newCacheKey(request)]; ok {
+		element.Value.(*cacheEntry).prefetching = false
+	}
+}
+
+// Stats returns the cache counters.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - CacheStats: The counters.
+func (C *Cache) Stats() CacheStats {
+	C.mu.Lock()
+	defer C.mu.Unlock()
+
+	stats := C.stats
+	stats.Entries = C.recent.Len()
+
+	return stats
+}
+
+// String formats the counters on one line.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - string: The counters.
+func (S CacheStats) String() string {
+	ratio := 0.0
+	if total := S.Hits + S.Misses; total > 0 {
+		ratio = float64(S.Hits) / float64(total) * 100
+	}
+
+	return fmt.Sprintf("%d entries, %d hits, %d misses (%.1f%% hit ratio), %d negative, %d stale, %d prefetches, %d evictions",
+		S.Entries, S.Hits, S.Misses, ratio, S.Negative, S.Stale, S.Prefetches, S.Evictions)
+}
+
+// remove drops an entry. The lock must be held.
+//
+// Args:
+//   - element: The entry.
+//
+// Returns:
+//   - None
+func (C *Cache) remove(element *list.Element) {
+	C.recent.Remove(element)
+	delete(C.entries, element.Value.(*cacheEntry).key)
+}
+
+// answer copies the cached answer with the TTL of every record lowered by
+// its age, or set to 30 seconds for a stale answer.
+//
+// Args:
+//   - age: The time spent in the cache.
+//   - stale: Whether the answer has expired.
+//
+// Returns:
+//   - *dns.Msg: The answer.
+func (E *cacheEntry) answer(age time.Duration, stale bool) *dns.Msg {
+	msg := E.msg.Copy()
+	elapsed := uint32(age.Seconds())
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			header := rr.Header()
+			if stale {
+				header.Ttl = staleTTL
+				continue
+			}
+			header.Ttl -= min(header.Ttl, elapsed)
+		}
+	}
+
+	return msg
+}
+
+// newCacheKey builds the key of the question of a request.
+//
+// Args:
+//   - request: The request, with one question.
+//
+// Returns:
+//   - cacheKey: The key.
+func newCacheKey(request *dns.Msg) cacheKey {
+	question := request.Question[0]
+	key := cacheKey{
+		name:   dns.CanonicalName(question.Name),
+		qtype:  question.Qtype,
+		qclass: question.Qclass,
+		cd:     request.CheckingDisabled,
+	}
+	if opt := request.IsEdns0(); opt != nil {
+		key.do = opt.Do()
+	}
+
+	return key
+}
+
+// cacheTTL finds how long an answer can be cached: the lowest TTL of its
+// records, or for NXDOMAIN and NODATA the lower of the SOA TTL and its
+// minimum field.
+//
+// Args:
+//   - reply: The answer.
+//
+// Returns:
+//   - uint32: The TTL in seconds.
+//   - bool: Whether the answer is negative.
+//   - bool: Whether the answer can be cached.
+func cacheTTL(reply *dns.Msg) (uint32, bool, bool) {
+	if reply.Rcode != dns.RcodeSuccess && reply.Rcode != dns.RcodeNameError {
+		return 0, false, false
+	}
+
+	negative := reply.Rcode == dns.RcodeNameError || len(reply.Answer) == 0
+	if negative {
+		for _, rr := range reply.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				return min(soa.Hdr.Ttl, soa.Minttl, maxNegativeTTL), true, true
+			}
+		}
+		return 0, true, false
+	}
+
+	ttl := uint32(maxCacheTTL)
+	for _, section := range [][]dns.RR{reply.Answer, reply.Ns, withoutOPT(reply.Extra)} {
+		for _, rr := range section {
+			ttl = min(ttl, rr.Header().Ttl)
+		}
+	}
+
+	return ttl, false, ttl > 0
+}
+
+// withoutOPT returns the records of an additional section other than the OPT
+// pseudo-record.
+//
+// Args:
+//   - extra: The additional section.
+//
+// Returns:
+//   - []dns.RR: The records.
+func withoutOPT(extra []dns.RR) []dns.RR {
+	var records []dns.RR
+	for _, rr := range extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			records = append(records, rr)
+		}
+	}
+
+	return records
+}
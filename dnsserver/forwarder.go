@@ -0,0 +1,189 @@
+package dnsserver
+
+import (
+	"context"
+	"time"
+
+	"commandCenter/dnsquery"
+
+	"github.com/miekg/dns"
+)
+
+// forwardTimeout bounds the time spent asking every upstream for one answer.
+const forwardTimeout = 10 * time.Second
+
+// Forwarder answers questions by asking upstream resolvers, falling back from
+// one upstream to the next, and caches their answers.
+type Forwarder struct {
+	resolver dnsquery.Resolver
+	cache    *Cache
+}
+
+// NewForwarder builds a forwarder.
+//
+// Args:
+//   - resolver: The upstream resolvers, dnsquery.Upstreams to fail over in order.
+//   - cache: The cache of answers, nil to ask the upstreams every time.
+//
+// Returns:
+//   - *Forwarder: The forwarder.
+func NewForwarder(resolver dnsquery.Resolver, cache *Cache) *Forwarder {
+	return &Forwarder{resolver: resolver, cache: cache}
+}
+
+// Cache returns the cache of the forwarder.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - *Cache: The cache, nil when answers are not cached.
+func (F *Forwarder) Cache() *Cache {
+	return F.cache
+}
+
+// Answer builds the reply to a request from the cache or the upstreams. When
+// no upstream answers or they all answer SERVFAIL, a stale cached answer is
+// used if the cache allows it, SERVFAIL otherwise.
+//
+// Args:
+//   - ctx: The context bounding the upstream queries.
+//   - request: The request.
+//
+// Returns:
+//   - *dns.Msg: The reply.
+func (F *Forwarder) Answer(ctx context.Context, request *dns.Msg) *dns.Msg {
+	reply := new(dns.Msg)
+	reply.SetReply(request)
+	reply.RecursionAvailable = true
+	if opt := request.IsEdns0(); opt != nil {
+		reply.SetEdns0(max(opt.UDPSize(), dns.MinMsgSize), opt.Do())
+	}
+
+	switch {
+	case request.Opcode != dns.OpcodeQuery:
+		reply.Rcode = dns.RcodeNotImplemented
+		return reply
+	case len(request.Question) != 1:
+		reply.Rcode = dns.RcodeFormatError
+		return reply
+	}
+
+	if F.cache != nil {
+		if cached, prefetch := F.cache.Get(request, false, time.Now()); cached != nil {
+			if prefetch {
+				go F.prefetch(request.Copy())
+			}
+			return fill(reply, cached)
+		}
+	}
+
+	answer, err := F.exchange(ctx, request)
+	if err != nil || answer.Rcode == dns.RcodeServerFailure {
+		if F.cache != nil {
+			if stale, _ := F.cache.Get(request, true, time.Now()); stale != nil {
+				return fill(reply, stale)
+			}
+		}
+		if err != nil {
+			reply.Rcode = dns.RcodeServerFailure
+			return reply
+		}
+	}
+
+	if F.cache != nil {
+		F.cache.Set(request, answer, time.Now())
+	}
+
+	return fill(reply, answer)
+}
+
+// ServeDNS implements dns.Handler.
+//
+// Args:
+//   - w: The response writer.
+//   - request: The request.
+//
+// Returns:
+//   - None
+func (F *Forwarder) ServeDNS(w dns.ResponseWriter, request *dns.Msg) {
+	ctx, cancel := context.WithTimeout(context.Background(), forwardTimeout)
+	defer cancel()
+
+	write(w, request, F.Answer(ctx, request))
+}
+
+// exchange asks the upstreams the question of a request, keeping its RD, CD
+// and DO bits.
+//
+// Args:
+//   - ctx: The context bounding the upstream queries.
+//   - request: The request.
+//
+// Returns:
+//   - *dns.Msg: The upstream answer.
+//   - error: An error if no upstream answered.
+func (F *Forwarder) exchange(ctx context.Context, request *dns.Msg) (*dns.Msg, error) {
+	query := new(dns.Msg)
+	query.SetQuestion(request.Question[0].Name, request.Question[0].Qtype)
+	query.Question[0].Qclass = request.Question[0].Qclass
+	query.RecursionDesired = request.RecursionDesired
+	query.CheckingDisabled = request.CheckingDisabled
+	do := false
+	if opt := request.IsEdns0(); opt != nil {
+		do = opt.Do()
+	}
+	query.SetEdns0(dnsquery.DnssecBufferSize, do)
+
+	response, err := F.resolver.Exchange(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Msg, nil
+}
+
+// prefetch refreshes a cached answer about to expire.
+//
+// Args:
+//   - request: The request whose answer is refreshed.
+//
+// Returns:
+//   - None
+func (F *Forwarder) prefetch(request *dns.Msg) {
+	ctx, cancel := context.WithTimeout(context.Background(), forwardTimeout)
+	defer cancel()
+
+	answer, err := F.exchange(ctx, request)
+	if err != nil {
+		F.cache.Release(request)
+		return
+	}
+	F.cache.Set(request, answer, time.Now())
+}
+
+// fill copies the rcode, flags and records of an answer into a reply, keeping
+// the OPT record of the reply. A truncated upstream answer stays truncated so
+// the client retries over TCP.
+//
+// Args:
+//   - reply: The reply to the client.
+//   - answer: The upstream or cached answer.
+//
+// Returns:
+//   - *dns.Msg: The reply.
+func fill(reply, answer *dns.Msg) *dns.Msg {
+	opt := reply.IsEdns0()
+
+	reply.Rcode = answer.Rcode
+	reply.Truncated = answer.Truncated
+	reply.AuthenticatedData = answer.AuthenticatedData
+	reply.Answer = answer.Answer
+	reply.Ns = answer.Ns
+	reply.Extra = withoutOPT(answer.Extra)
+	if opt != nil {
+		reply.Extra = append(reply.Extra, opt)
+	}
+
+	return reply
+}
@@ -0,0 +1,90 @@
+package dnsserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"commandCenter/dnsquery"
+
+	"github.com/miekg/dns"
+)
+
+// fakeUpstream answers every question with a fixed rcode, or fails.
+type fakeUpstream struct {
+	rcode     int
+	truncated bool
+	err       error
+}
+
+// Lookup is not used by the forwarder.
+func (F fakeUpstream) Lookup(ctx context.Context, name, qtype string) (dnsquery.Response, error) {
+	return dnsquery.Response{}, errors.New("not implemented")
+}
+
+// Exchange answers with an A record on NOERROR, nothing otherwise.
+func (F fakeUpstream) Exchange(ctx context.Context, m *dns.Msg) (dnsquery.Response, error) {
+	if F.err != nil {
+		return dnsquery.Response{}, F.err
+	}
+
+	reply := new(dns.Msg)
+	reply.SetRcode(m, F.rcode)
+	reply.Truncated = F.truncated
+	if F.rcode == dns.RcodeSuccess && !F.truncated {
+		reply.Answer = append(reply.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(192, 0, 2, 1),
+		})
+	}
+
+	return dnsquery.Response{Msg: reply, Server: "fake"}, nil
+}
+
+func TestForwarderAnswer(t *testing.T) {
+	tests := []struct {
+		name          string
+		upstream      fakeUpstream
+		cached        bool
+		wantRcode     int
+		wantAnswers   int
+		wantTruncated bool
+	}{
+		{name: "answer", upstream: fakeUpstream{}, wantAnswers: 1},
+		{name: "no upstream", upstream: fakeUpstream{err: dnsquery.ErrNoAnswer}, wantRcode: dns.RcodeServerFailure},
+		{name: "no upstream with stale answer", upstream: fakeUpstream{err: dnsquery.ErrNoAnswer}, cached: true, wantAnswers: 1},
+		{name: "servfail", upstream: fakeUpstream{rcode: dns.RcodeServerFailure}, wantRcode: dns.RcodeServerFailure},
+		{name: "servfail with stale answer", upstream: fakeUpstream{rcode: dns.RcodeServerFailure}, cached: true, wantAnswers: 1},
+		{name: "nxdomain over stale answer", upstream: fakeUpstream{rcode: dns.RcodeNameError}, cached: true, wantRcode: dns.RcodeNameError},
+		{name: "truncated", upstream: fakeUpstream{truncated: true}, wantTruncated: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := new(dns.Msg)
+			request.SetQuestion("www.example.test.", dns.TypeA)
+
+			cache := NewCache(CacheOptions{Size: 10, ServeStale: time.Hour})
+			if test.cached {
+				answer, _ := fakeUpstream{}.Exchange(context.Background(), request)
+				cache.Set(request, answer.Msg, time.Now().Add(-10*time.Minute))
+			}
+
+			reply := NewForwarder(test.upstream, cache).Answer(context.Background(), request)
+			if reply.Rcode != test.wantRcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[reply.Rcode], dns.RcodeToString[test.wantRcode])
+			}
+			if len(reply.Answer) != test.wantAnswers {
+				t.Errorf("answers = %d, want %d", len(reply.Answer), test.wantAnswers)
+			}
+			if reply.Truncated != test.wantTruncated {
+				t.Errorf("truncated = %t, want %t", reply.Truncated, test.wantTruncated)
+			}
+			if reply.Id != request.Id {
+				t.Errorf("id = %d, want %d", reply.Id, request.Id)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package dnsserver
+
+import (
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// Handler serves an authority that can be replaced while queries are being
+// answered: every query sees either the old or the new zones, never a mix.
+// Questions outside of the zones go to the forwarder, if there is one.
+type Handler struct {
+	authority atomic.Pointer[Authority]
+	forwarder *Forwarder
+}
+
+// NewHandler builds a handler serving an authority.
+//
+// Args:
+//   - authority: The authority.
+//   - forwarder: The forwarder for questions outside of the zones, nil to
+//     refuse them.
+//
+// Returns:
+//   - *Handler: The handler.
+func NewHandler(authority *Authority, forwarder *Forwarder) *Handler {
+	handler := &Handler{forwarder: forwarder}
+	handler.authority.Store(authority)
+
+	return handler
+}
+
+// Swap replaces the authority served.
+//
+// Args:
+//   - authority: The new authority.
+//
+// Returns:
+//   - *Authority: The authority served until now.
+func (H *Handler) Swap(authority *Authority) *Authority {
+	return H.authority.Swap(authority)
+}
+
+// ServeDNS implements dns.Handler with the current authority, or the
+// forwarder when no zone holds the name asked.
+//
+// Args:
+//   - w: The response writer.
+//   - request: The request.
+//
+// Returns:
+//   - None
+func (H *Handler) ServeDNS(w dns.ResponseWriter, request *dns.Msg) {
+	authority := H.authority.Load()
+	if H.forwarder != nil && len(request.Question) == 1 && authority.Zone(request.Question[0].Name) == nil {
+		H.forwarder.ServeDNS(w, request)
+		return
+	}
+
+	authority.ServeDNS(w, request)
+}
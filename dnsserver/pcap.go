@@ -0,0 +1,294 @@
+package dnsserver
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// pcapLinkTypeRaw is LINKTYPE_RAW: packets start with their IPv4 or IPv6
+	// header.
+	pcapLinkTypeRaw = 101
+	pcapSnapLen     = 65535
+	protocolTCP     = 6
+	protocolUDP     = 17
+	tcpFlagsPSHACK  = 0x18
+	// pcapMaxFlows is the number of TCP connections whose sequence numbers
+	// are tracked, the least recently used being forgotten first.
+	pcapMaxFlows = 4096
+)
+
+// PcapWriter writes DNS messages to a pcap file that Wireshark can open. The
+// IP and UDP or TCP headers are synthesized from the addresses of the query;
+// TCP messages carry their length prefix and sequence numbers that follow
+// each other, so streams can be followed. It is safe for concurrent use.
+type PcapWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	flows  map[string]*list.Element
+	recent *list.List
+}
+
+// tcpFlow holds the next sequence number of both directions of a TCP
+// connection, the first one going from the lower address.
+type tcpFlow struct {
+	key string
+	seq [2]uint32
+}
+
+// NewPcapWriter writes the pcap file header.
+//
+// Args:
+//   - w: Where the capture is written.
+//
+// Returns:
+//   - *PcapWriter: The writer.
+//   - error: An error if the header cannot be written.
+func NewPcapWriter(w io.Writer) (*PcapWriter, error) {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(header[20:], pcapLinkTypeRaw)
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("could not write pcap header: %w", err)
+	}
+
+	return &PcapWriter{w: w, flows: map[string]*list.Element{}, recent: list.New()}, nil
+}
+
+// WritePacket writes one DNS message as a packet.
+//
+// Args:
+//   - when: The time of the packet.
+//   - transport: "udp" or "tcp".
+//   - src: The address the message comes from.
+//   - dst: The address the message goes to.
+//   - payload: The wire format message.
+//
+// Returns:
+//   - error: An error if the addresses are not IP addresses or the packet
+//     cannot be written.
+func (P *PcapWriter) WritePacket(when time.Time, transport string, src, dst net.Addr, payload []byte) error {
+	srcIP, srcPort := addrIPPort(src)
+	dstIP, dstPort := addrIPPort(dst)
+	if srcIP == nil || dstIP == nil {
+		return fmt.Errorf("cannot capture a packet from %s to %s", src, dst)
+	}
+	srcIP, dstIP = capturedIP(srcIP, dstIP), capturedIP(dstIP, srcIP)
+	if (srcIP.To4() == nil) != (dstIP.To4() == nil) {
+		srcIP, dstIP = srcIP.To16(), dstIP.To16()
+	}
+
+	P.mu.Lock()
+	defer P.mu.Unlock()
+
+	var segment []byte
+	protocol := uint8(protocolUDP)
+	if transport == "tcp" {
+		protocol = protocolTCP
+		framed := binary.BigEndian.AppendUint16(nil, uint16(len(payload)))
+		framed = append(framed, payload...)
+
+		flow, direction := P.flow(src.String(), dst.String())
+		segment = make([]byte, 20, 20+len(framed))
+		binary.BigEndian.PutUint16(segment[0:], srcPort)
+		binary.BigEndian.PutUint16(segment[2:], dstPort)
+		binary.BigEndian.PutUint32(segment[4:], flow.seq[direction])
+		binary.BigEndian.PutUint32(segment[8:], flow.seq[1-direction])
+		segment[12] = 5 << 4
+		segment[13] = tcpFlagsPSHACK
+		binary.BigEndian.PutUint16(segment[14:], 65535)
+		segment = append(segment, framed...)
+		flow.seq[direction] += uint32(len(framed))
+	} else {
+		segment = make([]byte, 8, 8+len(payload))
+		binary.BigEndian.PutUint16(segment[0:], srcPort)
+		binary.BigEndian.PutUint16(segment[2:], dstPort)
+		binary.BigEndian.PutUint16(segment[4:], uint16(8+len(payload)))
+		segment = append(segment, payload...)
+	}
+
+	checksumAt := 6
+	if protocol == protocolTCP {
+		checksumAt = 16
+	}
+	sum := checksum(pseudoHeader(srcIP, dstIP, protocol, len(segment)), segment)
+	if protocol == protocolUDP && sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(segment[checksumAt:], sum)
+
+	packet := append(ipHeader(srcIP, dstIP, protocol, len(segment)), segment...)
+
+	record := make([]byte, 16, 16+len(packet))
+	binary.LittleEndian.PutUint32(record[0:], uint32(when.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(when.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(packet)))
+	if _, err := P.w.Write(append(record, packet...)); err != nil {
+		return fmt.Errorf("could not write pcap packet: %w", err)
+	}
+
+	return nil
+}
+
+// flow returns the sequence numbers of the TCP connection between two
+// addresses, tracking it if it is new and forgetting the least recently used
+// connection past pcapMaxFlows. A forgotten connection starts again at 0.
+//
+// Args:
+//   - src: The address the segment comes from.
+//   - dst: The address the segment goes to.
+//
+// Returns:
+//   - *tcpFlow: The connection.
+//   - int: The index of the direction of the segment in its sequence numbers.
+func (P *PcapWriter) flow(src, dst string) (*tcpFlow, int) {
+	key, direction := src+" "+dst, 0
+	if dst < src {
+		key, direction = dst+" "+src, 1
+	}
+
+	if element, ok := P.flows[key]; ok {
+		P.recent.MoveToFront(element)
+		return element.Value.(*tcpFlow), direction
+	}
+
+	flow := &tcpFlow{key: key}
+	P.flows[key] = P.recent.PushFront(flow)
+	for P.recent.Len() > pcapMaxFlows {
+		oldest := P.recent.Back()
+		P.recent.Remove(oldest)
+		delete(P.flows, oldest.Value.(*tcpFlow).key)
+	}
+
+	return flow, direction
+}
+
+// ipHeader builds the IPv4 or IPv6 header of a packet.
+//
+// Args:
+//   - src: The source address.
+//   - dst: The destination address.
+//   - protocol: The protocol of the payload.
+//   - length: The length of the payload.
+//
+// Returns:
+//   - []byte: The header.
+func ipHeader(src, dst net.IP, protocol uint8, length int) []byte {
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		header := make([]byte, 20)
+		header[0] = 0x45
+		binary.BigEndian.PutUint16(header[2:], uint16(20+length))
+		header[8] = 64
+		header[9] = protocol
+		copy(header[12:], src4)
+		copy(header[16:], dst4)
+		binary.BigEndian.PutUint16(header[10:], checksum(nil, header))
+		return header
+	}
+
+	header := make([]byte, 40)
+	header[0] = 0x60
+	binary.BigEndian.PutUint16(header[4:], uint16(length))
+	header[6] = protocol
+	header[7] = 64
+	copy(header[8:], src.To16())
+	copy(header[24:], dst.To16())
+
+	return header
+}
+
+// pseudoHeader builds the pseudo-header covered by UDP and TCP checksums.
+//
+// Args:
+//   - src: The source address.
+//   - dst: The destination address.
+//   - protocol: The protocol of the segment.
+//   - length: The length of the segment.
+//
+// Returns:
+//   - []byte: The pseudo-header.
+func pseudoHeader(src, dst net.IP, protocol uint8, length int) []byte {
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		header := append(append([]byte{}, src4...), dst4...)
+		return append(header, 0, protocol, byte(length>>8), byte(length))
+	}
+
+	header := append(append([]byte{}, src.To16()...), dst.To16()...)
+	header = binary.BigEndian.AppendUint32(header, uint32(length))
+
+	return append(header, 0, 0, 0, protocol)
+}
+
+// checksum computes the Internet checksum of RFC 1071 over two buffers.
+//
+// Args:
+//   - pseudo: The pseudo-header, may be nil.
+//   - data: The data.
+//
+// Returns:
+//   - uint16: The checksum.
+func checksum(pseudo, data []byte) uint16 {
+	var sum uint32
+	for _, buffer := range [][]byte{pseudo, data} {
+		for i := 0; i+1 < len(buffer); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(buffer[i:]))
+		}
+		if len(buffer)%2 == 1 {
+			sum += uint32(buffer[len(buffer)-1]) << 8
+		}
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+
+	return ^uint16(sum)
+}
+
+// addrIPPort extracts the IP address and port of a UDP or TCP address.
+//
+// Args:
+//   - addr: The address.
+//
+// Returns:
+//   - net.IP: The IP address, nil for other addresses.
+//   - uint16: The port.
+func addrIPPort(addr net.Addr) (net.IP, uint16) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP, uint16(a.Port)
+	case *net.TCPAddr:
+		return a.IP, uint16(a.Port)
+	}
+
+	return nil, 0
+}
+
+// capturedIP replaces the wildcard address a listener is bound to with the
+// loopback address of the family of the peer, so captured packets have a
+// usable address.
+//
+// Args:
+//   - ip: The address.
+//   - peer: The address at the other end.
+//
+// Returns:
+//   - net.IP: The address to capture.
+func capturedIP(ip, peer net.IP) net.IP {
+	switch {
+	case !ip.IsUnspecified():
+		return ip
+	case peer.To4() != nil:
+		return net.IPv4(127, 0, 0, 1)
+	}
+
+	return net.IPv6loopback
+}
@@ -0,0 +1,75 @@
+package dnsserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// tcpSequence reads the sequence and acknowledgment numbers of the IPv4 TCP
+// packet of a pcap record starting at offset.
+func tcpSequence(capture []byte, offset int) (uint32, uint32, int) {
+	length := int(binary.LittleEndian.Uint32(capture[offset+8:]))
+	segment := capture[offset+16+20:]
+
+	return binary.BigEndian.Uint32(segment[4:]), binary.BigEndian.Uint32(segment[8:]), offset + 16 + length
+}
+
+func TestPcapWriterSequence(t *testing.T) {
+	var capture bytes.Buffer
+	writer, err := NewPcapWriter(&capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000}
+	server := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 53), Port: 53}
+	query, reply := make([]byte, 30), make([]byte, 100)
+	for _, packet := range []struct {
+		src, dst net.Addr
+		payload  []byte
+	}{{client, server, query}, {server, client, reply}, {client, server, query}} {
+		if err := writer.WritePacket(time.Now(), "tcp", packet.src, packet.dst, packet.payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every segment carries the 2 byte length prefix of its message.
+	want := [][2]uint32{{0, 0}, {0, 32}, {32, 102}}
+	offset := 24
+	for i, numbers := range want {
+		var seq, ack uint32
+		seq, ack, offset = tcpSequence(capture.Bytes(), offset)
+		if seq != numbers[0] || ack != numbers[1] {
+			t.Errorf("packet %d: seq %d ack %d, want seq %d ack %d", i, seq, ack, numbers[0], numbers[1])
+		}
+	}
+}
+
+func TestPcapWriterFlows(t *testing.T) {
+	writer, err := NewPcapWriter(&bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 53), Port: 53}
+	for port := range pcapMaxFlows + 10 {
+		client := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1024 + port}
+		if err := writer.WritePacket(time.Now(), "tcp", client, server, []byte{0}); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.WritePacket(time.Now(), "tcp", server, client, []byte{0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	udp := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5353}
+	if err := writer.WritePacket(time.Now(), "udp", udp, &net.UDPAddr{IP: server.IP, Port: 53}, []byte{0}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(writer.flows) != pcapMaxFlows || writer.recent.Len() != pcapMaxFlows {
+		t.Errorf("tracking %d flows, want %d", len(writer.flows), pcapMaxFlows)
+	}
+}
@@ -0,0 +1,218 @@
+package dnsserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// QueryLogOptions configures a query log.
+type QueryLogOptions struct {
+	// JSON receives one JSON object per query, nil to not log them.
+	JSON io.Writer
+	// Pcap receives the request and response packets, nil to not capture them.
+	Pcap *PcapWriter
+	// Sample is the share of queries logged, between 0 and 1.
+	Sample float64
+	// Names restricts the log to questions for these names and the names
+	// below them, every question being logged when empty.
+	Names []string
+	// Errors receives the errors met while logging, nil to ignore them.
+	Errors func(error)
+}
+
+// QueryEntry is one line of the JSON query log.
+type QueryEntry struct {
+	Time      time.Time `json:"time"`
+	Client    string    `json:"client"`
+	Transport string    `json:"transport"`
+	ID        uint16    `json:"id"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Class     string    `json:"class"`
+	Rcode     string    `json:"rcode"`
+	Answers   int       `json:"answers"`
+	Size      int       `json:"size"`
+	LatencyMs float64   `json:"latency_ms"`
+}
+
+// QueryLog records the queries a handler answers as JSON Lines and pcap
+// packets.
+type QueryLog struct {
+	options QueryLogOptions
+	names   []string
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// queryLogWriter keeps the reply a handler writes.
+type queryLogWriter struct {
+	dns.ResponseWriter
+	reply *dns.Msg
+}
+
+// NewQueryLog builds a query log.
+//
+// Args:
+//   - options: The query log options.
+//
+// Returns:
+//   - *QueryLog: The query log.
+//   - error: An error if the sample rate is not between 0 and 1.
+func NewQueryLog(options QueryLogOptions) (*QueryLog, error) {
+	if options.Sample < 0 || options.Sample > 1 {
+		return nil, fmt.Errorf("invalid sample rate %g, use a value between 0 and 1", options.Sample)
+	}
+
+	queryLog := &QueryLog{options: options}
+	for _, name := range options.Names {
+		queryLog.names = append(queryLog.names, dns.CanonicalName(name))
+	}
+	if options.JSON != nil {
+		queryLog.encoder = json.NewEncoder(options.JSON)
+	}
+
+	return queryLog, nil
+}
+
+// Wrap returns a handler that answers with next and logs the queries that
+// pass the name filters and the sampling.
+//
+// Args:
+//   - next: The handler answering the queries.
+//
+// Returns:
+//   - dns.Handler: The logging handler.
+func (Q *QueryLog) Wrap(next dns.Handler) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, request *dns.Msg) {
+		if !Q.selected(request) {
+			next.ServeDNS(w, request)
+			return
+		}
+
+		start := time.Now()
+		writer := &queryLogWriter{ResponseWriter: w}
+		next.ServeDNS(writer, request)
+		Q.record(w, request, writer.reply, start, time.Since(start))
+	})
+}
+
+// WriteMsg implements dns.ResponseWriter, keeping the reply.
+//
+// Args:
+//   - reply: The reply.
+//
+// Returns:
+//   - error: The error of the underlying writer.
+func (W *queryLogWriter) WriteMsg(reply *dns.Msg) error {
+	W.reply = reply
+
+	return W.ResponseWriter.WriteMsg(reply)
+}
+
+// selected reports whether a query passes the name filters and the sampling.
+//
+// Args:
+//   - request: The request.
+//
+// Returns:
+//   - bool: Whether the query is logged.
+func (Q *QueryLog) selected(request *dns.Msg) bool {
+	if len(Q.names) > 0 {
+		if len(request.Question) == 0 {
+			return false
+		}
+		name := dns.CanonicalName(request.Question[0].Name)
+		matched := false
+		for _, filter := range Q.names {
+			if dns.IsSubDomain(filter, name) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return Q.options.Sample >= 1 || rand.Float64() < Q.options.Sample
+}
+
+// record writes the JSON entry and the packets of a query.
+//
+// Args:
+//   - w: The response writer, giving the addresses.
+//   - request: The request.
+//   - reply: The reply, nil if none was written.
+//   - start: When the query was received.
+//   - latency: The time taken to answer.
+//
+// Returns:
+//   - None
+func (Q *QueryLog) record(w dns.ResponseWriter, request, reply *dns.Msg, start time.Time, latency time.Duration) {
+	transport := "tcp"
+	if _, ok := w.LocalAddr().(*net.UDPAddr); ok {
+		transport = "udp"
+	}
+
+	var wire []byte
+	if reply != nil {
+		wire, _ = reply.Pack()
+	}
+
+	if Q.options.Pcap != nil {
+		if packed, err := request.Pack(); err == nil {
+			Q.report(Q.options.Pcap.WritePacket(start, transport, w.RemoteAddr(), w.LocalAddr(), packed))
+		}
+		if wire != nil {
+			Q.report(Q.options.Pcap.WritePacket(start.Add(latency), transport, w.LocalAddr(), w.RemoteAddr(), wire))
+		}
+	}
+
+	if Q.encoder == nil {
+		return
+	}
+
+	entry := QueryEntry{
+		Time:      start.UTC(),
+		Client:    w.RemoteAddr().String(),
+		Transport: transport,
+		ID:        request.Id,
+		Size:      len(wire),
+		LatencyMs: float64(latency.Microseconds()) / 1000,
+	}
+	if len(request.Question) > 0 {
+		question := request.Question[0]
+		entry.Name = question.Name
+		entry.Type = dns.Type(question.Qtype).String()
+		entry.Class = dns.Class(question.Qclass).String()
+	}
+	if reply != nil {
+		entry.Rcode = dns.RcodeToString[reply.Rcode]
+		entry.Answers = len(reply.Answer)
+	}
+
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+
+	Q.report(Q.encoder.Encode(entry))
+}
+
+// report passes an error met while logging to the error callback.
+//
+// Args:
+//   - err: The error, may be nil.
+//
+// Returns:
+//   - None
+func (Q *QueryLog) report(err error) {
+	if err != nil && Q.options.Errors != nil {
+		Q.options.Errors(err)
+	}
+}
@@ -0,0 +1,284 @@
+package dnsserver
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
+)
+
+// DefaultRecordTTL is the TTL of YAML records that do not set one.
+const DefaultRecordTTL = 300
+
+// recordTypes are the types accepted in a records file.
+var recordTypes = map[string]bool{"A": true, "AAAA": true, "CNAME": true, "TXT": true, "MX": true, "SRV": true}
+
+// RecordsFile is the YAML form of records served without a zone file:
+//
+//	ttl: 300
+//	records:
+//	  www.example.test:
+//	    - type: A
+//	      value: 192.0.2.10
+//	    - type: TXT
+//	      value: hello world
+//	      ttl: 60
+type RecordsFile struct {
+	TTL     uint32                    `yaml:"ttl"`
+	Records map[string][]RecordConfig `yaml:"records"`
+}
+
+// RecordConfig is one record of a records file, its TTL defaulting to the
+// TTL of the file.
+type RecordConfig struct {
+	Type  string  `yaml:"type"`
+	Value string  `yaml:"value"`
+	TTL   *uint32 `yaml:"ttl"`
+}
+
+// LoadRecords reads a YAML records file.
+//
+// Args:
+//   - path: The path to the records file.
+//
+// Returns:
+//   - []dns.RR: The records, sorted by name.
+//   - error: An error if the file cannot be read or a record is not valid.
+func LoadRecords(path string) ([]dns.RR, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read records file '%s': %w", path, err)
+	}
+
+	records, err := ParseRecords(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid records file '%s': %w", path, err)
+	}
+
+	return records, nil
+}
+
+// ParseRecords parses the YAML form of records. TXT values are taken as is
+// unless they start with a quote, the values of other types use the zone file
+// syntax.
+//
+// Args:
+//   - data: The YAML document.
+//
+// Returns:
+//   - []dns.RR: The records, sorted by name.
+//   - error: An error if the document or a record is not valid.
+func ParseRecords(data []byte) ([]dns.RR, error) {
+	var file RecordsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	if file.TTL == 0 {
+		file.TTL = DefaultRecordTTL
+	}
+
+	var records []dns.RR
+	for _, name := range slices.Sorted(maps.Keys(file.Records)) {
+		for _, config := range file.Records[name] {
+			rtype := strings.ToUpper(config.Type)
+			if !recordTypes[rtype] {
+				return nil, fmt.Errorf("%s: unsupported type '%s', use A, AAAA, CNAME, TXT, MX or SRV", name, config.Type)
+			}
+
+			ttl := file.TTL
+			if config.TTL != nil {
+				ttl = *config.TTL
+			}
+			if rtype == "TXT" && !strings.HasPrefix(config.Value, `"`) {
+				records = append(records, &dns.TXT{
+					Hdr: dns.RR_Header{Name: dns.Fqdn(name), Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl},
+					Txt: SplitTXT(config.Value),
+				})
+				continue
+			}
+
+			rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", dns.Fqdn(name), ttl, rtype, config.Value))
+			if err != nil {
+				return nil, fmt.Errorf("%s %s '%s': %w", name, rtype, config.Value, err)
+			}
+			if rr == nil {
+				return nil, fmt.Errorf("%s %s: missing value", name, rtype)
+			}
+			records = append(records, rr)
+		}
+	}
+
+	return records, nil
+}
+
+// BuildAuthority builds the zones served from zone files and extra records.
+// An extra record is added to the zone file zone it belongs to; the others
+// are grouped into zones named after the domain registered under their
+// public suffix (example.co.uk. for www.example.co.uk.), served with a
+// generated SOA. The records are copied, so the same records can build the
+// next authority while this one serves queries.
+//
+// Args:
+//   - zoneFiles: The records of every zone file.
+//   - extra: The extra records, from a records file.
+//
+// Returns:
+//   - *Authority: The authority serving the zones.
+//   - error: An error if a zone is not valid.
+func BuildAuthority(zoneFiles [][]dns.RR, extra []dns.RR) (*Authority, error) {
+	zones := map[string][]dns.RR{}
+	for _, records := range zoneFiles {
+		origin := ""
+		for _, rr := range records {
+			if _, ok := rr.(*dns.SOA); ok {
+				origin = dns.CanonicalName(rr.Header().Name)
+				break
+			}
+		}
+		if origin == "" {
+			return nil, fmt.Errorf("a zone file has no SOA record")
+		}
+		if _, ok := zones[origin]; ok {
+			return nil, fmt.Errorf("zone %s is loaded twice", origin)
+		}
+		zones[origin] = copyRecords(records)
+	}
+
+	generated := map[string]bool{}
+	for _, rr := range extra {
+		name := dns.CanonicalName(rr.Header().Name)
+		origin := ""
+		for candidate := range zones {
+			if dns.IsSubDomain(candidate, name) && len(candidate) > len(origin) {
+				origin = candidate
+			}
+		}
+		if origin == "" {
+			origin = registeredDomain(name)
+			if _, ok := zones[origin]; !ok {
+				generated[origin] = true
+			}
+		}
+		zones[origin] = append(zones[origin], dns.Copy(rr))
+	}
+
+	serial := uint32(time.Now().Unix())
+	for origin := range generated {
+		zones[origin] = append(zones[origin], &dns.SOA{
+			Hdr:     dns.RR_Header{Name: origin, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: DefaultRecordTTL},
+			Ns:      "ns." + origin,
+			Mbox:    "hostmaster." + origin,
+			Serial:  serial,
+			Refresh: 3600,
+			Retry:   600,
+			Expire:  86400,
+			Minttl:  60,
+		})
+	}
+
+	var built []*Zone
+	for _, origin := range slices.Sorted(maps.Keys(zones)) {
+		zone, err := NewZone(zones[origin])
+		if err != nil {
+			return nil, fmt.Errorf("zone %s: %w", origin, err)
+		}
+		built = append(built, zone)
+	}
+
+	return NewAuthority(built...)
+}
+
+// DiffRecords compares two sets of records.
+//
+// Args:
+//   - previous: The records before.
+//   - current: The records after.
+//
+// Returns:
+//   - []dns.RR: The records only in current.
+//   - []dns.RR: The records only in previous.
+func DiffRecords(previous, current []dns.RR) ([]dns.RR, []dns.RR) {
+	var added, removed []dns.RR
+	for _, rr := range current {
+		if !slices.ContainsFunc(previous, func(other dns.RR) bool { return sameRecord(rr, other) }) {
+			added = append(added, rr)
+		}
+	}
+	for _, rr := range previous {
+		if !slices.ContainsFunc(current, func(other dns.RR) bool { return sameRecord(rr, other) }) {
+			removed = append(removed, rr)
+		}
+	}
+
+	return added, removed
+}
+
+// sameRecord reports whether two records have the same owner, type, data and
+// TTL.
+//
+// Args:
+//   - a: A record.
+//   - b: Another record.
+//
+// Returns:
+//   - bool: Whether they are the same.
+func sameRecord(a, b dns.RR) bool {
+	return dns.IsDuplicate(a, b) && a.Header().Ttl == b.Header().Ttl
+}
+
+// copyRecords returns a deep copy of records.
+//
+// Args:
+//   - records: The records.
+//
+// Returns:
+//   - []dns.RR: The copies.
+func copyRecords(records []dns.RR) []dns.RR {
+	copies := make([]dns.RR, 0, len(records))
+	for _, rr := range records {
+		copies = append(copies, dns.Copy(rr))
+	}
+
+	return copies
+}
+
+// SplitTXT splits a TXT value into the 255 byte strings a TXT record holds.
+//
+// Args:
+//   - value: The value.
+//
+// Returns:
+//   - []string: The strings.
+func SplitTXT(value string) []string {
+	chunks := []string{}
+	for len(value) > 255 {
+		chunks = append(chunks, value[:255])
+		value = value[255:]
+	}
+
+	return append(chunks, value)
+}
+
+// registeredDomain returns the domain registered under the public suffix of
+// a name, from the public suffix list.
+//
+// Args:
+//   - name: A fully qualified name.
+//
+// Returns:
+//   - string: The registered domain, the name itself when it is a public
+//     suffix.
+func registeredDomain(name string) string {
+	domain, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimSuffix(name, "."))
+	if err != nil {
+		return name
+	}
+
+	return dns.Fqdn(domain)
+}
@@ -0,0 +1,59 @@
+package dnsserver
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestBuildAuthorityZones(t *testing.T) {
+	zoneFile := []dns.RR{}
+	for _, line := range []string{
+		"example.test. 3600 IN SOA ns1.example.test. hostmaster.example.test. 1 3600 600 86400 300",
+		"example.test. 3600 IN NS ns1.example.test.",
+	} {
+		rr, err := dns.NewRR(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zoneFile = append(zoneFile, rr)
+	}
+
+	extra, err := ParseRecords([]byte(`
+records:
+  www.example.test:
+    - type: A
+      value: 192.0.2.1
+  www.shop.example.co.uk:
+    - type: A
+      value: 192.0.2.2
+  mail.example.co.uk:
+    - type: A
+      value: 192.0.2.3
+  api.other.com:
+    - type: A
+      value: 192.0.2.4
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authority, err := BuildAuthority([][]dns.RR{zoneFile}, extra)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origins := slices.Sorted(maps.Keys(authority.Zones()))
+	want := []string{"example.co.uk.", "example.test.", "other.com."}
+	if !slices.Equal(origins, want) {
+		t.Errorf("zones = %v, want %v", origins, want)
+	}
+	if zone := authority.Zone("www.example.test."); zone.Len() != 3 {
+		t.Errorf("zone file zone holds %d records, want the extra record added", zone.Len())
+	}
+	if zone := authority.Zone("www.shop.example.co.uk."); zone.Origin != "example.co.uk." || zone.Len() != 3 {
+		t.Errorf("zone %s holds %d records, want both example.co.uk. records and a SOA", zone.Origin, zone.Len())
+	}
+}
@@ -0,0 +1,237 @@
+package dnsserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// DefaultTCPIdleTimeout is how long a TCP connection is kept open waiting
+	// for the next query (RFC 7766 section 6.2.3).
+	DefaultTCPIdleTimeout = 10 * time.Second
+	// DefaultTCPMaxQueries is the number of queries answered on one TCP
+	// connection before it is closed.
+	DefaultTCPMaxQueries = 128
+)
+
+// Networks are the transports a server listens on.
+var Networks = []string{"udp", "tcp"}
+
+// ServerOptions configures the listeners of a server.
+type ServerOptions struct {
+	// Listen holds the host:port addresses listened on, ":port" for every
+	// IPv4 and IPv6 address.
+	Listen []string
+	// Networks holds the transports, "udp" and "tcp", used on every address.
+	Networks []string
+	// TCPIdleTimeout is how long an idle TCP connection is kept open.
+	TCPIdleTimeout time.Duration
+	// TCPMaxQueries is the number of queries answered on one TCP
+	// connection, pipelined queries being answered in order. 1 closes the
+	// connection after the first answer, -1 never does.
+	TCPMaxQueries int
+}
+
+// Listener is one address and transport a server listens on.
+type Listener struct {
+	Address string
+	Network string
+}
+
+// Server runs one DNS listener for every address and transport. Listeners
+// start and stop together: if one fails, all of them are shut down.
+type Server struct {
+	listeners []Listener
+	servers   []*dns.Server
+}
+
+// NewServer builds a server answering with a handler on every address and
+// transport of the options.
+//
+// Args:
+//   - handler: The handler answering the queries.
+//   - options: The listener options.
+//
+// Returns:
+//   - *Server: The server, not yet listening.
+//   - error: An error if an address or transport is not valid.
+func NewServer(handler dns.Handler, options ServerOptions) (*Server, error) {
+	if len(options.Listen) == 0 {
+		return nil, errors.New("no address to listen on")
+	}
+	if len(options.Networks) == 0 {
+		options.Networks = Networks
+	}
+	if options.TCPIdleTimeout <= 0 {
+		options.TCPIdleTimeout = DefaultTCPIdleTimeout
+	}
+	if options.TCPMaxQueries == 0 {
+		options.TCPMaxQueries = DefaultTCPMaxQueries
+	}
+
+	server := &Server{}
+	for _, address := range options.Listen {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return nil, fmt.Errorf("invalid listen address '%s': %w", address, err)
+		}
+
+		for _, network := range options.Networks {
+			network = strings.ToLower(network)
+			if !slices.Contains(Networks, network) {
+				return nil, fmt.Errorf("unsupported transport '%s', use udp or tcp", network)
+			}
+			if slices.Contains(server.listeners, Listener{Address: address, Network: network}) {
+				continue
+			}
+
+			server.listeners = append(server.listeners, Listener{Address: address, Network: network})
+			server.servers = append(server.servers, &dns.Server{
+				Addr:          address,
+				Net:           network,
+				UDPSize:       65535,
+				Handler:       handler,
+				IdleTimeout:   func() time.Duration { return options.TCPIdleTimeout },
+				MaxTCPQueries: options.TCPMaxQueries,
+			})
+		}
+	}
+
+	return server, nil
+}
+
+// Listeners returns the addresses and transports listened on.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - []Listener: The listeners.
+func (S *Server) Listeners() []Listener {
+	return slices.Clone(S.listeners)
+}
+
+// ListenAndServe binds every listener, then serves until the context is
+// done or a listener fails, and shuts all of them down. Nothing is served
+// when one of the addresses cannot be bound.
+//
+// Args:
+//   - ctx: The context stopping the server.
+//   - started: Called once every listener is listening, may be nil.
+//
+// Returns:
+//   - error: The error of the first listener that failed, nil when stopped
+//     by the context.
+func (S *Server) ListenAndServe(ctx context.Context, started func()) error {
+	if err := S.listen(); err != nil {
+		return err
+	}
+
+	failed := make(chan error, len(S.servers))
+	ready := make(chan struct{}, len(S.servers))
+	for i, server := range S.servers {
+		server.NotifyStartedFunc = func() { ready <- struct{}{} }
+		go func() {
+			if err := server.ActivateAndServe(); err != nil {
+				failed <- fmt.Errorf("%s %s: %w", S.listeners[i].Network, S.listeners[i].Address, err)
+			}
+		}()
+	}
+
+	// The sockets are bound, so every server reports quickly whether it
+	// started; waiting for all of them means none starts after the shutdown.
+	var err error
+	for range S.servers {
+		select {
+		case <-ready:
+		case failure := <-failed:
+			if err == nil {
+				err = failure
+			}
+		}
+	}
+	if err == nil {
+		if started != nil {
+			started()
+		}
+		select {
+		case err = <-failed:
+		case <-ctx.Done():
+		}
+	}
+
+	S.shutdown()
+
+	return err
+}
+
+// listen binds the socket of every listener, closing all of them when one
+// cannot be bound.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - error: The error of the listener that could not be bound.
+func (S *Server) listen() error {
+	for i, server := range S.servers {
+		listener := S.listeners[i]
+
+		var err error
+		if listener.Network == "udp" {
+			server.PacketConn, err = net.ListenPacket(listener.Network, listener.Address)
+		} else {
+			server.Listener, err = net.Listen(listener.Network, listener.Address)
+		}
+		if err != nil {
+			S.close()
+			return fmt.Errorf("%s %s: %w", listener.Network, listener.Address, err)
+		}
+	}
+
+	return nil
+}
+
+// shutdown stops every listener that is running.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - None
+func (S *Server) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, server := range S.servers {
+		// Listeners that failed report it, nothing to undo.
+		_ = server.ShutdownContext(ctx)
+	}
+	S.close()
+}
+
+// close closes the sockets bound by listen that are still open.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - None
+func (S *Server) close() {
+	for _, server := range S.servers {
+		if server.PacketConn != nil {
+			_ = server.PacketConn.Close()
+			server.PacketConn = nil
+		}
+		if server.Listener != nil {
+			_ = server.Listener.Close()
+			server.Listener = nil
+		}
+	}
+}
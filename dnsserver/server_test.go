@@ -0,0 +1,82 @@
+package dnsserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestServerPortInUse(t *testing.T) {
+	probe, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen on udp: %v", err)
+	}
+	address := probe.LocalAddr().String()
+	probe.Close()
+
+	options := ServerOptions{Listen: []string{address}, Networks: []string{"udp"}}
+	first, err := NewServer(dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {}), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- first.ListenAndServe(ctx, func() { close(started) }) }()
+	select {
+	case <-started:
+	case err := <-done:
+		t.Skipf("cannot listen on %s: %v", address, err)
+	}
+
+	second, err := NewServer(dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {}), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := make(chan error, 1)
+	go func() { failed <- second.ListenAndServe(context.Background(), nil) }()
+
+	select {
+	case err := <-failed:
+		if err == nil {
+			t.Error("the second server stopped without an error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("a second server listened on a port already in use")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("first server: %v", err)
+	}
+}
+
+func TestServerBindFailure(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen on tcp: %v", err)
+	}
+	defer busy.Close()
+	address := busy.Addr().String()
+
+	options := ServerOptions{Listen: []string{address}, Networks: []string{"udp", "tcp"}}
+	server, err := NewServer(dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {}), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ListenAndServe(context.Background(), func() { t.Error("started with a port in use") }); err == nil {
+		t.Fatal("the server started with a port in use")
+	}
+
+	// The UDP socket bound before the TCP one failed must be released.
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		t.Fatalf("udp %s is still in use: %v", address, err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,195 @@
+package dnsserver
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/miekg/dns"
+)
+
+// Zone holds the records of a zone indexed by owner name and type. Names
+// that only exist because something lives below them (empty non-terminals)
+// are tracked too, so they answer NODATA instead of NXDOMAIN.
+type Zone struct {
+	Origin  string
+	SOA     *dns.SOA
+	records map[string]map[uint16][]dns.RR
+	names   map[string]bool
+	size    int
+}
+
+// ReadZoneFile reads the records of an RFC 1035 master file. $ORIGIN, $TTL
+// and $INCLUDE are supported.
+//
+// Args:
+//   - path: The path to the zone file.
+//   - origin: The origin relative names start from until $ORIGIN is set,
+//     empty for the root.
+//
+// Returns:
+//   - []dns.RR: The records.
+//   - error: An error if the file cannot be read or parsed.
+func ReadZoneFile(path, origin string) ([]dns.RR, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open zone file '%s': %w", path, err)
+	}
+	defer file.Close()
+
+	if origin == "" {
+		origin = "."
+	}
+
+	var records []dns.RR
+	parser := dns.NewZoneParser(file, dns.Fqdn(origin), path)
+	parser.SetIncludeAllowed(true)
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		records = append(records, rr)
+	}
+	if err := parser.Err(); err != nil {
+		return nil, fmt.Errorf("could not parse zone file '%s': %w", path, err)
+	}
+
+	return records, nil
+}
+
+// NewZone builds a zone from its records. It needs exactly one SOA record,
+// whose owner is the origin, every other record must be inside the zone and
+// a name with a CNAME cannot have other records.
+//
+// Args:
+//   - records: The records of the zone.
+//
+// Returns:
+//   - *Zone: The zone.
+//   - error: An error if the records do not form a valid zone.
+func NewZone(records []dns.RR) (*Zone, error) {
+	zone := &Zone{records: map[string]map[uint16][]dns.RR{}, names: map[string]bool{}}
+	for _, rr := range records {
+		if soa, ok := rr.(*dns.SOA); ok {
+			if zone.SOA != nil {
+				return nil, fmt.Errorf("more than one SOA record (%s and %s)", zone.Origin, soa.Hdr.Name)
+			}
+			zone.SOA = soa
+			zone.Origin = dns.CanonicalName(soa.Hdr.Name)
+		}
+	}
+	if zone.SOA == nil {
+		return nil, fmt.Errorf("no SOA record")
+	}
+
+	for _, rr := range records {
+		header := rr.Header()
+		header.Name = dns.CanonicalName(header.Name)
+		if !dns.IsSubDomain(zone.Origin, header.Name) {
+			return nil, fmt.Errorf("%s is outside of the zone %s", header.Name, zone.Origin)
+		}
+		if header.Class != dns.ClassINET {
+			return nil, fmt.Errorf("%s %s: only the IN class is served", header.Name, dns.ClassToString[header.Class])
+		}
+
+		if zone.records[header.Name] == nil {
+			zone.records[header.Name] = map[uint16][]dns.RR{}
+		}
+		if !containsRR(zone.records[header.Name][header.Rrtype], rr) {
+			zone.records[header.Name][header.Rrtype] = append(zone.records[header.Name][header.Rrtype], rr)
+			zone.size++
+		}
+
+		// Every name between the record and the origin exists.
+		for name := header.Name; !zone.names[name]; name = parent(name) {
+			zone.names[name] = true
+			if name == zone.Origin {
+				break
+			}
+		}
+	}
+
+	for name, rrsets := range zone.records {
+		if _, ok := rrsets[dns.TypeCNAME]; ok && len(rrsets) > 1 {
+			return nil, fmt.Errorf("%s has a CNAME and other records", name)
+		}
+		if len(rrsets[dns.TypeCNAME]) > 1 {
+			return nil, fmt.Errorf("%s has more than one CNAME", name)
+		}
+	}
+
+	return zone, nil
+}
+
+// Len returns the number of records in the zone.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - int: The number of records.
+func (Z *Zone) Len() int {
+	return Z.size
+}
+
+// negativeSOA returns the SOA record put in the authority section of negative
+// answers, with the TTL lowered to its minimum field as RFC 2308 asks.
+//
+// Args:
+//   - None
+//
+// Returns:
+//   - dns.RR: The SOA record.
+func (Z *Zone) negativeSOA() dns.RR {
+	soa := dns.Copy(Z.SOA).(*dns.SOA)
+	soa.Hdr.Ttl = min(soa.Hdr.Ttl, soa.Minttl)
+
+	return soa
+}
+
+// addresses returns the A and AAAA records of a name of the zone, used as
+// glue and additional records.
+//
+// Args:
+//   - name: The name.
+//
+// Returns:
+//   - []dns.RR: The address records.
+func (Z *Zone) addresses(name string) []dns.RR {
+	name = dns.CanonicalName(name)
+	if !dns.IsSubDomain(Z.Origin, name) {
+		return nil
+	}
+
+	return append(append([]dns.RR{}, Z.records[name][dns.TypeA]...), Z.records[name][dns.TypeAAAA]...)
+}
+
+// parent returns the name one label up, the root being its own parent.
+//
+// Args:
+//   - name: A fully qualified name.
+//
+// Returns:
+//   - string: The parent name.
+func parent(name string) string {
+	next, end := dns.NextLabel(name, 0)
+	if end {
+		return "."
+	}
+
+	return name[next:]
+}
+
+// containsRR reports whether a set already holds a record with the same data.
+//
+// Args:
+//   - set: The records.
+//   - rr: The record.
+//
+// Returns:
+//   - bool: Whether the record is a duplicate.
+func containsRR(set []dns.RR, rr dns.RR) bool {
+	for _, existing := range set {
+		if dns.IsDuplicate(existing, rr) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -3,6 +3,8 @@ package validators
 import (
 	"commandCenter/styles"
 	"fmt"
+	"time"
+
 	"github.com/spf13/cobra"
 )
 
@@ -45,3 +47,103 @@ func VerifyBoolInputs(cmd *cobra.Command, flag string) (bool, error) {
 
 	return passedFlag, nil
 }
+
+// VerifyStringSliceInputs verifies and returns a string slice flag from the cobra command.
+//
+// Args:
+//   - cmd: The cobra command.
+//   - flag: The name of the string slice flag to verify.
+//
+// Returns:
+//   - []string: The values of the string slice flag.
+//   - error: An error if the flag is not found or cannot be parsed.
+func VerifyStringSliceInputs(cmd *cobra.Command, flag string) ([]string, error) {
+	passedFlag, err := cmd.Flags().GetStringSlice(flag)
+	if err != nil {
+		message := fmt.Errorf(styles.NewStyles().Error.Render("An error occurred while parsing flag '%s'.\nError: %s"), flag, err)
+
+		return passedFlag, message
+	}
+
+	return passedFlag, nil
+}
+
+// VerifyIntInputs verifies and returns an integer flag from the cobra command.
+//
+// Args:
+//   - cmd: The cobra command.
+//   - flag: The name of the integer flag to verify.
+//
+// Returns:
+//   - int: The value of the integer flag.
+//   - error: An error if the flag is not found or cannot be parsed.
+func VerifyIntInputs(cmd *cobra.Command, flag string) (int, error) {
+	passedFlag, err := cmd.Flags().GetInt(flag)
+	if err != nil {
+		message := fmt.Errorf(styles.NewStyles().Error.Render("An error occurred while parsing flag '%s'.\nError: %s"), flag, err)
+
+		return passedFlag, message
+	}
+
+	return passedFlag, nil
+}
+
+// VerifyUint32Inputs verifies and returns an unsigned 32-bit integer flag from the cobra command.
+//
+// Args:
+//   - cmd: The cobra command.
+//   - flag: The name of the unsigned integer flag to verify.
+//
+// Returns:
+//   - uint32: The value of the unsigned integer flag.
+//   - error: An error if the flag is not found or cannot be parsed.
+func VerifyUint32Inputs(cmd *cobra.Command, flag string) (uint32, error) {
+	passedFlag, err := cmd.Flags().GetUint32(flag)
+	if err != nil {
+		message := fmt.Errorf(styles.NewStyles().Error.Render("An error occurred while parsing flag '%s'.\nError: %s"), flag, err)
+
+		return passedFlag, message
+	}
+
+	return passedFlag, nil
+}
+
+// VerifyFloatInputs verifies and returns a float flag from the cobra command.
+//
+// Args:
+//   - cmd: The cobra command.
+//   - flag: The name of the float flag to verify.
+//
+// Returns:
+//   - float64: The value of the float flag.
+//   - error: An error if the flag is not found or cannot be parsed.
+func VerifyFloatInputs(cmd *cobra.Command, flag string) (float64, error) {
+	passedFlag, err := cmd.Flags().GetFloat64(flag)
+	if err != nil {
+		message := fmt.Errorf(styles.NewStyles().Error.Render("An error occurred while parsing flag '%s'.\nError: %s"), flag, err)
+
+		return passedFlag, message
+	}
+
+	return passedFlag, nil
+}
+
+// VerifyDurationInputs verifies and returns a duration flag from the cobra command.
+//
+// Args:
+//   - cmd: The cobra command.
+//   - flag: The name of the duration flag to verify.
+//
+// Returns:
+//   - time.Duration: The value of the duration flag.
+//   - error: An error if the flag is not found or cannot be parsed.
+func VerifyDurationInputs(cmd *cobra.Command, flag string) (time.Duration, error) {
+	passedFlag, err := cmd.Flags().GetDuration(flag)
+	if err != nil {
+		message := fmt.Errorf(styles.NewStyles().Error.Render("An error occurred while parsing flag '%s'.\nError: %s"), flag, err)
+
+		return passedFlag, message
+	}
+
+	return passedFlag, nil
+}